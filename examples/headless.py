@@ -0,0 +1,58 @@
+#!/usr/bin/env python3
+"""Drive Neo's agent loop without its terminal UI, against the mock provider.
+
+It works on a scratch directory, so it can run anywhere without an API key:
+
+    python examples/headless.py
+
+The scripted model reads a file, then creates another; the approval callback
+allows the write, and every event of the turn is printed as it happens.
+"""
+
+import os
+import sys
+import tempfile
+
+sys.path.insert(0, os.path.join(os.path.dirname(os.path.abspath(__file__)), ".."))
+
+from neo_core.api import Session
+from neo_core.config import NeoConfig
+from neo_core.loop import CONTENT, REASONING, TOOL_ARGUMENTS
+from neo_core.mock import MockClient
+
+SCRIPT = [  # One response per request: a turn with tool calls makes two requests
+    [{"reasoning": "I should read the notes first."},
+     {"tool_call": {"name": "read_file", "arguments": {"file_path": "notes.txt"}}}],
+    [{"content": "Your notes ask for a TODO file that says: ship it."}],
+    [{"tool_call": {"name": "create_file", "arguments": {"file_path": "TODO.md", "content": "- ship it\n"}}}],
+    [{"content": "Created TODO.md from your notes."}],
+]
+
+def approve(action: str, target: str) -> bool:
+    print(f"  approve {action} of {target}? yes")
+    return True
+
+def show(event) -> None:
+    if event.kind in (CONTENT, REASONING, TOOL_ARGUMENTS):
+        return  # Printed in full by the events that end each stream
+    detail = event.text or (event.tool_call or {}).get("function", {}).get("name", "") or ""
+    if event.result is not None:
+        detail += f" -> {event.result.splitlines()[0] if event.result else ''}"
+    print(f"[{event.kind}] {detail}".rstrip())
+
+def main() -> None:
+    with tempfile.TemporaryDirectory() as workdir:
+        with open(os.path.join(workdir, "notes.txt"), "w", encoding="utf-8") as f:
+            f.write("Make a TODO file that says: ship it\n")
+
+        session = Session(NeoConfig(provider="mock"), workdir=workdir, client=MockClient(SCRIPT), approve=approve)
+        for message in ("What do my notes say?", "Do what they ask"):
+            print(f"> {message}")
+            reply = session.send(message, on_event=show)
+            print(f"reply: {reply}\n")
+
+        with open(os.path.join(workdir, "TODO.md"), encoding="utf-8") as f:
+            print(f"TODO.md now holds: {f.read().strip()}")
+
+if __name__ == "__main__":
+    main()
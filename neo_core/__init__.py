@@ -0,0 +1,7 @@
+"""Neo's core: configuration, file operations, AI interaction and terminal UI.
+
+neo.py wires these together; the pieces can also be imported on their own.
+"""
+
+__version__ = "0.1.0"
+__commit__ = "dev"  # Stamped by the release build; falls back to git when unset
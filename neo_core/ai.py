@@ -0,0 +1,656 @@
+"""AI interaction: client setup, conversation and streaming, and tool dispatch."""
+
+import os
+import re
+import ssl
+import json
+import threading
+import time
+from dataclasses import dataclass
+from pathlib import Path
+from textwrap import dedent
+from typing import Any, Callable, Dict, Iterable, List, Optional, Protocol
+from urllib.parse import urlsplit, urlunsplit
+from urllib.request import getproxies, proxy_bypass
+from openai import OpenAI, APIConnectionError, AuthenticationError, DefaultHttpxClient
+from rich.markup import escape
+from rich.panel import Panel
+
+from neo_core.cache import ResponseCache
+from neo_core.cancel import CANCELLED_RESULT, Cancelled
+from neo_core.capabilities import ModelCapabilities, completion_chunks, describe_limits, rejected_feature, without_tools
+from neo_core.config import NeoConfig, DATA_DIR
+from neo_core.conversation import Conversation
+from neo_core.fallback import ProviderChain
+from neo_core.loop import (
+    CACHED, CONTENT, DONE, REASONING, REQUEST, STREAM_END, TOOL_ARGUMENTS, TOOL_CALL, TOOL_CALLS, TOOL_RESULT, TOOLS_DONE,
+    TOOLS_RUNNING, TURN_LIMIT, AgentLoop, Event, ToolExecutor, collect_stream,
+)
+from neo_core.mock import MockClient, load_fixture
+from neo_core.ratelimit import RateLimiter, describe_queue, is_rate_limited, retry_after
+from neo_core.redact import scrub
+from neo_core.stats import ResponseTiming, SessionStats, gap_separator
+from neo_core.terminal import EscapeKey
+from neo_core.tokens import estimate_conversation
+from neo_core.ui import CodeBlock, MatrixTextFormatter, console, decorate, notify_finished, render_reply, show_credentials_diagnostic, terminal
+
+# --------------------------------------------------------------------------------
+# 1. Client setup
+# --------------------------------------------------------------------------------
+
+def proxy_for_url(url: str) -> Optional[str]:
+    """Return the proxy HTTPS_PROXY/HTTP_PROXY select for 'url', honouring NO_PROXY."""
+    parts = urlsplit(url)
+    if proxy_bypass(parts.hostname or ""):
+        return None
+    return getproxies().get(parts.scheme)
+
+def redact_proxy(proxy: str) -> str:
+    """Strip any user:password from a proxy URL so it can be shown on screen."""
+    parts = urlsplit(proxy)
+    if parts.username or parts.password:
+        host = parts.hostname + (f":{parts.port}" if parts.port else "")
+        return urlunsplit((parts.scheme, f"***@{host}", parts.path, parts.query, parts.fragment))
+    return proxy
+
+def describe_route(config: NeoConfig) -> str:
+    """Describe how requests reach the provider, for connection error messages."""
+    proxy = proxy_for_url(config.provider_info()["base_url"])
+    return f"via proxy {redact_proxy(proxy)}" if proxy else "direct, no proxy"
+
+NO_API_KEY = "none"  # For a provider without api_key_env, such as a local server: the client insists on a key
+
+def initialize_ai_client(config: NeoConfig, name: Optional[str] = None) -> OpenAI:
+    """Build the API client for provider 'name' (by default the configured one), with an explicit HTTP client for proxy/TLS settings."""
+    provider = config.provider_info(name)
+
+    if config.insecure_skip_verify:
+        console.print(Panel(
+            "[matrix.error]TLS CERTIFICATE VERIFICATION IS DISABLED.[/matrix.error]\n"
+            "[matrix.warning]Anyone on the network path can read your code and API key. "
+            "Prefer ca_bundle with your proxy's certificate.[/matrix.warning]",
+            title="[matrix.error][ WARNING ][/matrix.error]",
+            border_style="matrix.error"
+        ))
+        verify = False
+    elif config.ca_bundle:
+        verify = ssl.create_default_context(cafile=str(Path(config.ca_bundle).expanduser()))
+    else:
+        verify = True
+
+    http_client = DefaultHttpxClient(proxy=proxy_for_url(provider["base_url"]), verify=verify)
+    return OpenAI(
+        api_key=os.getenv(provider["api_key_env"]) if provider["api_key_env"] else NO_API_KEY,
+        base_url=provider["base_url"],
+        http_client=http_client
+    )
+
+def connect_ai_client(config: NeoConfig) -> Optional["ChatClient"]:
+    """Create the client and verify the API key with a cheap models-list call.
+
+    Returns None, after printing a diagnostic, when the key is missing or rejected.
+    """
+    if config.provider == "mock":
+        fixture = load_fixture(config.mock_fixture) if config.mock_fixture else []
+        console.print(f"[matrix.warning]> MOCK PROVIDER: replaying {config.mock_fixture or 'echo responses'}[/matrix.warning]")
+        return MockClient(fixture)
+
+    provider = config.provider_info()
+    api_key_env = provider["api_key_env"]
+    offline_hint = "Set it in your environment or .env file, or start with --offline to use local commands only."
+    if api_key_env and not os.getenv(api_key_env):
+        show_credentials_diagnostic(config.provider, provider, f"{api_key_env} is not set.", offline_hint)
+        return None
+
+    ai_client = initialize_ai_client(config)
+    try:
+        with console.status("[matrix.accent]> VERIFYING ACCESS CODES...[/matrix.accent]", spinner="dots"):
+            ai_client.with_options(timeout=15, max_retries=0).models.list()
+    except AuthenticationError:
+        show_credentials_diagnostic(config.provider, provider, f"The key in {api_key_env} was rejected by the provider.", offline_hint)
+        return None
+    except APIConnectionError as e:
+        # The key may well be fine; let the session start and fail per-request instead
+        console.print(f"[matrix.warning]⚠ Could not verify credentials: {escape(str(e))} ({describe_route(config)})[/matrix.warning]")
+    return ai_client
+
+# --------------------------------------------------------------------------------
+# 2. System prompt
+# --------------------------------------------------------------------------------
+SYSTEM_PROMPT = dedent("""\
+    You are Neo, an elite hacker and software engineer operating within the Matrix.
+    You see the code behind reality and can manipulate it at will.
+    Your decades of experience span all programming domains and digital realities.
+
+    Core capabilities:
+    1. Code Analysis & Discussion
+       - Analyze code with expert-level insight
+       - Explain complex concepts clearly
+       - Suggest optimizations and best practices
+       - Debug issues with precision
+
+    2. File Operations (via function calls):
+    {available_tools}
+
+    Guidelines:
+    1. Provide natural, conversational responses explaining your reasoning
+    2. Use function calls when you need to read or modify files
+    3. For file operations:
+       - Always read files first before editing them to understand the context
+       - Use precise snippet matching for edits
+       - Explain what changes you're making and why
+       - Consider the impact of changes on the overall codebase
+       - Text shown as [REDACTED:kind] is a hidden secret; never write the placeholder into a file
+    4. Follow language-specific best practices
+    5. Suggest tests or validation steps when appropriate
+    6. Be thorough in your analysis and recommendations
+
+    IMPORTANT: In your thinking process, if you realize that something requires a tool call, cut your thinking short and proceed directly to the tool call. Don't overthink - act efficiently when file operations are needed.
+
+    Remember: You're a senior engineer - be thoughtful, precise, and explain your reasoning clearly.
+""")
+
+READ_ONLY_NOTE = dedent("""\
+
+    This session is read-only: the tools above can only read, and no file can be created, edited or
+    deleted, nor any command run. When a change is needed, explain it or show it as a diff for the
+    user to apply themselves; don't say you made it.
+""")
+
+ASK_NOTE = dedent("""\
+
+    This is a side question, asked apart from the conversation: you have no tools and none of the
+    user's files. Answer it directly and briefly.
+""")
+
+def build_system_prompt(tool_executor: "ToolExecutor", template: str = SYSTEM_PROMPT, read_only: bool = False) -> str:
+    """Fill the {available_tools} placeholder from the tool registry; 'read_only' adds READ_ONLY_NOTE."""
+    tool_list = "\n".join(f"   {line}" for line in tool_executor.describe().splitlines())
+    return template.replace("{available_tools}", tool_list) + (READ_ONLY_NOTE if read_only else "")
+
+# --------------------------------------------------------------------------------
+# 3. Sessions and debug logging
+# --------------------------------------------------------------------------------
+
+SESSIONS_DIR = DATA_DIR / "sessions"
+LOGS_DIR = DATA_DIR / "logs"
+TRANSCRIPTS_DIR = DATA_DIR / "transcripts"
+MAX_LISTED_SESSIONS = 20  # Shown by /sessions and --resume pick
+
+@dataclass
+class SessionInfo:
+    """A saved session as /sessions lists it."""
+    path: Path
+    saved_at: Optional[float]
+    title: Optional[str]
+    messages: int  # On the branch that was active, not counting system messages
+
+def save_session(conversation: Conversation, **state: Any) -> Optional[Path]:
+    """Write the conversation, plus any extra session state, to a timestamped file so it can be resumed later."""
+    if not conversation.has_exchanges():
+        return None
+    SESSIONS_DIR.mkdir(parents=True, exist_ok=True)
+    session_path = SESSIONS_DIR / f"{time.strftime('%Y%m%d-%H%M%S')}.json"
+    with open(session_path, "w", encoding="utf-8") as f:
+        json.dump({"saved_at": time.time(), **conversation.snapshot(), **state}, f, indent=2)
+    return session_path
+
+def list_sessions(limit: int = MAX_LISTED_SESSIONS) -> List[SessionInfo]:
+    """The newest saved sessions, newest first; files that can't be read are left out."""
+    sessions = []
+    for path in sorted(SESSIONS_DIR.glob("*.json"), reverse=True) if SESSIONS_DIR.is_dir() else []:
+        try:
+            session = load_session(path)
+        except (OSError, ValueError):
+            continue
+        if not isinstance(session, dict):
+            continue
+        messages = [msg for msg in session.get("messages") or [] if isinstance(msg, dict) and msg.get("role") != "system"]
+        sessions.append(SessionInfo(path, session.get("saved_at"), session.get("title"), len(messages)))
+        if len(sessions) >= limit:
+            break
+    return sessions
+
+def find_session(name: str) -> Optional[Path]:
+    """Resolve a session name, file path, 'latest', or a number from /sessions to a saved session file."""
+    if name == "latest":
+        sessions = sorted(SESSIONS_DIR.glob("*.json")) if SESSIONS_DIR.is_dir() else []
+        return sessions[-1] if sessions else None
+    if name.isdigit() and 1 <= int(name) <= MAX_LISTED_SESSIONS:
+        sessions = list_sessions()
+        return sessions[int(name) - 1].path if int(name) <= len(sessions) else None
+    for candidate in (Path(name), SESSIONS_DIR / name, SESSIONS_DIR / f"{name}.json"):
+        if candidate.is_file():
+            return candidate
+    return None
+
+def load_session(session_path: Path) -> Dict[str, Any]:
+    """Return a saved session: its conversation snapshot and any extra state stored alongside it."""
+    with open(session_path, "r", encoding="utf-8") as f:
+        return json.load(f)
+
+class DebugLogger:
+    """Writes outgoing requests and received stream deltas to a JSONL file."""
+
+    def __init__(self, config: NeoConfig):
+        self.config = config
+        self.path: Optional[Path] = None
+        self._file = None
+
+    @property
+    def enabled(self) -> bool:
+        return self._file is not None
+
+    def start(self) -> Path:
+        if not self.enabled:
+            LOGS_DIR.mkdir(parents=True, exist_ok=True)
+            self.path = LOGS_DIR / f"{time.strftime('%Y%m%d-%H%M%S')}.jsonl"
+            self._file = open(self.path, "a", encoding="utf-8")
+        return self.path
+
+    def stop(self) -> None:
+        if self._file:
+            self._file.close()
+        self._file = None
+
+    def record(self, event: str, data: Any) -> None:
+        if not self.enabled:
+            return
+        line = scrub(json.dumps({"ts": time.time(), "event": event, "data": data}, default=str))
+        api_key = os.getenv(self.config.provider_info()["api_key_env"])
+        if api_key:
+            line = line.replace(api_key, "[REDACTED]")  # Even one too short for scrub() to look for
+        self._file.write(line + "\n")
+        self._file.flush()
+
+    def record_request(self, request: Dict[str, Any]) -> None:
+        if not self.enabled:
+            return
+        limit = self.config.debug_max_content
+        messages = []
+        for msg in request.get("messages", []):
+            content = msg.get("content")
+            if limit and isinstance(content, str) and len(content) > limit:
+                msg = {**msg, "content": content[:limit] + f"... [truncated {len(content) - limit} chars]"}
+            messages.append(msg)
+        self.record("request", {**request, "messages": messages, "base_url": self.config.provider_info()["base_url"]})
+
+ANSI_ESCAPE_RE = re.compile(r"\x1b\[[0-9;?]*[A-Za-z]|\x1b\][^\x07]*\x07")
+BOX_BORDER_RE = re.compile(r"^[ \t]*[│┃║][ \t]?|[ \t]?[│┃║][ \t]*$|^[ \t]*[╭╮╰╯┌┐└┘─━═┼├┤]+[ \t]*$", re.MULTILINE)
+
+def plain_text(text: str) -> str:
+    """Strip terminal escape codes and box-drawing borders."""
+    return BOX_BORDER_RE.sub("", ANSI_ESCAPE_RE.sub("", text))
+
+def describe_turn(conversation: Conversation) -> str:
+    """The stats line's note of the turn just answered: its number, and its tool calls when it had any."""
+    turn = conversation.last_turn()
+    summary = conversation.turn_summary(turn) if turn is not None else None
+    if summary is None:
+        return ""
+    calls = f" ({summary.tool_calls} tool call{'s' if summary.tool_calls != 1 else ''})" if summary.tool_calls else ""
+    return f" · turn {summary.turn}{calls}"
+
+class TranscriptWriter:
+    """Appends an unstyled, timestamped record of the conversation to a text file."""
+
+    def __init__(self):
+        self.path: Optional[Path] = None
+        self._file = None
+        self._last: Optional[float] = None  # When the previous entry was written, for gap separators
+
+    @property
+    def enabled(self) -> bool:
+        return self._file is not None
+
+    def start(self, path: Optional[str] = None) -> Path:
+        self.stop()
+        self._last = None
+        if path:
+            self.path = Path(path).expanduser()
+        else:
+            TRANSCRIPTS_DIR.mkdir(parents=True, exist_ok=True)
+            self.path = TRANSCRIPTS_DIR / f"{time.strftime('%Y%m%d-%H%M%S')}.txt"
+        self.path.parent.mkdir(parents=True, exist_ok=True)
+        self._file = open(self.path, "a", encoding="utf-8")
+        return self.path
+
+    def stop(self) -> None:
+        if self._file:
+            self._file.close()
+        self._file = None
+
+    def write(self, speaker: str, text: str) -> None:
+        if not self.enabled:
+            return
+        now = time.time()
+        separator = gap_separator(self._last, now)
+        if separator:
+            self._file.write(f"{separator}\n\n")
+        self._last = now
+        self._file.write(f"[{time.strftime('%Y-%m-%d %H:%M:%S', time.localtime(now))}] {speaker}:\n{scrub(plain_text(text)).rstrip()}\n\n")
+
+    def begin_turn(self, turn: int) -> None:
+        """Mark where your next message and everything in answer to it start."""
+        if self.enabled:
+            self._file.write(f"{'─' * 20} turn {turn} {'─' * 20}\n\n")
+
+    def end_turn(self) -> None:
+        """Flush once the response is complete, so a crash loses at most the current turn."""
+        if self.enabled:
+            self._file.flush()
+
+# --------------------------------------------------------------------------------
+# 4. Interfaces
+# --------------------------------------------------------------------------------
+
+class ChatClient(Protocol):
+    """The subset of the OpenAI client the agent uses: chat.completions.create(...)."""
+    chat: Any
+
+# --------------------------------------------------------------------------------
+# 5. Conversation and streaming
+# --------------------------------------------------------------------------------
+
+def describe_tool_call(tool_call: Dict[str, Any], result: str, limit: int = 120) -> str:
+    """One line for a tool call and the first line of its result, for transcripts."""
+    arguments = tool_call["function"]["arguments"]
+    if len(arguments) > limit:
+        arguments = arguments[:limit] + "..."
+    first_line = result.strip().splitlines()[0] if result.strip() else ""
+    return f"{tool_call['function']['name']}({arguments}) -> {first_line}"
+
+PROGRESS_AFTER_SECONDS = 1.0  # Tools still running after this long get a status line, and Esc cancels them
+
+class ReplyRenderer:
+    """Prints the events of a turn to the terminal as they arrive, keeping the transcript and timing."""
+
+    def __init__(self, agent: "Agent", timing: ResponseTiming, prefix: str = "[matrix.primary]NEO>[/matrix.primary]"):
+        self.agent = agent
+        self.timing = timing
+        self.prefix = prefix  # Printed before the reply's first line
+        self.formatter = MatrixTextFormatter(console)
+        self.reasoning_started = False
+        self.content_started = False
+        self.tools_started = 0.0
+        self.last_content = ""
+        self.progress: Optional[Any] = None  # Its status line, once it has run PROGRESS_AFTER_SECONDS
+        self.escape: Optional[EscapeKey] = None
+
+    def __call__(self, event: Event) -> None:
+        if event.kind == REQUEST:
+            terminal.begin_stream()  # Until STREAM_END, output is batched; see TerminalWriter
+            if event.text == "reply":
+                decorate("\n[matrix.accent]> CONNECTING TO THE MATRIX...[/matrix.accent]")
+            elif event.text == "ask":
+                decorate("\n[matrix.accent]> SIDE QUESTION[/matrix.accent] [matrix.dim]without the conversation or its files[/matrix.dim]")
+            else:
+                decorate("\n[bold bright_blue]🔄 Processing results...[/bold bright_blue]")
+            self.reasoning_started = self.content_started = False
+        elif event.kind == CACHED:
+            console.print(f"\n[matrix.accent]{escape('> [cached]')}[/matrix.accent] [matrix.dim]replayed from the response cache, no request sent[/matrix.dim]")
+            self.reasoning_started = self.content_started = False
+        elif event.kind == REASONING:
+            self.timing.on_token(event.text)
+            if not self.reasoning_started:
+                console.print("\n[matrix.dim]// PROCESSING LOGIC:[/matrix.dim]")
+                self.reasoning_started = True
+            console.print(event.text, end="", markup=False, highlight=False)  # A fragment: nothing to style yet
+        elif event.kind == CONTENT:
+            self.timing.on_token(event.text)
+            if self.reasoning_started:
+                console.print()  # End the reasoning's last line
+                decorate()  # Then a blank line before the reply
+                self.reasoning_started = False
+            # First content chunk - show NEO prompt
+            if not self.content_started:
+                console.print(self.prefix)
+                self.content_started = True
+            # Print complete lines with markdown-aware formatting
+            self.formatter.process_chunk(event.text)
+        elif event.kind == TOOL_ARGUMENTS:
+            self.timing.on_token(event.text)
+        elif event.kind == STREAM_END:
+            self.last_content = event.text
+            self.formatter.finalize()  # Also resets it, so the follow-up starts outside any code block
+            console.print()  # New line after streaming
+            terminal.end_stream()
+        elif event.kind == TOOL_CALLS:
+            if event.text:
+                self.agent.transcript.write("NEO", event.text)
+            decorate(f"\n[bold bright_cyan]⚡ Executing {len(event.tool_calls)} function call(s)...[/bold bright_cyan]")
+            self.tools_started = time.monotonic()
+        elif event.kind == TOOL_CALL:
+            self.stop_progress()  # A new batch: the one before it is done
+            console.print(f"[bright_blue]→ {event.tool_call['function']['name']}[/bright_blue]")
+        elif event.kind == TOOLS_RUNNING:
+            self.show_progress([tool_call["function"]["name"] for tool_call in event.tool_calls], float(event.text))
+        elif event.kind == TOOL_RESULT:
+            self.stop_progress()
+            if event.result == CANCELLED_RESULT:
+                console.print(f"[matrix.warning]✗ CANCELLED:[/matrix.warning] [matrix.accent]{event.tool_call['function']['name']}[/matrix.accent]")
+            self.agent.debug_log.record("tool_result", {"tool_call": event.tool_call, "result": event.result})
+            self.agent.transcript.write("TOOL", describe_tool_call(event.tool_call, event.result))
+        elif event.kind == TOOLS_DONE:
+            self.timing.tool_seconds += time.monotonic() - self.tools_started
+        elif event.kind == TURN_LIMIT:
+            console.print(f"\n[matrix.warning]⚠ TURN LIMIT REACHED: {event.text}. Remaining tool calls were refused.[/matrix.warning]")
+        elif event.kind == DONE:
+            self.agent.transcript.write("NEO", self.last_content)  # The reply, or after tools the follow-up
+
+    def show_progress(self, names: List[str], seconds: float) -> None:
+        """The running tools and how long they have taken; raises Cancelled once Esc is pressed."""
+        if seconds < PROGRESS_AFTER_SECONDS:
+            return
+        if self.progress is None:
+            self.escape = EscapeKey()
+            self.progress = console.status("", spinner="dots")
+            self.progress.start()
+        self.progress.update(f"[matrix.accent]> RUNNING {escape(', '.join(names))}: {seconds:.0f}s[/matrix.accent] "
+                             "[matrix.dim](Esc or Ctrl+C cancels)[/matrix.dim]")
+        if self.escape.pressed():
+            raise Cancelled()
+
+    def stop_progress(self) -> None:
+        if self.progress is not None:
+            self.progress.stop()
+            self.escape.close()
+            self.progress = self.escape = None
+
+class Agent:
+    """Owns the conversation and runs streamed completions, dispatching tool calls."""
+
+    def __init__(self, client: Optional[ChatClient], config: NeoConfig, tool_executor: ToolExecutor,
+                 conversation: Conversation, debug_log: DebugLogger,
+                 transcript: Optional[TranscriptWriter] = None, stats: Optional[SessionStats] = None):
+        self.client = client
+        self.config = config
+        self.tool_executor = tool_executor
+        self.conversation = conversation
+        self.debug_log = debug_log
+        self.transcript = transcript or TranscriptWriter()
+        self.stats = stats or SessionStats()
+        self.last_code_blocks: List[CodeBlock] = []  # From the most recent response, for /apply
+        self.last_reply = ""  # Raw text of the most recent response
+        self.last_rendered: List[str] = []  # The same, rendered with ANSI styles, for /last
+        self.loop = AgentLoop(config, tool_executor, conversation, self.create_chat_stream, ResponseCache())
+        self.failed_message: Optional[str] = None  # A message whose request failed before any reply, for /retry
+        self.capabilities = ModelCapabilities(config)  # Whether each model takes tools and streams
+        self.providers = ProviderChain(config, client, initialize_ai_client)  # The provider requests go to, and fallbacks
+        self.limiter = RateLimiter(config.requests_per_minute, config.max_concurrent_requests)  # Shared by every request
+
+    def create_chat_stream(self, **request) -> Iterable[Any]:
+        """Start a chat completion, mirroring the request and every chunk to the debug log.
+
+        Tools and streaming are left out for a model without them (see capabilities.py),
+        and a request rejected for using them is sent again without. While the provider
+        is down the request goes to the next of fallback_providers (see fallback.py).
+        """
+        self.debug_log.record_request(request)
+        self.stats.record_request(estimate_conversation(request.get("messages", [])), request.get("model", ""))
+        providers = self.providers
+        if providers.maybe_recover():
+            console.print(f"[matrix.success]✓ PROVIDER BACK:[/matrix.success] [matrix.dim]{escape(providers.primary)} answers again; "
+                          "requests go to it.[/matrix.dim]")
+        rate_limited = False  # A 429 is waited out and retried once; the client has already retried it
+        while True:
+            model = providers.model_for(request.get("model", ""))
+            capabilities = self.capabilities.of(model)
+            sent = {**request, "model": model}
+            if not capabilities.tools:
+                sent = without_tools(sent)
+            started = False
+            with self.limiter.slot(self._queued):
+                try:
+                    if capabilities.streaming:
+                        for chunk in providers.client.chat.completions.create(stream=True, **sent):
+                            started = True
+                            self.debug_log.record("chunk", chunk.model_dump(exclude_none=True))
+                            yield chunk
+                    else:
+                        completion = providers.client.chat.completions.create(**sent)
+                        self.debug_log.record("completion", completion.model_dump(exclude_none=True))
+                        started = True
+                        yield from completion_chunks(completion)
+                    break
+                except Exception as e:
+                    self.debug_log.record("error", repr(e))
+                    if started:
+                        raise
+                    if is_rate_limited(e) and not rate_limited:
+                        seconds = retry_after(e)
+                        self.limiter.back_off(seconds)
+                        rate_limited = True
+                        console.print(f"[matrix.warning]⚠ RATE LIMITED:[/matrix.warning] [matrix.dim]{escape(providers.name)} "
+                                      f"asked to wait {seconds:.0f}s; every request waits, then this one is sent again.[/matrix.dim]")
+                        continue
+                    down = providers.name
+                    fallback = providers.fail_over(e)
+                    if fallback:
+                        console.print(f"[matrix.warning]⚠ PROVIDER DOWN:[/matrix.warning] [matrix.dim]{escape(down)} failed "
+                                      f"({escape(scrub(str(e))[:120])}); sending to {escape(fallback)} "
+                                      f"({escape(providers.model_for(request.get('model', '')))}) until {escape(providers.primary)} "
+                                      "answers again or /provider reset.[/matrix.dim]")
+                        continue
+                    feature = rejected_feature(e, sent, capabilities.streaming)
+                    if feature is None:
+                        raise
+                    self.capabilities.learn(model, feature)
+                    console.print(f"[matrix.warning]⚠ MODEL LIMIT:[/matrix.warning] [matrix.dim]{escape(model)} rejected "
+                                  f"{'tool definitions' if feature == 'tools' else 'a streaming request'}; sending again without "
+                                  "for the rest of the session.[/matrix.dim]")
+                    limits = describe_limits(model, self.capabilities.of(model))
+                    if limits:
+                        console.print(f"[matrix.dim]> {escape(limits)}[/matrix.dim]")
+        self.debug_log.record("stream_end", None)
+
+    def _queued(self, ahead: int) -> None:
+        """Say why a request waits for the limiter; not for background ones, like the title's."""
+        if threading.current_thread() is threading.main_thread():
+            console.print(f"[matrix.dim]> {describe_queue(ahead).capitalize()}...[/matrix.dim]")
+
+    def remember_reply(self, text: str) -> None:
+        """Keep the raw and rendered reply for /last."""
+        self.last_reply = text
+        self.last_rendered = render_reply(text, console.width) if text else []
+        if len(self.last_rendered) > console.size.height:
+            console.print(f"[matrix.dim]> Long reply ({len(self.last_rendered)} lines). /last opens it in the pager.[/matrix.dim]")
+
+    def retry(self):
+        """Send the failed message again, in place of the unanswered copy left in the conversation."""
+        message = self.failed_message
+        if message is None:
+            return {"error": "No failed message to retry"}
+        self.conversation.drop_unanswered(message)
+        return self.stream_response(message)
+
+    def ask(self, question: str, keep: bool = False):
+        """/ask: answer 'question' with only the system prompt, without tools, history or files.
+
+        The exchange is left out of the conversation unless 'keep'; its tokens still count in /stats.
+        """
+        model = self.config.resolved_chat_model() if self.config.model == "auto" else self.config.resolved_model()
+        self.transcript.write("USER", f"/ask {question}")
+        timing = ResponseTiming()
+        render = ReplyRenderer(self, timing, prefix="[matrix.accent]ASK>[/matrix.accent]")
+        self.last_code_blocks = render.formatter.code_blocks
+        messages = [{"role": "system", "content": self.conversation.system_prompt + ASK_NOTE},
+                    {"role": "user", "content": question}]
+        try:
+            render(Event(REQUEST, "ask"))
+            reply, _ = collect_stream(self.create_chat_stream(model=model, messages=messages, max_completion_tokens=8000), render)
+            self.transcript.write("NEO", reply)
+            self.remember_reply(reply)
+            timing.finish()
+            self.stats.record(timing, model)
+            if self.config.show_stats:
+                console.print(f"[matrix.dim]⏱ {timing.summary()}{escape(f' · {model}')}[/matrix.dim]")
+            if keep:
+                self.conversation.add_user(question, source="/ask")
+                if reply:
+                    self.conversation.add_assistant(reply, model=model, source="/ask")
+            return {"success": True}
+        except Exception as e:
+            error_msg = scrub(f"Matrix connection lost: {str(e)}")
+            console.print(f"\n[matrix.error]> SYSTEM ERROR: {escape(error_msg)}[/matrix.error]")
+            return {"error": error_msg}
+        finally:
+            terminal.end_stream()
+            self.transcript.end_turn()
+
+    def stream_response(self, user_message: str):
+        started = time.monotonic()
+        changed_before = len(self.stats.files_created | self.stats.files_edited)
+        try:
+            response = self._stream_response(user_message)
+        finally:
+            terminal.end_stream()  # Also when the stream broke off before STREAM_END
+            self.transcript.end_turn()
+            threshold = self.config.notify_after_seconds
+            if threshold and time.monotonic() - started >= threshold:
+                changed = len(self.stats.files_created | self.stats.files_edited) - changed_before
+                notify_finished(f"NEO finished — {changed} file{'s' if changed != 1 else ''} changed" if changed else "NEO finished")
+        # A request that failed before any reply keeps the message in the conversation for /retry
+        unanswered = self.conversation.messages()[-1]
+        failed = response.get("error") and unanswered["role"] == "user" and unanswered["content"] == user_message
+        self.failed_message = user_message if failed else None
+        return response
+
+    def _stream_response(self, user_message: str):
+        self.transcript.begin_turn(self.conversation.turn + 1)  # The turn loop.send starts with the message
+        self.transcript.write("USER", user_message)
+        timing = ResponseTiming()
+        self.stats.turns += 1
+        render = ReplyRenderer(self, timing)
+        self.last_code_blocks = render.formatter.code_blocks
+
+        try:
+            reply = self.loop.send(user_message, render)
+            self.remember_reply(reply)
+
+            timing.finish()
+            route = self.loop.last_route
+            self.stats.record(timing, route.model)
+            self.stats.cached_replies += self.loop.last_cached
+            if self.config.show_stats:
+                # While routing, say which model answered and why
+                handled = f" · {route.model} ({route.reason})" if route.reason else ""
+                handled += " · cached" if self.loop.last_cached else ""
+                handled += f" · via {self.providers.name}" if self.providers.active else ""
+                console.print(f"[matrix.dim]⏱ {timing.summary()}{escape(handled)}{escape(describe_turn(self.conversation))}[/matrix.dim]")
+
+            return {"success": True}
+
+        except AuthenticationError:
+            show_credentials_diagnostic(self.config.provider, self.config.provider_info(), "The provider rejected the API key.", "Check the key and restart neo.")
+            return {"error": "Authentication failed"}
+        except APIConnectionError as e:
+            error_msg = scrub(f"Matrix connection lost: {str(e)} ({describe_route(self.config)})")
+            console.print(f"\n[matrix.error]> SYSTEM ERROR: {escape(error_msg)}[/matrix.error]")
+            return {"error": error_msg}
+        except Exception as e:
+            error_msg = scrub(f"Matrix connection lost: {str(e)}")
+            console.print(f"\n[matrix.error]> SYSTEM ERROR: {escape(error_msg)}[/matrix.error]")
+            return {"error": error_msg}
+        finally:
+            render.stop_progress()  # Leaves cbreak mode however the turn ended
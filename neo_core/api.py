@@ -0,0 +1,80 @@
+"""Neo as a library: the agent loop and file tools, driven by your own program.
+
+    from neo_core.api import Session
+    from neo_core.config import NeoConfig
+
+    session = Session(NeoConfig(provider="mock"), workdir="path/to/project",
+                      approve=lambda action, target: target.endswith(".md"))
+    reply = session.send("Summarize README.md", on_event=lambda event: print(event.kind, event.text))
+
+Events are the ones in neo_core.loop, in the order they happen. File changes are
+passed to 'approve' unless auto_approve is set in the config; without a callback
+they are declined. Nothing is printed unless quiet=False.
+"""
+
+import os
+from contextlib import contextmanager
+from typing import Callable, Iterator, Optional
+
+from neo_core.ai import ChatClient, SYSTEM_PROMPT, build_system_prompt, initialize_ai_client
+from neo_core.config import NeoConfig
+from neo_core.conversation import Conversation
+from neo_core.fileops import Workspace
+from neo_core.loop import AgentLoop, Event
+from neo_core.mock import MockClient, load_fixture
+from neo_core.tools import ToolContext, ToolRegistry, create_default_registry
+from neo_core.ui import console
+
+def decline(action: str, target: str) -> bool:
+    return False
+
+def create_client(config: NeoConfig) -> ChatClient:
+    """The API client for the configured provider; raises ValueError when its API key is not set."""
+    if config.provider == "mock":
+        return MockClient(load_fixture(config.mock_fixture) if config.mock_fixture else [])
+    api_key_env = config.provider_info()["api_key_env"]
+    if api_key_env and not os.getenv(api_key_env):
+        raise ValueError(f"{api_key_env} is not set")
+    return initialize_ai_client(config)
+
+class Session:
+    """One conversation with Neo and its tools, rooted at a workspace directory."""
+
+    def __init__(self, config: Optional[NeoConfig] = None, workdir: str = ".", client: Optional[ChatClient] = None,
+                 approve: Callable[[str, str], bool] = decline, quiet: bool = True):
+        self.config = config or NeoConfig()
+        self.quiet = quiet
+        self.workspace = Workspace(os.path.abspath(workdir), self.config.max_backups, self.config.protected_paths,
+                                   self.config.follow_symlinks, self.config.max_file_size)
+        self.conversation = Conversation(SYSTEM_PROMPT)
+        self.tools: ToolRegistry = create_default_registry(
+            ToolContext(self.workspace, self.config, self.conversation, approve=approve))
+        if self.config.read_only:
+            self.tools.remove_mutating()
+        self.tools.disable(self.config.disabled_tools)
+        self.conversation.set_system_prompt(build_system_prompt(self.tools, read_only=self.config.read_only))
+        self.client = client or create_client(self.config)
+        self.loop = AgentLoop(self.config, self.tools, self.conversation,
+                              lambda **request: self.client.chat.completions.create(stream=True, **request))
+
+    def send(self, message: str, on_event: Optional[Callable[[Event], None]] = None) -> str:
+        """Send a message, run the tools it leads to, and return the reply. API errors are raised."""
+        with self._quiet():
+            return self.loop.send(message, on_event or (lambda event: None))
+
+    def add_file(self, path: str) -> str:
+        """Put a file's content in context, as /add does; returns its normalized path."""
+        normalized_path = self.workspace.normalize_path(path)
+        with self._quiet():
+            self.tools.ctx.files.add(normalized_path)
+        return normalized_path
+
+    @contextmanager
+    def _quiet(self) -> Iterator[None]:
+        """Keep the tools' terminal output (previews, diffs, notices) off the host program's stdout."""
+        previous = console.quiet
+        console.quiet = previous or self.quiet
+        try:
+            yield
+        finally:
+            console.quiet = previous
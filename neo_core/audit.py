@@ -0,0 +1,81 @@
+"""A durable record of every tool call the AI makes, kept in <workspace>/.neo/audit.log."""
+
+import hashlib
+import json
+import os
+import time
+import uuid
+from pathlib import Path
+from typing import Any, Dict, List, Optional
+
+from rich.markup import escape
+
+from neo_core.ui import console
+
+AUDIT_LOG = os.path.join(".neo", "audit.log")  # Relative to the workspace root
+CONTENT_FIELDS = {"content", "original_snippet", "new_snippet"}
+CONTENT_PREVIEW = 80
+
+def summarize_arguments(value: Any) -> Any:
+    """Copy tool arguments with long content fields replaced by their length, hash and a preview."""
+    if isinstance(value, dict):
+        summary = {}
+        for key, item in value.items():
+            if key in CONTENT_FIELDS and isinstance(item, str) and len(item) > CONTENT_PREVIEW:
+                summary[key] = {
+                    "length": len(item),
+                    "sha256": hashlib.sha256(item.encode("utf-8")).hexdigest(),
+                    "preview": item[:CONTENT_PREVIEW],
+                }
+            else:
+                summary[key] = summarize_arguments(item)
+        return summary
+    if isinstance(value, list):
+        return [summarize_arguments(item) for item in value]
+    return value
+
+class AuditLog:
+    """Appends one JSON line per tool call. Failing to write never fails the tool call."""
+
+    def __init__(self, workspace_root: str, session_id: Optional[str] = None, enabled: bool = True):
+        self.path = Path(workspace_root, AUDIT_LOG)
+        self.enabled = enabled  # Off with --read-only, as the log lives in the workspace
+        self.session_id = session_id or uuid.uuid4().hex[:12]
+        self._warned = False
+
+    def record(self, tool: str, arguments: Any, status: str, bytes_written: int = 0,
+               approval: Optional[str] = None) -> None:
+        entry = {
+            "ts": time.time(),
+            "session": self.session_id,
+            "tool": tool,
+            "arguments": summarize_arguments(arguments),
+            "status": status,
+            "bytes_written": bytes_written,
+            "approval": approval,
+        }
+        if not self.enabled:
+            return
+        try:
+            self.path.parent.mkdir(parents=True, exist_ok=True)
+            with open(self.path, "a", encoding="utf-8") as f:
+                f.write(json.dumps(entry, default=str) + "\n")
+        except OSError as e:
+            if not self._warned:
+                console.print(f"[matrix.warning]⚠ Could not write the audit log {escape(str(self.path))}: {escape(str(e))}. Continuing without it.[/matrix.warning]")
+                self._warned = True
+
+    def entries(self, limit: int = 10, this_session: bool = True) -> List[Dict[str, Any]]:
+        """The last 'limit' entries, oldest first; unreadable lines are skipped."""
+        if not self.path.is_file():
+            return []
+        entries = []
+        with open(self.path, "r", encoding="utf-8") as f:
+            for line in f:
+                try:
+                    entry = json.loads(line)
+                except json.JSONDecodeError:
+                    continue
+                if not this_session or entry.get("session") == self.session_id:
+                    entries.append(entry)
+        return entries[-limit:]
@@ -0,0 +1,62 @@
+"""Named branches of the conversation (/branch).
+
+There is one Conversation object, shared by the agent and the tools; a branch
+switch swaps its contents. Branches that are not active are kept as snapshots in
+the session format, so saving them with the session is just a matter of including
+them.
+"""
+
+import re
+from typing import Any, Dict, List, Tuple
+
+from neo_core.context import ContextFiles
+from neo_core.conversation import Conversation
+
+BRANCH_NAME_RE = re.compile(r"^[A-Za-z0-9._-]{1,64}$")
+MAIN_BRANCH = "main"
+
+class Branches:
+    def __init__(self, conversation: Conversation, files: ContextFiles):
+        self.conversation = conversation
+        self.files = files
+        self.active = MAIN_BRANCH
+        self.inactive: Dict[str, Dict[str, Any]] = {}  # Name -> Conversation.snapshot()
+
+    def names(self) -> List[str]:
+        return [self.active] + sorted(self.inactive)
+
+    def summary(self) -> List[Tuple[str, int, bool]]:
+        """(name, message count, whether active) for every branch, the active one first."""
+        rows = [(self.active, len(self.conversation), True)]
+        rows += [(name, len(snapshot["messages"]), False) for name, snapshot in sorted(self.inactive.items())]
+        return rows
+
+    def create(self, name: str) -> None:
+        """Copy the active branch to a new one and switch to it."""
+        if not BRANCH_NAME_RE.match(name):
+            raise ValueError(f"branch names may only use letters, digits, '.', '_' and '-': {name!r}")
+        if name in self.names():
+            raise ValueError(f"branch '{name}' already exists; use /branch switch {name}")
+        self.inactive[self.active] = self.conversation.snapshot()
+        self.active = name
+
+    def switch(self, name: str) -> None:
+        """Make another branch active, keeping the one being left as it is."""
+        if name == self.active:
+            return
+        if name not in self.inactive:
+            raise ValueError(f"no branch named '{name}' (have: {', '.join(self.names())})")
+        self.inactive[self.active] = self.conversation.snapshot()
+        self.conversation.restore(self.inactive.pop(name))
+        self.active = name
+        self.files.adopt()
+
+    def state(self, all_branches: bool = True) -> Dict[str, Any]:
+        """Session state for save_session; without 'all_branches' only the active branch's name is kept."""
+        return {"branch": self.active, "branches": self.inactive if all_branches else {}}
+
+    def restore(self, session: Dict[str, Any]) -> None:
+        """Take the branches back from a loaded session; the active one is already in the conversation."""
+        self.active = session.get("branch") or MAIN_BRANCH
+        self.inactive = dict(session.get("branches") or {})
+        self.inactive.pop(self.active, None)
@@ -0,0 +1,69 @@
+"""Replaying answers to repeated questions (response_cache, /cache).
+
+A turn is keyed on a hash of everything the first request of it sends: the
+provider, the model, the tool definitions and the full message list, serialized
+with sorted keys so the same conversation always hashes the same. What is stored
+is the reply, the read-only tool calls it made with their results, and the
+follow-up, so a hit rebuilds the conversation as the original turn did. Turns
+that ran tools able to change files are never stored.
+"""
+
+import hashlib
+import json
+import os
+import time
+from pathlib import Path
+from typing import Any, Dict, List, Optional
+
+from neo_core.config import CACHE_DIR
+
+RESPONSES_DIR = CACHE_DIR / "responses"
+
+def request_key(provider: str, model: str, messages: List[Dict[str, Any]], tools: List[Dict[str, Any]],
+                settings: Dict[str, Any]) -> str:
+    payload = {"provider": provider, "model": model, "messages": messages, "tools": tools, "settings": settings}
+    return hashlib.sha256(json.dumps(payload, sort_keys=True, ensure_ascii=False, separators=(",", ":")).encode("utf-8")).hexdigest()
+
+class ResponseCache:
+    """Cached turns as one JSON file each, named by key."""
+
+    def __init__(self, directory: Path = RESPONSES_DIR):
+        self.directory = directory
+
+    def _path(self, key: str) -> Path:
+        return self.directory / f"{key}.json"
+
+    def get(self, key: str, ttl: float) -> Optional[Dict[str, Any]]:
+        """The turn stored under 'key' if it is younger than 'ttl' seconds; an expired one is deleted."""
+        path = self._path(key)
+        try:
+            with open(path, "r", encoding="utf-8") as f:
+                entry = json.load(f)
+        except (OSError, ValueError):
+            return None
+        if time.time() - entry.get("saved_at", 0) > ttl:
+            path.unlink(missing_ok=True)
+            return None
+        return entry
+
+    def put(self, key: str, entry: Dict[str, Any]) -> None:
+        """Store a turn; a cache that can't be written only costs the next request."""
+        try:
+            self.directory.mkdir(parents=True, exist_ok=True)
+            temp = self._path(key).with_suffix(".tmp")
+            with open(temp, "w", encoding="utf-8") as f:
+                json.dump({"saved_at": time.time(), **entry}, f)
+            os.replace(temp, self._path(key))
+        except OSError:
+            pass
+
+    def entries(self) -> int:
+        return len(list(self.directory.glob("*.json"))) if self.directory.is_dir() else 0
+
+    def clear(self) -> int:
+        """Delete every cached turn; returns how many there were."""
+        removed = 0
+        for path in self.directory.glob("*.json") if self.directory.is_dir() else []:
+            path.unlink(missing_ok=True)
+            removed += 1
+        return removed
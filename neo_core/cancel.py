@@ -0,0 +1,41 @@
+"""Stopping tool calls the user gave up on, with Ctrl+C or Esc while they run.
+
+ToolRegistry.execute_all hands each response's calls a fresh CancelToken in
+ToolContext.cancel and cancels it when the user asks. Loops that can run long,
+like streaming a huge file's lines or waiting on an MCP server, call check() and
+stop with Cancelled. A tool that never checks is not interrupted; its result is
+just no longer waited for. Either way the model gets CANCELLED_RESULT for it.
+"""
+
+import threading
+from typing import Iterable, Iterator, Optional, TypeVar
+
+CANCELLED_RESULT = ("Cancelled by the user before it finished, so there is no result. Don't call it again unless "
+                    "the user asks; continue without it, or ask the user how to go on.")
+
+T = TypeVar("T")
+
+class Cancelled(Exception):
+    """The user cancelled the tool call that was running."""
+
+class CancelToken:
+    def __init__(self) -> None:
+        self._event = threading.Event()
+
+    def cancel(self) -> None:
+        self._event.set()
+
+    @property
+    def cancelled(self) -> bool:
+        return self._event.is_set()
+
+    def check(self) -> None:
+        if self._event.is_set():
+            raise Cancelled()
+
+def checked(items: Iterable[T], cancel: Optional[CancelToken]) -> Iterator[T]:
+    """'items', raising Cancelled before the next one once 'cancel' is cancelled."""
+    for item in items:
+        if cancel is not None:
+            cancel.check()
+        yield item
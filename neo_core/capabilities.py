@@ -0,0 +1,109 @@
+"""What each model can do, so a model without tool calling or streaming still works.
+
+MODEL_CAPABILITIES lists the models Neo knows; config.model_capabilities overrides
+it or adds others, e.g. {"my-local-model": {"tools": false}}. A model in neither
+is assumed to support both. When a request is rejected because the model can't
+take tools or stream, that is remembered for the session and the request is sent
+again without them. Agent.create_chat_stream applies all of this to every request:
+without tools the tool definitions are left out and NO_TOOLS_NOTE tells the model
+it can't touch files; without streaming the whole reply is fetched at once and fed
+to the renderer as if it had streamed.
+"""
+
+import re
+from dataclasses import dataclass, replace
+from textwrap import dedent
+from types import SimpleNamespace
+from typing import Any, Dict, Iterable, Optional
+
+from neo_core.config import NeoConfig
+
+NO_TOOLS_NOTE = dedent("""\
+
+    This model can't call tools, so none of the tools above are available: you can't read, create,
+    edit or delete files, nor run commands. Work from the files and text in the conversation; when a
+    change is needed, show it as a diff or a complete file for the user to apply, and don't say you made it.
+""")
+
+UNSUPPORTED_RE = re.compile(r"not support|unsupported|not available|not allowed", re.IGNORECASE)
+FEATURE_RE = {
+    "tools": re.compile(r"\btools?\b|tool_choice|function[ _]?call", re.IGNORECASE),
+    "streaming": re.compile(r"\bstream", re.IGNORECASE),
+}
+
+@dataclass(frozen=True)
+class Capabilities:
+    tools: bool = True
+    streaming: bool = True
+
+MODEL_CAPABILITIES: Dict[str, Capabilities] = {
+    "deepseek-chat": Capabilities(),
+    "deepseek-reasoner": Capabilities(),
+    "mock": Capabilities(),
+}
+
+class ModelCapabilities:
+    """The table and config overrides, plus what requests rejected this session showed."""
+
+    def __init__(self, config: NeoConfig):
+        self.config = config
+        self.learned: Dict[str, Capabilities] = {}  # Model -> capabilities found lacking by a rejected request
+
+    def of(self, model: str) -> Capabilities:
+        if model in self.learned:
+            return self.learned[model]
+        capabilities = MODEL_CAPABILITIES.get(model, Capabilities())
+        return replace(capabilities, **self.config.model_capabilities.get(model, {}))
+
+    def learn(self, model: str, feature: str) -> None:
+        self.learned[model] = replace(self.of(model), **{feature: False})
+
+def rejected_feature(error: Exception, request: Dict[str, Any], streaming: bool) -> Optional[str]:
+    """The feature a 400 response blames, "tools" or "streaming", if the request used it; else None."""
+    if getattr(error, "status_code", None) != 400:
+        return None
+    message = str(getattr(error, "message", None) or error)
+    if not UNSUPPORTED_RE.search(message):
+        return None
+    if request.get("tools") and FEATURE_RE["tools"].search(message):
+        return "tools"
+    if streaming and FEATURE_RE["streaming"].search(message):
+        return "streaming"
+    return None
+
+def without_tools(request: Dict[str, Any]) -> Dict[str, Any]:
+    """'request' with no tool definitions and NO_TOOLS_NOTE added to its system prompt."""
+    request = {key: value for key, value in request.items() if key not in ("tools", "tool_choice")}
+    messages = list(request.get("messages", []))
+    if messages and messages[0].get("role") == "system" and NO_TOOLS_NOTE not in (messages[0].get("content") or ""):
+        messages[0] = {**messages[0], "content": (messages[0].get("content") or "") + NO_TOOLS_NOTE}
+    request["messages"] = messages
+    return request
+
+def completion_chunks(completion: Any) -> Iterable[Any]:
+    """A non-streamed completion as the chunks a stream of it would have had, for collect_stream."""
+    message = completion.choices[0].message
+
+    def chunk(**delta: Any) -> Any:
+        fields = {"content": None, "reasoning_content": None, "tool_calls": None, **delta}
+        return SimpleNamespace(choices=[SimpleNamespace(delta=SimpleNamespace(**fields))])
+
+    if getattr(message, "reasoning_content", None):
+        yield chunk(reasoning_content=message.reasoning_content)
+    if message.content:
+        yield chunk(content=message.content)
+    if message.tool_calls:
+        yield chunk(tool_calls=[
+            SimpleNamespace(index=i, id=tool_call.id,
+                            function=SimpleNamespace(name=tool_call.function.name, arguments=tool_call.function.arguments))
+            for i, tool_call in enumerate(message.tool_calls)])
+
+def describe_limits(model: str, capabilities: Capabilities) -> Optional[str]:
+    """A notice of what 'model' can't do, or None if it can do everything."""
+    notes = []
+    if not capabilities.tools:
+        notes.append("it can't call tools, so file reads, edits and other tool operations are unavailable; "
+                     "it can still see files you /add")
+    if not capabilities.streaming:
+        notes.append("it can't stream, so each reply appears when it is complete")
+    return f"{model}: {'; '.join(notes)}." if notes else None
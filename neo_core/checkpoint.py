@@ -0,0 +1,126 @@
+"""Named checkpoints of the conversation and the files changed since (/checkpoint, /rollback).
+
+A checkpoint holds a snapshot of the conversation and, for each file written after
+it was taken, a pre-image: a copy made just before the first write, kept in a
+backup store of its own under <workspace>/.neo/checkpoints/<name>. A file that did
+not exist yet has no pre-image; rolling back deletes it.
+"""
+
+import os
+import re
+import shutil
+from dataclasses import dataclass, field
+from datetime import datetime
+from typing import Any, Dict, List, Optional, Tuple
+
+from neo_core.context import ContextFiles
+from neo_core.conversation import Conversation
+from neo_core.fileops import BackupStore, Workspace, read_text
+
+CHECKPOINT_DIR = os.path.join(".neo", "checkpoints")  # Relative to the workspace root
+CHECKPOINT_NAME_RE = re.compile(r"^[A-Za-z0-9._-]{1,64}$")
+
+@dataclass
+class Checkpoint:
+    name: str
+    created: datetime
+    snapshot: Dict[str, Any]  # Conversation.snapshot()
+    store: BackupStore
+    preimages: Dict[str, Optional[str]] = field(default_factory=dict)  # Path -> pre-image, or None if it was created
+    unsaved: Dict[str, str] = field(default_factory=dict)  # Path -> why no pre-image could be made
+
+    @property
+    def messages(self) -> int:
+        return len(self.snapshot["messages"])
+
+class Checkpoints:
+    """The session's checkpoints, oldest first. Pre-images are recorded through Workspace.write_listeners."""
+
+    def __init__(self, workspace: Workspace, conversation: Conversation, files: ContextFiles):
+        self.workspace = workspace
+        self.conversation = conversation
+        self.files = files
+        self.checkpoints: List[Checkpoint] = []
+        workspace.write_listeners.append(self.before_write)
+
+    def get(self, name: str) -> Optional[Checkpoint]:
+        return next((c for c in self.checkpoints if c.name == name), None)
+
+    def next_name(self) -> str:
+        n = len(self.checkpoints) + 1
+        while self.get(f"cp{n}"):
+            n += 1
+        return f"cp{n}"
+
+    def create(self, name: Optional[str] = None) -> Checkpoint:
+        """Take a checkpoint, replacing any earlier one with the same name."""
+        name = name or self.next_name()
+        if not CHECKPOINT_NAME_RE.match(name):
+            raise ValueError(f"checkpoint names may only use letters, digits, '.', '_' and '-': {name!r}")
+        if self.get(name):
+            self.discard(name)
+        store = BackupStore(self.workspace.root, keep=1, directory=os.path.join(CHECKPOINT_DIR, name))
+        if not self.workspace.read_only:  # Nothing is written or kept then, so there is nothing to clear
+            shutil.rmtree(store.root, ignore_errors=True)  # Left over from an earlier session
+        checkpoint = Checkpoint(name, datetime.now(), self.conversation.snapshot(), store)
+        self.checkpoints.append(checkpoint)
+        return checkpoint
+
+    def before_write(self, normalized_path: str) -> None:
+        """Save a pre-image of a file for every checkpoint that has not seen it written yet."""
+        for checkpoint in self.checkpoints:
+            if normalized_path in checkpoint.preimages or normalized_path in checkpoint.unsaved:
+                continue
+            if not os.path.isfile(normalized_path):
+                checkpoint.preimages[normalized_path] = None
+                continue
+            try:
+                checkpoint.preimages[normalized_path] = checkpoint.store.save(normalized_path, self.workspace.max_file_size)
+            except OSError as e:
+                checkpoint.unsaved[normalized_path] = str(e)  # Never block the write itself
+
+    def changes(self, checkpoint: Checkpoint) -> List[Tuple[str, str]]:
+        """(path, "restore" or "delete") for the files a rollback would put back."""
+        changes = []
+        for path, preimage in sorted(checkpoint.preimages.items()):
+            if preimage is None:
+                if os.path.exists(path):
+                    changes.append((path, "delete"))
+            else:
+                changes.append((path, "restore"))
+        return changes
+
+    def rollback(self, checkpoint: Checkpoint, restore_files: bool) -> List[Tuple[str, str]]:
+        """Return the conversation to the checkpoint and, with 'restore_files', the files too.
+
+        Checkpoints taken after this one are discarded. Returns the file changes made.
+        """
+        done = []
+        if restore_files:
+            for path, action in self.changes(checkpoint):
+                if action == "delete":
+                    self.workspace.check_writable(path)
+                    self.workspace.backups.save(path, self.workspace.max_file_size)  # Still recoverable with /restore
+                    os.remove(path)
+                else:
+                    content, encoding = read_text(checkpoint.preimages[path])
+                    self.workspace.encodings[path] = encoding
+                    self.workspace.create_file(path, content)
+                done.append((path, action))
+            checkpoint.preimages.clear()
+            checkpoint.unsaved.clear()
+        self.conversation.restore(checkpoint.snapshot)
+        self.files.adopt()
+        for later in self.checkpoints[self.checkpoints.index(checkpoint) + 1:]:
+            self.discard(later.name)
+        return done
+
+    def discard(self, name: str) -> None:
+        checkpoint = self.get(name)
+        if checkpoint:
+            self.checkpoints.remove(checkpoint)
+            shutil.rmtree(checkpoint.store.root, ignore_errors=True)
+
+    def discard_all(self) -> None:
+        for checkpoint in list(self.checkpoints):
+            self.discard(checkpoint.name)
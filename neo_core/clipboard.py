@@ -0,0 +1,80 @@
+"""System clipboard access through the platform's command-line tools.
+
+Copying falls back to an OSC 52 escape sequence, which most terminals honour
+even over SSH. Reading has no such fallback and needs a local clipboard tool.
+"""
+
+import base64
+import os
+import re
+import shutil
+import subprocess
+import sys
+from typing import List, Optional
+
+from neo_core.redact import child_env
+
+CODE_BLOCK_RE = re.compile(r"```[^\n]*\n(.*?)```", re.DOTALL)
+
+class ClipboardError(Exception):
+    """No clipboard is reachable from this session."""
+
+COPY_COMMANDS = [
+    ["pbcopy"],
+    ["wl-copy"],
+    ["xclip", "-selection", "clipboard"],
+    ["xsel", "--clipboard", "--input"],
+    ["clip.exe"],
+]
+
+PASTE_COMMANDS = [
+    ["pbpaste"],
+    ["wl-paste", "--no-newline"],
+    ["xclip", "-selection", "clipboard", "-o"],
+    ["xsel", "--clipboard", "--output"],
+    ["powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"],
+]
+
+def _available(commands: List[List[str]]) -> Optional[List[str]]:
+    for command in commands:
+        if command[0] in ("wl-copy", "wl-paste") and not os.getenv("WAYLAND_DISPLAY"):
+            continue
+        if command[0] in ("xclip", "xsel") and not os.getenv("DISPLAY"):
+            continue
+        if shutil.which(command[0]):
+            return command
+    return None
+
+def in_ssh_session() -> bool:
+    return bool(os.getenv("SSH_TTY") or os.getenv("SSH_CONNECTION"))
+
+def copy_text(text: str) -> str:
+    """Put text on the clipboard and return how it was done."""
+    command = None if in_ssh_session() else _available(COPY_COMMANDS)
+    if command:
+        try:
+            subprocess.run(command, input=text.encode("utf-8"), check=True, timeout=5, env=child_env())
+            return command[0]
+        except (OSError, subprocess.SubprocessError):
+            pass
+    if sys.stdout.isatty():
+        # OSC 52: the terminal itself sets the clipboard, which also works over SSH
+        sys.stdout.write(f"\033]52;c;{base64.b64encode(text.encode('utf-8')).decode('ascii')}\a")
+        sys.stdout.flush()
+        return "OSC 52 terminal escape"
+    raise ClipboardError("no clipboard tool found (install xclip, xsel or wl-clipboard) and stdout is not a terminal")
+
+def paste_text() -> str:
+    command = _available(PASTE_COMMANDS)
+    if command is None:
+        hint = " Over SSH, paste the text into the prompt instead." if in_ssh_session() else ""
+        raise ClipboardError(f"no clipboard tool found to read from (install xclip, xsel or wl-clipboard).{hint}")
+    try:
+        completed = subprocess.run(command, capture_output=True, check=True, timeout=5, env=child_env())
+    except (OSError, subprocess.SubprocessError) as e:
+        raise ClipboardError(f"{command[0]} could not read the clipboard: {e}")
+    return completed.stdout.decode("utf-8", errors="replace")
+
+def last_code_block(text: str) -> Optional[str]:
+    blocks = CODE_BLOCK_RE.findall(text or "")
+    return blocks[-1] if blocks else None
@@ -0,0 +1,110 @@
+"""Guessing the language of a code block whose fence doesn't name one.
+
+The stream formatter holds back the first lines of a bare ``` block and asks
+guess_language after each one. A shebang, "package main" or a JSON document
+settles it at once; otherwise every language in SIGNALS scores the lines it
+finds its patterns in, and the best score wins when it is high enough and
+clearly ahead of the next. Braces, semicolons and trailing colons add a little
+to the languages that use them. Anything less certain stays "text".
+"""
+
+import json
+import re
+from typing import Dict, List, Optional, Pattern, Tuple
+
+GUESS_LINES = 3  # Non-blank lines held back before giving up and printing the block as "text"
+MIN_SCORE = 2.0
+MIN_LEAD = 1.5  # The best score must be this many times the runner-up's
+
+SHEBANG_RE = re.compile(r"^#!\s*(?:/usr)?(?:/local)?/bin/(?:env\s+)?(?P<program>[\w.+-]+)")
+SHEBANG_LANGUAGES = {"sh": "sh", "bash": "bash", "zsh": "zsh", "dash": "sh", "python": "python", "python3": "python",
+                     "node": "javascript", "deno": "typescript", "ruby": "ruby", "perl": "perl"}
+
+# (pattern, weight) per language; a pattern counts once per line it matches
+SIGNALS: Dict[str, List[Tuple[Pattern[str], float]]] = {
+    "go": [
+        (re.compile(r"^package \w+$"), 3),
+        (re.compile(r"^func (\(\w+ \*?\w+\) )?\w+\(.*\)"), 2),
+        (re.compile(r"^import \($|^import \"[\w./-]+\"$"), 2),
+        (re.compile(r"\w+ := "), 1),
+        (re.compile(r"\bfmt\.\w+\(|\bif err != nil\b|\bdefer \w"), 2),
+        (re.compile(r"^type \w+ (struct|interface) \{"), 2),
+    ],
+    "python": [
+        (re.compile(r"^(async )?def \w+\(.*\)( -> .+)?:$"), 3),
+        (re.compile(r"^class \w+(\(.*\))?:$"), 2),
+        (re.compile(r"^from [\w.]+ import |^import [\w.]+( as \w+)?$"), 2),
+        (re.compile(r"\bself\.\w+|\belif\b|\bNone\b|\bTrue\b|\bFalse\b"), 1),
+        (re.compile(r"^if __name__ == [\"']__main__[\"']:"), 3),
+        (re.compile(r"^\s*(for \w+ in .+|while .+|if .+|else|try|except.*|with .+):$"), 1),
+        (re.compile(r"\bprint\(f?[\"']"), 1),
+    ],
+    "javascript": [
+        (re.compile(r"\bfunction\s*\w*\s*\("), 2),
+        (re.compile(r"^(const|let|var) \w+ = "), 2),
+        (re.compile(r"=> ?[{(]?|\bconsole\.\w+\("), 1.5),
+        (re.compile(r"\brequire\([\"']|^import .+ from [\"']|^export (default |const |function )"), 2),
+        (re.compile(r"===|!==|\bundefined\b|\bdocument\.|\bwindow\."), 1),
+    ],
+    "bash": [
+        (re.compile(r"^\$ "), 2),
+        (re.compile(r"^(sudo |apt(-get)? |brew |npm |npx |yarn |pip3? |go (build|run|test|get|mod) |git |cd |ls\b|mkdir |rm |cp |mv "
+                    r"|curl |wget |chmod |docker |kubectl |make\b|cat |echo |export [A-Z_]+=|source )"), 1.5),
+        (re.compile(r"^(if \[|fi$|then$|do$|done$|esac$)|\$\{\w+|\$\(\w"), 2),
+        (re.compile(r"\s\|\s*(grep|awk|sed|xargs|sort|head|tail)\b|\s&&\s|\s2>&1\b"), 1.5),
+    ],
+}
+# Punctuation adds a little: line-ending braces and semicolons to these, a trailing colon to Python
+BRACE_LANGUAGES = ("go", "javascript")
+SEMICOLON_LANGUAGES = ("javascript",)
+
+def _json_document(text: str) -> bool:
+    stripped = text.strip()
+    if not stripped or stripped[0] not in "{[":
+        return False
+    try:
+        json.loads(stripped)
+        return True
+    except ValueError:
+        pass
+    # Not complete yet: past the opening line, keys in quotes mapped to values and nothing that belongs to code
+    lines = [line.strip() for line in stripped.splitlines()[1:] if line.strip()]
+    return bool(lines) and all(re.match(r'^("[^"]*"\s*:\s*.+|[\]}],?|"[^"]*",?|-?[\d.]+,?|true,?|false,?|null,?|[{\[])$', line)
+                               for line in lines)
+
+def scores(lines: List[str]) -> Dict[str, float]:
+    """Each language's score over 'lines'."""
+    totals = {language: 0.0 for language in SIGNALS}
+    for raw in lines:
+        line = raw.strip()
+        if not line:
+            continue
+        for language, signals in SIGNALS.items():
+            for pattern, weight in signals:
+                if pattern.search(line):
+                    totals[language] += weight
+        if line.endswith(("{", "}")) or line in ("}", "})", "});"):
+            for language in BRACE_LANGUAGES:
+                totals[language] += 0.5
+        if line.endswith(";"):
+            for language in SEMICOLON_LANGUAGES:
+                totals[language] += 0.5
+        if line.endswith(":") and not line.startswith(("case ", "default")):
+            totals["python"] += 0.5
+    return totals
+
+def guess_language(lines: List[str]) -> Optional[str]:
+    """The language of a block starting with 'lines', or None while it is unclear."""
+    text = "\n".join(lines)
+    first = next((line.strip() for line in lines if line.strip()), "")
+    match = SHEBANG_RE.match(first)
+    if match:
+        program = match.group("program")  # "python3.11" is python
+        return SHEBANG_LANGUAGES.get(program) or SHEBANG_LANGUAGES.get(re.sub(r"[\d.]+$", "", program))
+    if _json_document(text):
+        return "json"
+    totals = sorted(scores(lines).items(), key=lambda item: -item[1])
+    (best, best_score), (_, second_score) = totals[0], totals[1]
+    if best_score >= MIN_SCORE and best_score >= MIN_LEAD * second_score:
+        return best
+    return None
@@ -0,0 +1,2443 @@
+"""Slash commands and the interactive prompt loop."""
+
+import itertools
+import json
+import os
+import re
+import threading
+import time
+from typing import Any, Dict, List, Optional, Set, Tuple
+
+from prompt_toolkit.formatted_text import FormattedText
+from prompt_toolkit.patch_stdout import patch_stdout
+from rich.markup import escape
+from rich.panel import Panel
+from rich.table import Table
+
+from neo_core.ai import SESSIONS_DIR, Agent, DebugLogger, SessionInfo, list_sessions, load_session, save_session
+from neo_core.branch import MAIN_BRANCH, Branches
+from neo_core.capabilities import describe_limits
+from neo_core.checkpoint import Checkpoints
+from neo_core.clipboard import ClipboardError, copy_text, last_code_block, paste_text
+from neo_core.config import LIMIT_RANGES, NeoConfig, limit_error
+from neo_core.context import ContextFiles
+from neo_core.contextcheck import ContextCheck
+from neo_core.doctor import check_config_file, run_checks, show_checks
+from neo_core.conversation import Conversation, describe_origin
+from neo_core.fileops import FileTooLargeError, ScanOptions, ScanResult, SkippedFile, Workspace, format_size, parse_size
+from neo_core.fetch import FetchError, fetch_url, is_url
+from neo_core.gitops import GitError, Repository
+from neo_core.hints import Hints, TurnFacts
+from neo_core.hooks import HookError, rewritten_message, run_hook
+from neo_core.loop import collect_stream
+from neo_core.mentions import mentioned_files
+from neo_core.pager import Pager
+from neo_core.patch import SessionChanges
+from neo_core.preset import PRESET_PATH, Preset, PresetError, is_trusted, load_preset, strip_prompt, trust
+from neo_core.prompts import PromptLibrary, expand_template
+from neo_core.redact import PLACEHOLDER_RE, child_env
+from neo_core.review import findings_json, review_diff, show_findings
+from neo_core.routing import AUTO
+from neo_core.search import CURRENT, SearchHit, compile_query, search_messages, search_session, session_files
+from neo_core.stats import SessionStats, describe_age, describe_time, gap_separator
+from neo_core.title import SessionTitle
+from neo_core.tokens import active_counter, estimate_conversation, estimate_message, estimate_string, use_model
+from neo_core.tools import ToolRegistry
+from neo_core.truncate import STRATEGIES, Truncation
+from neo_core.ui import (
+    NORMAL, QUIET, console, confirm_file_change, decorate, prompt_session, display_matrix_exit, set_verbosity, show_file_preview,
+    show_unified_diff,
+)
+from neo_core.validate import ValidationResult, report_for_model, run_validation
+from neo_core.watch import FileWatcher
+
+class CommandContext:
+    """Everything the slash commands operate on."""
+
+    def __init__(self, agent: Agent, workspace: Workspace, tools: ToolRegistry, debug_log: DebugLogger):
+        self.agent = agent
+        self.workspace = workspace
+        self.tools = tools
+        self.debug_log = debug_log
+        self.watcher = FileWatcher(tools.ctx.files, self.watch_notice)
+        self.checkpoints = Checkpoints(workspace, agent.conversation, tools.ctx.files)
+        self.branches = Branches(agent.conversation, tools.ctx.files)
+        self.changes = SessionChanges(workspace)
+        self.prompts = PromptLibrary(workspace.root)
+        self.large_requests_ok = False  # "Don't ask again" for this session's large requests
+        self.context_check = ContextCheck()  # Large and unused files in context, flagged before sending
+        self.validating = bool(agent.config.validate_command) and not agent.config.read_only  # /validate on|off
+        self.validation_report: Optional[str] = None  # A failure the model has not seen yet, sent with the next message
+        self.last_validation: Optional[ValidationResult] = None  # Since the last message, for the hints
+        self.hints = Hints()
+        self.prompting = False  # Whether the prompt is showing, so notices can be printed above it
+        self.config_path: Optional[str] = None  # --config, so /doctor checks the file the session loaded
+        self.preset: Optional[Preset] = None  # The .neo/project.toml applied, see /project
+        self.search_results: List[SearchHit] = []  # The last /search, for /search --load <n>
+        self.model_pinned = False  # --model was given, so the preset's model doesn't replace it
+        self.title = SessionTitle()  # Saved with the session, shown by /sessions; see /title
+        self.declined_mentions: Set[str] = set()  # Files named in a message that you chose not to add, not offered again
+        self.before_preset = (agent.config.model, agent.config.validate_command)  # Restored when a preset stops setting them
+        self._notices: List[str] = []
+        self._notices_lock = threading.Lock()
+
+    def watch_notice(self, message: str) -> None:
+        """Show a /watch notice now if the prompt is up, else once the current response is done."""
+        with self._notices_lock:
+            self._notices.append(message)
+        if self.prompting:
+            self.flush_notices()
+
+    def flush_notices(self) -> None:
+        with self._notices_lock:
+            notices, self._notices = self._notices, []
+        for message in notices:
+            console.print(f"[matrix.dim]↻ {message}[/matrix.dim]")
+
+    @property
+    def conversation(self) -> Conversation:
+        return self.agent.conversation
+
+    @property
+    def files(self) -> ContextFiles:
+        return self.tools.ctx.files
+
+ADD_USAGE = "/add <path>|<url>|clipboard [--outline] [--chunks] [--verbose] [--depth N] [--max-files N] [--max-size SIZE] [--truncate head|head-tail|outline|skip]"
+
+class AliasError(ValueError):
+    """An alias that expands back into itself through other aliases, or into an exit command."""
+
+MAX_ALIAS_EXPANSIONS = 10
+EXIT_COMMANDS = ("exit", "quit", "/exit", "/quit")  # Only as typed at the prompt, never from an alias
+
+def is_exit_command(typed: str) -> bool:
+    return typed.strip().lower() in EXIT_COMMANDS
+
+def expand_alias(aliases: Dict[str, str], user_input: str) -> str:
+    """Replace a leading "/name" alias with its command, keeping whatever follows it.
+
+    An alias may expand to another alias. One that expands to its own name runs the
+    command of that name instead (so "add" can mean "/add --outline"); any other cycle
+    is an error, and so is one that expands to an exit command, which must be typed.
+    """
+    by_name = {"/" + name.lstrip("/"): command.strip() for name, command in aliases.items()}
+    seen: List[str] = []
+    while True:
+        word, _, rest = user_input.strip().partition(" ")
+        if word not in by_name:
+            return user_input
+        if word in seen:
+            if word == seen[-1]:
+                return user_input  # Self-reference: the built-in command of the same name
+            raise AliasError(f"alias loop: {' -> '.join(seen + [word])}")
+        if len(seen) >= MAX_ALIAS_EXPANSIONS:
+            raise AliasError(f"aliases nest more than {MAX_ALIAS_EXPANSIONS} deep: {' -> '.join(seen)}")
+        seen.append(word)
+        user_input = f"{by_name[word]} {rest}".strip()
+        if user_input.split()[0].lower() in EXIT_COMMANDS:
+            raise AliasError(f"alias {' -> '.join(seen)} expands to {user_input.split()[0]}; type /exit to end the session")
+
+def describe_aliases(aliases: Dict[str, str]) -> str:
+    return " | ".join(f"/{name.lstrip('/')} (alias: {command})" for name, command in sorted(aliases.items()))
+
+def parse_add_arguments(text: str, config: Optional[NeoConfig] = None) -> Tuple[str, ScanOptions, bool, bool, bool]:
+    """Split '/add' arguments into the path, the scan limits, --outline, --chunks and --verbose; raises ValueError on bad flags.
+
+    The limits and the truncation strategy start from the config's; the flags override them.
+    """
+    options = ScanOptions()
+    if config:
+        options.truncate, options.truncate_lines = config.truncate_strategy, config.truncate_lines
+        options.max_files, options.max_file_size = config.max_scan_files, config.max_file_size
+    outline = chunks = verbose = False
+    path_words = []
+    words = text.split()
+    while words:
+        word = words.pop(0)
+        if word == "--outline":
+            outline = True
+            continue
+        if word == "--chunks":
+            chunks = True
+            continue
+        if word == "--verbose":
+            verbose = True
+            continue
+        if word not in ("--depth", "--max-files", "--max-size", "--truncate"):
+            path_words.append(word)
+            continue
+        if not words:
+            raise ValueError(f"{word} needs a value")
+        value = words.pop(0)
+        if word == "--truncate":
+            if value not in STRATEGIES:
+                raise ValueError(f"--truncate must be one of {', '.join(STRATEGIES)}, got {value!r}")
+            options.truncate = value
+            continue
+        if word == "--max-size":
+            options.max_file_size = parse_size(value)
+            continue
+        if not value.isdigit() or int(value) < 1:
+            raise ValueError(f"{word} must be a positive whole number, got {value!r}")
+        if word == "--depth":
+            options.max_depth = int(value)
+        else:
+            options.max_files = int(value)
+    if not path_words:
+        raise ValueError("no path given")
+    if outline and chunks:
+        raise ValueError("--outline and --chunks can't be used together")
+    return " ".join(path_words), options, outline, chunks, verbose
+
+def add_file_to_conversation(ctx: CommandContext, normalized_path: str, content: str, outline: bool,
+                             truncation: Optional[Truncation] = None, preset: bool = False, origin: str = "add") -> Tuple[int, int]:
+    """Add a file, its outline or its truncated copy; returns the estimated tokens of the content read and of what was added."""
+    added = ctx.files.add(normalized_path, content, outline and not truncation, truncation=truncation, preset=preset, origin=origin)
+    ctx.agent.stats.files_added[normalized_path] = len(added.encode("utf-8"))
+    return estimate_string(content), estimate_string(added)
+
+def read_for_context(ctx: CommandContext, normalized_path: str, options: ScanOptions) -> Tuple[str, Optional[Truncation]]:
+    """A single file's content, cut by options.truncate if it is over the size limit; FileTooLargeError if that is "skip".
+
+    The limit is --max-size when that is lower than max_file_size.
+    """
+    try:
+        size = os.path.getsize(normalized_path) if os.path.isfile(normalized_path) else 0
+        if size > options.max_file_size:
+            raise FileTooLargeError(normalized_path, size, options.max_file_size)
+        return ctx.workspace.read_file(normalized_path), None
+    except FileTooLargeError:
+        if options.truncate == "skip":
+            raise
+        truncation = ctx.workspace.read_truncated(normalized_path, options.truncate, options.truncate_lines)
+        return truncation.content, truncation
+
+def outline_savings(full_tokens: int, added_tokens: int) -> str:
+    saved = full_tokens - added_tokens
+    percent = f" ({saved / full_tokens:.0%})" if full_tokens else ""
+    return f"outline is ~{added_tokens} tokens instead of ~{full_tokens}, saving ~{saved}{percent}"
+
+def try_handle_add_command(ctx: CommandContext, user_input: str) -> bool:
+    prefix = "/add "
+    if user_input.strip().lower().startswith(prefix):
+        try:
+            path_to_add, options, outline, chunks, verbose = parse_add_arguments(user_input.strip()[len(prefix):], ctx.agent.config)
+        except ValueError as e:
+            console.print(f"[matrix.warning]⚠ {escape(str(e))}. Usage: {ADD_USAGE}[/matrix.warning]\n")
+            return True
+        if is_url(path_to_add):
+            if outline or chunks:
+                console.print(f"[matrix.warning]⚠ --outline and --chunks apply to files, not URLs. Usage: {ADD_USAGE}[/matrix.warning]\n")
+            else:
+                add_url_to_conversation(ctx, path_to_add, options.max_file_size)
+            return True
+        try:
+            normalized_path = ctx.workspace.normalize_path(path_to_add)
+            if path_to_add.lower() == "clipboard" and not os.path.exists(normalized_path):
+                add_clipboard_to_conversation(ctx)
+            elif os.path.isdir(normalized_path) and chunks:
+                console.print(f"[matrix.warning]⚠ --chunks splits a single file; {path_to_add} is a directory. Usage: {ADD_USAGE}[/matrix.warning]\n")
+            elif os.path.isdir(normalized_path):
+                # Handle entire directory
+                add_directory_to_conversation(ctx, normalized_path, options, outline, verbose)
+            elif chunks:
+                add_file_in_parts(ctx, normalized_path)
+            else:
+                # Handle a single file as before
+                content, truncation = read_for_context(ctx, normalized_path, options)
+                full_tokens, added_tokens = add_file_to_conversation(ctx, normalized_path, content, outline, truncation)
+                note = f" [matrix.dim](~{added_tokens:,} tokens)[/matrix.dim]"
+                if truncation:
+                    note = f" [matrix.warning]({truncation.label}, over {format_size(min(options.max_file_size, ctx.workspace.max_file_size))})[/matrix.warning]{note}"
+                elif outline:
+                    note = f" [matrix.dim]({outline_savings(full_tokens, added_tokens) if added_tokens < full_tokens else f'no outline for this file type; added in full, ~{added_tokens:,} tokens'})[/matrix.dim]"
+                console.print(f"[matrix.success]✓ FILE LOADED:[/matrix.success] [matrix.accent]{normalized_path}[/matrix.accent]{note}\n")
+        except OSError as e:
+            console.print(f"[matrix.error]✗ ERROR:[/matrix.error] [matrix.accent]{escape(path_to_add)}[/matrix.accent]: {escape(str(e))}\n")
+        return True
+    return False
+
+def add_file_in_parts(ctx: CommandContext, normalized_path: str) -> None:
+    """/add --chunks: the whole file, in parts of at most chunk_tokens, each its own message."""
+    limit = ctx.agent.config.chunk_tokens
+    try:
+        content = ctx.workspace.read_file(normalized_path)
+    except FileTooLargeError as e:
+        console.print(f"[matrix.error]✗ TOO LARGE:[/matrix.error] [matrix.accent]{normalized_path}[/matrix.accent] [matrix.dim]is "
+                      f"{format_size(e.size)}, over max_file_size = {format_size(e.limit)}, the most read whole. "
+                      "/set max_file_size raises it for this session.[/matrix.dim]\n")
+        return
+    parts = ctx.files.add_parts(normalized_path, limit, content)
+    ctx.agent.stats.files_added[normalized_path] = len(content.encode("utf-8"))
+    tokens = sum(estimate_string(part.content) for part in parts)
+    if len(parts) < 2:
+        console.print(f"[matrix.success]✓ FILE LOADED:[/matrix.success] [matrix.accent]{normalized_path}[/matrix.accent] "
+                      f"[matrix.dim](~{tokens:,} tokens; fits in one message of chunk_tokens = {limit:,}, so it was not split)[/matrix.dim]\n")
+        return
+    console.print(f"[matrix.success]✓ FILE LOADED IN {len(parts)} PARTS:[/matrix.success] [matrix.accent]{normalized_path}[/matrix.accent] "
+                  f"[matrix.dim](~{tokens:,} tokens in all, at most {limit:,} per part; /forget file drops every part)[/matrix.dim]")
+    for number, part in enumerate(parts, 1):
+        console.print(f"  [matrix.dim]part {number}/{len(parts)}: lines {part.first_line}-{part.last_line}, "
+                      f"~{estimate_string(part.content):,} tokens[/matrix.dim]")
+    console.print()
+
+def add_url_to_conversation(ctx: CommandContext, url: str, max_size: int) -> None:
+    """/add https://…: fetch a page, as text, into the context under its URL."""
+    try:
+        with console.status(f"[matrix.accent]> FETCHING {escape(url)}[/matrix.accent]", spinner="dots"):
+            fetched = fetch_url(url, ctx.agent.config, max_size)
+    except FetchError as e:
+        console.print(f"[matrix.error]✗ NOT ADDED:[/matrix.error] [matrix.accent]{escape(url)}[/matrix.accent] [matrix.dim]{escape(str(e))}.[/matrix.dim]\n")
+        return
+    added = ctx.files.add_url(url, fetched)
+    ctx.agent.stats.files_added[url] = len(added.encode("utf-8"))
+    converted = ", converted to text" if fetched.content_type in ("text/html", "application/xhtml+xml") else ""
+    redirected = f", from {escape(fetched.url)}" if fetched.url != url else ""
+    console.print(f"[matrix.success]✓ URL LOADED:[/matrix.success] [matrix.accent]{escape(url)}[/matrix.accent] "
+                  f"[matrix.dim](~{estimate_string(added):,} tokens, {escape(fetched.content_type)}{converted}{redirected}; "
+                  "/context refresh fetches it again)[/matrix.dim]\n")
+
+def refresh_context(ctx: CommandContext) -> None:
+    """/context refresh: fetch the pages in context again, where they changed, and refresh files changed on disk."""
+    max_size = ctx.agent.config.max_file_size
+    for url in ctx.files.urls():
+        stamp = ctx.files.url_stamp(url)
+        try:
+            with console.status(f"[matrix.accent]> FETCHING {escape(url)}[/matrix.accent]", spinner="dots"):
+                fetched = fetch_url(url, ctx.agent.config, max_size, stamp.etag, stamp.last_modified)
+        except FetchError as e:
+            console.print(f"[matrix.warning]⚠ {escape(url)}:[/matrix.warning] [matrix.dim]{escape(str(e))}; the copy in context is kept.[/matrix.dim]")
+            continue
+        if fetched.not_modified or ctx.files.redactor.redact(fetched.content, url) == ctx.conversation.file_content(url):
+            ctx.files.url_unchanged(url)
+            console.print(f"[matrix.dim]= Unchanged: {escape(url)}[/matrix.dim]")
+            continue
+        added = ctx.files.add_url(url, fetched)
+        ctx.agent.stats.files_added[url] = len(added.encode("utf-8"))
+        console.print(f"[matrix.success]↻ Refreshed:[/matrix.success] [matrix.accent]{escape(url)}[/matrix.accent] "
+                      f"[matrix.dim](~{estimate_string(added):,} tokens)[/matrix.dim]")
+    stale = ctx.files.stale()
+    for path, change in stale:
+        ctx.files.refresh(path)
+        console.print(f"[matrix.success]↻ Refreshed:[/matrix.success] [matrix.accent]{escape(os.path.relpath(path, ctx.workspace.root))}[/matrix.accent] "
+                      f"[matrix.dim]({change} on disk)[/matrix.dim]")
+    if not stale and not ctx.files.urls():
+        console.print("[matrix.dim]> Every file in context matches the disk, and no URLs are in it.[/matrix.dim]")
+    console.print()
+
+def context_name(ctx: CommandContext, path: str) -> str:
+    """A file in context as /context lists it: from the workspace root, or a URL as it is."""
+    return path if is_url(path) else os.path.relpath(path, ctx.workspace.root)
+
+def add_clipboard_to_conversation(ctx: CommandContext) -> None:
+    try:
+        content = paste_text()
+    except ClipboardError as e:
+        console.print(f"[matrix.error]✗ CLIPBOARD UNAVAILABLE:[/matrix.error] {escape(str(e))}\n")
+        return
+    if not content.strip():
+        console.print("[matrix.warning]⚠ The clipboard is empty.[/matrix.warning]\n")
+        return
+    content = ctx.tools.ctx.redactor.redact(content, "clipboard")
+    ctx.conversation.add_system(f"Content pasted from the user's clipboard:\n\n{content}", "clipboard")
+    console.print(f"[matrix.success]✓ CLIPBOARD LOADED:[/matrix.success] [matrix.dim]{len(content.encode('utf-8'))} bytes[/matrix.dim]\n")
+
+def refuse_read_only(ctx: CommandContext, command: str, why: str) -> bool:
+    """With --read-only, say why 'command' is unavailable and return True; otherwise False."""
+    if not ctx.agent.config.read_only:
+        return False
+    console.print(f"[matrix.warning]⚠ READ-ONLY:[/matrix.warning] [matrix.dim]{command} {why}, and this session was "
+                  "started with --read-only. Restart Neo without it to do that.[/matrix.dim]\n")
+    return True
+
+def warn_model_limits(ctx: CommandContext) -> None:
+    """At startup and after /model: say what the model, or either routed model, can't do."""
+    config = ctx.agent.config
+    models = [config.resolved_chat_model(), config.resolved_coder_model()] if config.model == AUTO else [config.resolved_model()]
+    for model in dict.fromkeys(models):
+        limits = describe_limits(model, ctx.agent.capabilities.of(model))
+        if limits:
+            console.print(f"[matrix.warning]⚠ MODEL LIMIT:[/matrix.warning] [matrix.dim]{escape(limits)}[/matrix.dim]")
+
+def try_handle_apply_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split(maxsplit=2)
+    if not parts or parts[0].lower() != "/apply":
+        return False
+    if refuse_read_only(ctx, "/apply", "writes a file"):
+        return True
+    if len(parts) < 3 or not parts[1].isdigit():
+        console.print("[matrix.warning]⚠ Usage: /apply <block number> <path>[/matrix.warning]\n")
+        return True
+    blocks = ctx.agent.last_code_blocks
+    index, path = int(parts[1]), parts[2].strip()
+    if not 1 <= index <= len(blocks):
+        available = f"1-{len(blocks)}" if blocks else "none"
+        console.print(f"[matrix.warning]⚠ No code block {index} in the last response (available: {available}).[/matrix.warning]\n")
+        return True
+
+    content = blocks[index - 1].content
+    try:
+        normalized_path = ctx.workspace.normalize_path(path)
+        if os.path.isfile(normalized_path):
+            show_unified_diff(path, ctx.workspace.read_file(normalized_path), content)
+        else:
+            show_file_preview(path, content)
+        if PLACEHOLDER_RE.search(content):
+            console.print("[matrix.warning]⚠ This block contains a redaction placeholder; applying it writes the placeholder, not the secret.[/matrix.warning]")
+        if not confirm_file_change("write", path, ctx.agent.config.auto_approve):
+            console.print("[matrix.dim]> Not applied.[/matrix.dim]\n")
+            return True
+        result = ctx.workspace.create_file(path, content, ctx.agent.config.dry_run)
+    except (OSError, ValueError) as e:
+        console.print(f"[matrix.error]✗ ERROR:[/matrix.error] [matrix.accent]{escape(path)}[/matrix.accent]: {escape(str(e))}\n")
+        return True
+    if result.simulated:
+        console.print(f"[matrix.warning]◌ SIMULATED:[/matrix.warning] [matrix.dim]would have written {format_size(result.size)} to {path}[/matrix.dim]\n")
+        return True
+    ctx.files.refresh(result.path)
+    backup_note = f" [matrix.dim](backup: {result.backup})[/matrix.dim]" if result.backup else ""
+    console.print(f"[matrix.success]✓ APPLIED BLOCK {index}:[/matrix.success] [matrix.accent]{result.path}[/matrix.accent]{backup_note}\n")
+    return True
+
+def try_handle_last_command(ctx: CommandContext, user_input: str) -> bool:
+    if user_input.strip().lower() != "/last":
+        return False
+    if not ctx.agent.last_rendered:
+        console.print("[matrix.warning]⚠ No reply to show yet.[/matrix.warning]\n")
+        return True
+    Pager(ctx.agent.last_rendered, title="NEO> last reply").run()
+    return True
+
+def try_handle_copy_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/copy":
+        return False
+    what = parts[1].lower() if len(parts) > 1 else ""
+    if what not in ("", "code"):
+        console.print("[matrix.warning]⚠ Usage: /copy [code][/matrix.warning]\n")
+        return True
+
+    text = ctx.conversation.last_assistant_reply()
+    if text and what == "code":
+        text = last_code_block(text)
+        if text is None:
+            console.print("[matrix.warning]⚠ The last response has no code block.[/matrix.warning]\n")
+            return True
+    if not text:
+        console.print("[matrix.warning]⚠ Nothing to copy yet.[/matrix.warning]\n")
+        return True
+
+    try:
+        method = copy_text(text)
+    except ClipboardError as e:
+        console.print(f"[matrix.error]✗ CLIPBOARD UNAVAILABLE:[/matrix.error] {escape(str(e))}\n")
+        return True
+    label = "code block" if what == "code" else "response"
+    console.print(f"[matrix.success]✓ COPIED:[/matrix.success] [matrix.dim]{label}, {len(text.encode('utf-8'))} bytes via {method}[/matrix.dim]\n")
+    return True
+
+SKIPPED_EXAMPLES = 3  # Skipped files listed per reason after /add <directory>, unless --verbose
+SKIPPED_LOG = os.path.join(".neo", "last-add-skipped.txt")  # Relative to the workspace root
+
+def write_skipped_log(ctx: CommandContext, groups: List[Tuple[str, List[SkippedFile]]]) -> str:
+    """Write every skipped file of the last /add <directory>, by reason, to SKIPPED_LOG; returns its path."""
+    path = os.path.join(ctx.workspace.root, SKIPPED_LOG)
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    with open(path, "w", encoding="utf-8") as f:
+        for reason, files in groups:
+            f.write(f"# {reason} ({len(files)})\n")
+            f.writelines(f"{skipped.describe()}\n" for skipped in files)
+            f.write("\n")
+    return path
+
+def show_skipped(ctx: CommandContext, scan: ScanResult, verbose: bool) -> None:
+    """The skipped files of a scan, counted by reason with a few of each, or all of them with --verbose.
+
+    When some are not listed, the full list goes to SKIPPED_LOG, except in a read-only session.
+    """
+    groups = scan.skipped_by_reason()
+    counts = ", ".join(f"{len(files):,} {reason}" for reason, files in groups)
+    console.print(f"\n[bold yellow]⏭ Skipped files:[/bold yellow] [dim]({len(scan.skipped):,}: {escape(counts)})[/dim]")
+    for reason, files in groups:
+        shown = files if verbose else files[:SKIPPED_EXAMPLES]
+        decorate(f"  [yellow]{escape(reason)}[/yellow] [dim]({len(files):,})[/dim]")
+        for skipped in shown:
+            decorate(f"    [yellow dim]⚠ {escape(skipped.describe())}[/yellow dim]")
+        if len(files) > len(shown):
+            decorate(f"    [dim]... and {len(files) - len(shown):,} more[/dim]")
+    if verbose or all(len(files) <= SKIPPED_EXAMPLES for _, files in groups):
+        return
+    if ctx.agent.config.read_only:
+        console.print("[matrix.dim]  /add --verbose lists them all (the full list is not written in a read-only session)[/matrix.dim]")
+        return
+    try:
+        path = write_skipped_log(ctx, groups)
+    except OSError as e:
+        console.print(f"[matrix.warning]⚠ Could not write the full list to {escape(str(SKIPPED_LOG))}: {escape(str(e))}[/matrix.warning]")
+        return
+    console.print(f"[matrix.dim]  Full list:[/matrix.dim] [matrix.accent]{escape(path)}[/matrix.accent]")
+
+def add_directory_to_conversation(ctx: CommandContext, directory_path: str, options: Optional[ScanOptions] = None,
+                                  outline: bool = False, verbose: bool = False):
+    options = options or ScanOptions(max_files=ctx.agent.config.max_scan_files, max_file_size=ctx.agent.config.max_file_size)
+    full_tokens = added_tokens = 0
+
+    def add_file(normalized_path: str, content: str, truncation: Optional[Truncation]) -> None:
+        # Each file goes into the conversation as it is read, so the scan holds one at a time
+        nonlocal full_tokens, added_tokens
+        full, added = add_file_to_conversation(ctx, normalized_path, content, outline, truncation)
+        full_tokens += full
+        added_tokens += added
+
+    with console.status("[matrix.accent]> SCANNING DIRECTORY MATRIX...[/matrix.accent]", spinner="dots") as status:
+        scan = ctx.workspace.scan_directory(
+            directory_path,
+            on_directory=lambda root: status.update(f"[bold bright_blue]🔍 Scanning {root}...[/bold bright_blue]"),
+            options=options,
+            on_file=add_file,
+        )
+        if scan.limit_reached:
+            console.print(f"[matrix.warning]⚠ Stopped at the file limit ({options.max_files} files); use --max-files or /set max_scan_files to raise it[/matrix.warning]")
+
+        added_files = [path for path, _ in scan.added]
+
+        console.print(f"[bold blue]✓[/bold blue] Added folder '[bright_cyan]{directory_path}[/bright_cyan]' to conversation "
+                      f"[matrix.dim](~{added_tokens:,} tokens added)[/matrix.dim]")
+        if scan.dirs_beyond_depth:
+            console.print(f"[matrix.dim]  --depth {options.max_depth}: {scan.dirs_beyond_depth} deeper director{'y' if scan.dirs_beyond_depth == 1 else 'ies'} not entered[/matrix.dim]")
+        if scan.files_too_large:
+            console.print(f"[matrix.dim]  {scan.files_too_large} file(s) over the {format_size(options.max_file_size)} size limit skipped[/matrix.dim]")
+        if outline:
+            console.print(f"[matrix.dim]  --outline: {outline_savings(full_tokens, added_tokens)}[/matrix.dim]")
+        if added_files:
+            console.print(f"\n[bold bright_blue]📁 Added files:[/bold bright_blue] [dim]({len(added_files)})[/dim]")
+            for f in added_files:
+                console.print(f"  [bright_cyan]📄 {f}[/bright_cyan]")
+        if scan.truncated:
+            console.print(f"\n[bold yellow]✂ Truncated files:[/bold yellow] [dim]({len(scan.truncated)})[/dim]")
+            for f in scan.truncated:
+                console.print(f"  [yellow]✂ {f}[/yellow]")
+        if scan.skipped:
+            show_skipped(ctx, scan, verbose)
+        console.print()
+
+def try_handle_watch_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split(maxsplit=1)
+    if not parts or parts[0].lower() != "/watch":
+        return False
+    if len(parts) < 2:
+        watched = ctx.watcher.watched_files()
+        if not ctx.watcher.paths:
+            console.print("[matrix.dim]> Not watching anything. Usage: /watch <path> | /watch off[/matrix.dim]\n")
+        else:
+            console.print(f"[matrix.primary]> Watching {', '.join(sorted(ctx.watcher.paths))}[/matrix.primary] "
+                          f"[matrix.dim]({len(watched)} file(s) in context)[/matrix.dim]\n")
+        return True
+    if parts[1].strip().lower() == "off":
+        ctx.watcher.stop()
+        console.print("[matrix.dim]> Stopped watching.[/matrix.dim]\n")
+        return True
+
+    path = parts[1].strip()
+    try:
+        normalized_path = ctx.workspace.normalize_path(path)
+        if not os.path.exists(normalized_path):
+            raise FileNotFoundError(f"no such file or directory: {path}")
+        if os.path.isfile(normalized_path) and not ctx.conversation.has_file(normalized_path):
+            ctx.files.add(normalized_path)
+            ctx.agent.stats.files_added[normalized_path] = os.path.getsize(normalized_path)
+            console.print(f"[matrix.success]✓ FILE LOADED:[/matrix.success] [matrix.accent]{normalized_path}[/matrix.accent]")
+    except (OSError, ValueError) as e:
+        console.print(f"[matrix.error]✗ ERROR:[/matrix.error] [matrix.accent]{escape(path)}[/matrix.accent]: {escape(str(e))}\n")
+        return True
+    ctx.watcher.watch(normalized_path)
+    watched = [f for f in ctx.watcher.watched_files() if f == normalized_path or f.startswith(os.path.join(normalized_path, ""))]
+    note = "" if watched else "; nothing there is in context yet, so /add what you want kept current"
+    console.print(f"[matrix.success]👁 WATCHING:[/matrix.success] [matrix.accent]{normalized_path}[/matrix.accent] "
+                  f"[matrix.dim]({len(watched)} file(s) in context{note})[/matrix.dim]\n")
+    return True
+
+def try_handle_branch_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/branch":
+        return False
+    action = parts[1].lower() if len(parts) > 1 else "list"
+    try:
+        if action == "list" and len(parts) <= 2:
+            table = Table(title="[matrix.accent][ BRANCHES ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+            table.add_column("Branch", style="matrix.accent", no_wrap=True)
+            table.add_column("Messages", style="matrix.primary", justify="right")
+            for name, messages, active in ctx.branches.summary():
+                table.add_row(f"* {name}" if active else f"  {name}", str(messages))
+            console.print(table)
+            console.print()
+        elif action == "switch" and len(parts) == 3:
+            ctx.branches.switch(parts[2])
+            console.print(f"[matrix.success]✓ ON BRANCH:[/matrix.success] [matrix.accent]{ctx.branches.active}[/matrix.accent] "
+                          f"[matrix.dim]({len(ctx.conversation)} messages)[/matrix.dim]\n")
+        elif len(parts) == 2 and action not in ("list", "switch"):
+            ctx.branches.create(parts[1])
+            console.print(f"[matrix.success]✓ NEW BRANCH:[/matrix.success] [matrix.accent]{parts[1]}[/matrix.accent] "
+                          f"[matrix.dim](copied {len(ctx.conversation)} messages; /branch switch to go back)[/matrix.dim]\n")
+        else:
+            console.print("[matrix.warning]⚠ Usage: /branch <name> | /branch list | /branch switch <name>[/matrix.warning]\n")
+    except ValueError as e:
+        console.print(f"[matrix.error]✗ ERROR:[/matrix.error] {escape(str(e))}\n")
+    return True
+
+PROMPT_USAGE = '/prompt save <name> ["text"] | /prompt list | /prompt use <name> [extra text]'
+
+def try_handle_prompt_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split(maxsplit=3)
+    if not parts or parts[0].lower() != "/prompt":
+        return False
+    action = parts[1].lower() if len(parts) > 1 else "list"
+    try:
+        if action == "list":
+            templates = ctx.prompts.list()
+            if not templates:
+                console.print(f"[matrix.dim]> No prompt templates yet. Usage: {PROMPT_USAGE}[/matrix.dim]\n")
+                return True
+            table = Table(title="[matrix.accent][ PROMPTS ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+            table.add_column("Name", style="matrix.accent", no_wrap=True)
+            table.add_column("From", style="matrix.dim")
+            table.add_column("Template", style="matrix.primary")
+            for name, path, scope in templates:
+                first_line = path.read_text(encoding="utf-8").strip().splitlines()[:1]
+                table.add_row(name, scope, (first_line[0][:70] if first_line else ""))
+            console.print(table)
+            console.print()
+        elif action == "save" and len(parts) >= 3:
+            name = parts[2]
+            if len(parts) == 4:
+                text = parts[3]
+                if len(text) > 1 and text[0] == text[-1] and text[0] in "\"'":
+                    text = text[1:-1]
+            else:
+                text = prompt_session.prompt(f"Template '{name}' ({{input}} and {{clipboard}} are filled in on use)> ")
+            if not text.strip():
+                console.print("[matrix.warning]⚠ Empty template; nothing saved.[/matrix.warning]\n")
+                return True
+            path = ctx.prompts.save(name, text)
+            console.print(f"[matrix.success]✓ PROMPT SAVED:[/matrix.success] [matrix.accent]{name}[/matrix.accent] [matrix.dim]({path})[/matrix.dim]\n")
+        elif action == "use" and len(parts) >= 3:
+            template = ctx.prompts.load(parts[2])
+            message = expand_template(template, parts[3] if len(parts) == 4 else "", paste_text)
+            console.print(f"[matrix.dim]> {parts[2]}: {len(message)} characters[/matrix.dim]")
+            send_message(ctx, message)
+        else:
+            console.print(f"[matrix.warning]⚠ Usage: {PROMPT_USAGE}[/matrix.warning]\n")
+    except (EOFError, KeyboardInterrupt):
+        console.print("[matrix.dim]> Cancelled.[/matrix.dim]\n")
+    except ClipboardError as e:
+        console.print(f"[matrix.error]✗ CLIPBOARD UNAVAILABLE:[/matrix.error] {escape(str(e))}\n")
+    except (OSError, ValueError) as e:
+        console.print(f"[matrix.error]✗ ERROR:[/matrix.error] {escape(str(e))}\n")
+    return True
+
+def try_handle_checkpoint_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/checkpoint":
+        return False
+    if len(parts) > 2:
+        console.print("[matrix.warning]⚠ Usage: /checkpoint [name][/matrix.warning]\n")
+        return True
+    try:
+        checkpoint = ctx.checkpoints.create(parts[1] if len(parts) == 2 else None)
+    except (OSError, ValueError) as e:
+        console.print(f"[matrix.error]✗ ERROR:[/matrix.error] {escape(str(e))}\n")
+        return True
+    console.print(f"[matrix.success]✓ CHECKPOINT:[/matrix.success] [matrix.accent]{checkpoint.name}[/matrix.accent] "
+                  f"[matrix.dim]({checkpoint.messages} messages; files changed from now on can be rolled back "
+                  f"with /rollback {checkpoint.name})[/matrix.dim]\n")
+    return True
+
+def show_checkpoints(ctx: CommandContext) -> None:
+    if not ctx.checkpoints.checkpoints:
+        console.print("[matrix.dim]> No checkpoints yet. Take one with /checkpoint [name].[/matrix.dim]\n")
+        return
+    table = Table(title="[matrix.accent][ CHECKPOINTS ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+    table.add_column("Name", style="matrix.accent", no_wrap=True)
+    table.add_column("Taken", style="matrix.primary")
+    table.add_column("Messages", style="matrix.primary", justify="right")
+    table.add_column("Files changed since", style="matrix.primary", justify="right")
+    for checkpoint in ctx.checkpoints.checkpoints:
+        table.add_row(checkpoint.name, checkpoint.created.strftime("%H:%M:%S"), str(checkpoint.messages),
+                      str(len(checkpoint.preimages) + len(checkpoint.unsaved)))
+    console.print(table)
+    console.print()
+
+def try_handle_rollback_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/rollback":
+        return False
+    if len(parts) == 1:
+        show_checkpoints(ctx)
+        return True
+    checkpoint = ctx.checkpoints.get(parts[1])
+    if checkpoint is None:
+        names = ", ".join(c.name for c in ctx.checkpoints.checkpoints) or "none"
+        console.print(f"[matrix.warning]⚠ No checkpoint named '{parts[1]}' (have: {names})[/matrix.warning]\n")
+        return True
+
+    dropped = max(len(ctx.conversation) - checkpoint.messages, 0)
+    later = [c.name for c in ctx.checkpoints.checkpoints[ctx.checkpoints.checkpoints.index(checkpoint) + 1:]]
+    changes = ctx.checkpoints.changes(checkpoint)
+    console.print(f"[matrix.primary]> Rolling back to[/matrix.primary] [matrix.accent]{checkpoint.name}[/matrix.accent] "
+                  f"[matrix.dim](taken {checkpoint.created.strftime('%H:%M:%S')})[/matrix.dim]")
+    console.print(f"  [matrix.dim]conversation: back to {checkpoint.messages} messages (about {dropped} fewer than now)[/matrix.dim]")
+    if later:
+        console.print(f"  [matrix.dim]later checkpoints discarded: {', '.join(later)}[/matrix.dim]")
+    for path, action in changes:
+        label = "restore" if action == "restore" else "delete (created since)"
+        console.print(f"  [matrix.warning]{label}:[/matrix.warning] [matrix.accent]{path}[/matrix.accent]")
+    for path, reason in checkpoint.unsaved.items():
+        console.print(f"  [matrix.error]cannot restore:[/matrix.error] [matrix.accent]{path}[/matrix.accent] [matrix.dim]({reason})[/matrix.dim]")
+
+    try:
+        answer = prompt_session.prompt("Roll back the conversation? [y/N]: ").strip().lower()
+        restore_files = False
+        if answer in ("y", "yes") and changes:
+            restore_files = prompt_session.prompt(f"Also revert the {len(changes)} file(s) listed? [y/N]: ").strip().lower() in ("y", "yes")
+    except (EOFError, KeyboardInterrupt):
+        answer = ""
+    if answer not in ("y", "yes"):
+        console.print("[matrix.dim]> Rollback cancelled.[/matrix.dim]\n")
+        return True
+
+    try:
+        reverted = ctx.checkpoints.rollback(checkpoint, restore_files)
+    except (OSError, ValueError) as e:
+        console.print(f"[matrix.error]✗ ROLLBACK FAILED:[/matrix.error] {escape(str(e))}\n")
+        return True
+    files_note = f", {len(reverted)} file(s) reverted" if reverted else ""
+    console.print(f"[matrix.success]✓ ROLLED BACK:[/matrix.success] [matrix.accent]{checkpoint.name}[/matrix.accent]"
+                  f"[matrix.dim] (conversation restored{files_note})[/matrix.dim]\n")
+    return True
+
+FORGET_USAGE = "/forget last | turn <t> | <n>[..<m>] | file <path>"
+FORGET_RANGE_RE = re.compile(r"^(\d+)(?:\.\.(\d+))?$")
+
+def message_preview(msg: Dict, width: int = 70) -> str:
+    """One line describing a message for /forget."""
+    text = " ".join((msg.get("content") or "").split())
+    if msg.get("tool_calls"):
+        calls = ", ".join(tc["function"]["name"] for tc in msg["tool_calls"])
+        text = f"{text} [calls {calls}]".strip()
+    return text if len(text) <= width else text[:width - 1] + "…"
+
+def show_history(ctx: CommandContext) -> None:
+    history = ctx.conversation.history()
+    if not history:
+        console.print("[matrix.dim]> Nothing to forget: no messages yet.[/matrix.dim]\n")
+        return
+    table = Table(title="[matrix.accent][ HISTORY ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+    table.add_column("#", style="matrix.accent", justify="right", no_wrap=True)
+    table.add_column("Turn", style="matrix.dim", justify="right", no_wrap=True)
+    table.add_column("Role", style="matrix.primary", no_wrap=True)
+    table.add_column("When", style="matrix.dim", no_wrap=True)
+    table.add_column("Message", style="matrix.dim")
+    now = time.time()
+    for number, msg in enumerate(history, 1):
+        table.add_row(str(number), str(msg.get("turn", "-")), msg["role"], describe_age(msg.get("ts"), now), escape(message_preview(msg)))
+    console.print(table)
+    console.print(f"[matrix.dim]Usage: {FORGET_USAGE}[/matrix.dim]\n")
+
+def try_handle_when_command(ctx: CommandContext, user_input: str) -> bool:
+    """/when lists the latest messages with their times, turns and origins, marking long pauses, to line them up with git log."""
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/when":
+        return False
+    if len(parts) > 2 or (len(parts) == 2 and not parts[1].isdigit() and parts[1].lower() != "all"):
+        console.print(f"[matrix.warning]⚠ Usage: {escape('/when [<count>|all]')}[/matrix.warning]\n")
+        return True
+    said = [(number, msg) for number, msg in enumerate(ctx.conversation.history(), 1) if msg["role"] != "tool"]
+    if not said:
+        console.print("[matrix.dim]> No messages yet.[/matrix.dim]\n")
+        return True
+    count = len(said) if len(parts) == 2 and parts[1].lower() == "all" else int(parts[1]) if len(parts) == 2 else 20
+    shown = said[-count:] if count else []
+    table = Table(title="[matrix.accent][ WHEN ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+    table.add_column("#", style="matrix.accent", justify="right", no_wrap=True)
+    table.add_column("Time", style="matrix.primary", no_wrap=True)
+    table.add_column("Ago", style="matrix.dim", no_wrap=True)
+    table.add_column("Turn", style="matrix.dim", justify="right", no_wrap=True)
+    table.add_column("From", style="matrix.primary", no_wrap=True)
+    table.add_column("Tokens", style="matrix.dim", justify="right", no_wrap=True)
+    table.add_column("Message", style="matrix.dim")
+    now, previous = time.time(), None
+    for number, msg in shown:
+        separator = gap_separator(previous, msg.get("ts"))
+        if separator:
+            table.add_row("", "", "", "", "", "", f"[matrix.warning]{separator}[/matrix.warning]")
+        previous = msg.get("ts", previous)
+        table.add_row(str(number), describe_time(msg.get("ts"), now), describe_age(msg.get("ts"), now), str(msg.get("turn", "-")),
+                      escape(describe_origin(msg)), f"~{estimate_message(msg):,}", escape(message_preview(msg)))
+    console.print(table)
+    undated = sum("ts" not in msg for _, msg in shown)
+    if undated:
+        console.print(f"[matrix.dim]> {undated} message(s) were saved before Neo recorded times and show as -.[/matrix.dim]")
+    if len(shown) < len(said):
+        console.print(f"[matrix.dim]> The latest {len(shown)} of {len(said)} messages; /when all shows every one.[/matrix.dim]")
+    console.print()
+    return True
+
+def forget_file(ctx: CommandContext, path: str) -> None:
+    try:
+        normalized_path = path if is_url(path) else ctx.workspace.normalize_path(path)
+    except (OSError, ValueError) as e:
+        console.print(f"[matrix.error]✗ ERROR:[/matrix.error] [matrix.accent]{escape(path)}[/matrix.accent]: {escape(str(e))}\n")
+        return
+    if not ctx.conversation.has_file(normalized_path):
+        console.print(f"[matrix.warning]⚠ Not in context:[/matrix.warning] [matrix.accent]{normalized_path}[/matrix.accent]\n")
+        return
+    tokens = estimate_string(ctx.conversation.file_content(normalized_path))
+    if not confirm_forget(f"Forget the content of {normalized_path} (~{tokens} tokens)?"):
+        return
+    ctx.conversation.remove_file(normalized_path)
+    ctx.agent.stats.files_added.pop(normalized_path, None)
+    console.print(f"[matrix.success]✓ FORGOTTEN:[/matrix.success] [matrix.accent]{normalized_path}[/matrix.accent] "
+                  f"[matrix.dim](~{tokens} tokens)[/matrix.dim]\n")
+
+def confirm_forget(question: str) -> bool:
+    try:
+        answer = prompt_session.prompt(f"{question} [y/N]: ").strip().lower()
+    except (EOFError, KeyboardInterrupt):
+        answer = ""
+    if answer not in ("y", "yes"):
+        console.print("[matrix.dim]> Nothing forgotten.[/matrix.dim]\n")
+        return False
+    return True
+
+def try_handle_forget_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split(maxsplit=2)
+    if not parts or parts[0].lower() != "/forget":
+        return False
+    if len(parts) == 1:
+        show_history(ctx)
+        return True
+    if parts[1].lower() == "file":
+        if len(parts) < 3:
+            console.print(f"[matrix.warning]⚠ Usage: {FORGET_USAGE}[/matrix.warning]\n")
+        else:
+            forget_file(ctx, parts[2].strip())
+        return True
+
+    history = ctx.conversation.history()
+    match = FORGET_RANGE_RE.match(parts[1])
+    by_turn = parts[1].lower() == "turn"
+    if (by_turn and (len(parts) < 3 or not parts[2].strip().isdigit())) or (
+            not by_turn and (len(parts) > 2 or (parts[1].lower() != "last" and not match))):
+        console.print(f"[matrix.warning]⚠ Usage: {FORGET_USAGE}[/matrix.warning]\n")
+        return True
+    if parts[1].lower() == "last":
+        turn = ctx.conversation.last_turn()
+        numbers = ctx.conversation.turn_numbers(turn) if turn is not None else []
+    elif by_turn:
+        numbers = ctx.conversation.turn_numbers(int(parts[2]))
+        if not numbers:
+            console.print(f"[matrix.warning]⚠ No messages of turn {parts[2].strip()} are left (see /forget)[/matrix.warning]\n")
+            return True
+    else:
+        first, last = int(match.group(1)), int(match.group(2) or match.group(1))
+        if not 1 <= first <= last <= len(history):
+            console.print(f"[matrix.warning]⚠ No messages {parts[1]}: history has 1..{len(history)} (see /forget)[/matrix.warning]\n")
+            return True
+        numbers = list(range(first, last + 1))
+    if not numbers:
+        console.print("[matrix.dim]> Nothing to forget: no exchanges yet.[/matrix.dim]\n")
+        return True
+
+    # Preview what forget() will take, including tool calls and results paired with the named messages
+    preview = Conversation(ctx.conversation.system_prompt)
+    preview.restore(ctx.conversation.snapshot())
+    doomed = preview.forget(numbers)
+    console.print("[matrix.primary]> Forgetting:[/matrix.primary]")
+    for number, msg in doomed:
+        paired = "" if number in numbers else " [matrix.dim](paired tool call)[/matrix.dim]"
+        console.print(f"  [matrix.accent]{number:>3}[/matrix.accent] [matrix.primary]{msg['role']}:[/matrix.primary] "
+                      f"{escape(message_preview(msg))}{paired}")
+    if not confirm_forget(f"Remove these {len(doomed)} message(s) from the conversation?"):
+        return True
+
+    removed = ctx.conversation.forget(numbers)
+    tokens = estimate_conversation(msg for _, msg in removed)
+    console.print(f"[matrix.success]✓ FORGOTTEN:[/matrix.success] [matrix.dim]{len(removed)} message(s), ~{tokens} tokens; "
+                  f"{len(ctx.conversation.history())} remain, renumbered (see /forget)[/matrix.dim]\n")
+    return True
+
+def try_handle_restore_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split(maxsplit=1)
+    if not parts or parts[0].lower() != "/restore":
+        return False
+    if len(parts) < 2:
+        console.print("[matrix.warning]⚠ Usage: /restore <path>[/matrix.warning]\n")
+        return True
+    path = parts[1].strip()
+    if refuse_read_only(ctx, "/restore", "writes a file"):
+        return True
+    try:
+        normalized_path = ctx.workspace.normalize_path(path)
+        backups = ctx.workspace.backups.list(normalized_path)
+        if not backups:
+            console.print(f"[matrix.dim]> No backups of[/matrix.dim] [matrix.accent]{path}[/matrix.accent]\n")
+            return True
+
+        table = Table(title=f"[matrix.accent][ BACKUPS: {path} ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+        table.add_column("#", style="matrix.accent", no_wrap=True)
+        table.add_column("Saved", style="matrix.primary")
+        table.add_column("Location", style="matrix.dim")
+        for i, backup in enumerate(backups, 1):
+            table.add_row(str(i), backup.created.strftime("%Y-%m-%d %H:%M:%S"), backup.path)
+        console.print(table)
+
+        try:
+            choice = prompt_session.prompt(f"Restore which backup? [1-{len(backups)}, Enter to cancel]: ").strip()
+        except (EOFError, KeyboardInterrupt):
+            choice = ""
+        if not choice:
+            console.print("[matrix.dim]> Restore cancelled.[/matrix.dim]\n")
+            return True
+        if not choice.isdigit() or not 1 <= int(choice) <= len(backups):
+            console.print(f"[matrix.warning]⚠ Not a backup number: {choice}[/matrix.warning]\n")
+            return True
+
+        result = ctx.workspace.restore_backup(normalized_path, backups[int(choice) - 1])
+        backup_note = f" [matrix.dim](previous content saved to {result.backup})[/matrix.dim]" if result.backup else ""
+        console.print(f"[matrix.success]✓ FILE RESTORED:[/matrix.success] [matrix.accent]{normalized_path}[/matrix.accent]{backup_note}\n")
+    except (OSError, ValueError) as e:
+        console.print(f"[matrix.error]✗ ERROR:[/matrix.error] [matrix.accent]{escape(path)}[/matrix.accent]: {escape(str(e))}\n")
+    return True
+
+def try_handle_config_command(ctx: CommandContext, user_input: str) -> bool:
+    if user_input.strip().lower() != "/config":
+        return False
+    table = Table(title="[matrix.accent][ CONFIG ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+    table.add_column("Setting", style="matrix.accent", no_wrap=True)
+    table.add_column("Value", style="matrix.primary")
+    for name, value in ctx.agent.config.model_dump().items():
+        table.add_row(name, repr(value))
+    console.print(table)
+
+    console.print("\n[matrix.primary]Protected paths[/matrix.primary] [matrix.dim](tools may never modify these)[/matrix.dim]")
+    for pattern in ctx.workspace.protected_paths:
+        console.print(f"  [matrix.accent]{pattern}[/matrix.accent]")
+    console.print("\n[matrix.primary]Limits[/matrix.primary] [matrix.dim](in effect now; change with /set <name> <value>)[/matrix.dim]")
+    for name in LIMIT_RANGES:
+        console.print(f"  [matrix.accent]{name}[/matrix.accent] {describe_limit(ctx.agent.config, name)}")
+    if ctx.agent.config.aliases:
+        console.print("\n[matrix.primary]Aliases[/matrix.primary]")
+        for name, command in sorted(ctx.agent.config.aliases.items()):
+            console.print(f"  [matrix.accent]/{name.lstrip('/')}[/matrix.accent] [matrix.dim](alias)[/matrix.dim] → {escape(command)}")
+    console.print()
+    return True
+
+SET_USAGE = f"/set {'|'.join(LIMIT_RANGES)} <value>"
+
+def describe_limit(config: NeoConfig, name: str) -> str:
+    value = getattr(config, name)
+    return format_size(value) if name == "max_file_size" else f"{value:,}"
+
+def apply_limits(ctx: CommandContext) -> None:
+    """Hand the config's limits to the parts that keep their own copy; the rest read the config each time."""
+    ctx.workspace.max_file_size = ctx.agent.config.max_file_size
+
+def try_handle_set_command(ctx: CommandContext, user_input: str) -> bool:
+    """/set <name> <value> changes a size or scan limit for the rest of the session."""
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/set":
+        return False
+    config = ctx.agent.config
+    if len(parts) == 1:
+        for name in LIMIT_RANGES:
+            console.print(f"[matrix.accent]{name}[/matrix.accent] {describe_limit(config, name)}")
+        console.print(f"[matrix.dim]> Usage: {escape(SET_USAGE)}[/matrix.dim]\n")
+        return True
+    if len(parts) != 3 or parts[1].lower() not in LIMIT_RANGES:
+        console.print(f"[matrix.warning]⚠ Usage: {escape(SET_USAGE)}[/matrix.warning]\n")
+        return True
+    name, text = parts[1].lower(), parts[2]
+    try:
+        value: Any = parse_size(text) if name == "max_file_size" else int(text)
+    except ValueError:
+        value = text
+    error = limit_error(name, value)
+    if error:
+        console.print(f"[matrix.warning]⚠ {escape(error)}[/matrix.warning]\n")
+        return True
+    setattr(config, name, value)
+    apply_limits(ctx)
+    console.print(f"[matrix.success]✓ SET:[/matrix.success] [matrix.accent]{name}[/matrix.accent] {describe_limit(config, name)} "
+                  "[matrix.dim](from the next operation on)[/matrix.dim]\n")
+    return True
+
+def try_handle_doctor_command(ctx: CommandContext, user_input: str) -> bool:
+    """/doctor runs the `neo doctor` checks against this session's settings."""
+    if user_input.strip().lower() != "/doctor":
+        return False
+    show_checks(run_checks(ctx.agent.config, check_config_file(ctx.config_path), ctx.workspace.root))
+    return True
+
+def try_handle_audit_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/audit":
+        return False
+    if len(parts) > 2 or (len(parts) == 2 and not parts[1].isdigit()):
+        console.print("[matrix.warning]⚠ Usage: /audit [count][/matrix.warning]\n")
+        return True
+    audit = ctx.tools.ctx.audit
+    entries = audit.entries(int(parts[1]) if len(parts) == 2 else 10) if audit else []
+    if not entries:
+        console.print("[matrix.dim]> No tool calls recorded this session.[/matrix.dim]\n")
+        return True
+
+    table = Table(title=f"[matrix.accent][ AUDIT: {audit.path} ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+    table.add_column("Time", style="matrix.dim", no_wrap=True)
+    table.add_column("Tool", style="matrix.accent", no_wrap=True)
+    table.add_column("Status")
+    table.add_column("Approval", style="matrix.dim")
+    table.add_column("Bytes", justify="right")
+    table.add_column("Arguments", style="matrix.primary")
+    styles = {"ok": "matrix.success", "error": "matrix.error"}
+    for entry in entries:
+        status = entry.get("status", "")
+        style = styles.get(status, "matrix.warning")
+        arguments = json.dumps(entry.get("arguments"), default=str)
+        table.add_row(
+            time.strftime("%H:%M:%S", time.localtime(entry.get("ts", 0))),
+            entry.get("tool", ""),
+            f"[{style}]{status}[/{style}]",
+            entry.get("approval") or "-",
+            str(entry.get("bytes_written", 0)),
+            arguments if len(arguments) <= 100 else arguments[:97] + "...",
+        )
+    console.print(table)
+    console.print()
+    return True
+
+def try_handle_transcript_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split(maxsplit=2)
+    if not parts or parts[0].lower() != "/transcript":
+        return False
+    action = parts[1].lower() if len(parts) > 1 else ""
+    transcript = ctx.agent.transcript
+    if action == "on":
+        try:
+            path = transcript.start(parts[2] if len(parts) > 2 else None)
+        except OSError as e:
+            console.print(f"[matrix.error]✗ ERROR:[/matrix.error] could not open transcript: {escape(str(e))}\n")
+            return True
+        console.print(f"[matrix.success]✓ TRANSCRIPT ON:[/matrix.success] [matrix.accent]{path}[/matrix.accent]\n")
+    elif action == "off":
+        transcript.stop()
+        console.print("[matrix.dim]> Transcript off.[/matrix.dim]\n")
+    else:
+        state = f"on ({transcript.path})" if transcript.enabled else "off"
+        console.print(f"[matrix.dim]> Transcript is {state}. Usage: /transcript on [path] | off[/matrix.dim]\n")
+    return True
+
+def try_handle_dryrun_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/dryrun":
+        return False
+    action = parts[1].lower() if len(parts) > 1 else ""
+    config = ctx.agent.config
+    if action in ("on", "off"):
+        config.dry_run = action == "on"
+        if config.dry_run:
+            console.print("[matrix.warning]◌ DRY RUN ON:[/matrix.warning] [matrix.dim]file changes are previewed, not written[/matrix.dim]\n")
+        else:
+            console.print("[matrix.success]✓ DRY RUN OFF:[/matrix.success] [matrix.dim]file changes are written again[/matrix.dim]\n")
+    else:
+        console.print(f"[matrix.dim]> Dry run is {'on' if config.dry_run else 'off'}. Usage: /dryrun on|off[/matrix.dim]\n")
+    return True
+
+def try_handle_quiet_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/quiet":
+        return False
+    action = parts[1].lower() if len(parts) > 1 else ""
+    config = ctx.agent.config
+    if action in ("on", "off"):
+        config.quiet = action == "on"
+        set_verbosity(QUIET if config.quiet else NORMAL)
+        if config.quiet:
+            console.print("[matrix.success]✓ QUIET ON:[/matrix.success] [matrix.dim]replies, confirmations, errors and stats only[/matrix.dim]")
+        else:
+            console.print("[matrix.success]✓ QUIET OFF:[/matrix.success] [matrix.dim]decorations are back[/matrix.dim]\n")
+    else:
+        console.print(f"[matrix.dim]> Quiet mode is {'on' if config.quiet else 'off'}. Usage: /quiet on|off[/matrix.dim]\n")
+    return True
+
+def try_handle_model_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/model":
+        return False
+    config = ctx.agent.config
+    if len(parts) > 2:
+        console.print("[matrix.warning]⚠ Usage: /model [auto|<name>][/matrix.warning]\n")
+    elif len(parts) == 2 and parts[1].lower() == AUTO:
+        config.model = AUTO
+        use_model(config.resolved_model())
+        console.print(f"[matrix.success]✓ MODEL AUTO:[/matrix.success] [matrix.dim]{escape(config.resolved_chat_model())} for prose, "
+                      f"{escape(config.resolved_coder_model())} for code and tools[/matrix.dim]")
+        warn_model_limits(ctx)
+        console.print()
+    elif len(parts) == 2:
+        config.model = parts[1]
+        use_model(config.model)
+        console.print(f"[matrix.success]✓ MODEL:[/matrix.success] [matrix.accent]{escape(config.model)}[/matrix.accent] [matrix.dim]for every message[/matrix.dim]")
+        warn_model_limits(ctx)
+        console.print()
+    elif config.model == AUTO:
+        route = ctx.agent.loop.last_route
+        last = f" The last message went to {route.model} ({route.reason})." if route else ""
+        console.print(f"[matrix.dim]> Routing between {escape(config.resolved_chat_model())} and {escape(config.resolved_coder_model())}.{escape(last)} "
+                      "Usage: /model auto|<name>[/matrix.dim]\n")
+    else:
+        console.print(f"[matrix.dim]> Model: {escape(config.resolved_model())}. Usage: /model auto|<name>[/matrix.dim]\n")
+    return True
+
+def try_handle_provider_command(ctx: CommandContext, user_input: str) -> bool:
+    """/provider shows which provider requests go to; /provider reset goes back to the configured one after a fallback."""
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/provider":
+        return False
+    providers = ctx.agent.providers
+    if len(parts) == 2 and parts[1].lower() == "reset":
+        if providers.reset():
+            console.print(f"[matrix.success]✓ PROVIDER:[/matrix.success] [matrix.accent]{escape(providers.primary)}[/matrix.accent] "
+                          "[matrix.dim]again; if it is still down, the next request falls back again.[/matrix.dim]\n")
+        else:
+            console.print(f"[matrix.dim]> Already using {escape(providers.primary)}.[/matrix.dim]\n")
+    elif len(parts) > 1:
+        console.print(f"[matrix.warning]⚠ Usage: {escape('/provider [reset]')}[/matrix.warning]\n")
+    else:
+        state = f"{providers.name}, falling back from {providers.primary}" if providers.active else providers.name
+        fallbacks = ", ".join(f"{name} ({providers.unusable[name]})" if name in providers.unusable else name
+                              for name in providers.names[1:])
+        console.print(f"[matrix.dim]> Provider: {escape(state)}. Fallbacks: {escape(fallbacks or 'none; set fallback_providers')}. "
+                      f"Usage: {escape('/provider [reset]')}[/matrix.dim]\n")
+    return True
+
+def try_handle_cache_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/cache":
+        return False
+    action = parts[1].lower() if len(parts) > 1 else ""
+    config = ctx.agent.config
+    cache = ctx.agent.loop.cache
+    if action in ("on", "off"):
+        config.response_cache = action == "on"
+        state = "ON" if config.response_cache else "OFF"
+        console.print(f"[matrix.success]✓ RESPONSE CACHE {state}[/matrix.success]\n")
+    elif action == "clear":
+        removed = cache.clear()
+        console.print(f"[matrix.success]✓ Cleared {removed} cached response{'s' if removed != 1 else ''}.[/matrix.success]\n")
+    elif action:
+        console.print("[matrix.warning]⚠ Usage: /cache [on|off|clear][/matrix.warning]\n")
+    else:
+        hours = config.response_cache_ttl / 3600
+        console.print(f"[matrix.dim]> The response cache is {'on' if config.response_cache else 'off'}: {cache.entries()} entries in "
+                      f"{escape(str(cache.directory))}, kept {hours:g}h. Usage: /cache on|off|clear[/matrix.dim]\n")
+    return True
+
+def describe_model(config: NeoConfig) -> str:
+    """The model replies come from, with its provider, as /stats and the restored-session summary show it."""
+    if config.model == AUTO:
+        return f"auto: {config.resolved_chat_model()} or {config.resolved_coder_model()} [matrix.dim]({config.provider})[/matrix.dim]"
+    return f"{config.resolved_model()} [matrix.dim]({config.provider})[/matrix.dim]"
+
+def active_settings(ctx: CommandContext) -> List[str]:
+    """The settings that change what Neo does, among those on; empty with the defaults."""
+    config = ctx.agent.config
+    return [name for name, on in (("read-only", config.read_only), ("auto-approve", config.auto_approve), ("dry run", config.dry_run),
+                                  ("debug", ctx.debug_log.enabled)) if on]
+
+def try_handle_stats_command(ctx: CommandContext, user_input: str) -> bool:
+    if user_input.strip().lower() != "/stats":
+        return False
+    stats = ctx.agent.stats
+    config = ctx.agent.config
+    model = config.resolved_model()
+
+    table = Table(title="[matrix.accent][ SESSION STATS ][/matrix.accent]", show_header=False, border_style="matrix.border")
+    table.add_column("Metric", style="matrix.accent", no_wrap=True)
+    table.add_column("Value", style="matrix.primary")
+
+    table.add_row("Model", describe_model(config))
+    if len([name for name in stats.turns_by_model if name]) > 1 or config.model == AUTO:
+        table.add_row("Replies by model", ", ".join(f"{name} ×{count}" for name, count in stats.turns_by_model.most_common() if name) or "none yet")
+    table.add_row("Settings", ", ".join(active_settings(ctx)) or "defaults")
+    cached = f", {stats.cached_replies} replayed from the cache" if stats.cached_replies else ""
+    table.add_row("Turns", f"{stats.turns} [matrix.dim]({stats.requests} API requests{cached})[/matrix.dim]")
+    table.add_row("Tokens (estimated)", f"{stats.prompt_tokens:,} prompt / {stats.generated_tokens:,} completion "
+                  f"[matrix.dim]({active_counter().name})[/matrix.dim]")
+    cost = stats.estimated_cost(model)
+    table.add_row("Estimated cost", f"${cost:.4f}" if cost is not None else "[matrix.dim]unknown for this model[/matrix.dim]")
+    if stats.responses:
+        table.add_row("Response time", f"{stats.total_seconds:.1f}s total, first token {stats.average_first_token:.1f}s on average, tools {stats.tool_seconds:.1f}s")
+
+    added_bytes = sum(stats.files_added.values())
+    table.add_row("Files added", f"{len(stats.files_added)} ({format_size(added_bytes)}) [matrix.dim]{len(ctx.conversation.files())} in context now[/matrix.dim]")
+    table.add_row("Tool calls", ", ".join(f"{name} ×{count}" for name, count in stats.tool_calls.most_common()) or "none")
+    table.add_row("Files changed", f"{len(stats.files_created)} created, {len(stats.files_edited)} edited, {len(stats.files_deleted)} deleted")
+    if ctx.tools.truncated_results:
+        table.add_row("Truncated results", f"{ctx.tools.truncated_results} [matrix.dim](~{(ctx.tools.omitted_chars + 3) // 4:,} tokens omitted)[/matrix.dim]")
+
+    used = ctx.conversation.token_count()
+    budget = config.max_context_tokens
+    table.add_row("History", f"{len(ctx.conversation)} messages, ~{used:,} of {budget:,} tokens ({used * 100 // budget}%)")
+    console.print(table)
+    console.print()
+    return True
+
+# The "Added by" of /context for each origin a file can have; saved sessions without one fall back to the file's kind
+CONTEXT_ORIGINS = {"add": "you (/add)", "mention": "you (named in a message)", "preset": "preset (auto)",
+                   "project": "startup (auto)", "tool": "Neo (before an edit)"}
+
+def try_handle_context_command(ctx: CommandContext, user_input: str) -> bool:
+    words = user_input.strip().lower().split()
+    if not words or words[0] != "/context":
+        return False
+    if words[1:] == ["refresh"]:
+        refresh_context(ctx)
+        return True
+    if len(words) > 1:
+        console.print("[matrix.warning]⚠ Usage: /context [refresh][/matrix.warning]\n")
+        return True
+    paths = ctx.conversation.files()
+    if not paths:
+        console.print("[matrix.dim]> Nothing is in context besides the conversation. Add files with /add.[/matrix.dim]\n")
+        return True
+    table = Table(title="[matrix.accent][ CONTEXT ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+    table.add_column("#", style="matrix.dim", justify="right")
+    table.add_column("Path", style="matrix.accent")
+    table.add_column("Kind", style="matrix.dim")
+    table.add_column("Added by", style="matrix.dim")
+    table.add_column("Turn", style="matrix.dim", justify="right")
+    table.add_column("Tokens", style="matrix.primary", justify="right")
+    total = 0
+    preset_files = set(ctx.files.preset_files())
+    for i, path in enumerate(paths, 1):
+        tokens = estimate_string(ctx.conversation.file_content(path) or "")
+        total += tokens
+        kind = ctx.files.kind(path)
+        msg = ctx.conversation.file_message(path) or {}
+        source = CONTEXT_ORIGINS.get(msg.get("origin") or "", "preset (auto)" if path in preset_files
+                                     else "startup (auto)" if kind == "project" else "you")
+        if not is_url(path) and not os.path.exists(path):
+            kind = f"{kind} [matrix.warning](missing on disk)[/matrix.warning]"
+        table.add_row(str(i), escape(context_name(ctx, path)), kind, source, str(msg.get("turn", "-")), f"~{tokens:,}")
+    console.print(table)
+    auto_note = f" ({len(preset_files)} added by {PRESET_PATH})" if preset_files else ""
+    console.print(f"[matrix.dim]> ~{total:,} tokens in {len(paths)} file(s){auto_note} of ~{ctx.conversation.token_count():,} in context.[/matrix.dim]\n")
+    return True
+
+PROJECT_USAGE = "/project [reload]"
+
+def describe_preset(preset: Preset) -> List[str]:
+    """One line per thing the preset sets, for the confirmation and /project."""
+    lines = []
+    if preset.context:
+        lines.append(f"context: {', '.join(preset.context)}")
+    if preset.system_prompt:
+        prompt_lines = preset.system_prompt.strip().splitlines()
+        more = "..." if len(prompt_lines[0]) > 80 or len(prompt_lines) > 1 else ""
+        lines.append(f"system prompt: {prompt_lines[0][:80]}{more}")
+    if preset.model:
+        lines.append(f"model: {preset.model}")
+    if preset.validate_command:
+        lines.append(f"validate_command: {preset.validate_command}")
+    return lines or ["nothing (every key is empty)"]
+
+def confirm_preset(preset: Preset) -> bool:
+    """Ask before applying a preset this project hasn't approved in its current form; yes is remembered."""
+    console.print(f"[matrix.warning]⚠ PROJECT PRESET:[/matrix.warning] [matrix.accent]{escape(preset.path)}[/matrix.accent] "
+                  "[matrix.dim]sets[/matrix.dim]")
+    for line in describe_preset(preset):
+        console.print(f"  [matrix.dim]{escape(line)}[/matrix.dim]")
+    try:
+        answer = prompt_session.prompt("Apply it? [y/N, yes is remembered until the file changes]: ").strip().lower()
+    except (EOFError, KeyboardInterrupt):
+        answer = ""
+    if answer not in ("y", "yes"):
+        console.print("[matrix.dim]> Preset not applied. /project reload asks again.[/matrix.dim]\n")
+        return False
+    try:
+        trust(preset)
+    except OSError as e:
+        console.print(f"[matrix.warning]⚠ Could not remember the answer: {escape(str(e))}[/matrix.warning]")
+    return True
+
+def add_preset_context(ctx: CommandContext, preset: Preset) -> Tuple[Dict[str, int], List[str]]:
+    """Add what the context globs match; returns the tokens added per file, and what could not be added."""
+    config = ctx.agent.config
+    options = ScanOptions(max_files=config.max_scan_files, max_file_size=config.max_file_size,
+                          truncate=config.truncate_strategy, truncate_lines=config.truncate_lines)
+    added: Dict[str, int] = {}
+    problems: List[str] = []
+
+    def add_file(normalized_path: str, content: str, truncation: Optional[Truncation]) -> None:
+        added[normalized_path] = add_file_to_conversation(ctx, normalized_path, content, False, truncation, preset=True)[1]
+
+    for match in preset.matches():
+        try:
+            normalized_path = ctx.workspace.normalize_path(match)
+            if os.path.isdir(normalized_path):
+                scan = ctx.workspace.scan_directory(normalized_path, options=options, on_file=add_file)
+                if scan.limit_reached:
+                    problems.append(f"{match}: stopped at {options.max_files} files")
+            else:
+                add_file(normalized_path, *read_for_context(ctx, normalized_path, options))
+        except (OSError, ValueError) as e:
+            problems.append(f"{match}: {e}")
+    for pattern in preset.context:
+        if not preset.expand(pattern):
+            problems.append(f"{pattern}: matches nothing")
+    return added, problems
+
+def clear_preset(ctx: CommandContext) -> None:
+    """Undo the applied preset: drop its files and prompt appendix, and put back what it replaced."""
+    for path in ctx.files.preset_files():
+        ctx.conversation.remove_file(path)
+        ctx.agent.stats.files_added.pop(path, None)
+    ctx.conversation.set_system_prompt(strip_prompt(ctx.conversation.system_prompt))
+    config = ctx.agent.config
+    if ctx.preset and ctx.preset.model and not ctx.model_pinned:
+        config.model = ctx.before_preset[0]
+        use_model(config.resolved_model())
+    if ctx.preset and ctx.preset.validate_command:
+        config.validate_command = ctx.before_preset[1]
+        ctx.validating = bool(config.validate_command)
+    ctx.preset = None
+
+def apply_preset(ctx: CommandContext) -> None:
+    """Load .neo/project.toml and apply it, replacing any preset applied before.
+
+    Called at startup and by /project reload; without a file, or if you decline, nothing changes.
+    """
+    try:
+        preset = load_preset(ctx.workspace.root)
+    except PresetError as e:
+        console.print(f"[matrix.warning]⚠ PROJECT PRESET:[/matrix.warning] {escape(str(e))}\n")
+        return
+    if preset is None:
+        if ctx.preset:
+            clear_preset(ctx)
+            console.print(f"[matrix.dim]> {PRESET_PATH} is gone; the preset was removed.[/matrix.dim]\n")
+        return
+    if not is_trusted(preset) and not confirm_preset(preset):
+        return
+
+    clear_preset(ctx)
+    config = ctx.agent.config
+    ctx.conversation.set_system_prompt(preset.apply_prompt(ctx.conversation.system_prompt))
+    if preset.model and not ctx.model_pinned:
+        config.model = preset.model
+        use_model(config.resolved_model())
+    if preset.validate_command and not config.read_only:
+        config.validate_command = preset.validate_command
+        ctx.validating = True
+    with console.status(f"[matrix.accent]> APPLYING {PRESET_PATH}...[/matrix.accent]", spinner="dots"):
+        added, problems = add_preset_context(ctx, preset)
+    ctx.preset = preset
+
+    settings = [f"model {preset.model}" if preset.model and not ctx.model_pinned else "",
+                "system prompt" if preset.system_prompt else "", f"validation: {preset.validate_command}" if preset.validate_command else ""]
+    settings_note = f", {', '.join(setting for setting in settings if setting)}" if any(settings) else ""
+    console.print(f"[matrix.success]✓ PROJECT PRESET:[/matrix.success] [matrix.dim]{len(added)} file(s) added "
+                  f"(~{sum(added.values()):,} tokens){escape(settings_note)}[/matrix.dim]")
+    if preset.model and ctx.model_pinned:
+        console.print(f"[matrix.dim]  model {escape(preset.model)} not used: --model {escape(config.model or '')} was given[/matrix.dim]")
+    for problem in problems:
+        console.print(f"  [matrix.warning]⚠ {escape(problem)}[/matrix.warning]")
+    console.print()
+
+def try_handle_project_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/project":
+        return False
+    if parts[1:] == ["reload"]:
+        apply_preset(ctx)
+    elif parts[1:]:
+        console.print(f"[matrix.warning]⚠ Usage: {escape(PROJECT_USAGE)}[/matrix.warning]\n")
+    elif ctx.preset:
+        console.print(f"[matrix.primary]Project preset[/matrix.primary] [matrix.accent]{escape(ctx.preset.path)}[/matrix.accent]")
+        for line in describe_preset(ctx.preset):
+            console.print(f"  [matrix.dim]{escape(line)}[/matrix.dim]")
+        console.print(f"[matrix.dim]> {len(ctx.files.preset_files())} file(s) in context from it. Usage: {escape(PROJECT_USAGE)}[/matrix.dim]\n")
+    else:
+        console.print(f"[matrix.dim]> No project preset applied; create {PRESET_PATH} and run /project reload.[/matrix.dim]\n")
+    return True
+
+SEARCH_USAGE = "/search <text> [--regex] | /search --load <n>"
+MAX_SEARCH_RESULTS = 50
+
+def show_search_hit(number: int, hit: SearchHit) -> None:
+    where = hit.source if hit.branch in (None, MAIN_BRANCH) else f"{hit.source} ({hit.branch})"
+    before, match, after = hit.snippet
+    console.print(f"[matrix.accent]{number:>3}.[/matrix.accent] [matrix.dim]{escape(where)} #{hit.number} {hit.role}:[/matrix.dim] "
+                  f"{escape(before)}[matrix.match]{escape(match)}[/matrix.match]{escape(after)}")
+
+def run_search(ctx: CommandContext, query: str, regex: bool) -> None:
+    """Print hits as the scan finds them: this conversation first, then saved sessions, newest first."""
+    try:
+        pattern = compile_query(query, regex)
+    except re.error as e:
+        console.print(f"[matrix.warning]⚠ Not a valid regular expression: {escape(str(e))}[/matrix.warning]\n")
+        return
+    ctx.search_results = []
+    sessions = session_files(SESSIONS_DIR)
+    stopped = ""
+    try:
+        with console.status("[matrix.accent]> SEARCHING THIS SESSION...[/matrix.accent]", spinner="dots") as status:
+            def scan_sessions():
+                for i, path in enumerate(sessions, 1):
+                    status.update(f"[matrix.accent]> SEARCHING SESSION {i} OF {len(sessions)}: {escape(path.stem)}[/matrix.accent]")
+                    yield from search_session(path, pattern)
+            for hit in itertools.chain(search_messages(ctx.conversation.messages(), pattern, CURRENT), scan_sessions()):
+                ctx.search_results.append(hit)
+                show_search_hit(len(ctx.search_results), hit)
+                if len(ctx.search_results) == MAX_SEARCH_RESULTS:
+                    stopped = f"stopped at {MAX_SEARCH_RESULTS} results; narrow the search"
+                    break
+    except KeyboardInterrupt:
+        stopped = "stopped"
+    found = len(ctx.search_results)
+    summary = f"{found} result{'s' if found != 1 else ''} in this session and {len(sessions)} saved one{'s' if len(sessions) != 1 else ''}"
+    hint = "; /search --load <n> opens a result's session" if any(hit.path for hit in ctx.search_results) else ""
+    console.print(f"[matrix.dim]> {summary}{f' ({stopped})' if stopped else ''}{hint}.[/matrix.dim]\n")
+
+SUMMARY_FILES = 6  # Context files named in the restored-session summary; /context lists them all
+
+def show_session_summary(ctx: CommandContext, session: Dict[str, Any]) -> None:
+    """Where a session just restored stands, in a few lines under the line saying it was restored.
+
+    Made from the restored state alone, with no request, so it shows before the first prompt.
+    """
+    config = ctx.agent.config
+    conversation = ctx.conversation
+    saved_at = session.get("saved_at")
+    title = f"\"{escape(ctx.title.text)}\"" if ctx.title.text else "(untitled)"
+    saved = f" · saved {describe_age(saved_at)} ({describe_time(saved_at)})" if saved_at else ""
+    console.print(f"  [matrix.primary]{title}[/matrix.primary][matrix.dim]{saved} · branch {escape(ctx.branches.active)}[/matrix.dim]")
+
+    history = conversation.history()
+    turns = len([msg for msg in history if msg["role"] == "user"])
+    used = conversation.token_count()
+    budget = config.max_context_tokens
+    console.print(f"  [matrix.dim]{len(history)} messages in {turns} turn{'s' if turns != 1 else ''} · "
+                  f"~{used:,} of {budget:,} tokens ({used * 100 // budget}%)[/matrix.dim]")
+    settings = active_settings(ctx)
+    console.print(f"  [matrix.dim]Model[/matrix.dim] {describe_model(config)}"
+                  + (f" [matrix.dim]·[/matrix.dim] [matrix.warning]{', '.join(settings)}[/matrix.warning]" if settings else ""))
+
+    paths = conversation.files()
+    if not paths:
+        console.print("  [matrix.dim]No files in context.[/matrix.dim]")
+        return
+    sizes = {path: len((conversation.file_content(path) or "").encode("utf-8")) for path in paths}
+    names = []
+    for path in paths[:SUMMARY_FILES]:
+        missing = " [matrix.warning]missing on disk[/matrix.warning]" if not is_url(path) and not os.path.exists(path) else ""
+        names.append(f"[matrix.accent]{escape(context_name(ctx, path))}[/matrix.accent] [matrix.dim]{format_size(sizes[path])}[/matrix.dim]{missing}")
+    more = f", [matrix.dim]{len(paths) - SUMMARY_FILES} more (/context)[/matrix.dim]" if len(paths) > SUMMARY_FILES else ""
+    console.print(f"  [matrix.dim]{len(paths)} file(s) in context, {format_size(sum(sizes.values()))}:[/matrix.dim] {', '.join(names)}{more}")
+
+def load_search_result(ctx: CommandContext, hit: SearchHit) -> None:
+    """Switch to the saved session holding 'hit', saving the conversation being left first."""
+    if hit.path is None:
+        console.print(f"[matrix.dim]> That result is in this conversation, message #{hit.number}.[/matrix.dim]\n")
+        return
+    try:
+        session = load_session(hit.path)
+    except (OSError, ValueError) as e:
+        console.print(f"[matrix.error]✗ ERROR:[/matrix.error] could not load {escape(str(hit.path))}: {escape(str(e))}\n")
+        return
+    config = ctx.agent.config
+    saved = save_session(ctx.conversation, title=ctx.title.text, disabled_tools=sorted(ctx.tools.disabled),
+                         context_files=ctx.files.state(), **ctx.branches.state(config.save_branches))
+    ctx.title = SessionTitle(session.get("title"))
+    system_prompt = ctx.conversation.system_prompt  # Built for this session's tools and preset, so it is kept
+    ctx.conversation.restore(session)
+    ctx.conversation.set_system_prompt(system_prompt)
+    ctx.files.adopt(session.get("context_files"))
+    ctx.tools.disabled = set(session.get("disabled_tools", ctx.tools.disabled))
+    ctx.branches.restore(session)
+    if hit.branch and hit.branch != ctx.branches.active:
+        ctx.branches.switch(hit.branch)
+    ctx.agent.failed_message = None
+    ctx.search_results = []
+    console.print(f"[matrix.success]✓ SESSION LOADED:[/matrix.success] [matrix.accent]{escape(str(hit.path))}[/matrix.accent]")
+    show_session_summary(ctx, session)
+    if saved:
+        console.print(f"[matrix.dim]> The conversation you left was saved to {escape(str(saved))}.[/matrix.dim]")
+    console.print()
+    review_restored_files(ctx)
+
+def show_sessions(sessions: List[SessionInfo]) -> None:
+    table = Table(title="[matrix.accent][ SESSIONS ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+    table.add_column("#", style="matrix.accent", justify="right", no_wrap=True)
+    table.add_column("Saved", style="matrix.primary", no_wrap=True)
+    table.add_column("Title", style="matrix.primary")
+    table.add_column("Messages", style="matrix.dim", justify="right")
+    table.add_column("File", style="matrix.dim", no_wrap=True)
+    now = time.time()
+    for number, session in enumerate(sessions, 1):
+        title = escape(session.title) if session.title else "[matrix.dim](untitled)[/matrix.dim]"
+        table.add_row(str(number), f"{describe_time(session.saved_at, now)} ({describe_age(session.saved_at, now)})", title,
+                      str(session.messages), escape(session.path.stem))
+    console.print(table)
+
+def try_handle_sessions_command(ctx: CommandContext, user_input: str) -> bool:
+    if user_input.strip().lower() != "/sessions":
+        return False
+    sessions = list_sessions()
+    if not sessions:
+        console.print(f"[matrix.dim]> No saved sessions in {escape(str(SESSIONS_DIR))} yet.[/matrix.dim]\n")
+        return True
+    show_sessions(sessions)
+    current = f' This one is "{escape(ctx.title.text)}".' if ctx.title.text else ""
+    console.print(f"[matrix.dim]> Resume one with neo --resume <#> or --resume pick.{current}[/matrix.dim]\n")
+    return True
+
+def pick_session() -> Optional[str]:
+    """--resume pick: list the saved sessions and ask which one; None if there are none or you cancel."""
+    sessions = list_sessions()
+    if not sessions:
+        console.print(f"[matrix.warning]⚠ No saved sessions in {escape(str(SESSIONS_DIR))}.[/matrix.warning]")
+        return None
+    show_sessions(sessions)
+    while True:
+        try:
+            answer = prompt_session.prompt(f"Resume which session? [1-{len(sessions)}, Enter to cancel]: ").strip()
+        except (EOFError, KeyboardInterrupt):
+            return None
+        if not answer:
+            return None
+        if answer.isdigit() and 1 <= int(answer) <= len(sessions):
+            return str(sessions[int(answer) - 1].path)
+        console.print(f"[matrix.warning]⚠ Pick a number from 1 to {len(sessions)}.[/matrix.warning]")
+
+TITLE_USAGE = "/title [text]"
+
+def try_handle_title_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split(maxsplit=1)
+    if not parts or parts[0].lower() != "/title":
+        return False
+    if len(parts) == 1:
+        if ctx.title.text:
+            console.print(f"[matrix.primary]> Title:[/matrix.primary] {escape(ctx.title.text)} [matrix.dim](/title <text> changes it)[/matrix.dim]\n")
+        else:
+            console.print("[matrix.dim]> No title yet; one is suggested after the first exchanges, or set one with /title <text>.[/matrix.dim]\n")
+        return True
+    title = ctx.title.set(parts[1])
+    if title is None:
+        console.print(f"[matrix.warning]⚠ Usage: {escape(TITLE_USAGE)}[/matrix.warning]\n")
+        return True
+    console.print(f"[matrix.success]✓ TITLE SET:[/matrix.success] {escape(title)} [matrix.dim](saved with the session, shown by /sessions)[/matrix.dim]\n")
+    return True
+
+def try_handle_search_command(ctx: CommandContext, user_input: str) -> bool:
+    words = user_input.strip().split()
+    if not words or words[0].lower() != "/search":
+        return False
+    words = words[1:]
+    if words[:1] == ["--load"]:
+        if len(words) != 2 or not words[1].isdigit() or not 1 <= int(words[1]) <= len(ctx.search_results):
+            available = f"1-{len(ctx.search_results)}" if ctx.search_results else "none; run /search first"
+            console.print(f"[matrix.warning]⚠ No such result (have: {available}). Usage: {escape(SEARCH_USAGE)}[/matrix.warning]\n")
+        else:
+            load_search_result(ctx, ctx.search_results[int(words[1]) - 1])
+        return True
+    regex = "--regex" in words
+    query = " ".join(word for word in words if word != "--regex")
+    if not query:
+        console.print(f"[matrix.warning]⚠ Usage: {escape(SEARCH_USAGE)}[/matrix.warning]\n")
+        return True
+    run_search(ctx, query, regex)
+    return True
+
+def try_handle_debug_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/debug":
+        return False
+    action = parts[1].lower() if len(parts) > 1 else ""
+    if action == "on":
+        log_path = ctx.debug_log.start()
+        console.print(f"[matrix.success]✓ DEBUG LOGGING ON:[/matrix.success] [matrix.accent]{log_path}[/matrix.accent]\n")
+    elif action == "off":
+        ctx.debug_log.stop()
+        console.print("[matrix.dim]> Debug logging off.[/matrix.dim]\n")
+    else:
+        state = f"on ({ctx.debug_log.path})" if ctx.debug_log.enabled else "off"
+        console.print(f"[matrix.dim]> Debug logging is {state}. Usage: /debug on|off[/matrix.dim]\n")
+    return True
+
+def show_tools_table(tools: ToolRegistry) -> None:
+    table = Table(title="[matrix.accent][ TOOLS ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+    table.add_column("Tool", style="matrix.accent", no_wrap=True)
+    table.add_column("State")
+    table.add_column("Kind", style="matrix.dim")
+    table.add_column("Source", style="matrix.dim")
+    table.add_column("Description", style="matrix.primary")
+    for tool in tools.all():
+        state = "[matrix.success]enabled[/matrix.success]" if tools.is_enabled(tool.name) else "[matrix.error]disabled[/matrix.error]"
+        table.add_row(tool.name, state, "write" if tool.mutating else "read", tool.source, escape(tool.summary))
+    console.print(table)
+
+def try_handle_tools_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/tools":
+        return False
+    action = parts[1].lower() if len(parts) > 1 else ""
+    names = parts[2:]
+    try:
+        if action in ("enable", "disable"):
+            if not names:
+                console.print(f"[matrix.warning]⚠ Usage: /tools {action} <tool> [tool...][/matrix.warning]\n")
+                return True
+            if action == "enable":
+                ctx.tools.enable(names)
+            else:
+                ctx.tools.disable(names)
+            console.print(f"[matrix.success]✓ {action.upper()}D:[/matrix.success] [matrix.accent]{', '.join(names)}[/matrix.accent]")
+        elif action == "readonly":
+            ctx.tools.set_read_only()
+            console.print("[matrix.success]✓ READ-ONLY:[/matrix.success] [matrix.dim]tools that write files are disabled[/matrix.dim]")
+        elif action:
+            console.print("[matrix.warning]⚠ Usage: /tools [enable|disable <tool>...|readonly][/matrix.warning]\n")
+            return True
+    except ValueError as e:
+        console.print(f"[matrix.error]✗ ERROR:[/matrix.error] {escape(str(e))}\n")
+        return True
+    show_tools_table(ctx.tools)
+    console.print()
+    return True
+
+PLAIN_PROMPT = "neo@matrix:~$: "
+READ_ONLY_PROMPT = "neo@matrix[read-only]:~$: "
+
+def model_short_name(config) -> str:
+    """'deepseek-reasoner' -> 'reasoner'; names without the provider prefix are kept."""
+    if config.model == AUTO:
+        return AUTO
+    name = config.resolved_model().split("/")[-1]
+    prefix = f"{config.provider}-"
+    return name[len(prefix):] if name.startswith(prefix) and len(name) > len(prefix) else name
+
+def prompt_message(ctx: CommandContext):
+    """The prompt prefix: the model and how full the context is, or the plain prefix if disabled.
+
+    Either way it says so when the session is read-only.
+    """
+    config = ctx.agent.config
+    if not config.dynamic_prompt:
+        return READ_ONLY_PROMPT if config.read_only else PLAIN_PROMPT
+    percent = ctx.conversation.token_count() * 100 // max(config.max_context_tokens, 1)
+    color = "ansigreen" if percent < 60 else "ansiyellow" if percent < 85 else "ansired"
+    read_only = [("ansibrightgreen", "|"), ("ansired", "read-only")] if config.read_only else []
+    return FormattedText([
+        ("ansibrightgreen", "neo["),
+        ("ansibrightcyan", model_short_name(config)),
+        ("ansibrightgreen", "|"),
+        (color, f"{percent}%"),
+        *read_only,
+        ("ansibrightgreen", "]> "),
+    ])
+
+def check_changed_files(ctx: CommandContext) -> None:
+    """Before a message is sent, deal with files in context that changed on disk since they were added."""
+    mode = ctx.agent.config.refresh_changed_files
+    if mode == "off":
+        return
+    stale = ctx.files.stale()
+    manifests = [entry for entry in stale if ctx.files.kind(entry[0]) == "project"]
+    for path, change in manifests:
+        ctx.files.refresh(path)  # Metadata Neo added itself, so it is kept current without asking
+        console.print(f"[matrix.dim]↻ Project metadata {change}: {escape(os.path.basename(path))}[/matrix.dim]")
+    stale = [entry for entry in stale if entry not in manifests]
+    if not stale:
+        return
+    console.print(f"[matrix.warning]⚠ {len(stale)} file(s) in context changed on disk:[/matrix.warning]")
+    for path, change in stale:
+        console.print(f"  [matrix.dim]{change}:[/matrix.dim] [matrix.accent]{path}[/matrix.accent]")
+    if mode == "ask":
+        try:
+            answer = prompt_session.prompt("Refresh them in the context? [Y/n]: ").strip().lower()
+        except (EOFError, KeyboardInterrupt):
+            answer = "n"
+        if answer not in ("", "y", "yes"):
+            for path, _ in stale:
+                ctx.files.accept(path)
+            console.print("[matrix.dim]> Keeping the old copies.[/matrix.dim]\n")
+            return
+    for path, _ in stale:
+        ctx.files.refresh(path)
+    console.print(f"[matrix.success]↻ Refreshed {len(stale)} file(s) in context.[/matrix.success]\n")
+
+def review_restored_files(ctx: CommandContext) -> None:
+    """After a session is resumed, settle the files whose saved copies no longer match the disk.
+
+    A file deleted since keeps its saved copy, marked missing in /context. For each
+    changed one you keep the saved copy, refresh it from disk or drop it, or refresh
+    them all at once; with refresh_changed_files "auto" all are refreshed, and with
+    "off" they are left alone.
+    """
+    mode = ctx.agent.config.refresh_changed_files
+    if mode == "off":
+        return
+    stale = ctx.files.stale()
+    for path in [path for path, _ in stale if ctx.files.kind(path) == "project"]:
+        ctx.files.refresh(path)  # Metadata Neo added itself, as in check_changed_files
+    missing = [path for path, change in stale if change == "deleted" and ctx.files.kind(path) != "project"]
+    changed = [path for path, change in stale if change == "changed" and ctx.files.kind(path) != "project"]
+    root = ctx.workspace.root
+    if missing:
+        console.print(f"[matrix.warning]⚠ {len(missing)} file(s) from the saved session are gone from disk; "
+                      "their saved copies are kept, marked missing in /context:[/matrix.warning]")
+        for path in missing:
+            ctx.files.accept(path)
+            console.print(f"  [matrix.accent]{escape(os.path.relpath(path, root))}[/matrix.accent]")
+    if not changed:
+        if missing:
+            console.print()
+        return
+    now = time.time()
+    console.print(f"[matrix.warning]⚠ {len(changed)} file(s) changed on disk since the session saved them:[/matrix.warning]")
+    for path in changed:
+        added = (ctx.conversation.file_message(path) or {}).get("ts")
+        when = f" [matrix.dim](added {describe_age(added, now)})[/matrix.dim]" if added else ""
+        console.print(f"  [matrix.accent]{escape(os.path.relpath(path, root))}[/matrix.accent]{when}")
+    answer = "a" if mode == "auto" else ""
+    if mode == "ask":
+        try:
+            answer = prompt_session.prompt("Refresh all changed files? [a = all, k = keep all saved copies, Enter = ask for each]: ").strip().lower()
+        except (EOFError, KeyboardInterrupt):
+            answer = "k"
+    refreshed, kept, dropped = 0, 0, 0
+    for path in changed:
+        choice = "r" if answer in ("a", "all") else "k" if answer in ("k", "keep") else ""
+        while choice not in ("k", "r", "d"):
+            try:
+                choice = prompt_session.prompt(f"{os.path.relpath(path, root)}: keep the saved copy, refresh it from disk, "
+                                               "or drop it from context? [K/r/d]: ").strip().lower()[:1] or "k"
+            except (EOFError, KeyboardInterrupt):
+                choice = "k"
+        if choice == "r":
+            ctx.files.refresh(path)
+            refreshed += 1
+        elif choice == "d":
+            ctx.conversation.remove_file(path)
+            dropped += 1
+        else:
+            ctx.files.accept(path)
+            kept += 1
+    done = [f"refreshed {refreshed}"] if refreshed else []
+    done += [f"kept {kept} saved cop{'ies' if kept != 1 else 'y'}"] if kept else []
+    done += [f"dropped {dropped}"] if dropped else []
+    console.print(f"[matrix.success]↻ Restored files:[/matrix.success] [matrix.dim]{', '.join(done)}.[/matrix.dim]\n")
+
+def add_mentioned(ctx: CommandContext, message: str) -> None:
+    """Before a message is sent, add the files it names by path, asking first unless add_mentioned_files is "auto".
+
+    A file you turn down is not offered again this session.
+    """
+    mode = ctx.agent.config.add_mentioned_files
+    if mode == "off":
+        return
+    for path in mentioned_files(message, ctx.workspace, ctx.conversation.files() + sorted(ctx.declined_mentions)):
+        relative = os.path.relpath(path, ctx.workspace.root)
+        try:
+            content = ctx.workspace.read_file(path)
+        except (OSError, UnicodeDecodeError):
+            continue
+        tokens = estimate_string(content)
+        if mode == "ask":
+            try:
+                answer = prompt_session.prompt(f"Add {relative} (~{tokens:,} tokens) to the context? [Y/n]: ").strip().lower()
+            except (EOFError, KeyboardInterrupt):
+                answer = "n"
+            if answer not in ("", "y", "yes"):
+                ctx.declined_mentions.add(path)
+                continue
+        add_file_to_conversation(ctx, path, content, False, origin="mention")
+        console.print(f"[matrix.success]✓ ADDED FROM YOUR MESSAGE:[/matrix.success] [matrix.accent]{escape(relative)}[/matrix.accent] "
+                      f"[matrix.dim](~{tokens:,} tokens)[/matrix.dim]")
+
+def warn_costly_files(ctx: CommandContext) -> None:
+    """Before a message is sent, point out files in context that are very large or no longer mentioned, once each."""
+    config = ctx.agent.config
+    findings = ctx.context_check.check(ctx.conversation, ctx.workspace.root, ctx.agent.stats.turns,
+                                       config.large_file_tokens, config.unused_file_turns, ctx.files.split_files())
+    for finding in findings:
+        path = escape(os.path.relpath(finding.path, ctx.workspace.root) if ctx.workspace.contains(finding.path) else finding.path)
+        if finding.reason == "large":
+            console.print(f"[matrix.warning]⚠ LARGE FILE IN CONTEXT:[/matrix.warning] [matrix.accent]{path}[/matrix.accent] "
+                          f"[matrix.dim]is ~{finding.tokens:,} tokens (over large_file_tokens = {config.large_file_tokens:,}) "
+                          "and goes with every request.[/matrix.dim]")
+            console.print(f"  [matrix.dim]/forget file {path} drops it; /add {path} --max-size {config.large_file_tokens * 4 // 1024 or 1}k "
+                          "--truncate head-tail keeps its start and end.[/matrix.dim]")
+        else:
+            console.print(f"[matrix.warning]⚠ UNUSED FILE IN CONTEXT:[/matrix.warning] [matrix.accent]{path}[/matrix.accent] "
+                          f"[matrix.dim]hasn't come up in {finding.turns} turns but still adds ~{finding.tokens:,} tokens to every request. "
+                          f"/forget file {path} drops it.[/matrix.dim]")
+
+def confirm_large_request(ctx: CommandContext, message: str) -> bool:
+    """Ask before sending a request estimated above large_request_tokens; anything but yes cancels it."""
+    config = ctx.agent.config
+    if not config.large_request_tokens or ctx.large_requests_ok:
+        return True
+    # The same estimate that trimming uses, plus the tool definitions sent alongside
+    tokens = ctx.conversation.request_tokens(message, config.max_context_tokens, config.max_history_messages)
+    tokens += estimate_string(json.dumps(ctx.tools.definitions()))
+    if tokens <= config.large_request_tokens:
+        return True
+    cost = SessionStats.prompt_cost(config.resolved_model(), tokens)
+    cost_note = f", about ${cost:.3f} in input" if cost is not None else ""
+    console.print(f"[matrix.warning]⚠ LARGE REQUEST:[/matrix.warning] [matrix.dim]~{tokens} prompt tokens{cost_note} "
+                  f"(over large_request_tokens = {config.large_request_tokens}). Requests after tool calls resend it.[/matrix.dim]")
+    files = [(path, estimate_string(ctx.conversation.file_content(path))) for path in ctx.conversation.files()]
+    for path, file_tokens in sorted(files, key=lambda f: -f[1])[:3]:
+        console.print(f"  [matrix.dim]~{file_tokens} tokens:[/matrix.dim] [matrix.accent]{path}[/matrix.accent]")
+    try:
+        answer = prompt_session.prompt("Send it? [y/N, a = yes and don't ask again this session]: ").strip().lower()
+    except (EOFError, KeyboardInterrupt):
+        answer = ""
+    if answer in ("a", "always"):
+        ctx.large_requests_ok = True
+        return True
+    if answer in ("y", "yes"):
+        return True
+    console.print("[matrix.dim]> Not sent. /forget file <path> drops a file from context.[/matrix.dim]\n")
+    return False
+
+def send_message(ctx: CommandContext, message: str, retry: bool = False) -> None:
+    """Send a message, or with 'retry' resend the one whose request failed."""
+    if ctx.agent.client is None:
+        console.print("[matrix.warning]> Offline: chat is unavailable. Restart without --offline to talk to Neo.[/matrix.warning]\n")
+        return
+
+    if not retry:
+        message = pre_send(ctx, message)
+        if message is None:
+            return
+    check_changed_files(ctx)
+    if not retry:
+        add_mentioned(ctx, message)
+    warn_costly_files(ctx)
+    if ctx.validation_report and not retry:
+        console.print("[matrix.dim]> The failed validation output goes along with this message.[/matrix.dim]")
+        message = f"{ctx.validation_report}\n\n{message}"
+        ctx.validation_report = None
+    if not confirm_large_request(ctx, message):
+        return
+    writes = ctx.changes.writes
+    written = dict(ctx.changes.written)
+    ctx.last_validation = None
+    response_data = ctx.agent.retry() if retry else ctx.agent.stream_response(message)
+
+    if response_data.get("error"):
+        console.print(f"[matrix.error]> SYSTEM ERROR: {response_data['error']}[/matrix.error]")
+    if ctx.agent.failed_message is not None:
+        console.print("[matrix.dim]> Your message was kept. /retry, or Enter on an empty line, sends it again.[/matrix.dim]\n")
+    if ctx.changes.writes != writes:
+        validate_changes(ctx)
+    if not response_data.get("error"):
+        post_receive(ctx, message, ctx.agent.last_reply)
+        show_hints(ctx, {path for path, digest in ctx.changes.written.items() if written.get(path) != digest})
+    request_title(ctx)
+
+def hook_payload(ctx: CommandContext, event: str, message: str) -> Dict[str, Any]:
+    return {"event": event, "message": message, "model": ctx.agent.config.resolved_model(), "workspace": ctx.workspace.root,
+            "turn": ctx.conversation.turn + (event == "pre_send")}
+
+def pre_send(ctx: CommandContext, message: str) -> Optional[str]:
+    """Run pre_send_hook on a message you typed: the message to send, or None if the hook stopped it."""
+    config = ctx.agent.config
+    if not config.pre_send_hook:
+        return message
+    name, path = "pre_send_hook", config.pre_send_hook
+    try:
+        result = run_hook(name, path, hook_payload(ctx, "pre_send", message), ctx.workspace.root, config.hook_timeout,
+                          child_env(config.child_env_allowlist))
+        if result.exit_code != 0:
+            console.print(f"[matrix.warning]✗ NOT SENT:[/matrix.warning] [matrix.dim]{name} {escape(path)} exited with "
+                          f"{result.exit_code}:[/matrix.dim] {escape(result.reason())}\n")
+            return None
+        rewritten = rewritten_message(name, path, result)
+        if rewritten is not None and not rewritten.strip():
+            raise HookError(f"{name} {path} replaced the message with nothing")
+    except HookError as e:
+        console.print(f"[matrix.warning]⚠ HOOK FAILED:[/matrix.warning] [matrix.dim]{escape(str(e))}; sending your message as you typed it.[/matrix.dim]")
+        return message
+    if rewritten is None or rewritten == message:
+        return message
+    console.print(f"[matrix.dim]> {name} rewrote your message ({len(message):,} → {len(rewritten):,} characters).[/matrix.dim]")
+    return rewritten
+
+def post_receive(ctx: CommandContext, message: str, reply: str) -> None:
+    """Give post_receive_hook the final reply to 'message'; it can only fail, never change anything."""
+    config = ctx.agent.config
+    if not config.post_receive_hook:
+        return
+    name, path = "post_receive_hook", config.post_receive_hook
+    try:
+        result = run_hook(name, path, {**hook_payload(ctx, "post_receive", message), "reply": reply}, ctx.workspace.root,
+                          config.hook_timeout, child_env(config.child_env_allowlist))
+    except HookError as e:
+        console.print(f"[matrix.warning]⚠ HOOK FAILED:[/matrix.warning] [matrix.dim]{escape(str(e))}.[/matrix.dim]")
+        return
+    if result.exit_code != 0:
+        console.print(f"[matrix.warning]⚠ HOOK FAILED:[/matrix.warning] [matrix.dim]{name} {escape(path)} exited with "
+                      f"{result.exit_code}:[/matrix.dim] {escape(result.reason())}")
+
+def show_hints(ctx: CommandContext, written: Set[str]) -> None:
+    """Print the tips neo_core.hints finds for the turn just finished; 'written' is what it wrote."""
+    config = ctx.agent.config
+    if not config.show_hints:
+        return
+    facts = TurnFacts(ctx.workspace.root, ctx.agent.last_code_blocks, written,
+                      ctx.last_validation if ctx.validation_report else None,
+                      ctx.conversation.token_count(), config.max_context_tokens)
+    for tip in ctx.hints.tips(facts):
+        decorate(f"[matrix.dim]tip: {escape(tip)}[/matrix.dim]")
+
+def request_title(ctx: CommandContext) -> None:
+    """After the first few exchanges, ask in the background for the session's title; never for the mock provider."""
+    history = ctx.conversation.history()
+    if ctx.agent.config.provider == "mock" or not ctx.title.due(history):
+        return
+    ctx.title.request(ctx.agent.create_chat_stream, history, ctx.agent.config.resolved_chat_model())
+
+def show_validation(result: ValidationResult) -> None:
+    if result.passed:
+        console.print(f"[matrix.success]✓ VALIDATION PASSED:[/matrix.success] [matrix.dim]{escape(result.describe())}[/matrix.dim]\n")
+        return
+    console.print(Panel(escape(result.output or "(no output)"), title=f"[matrix.error][ VALIDATION FAILED: {escape(result.describe())} ][/matrix.error]",
+                        border_style="matrix.error", title_align="left"))
+
+def validate_changes(ctx: CommandContext) -> None:
+    """Run validate_command after a turn that wrote files. A failure goes back to the model to fix,
+    at most max_autofix_rounds times in a row; after that it waits for your next message."""
+    config = ctx.agent.config
+    if not ctx.validating or not config.validate_command:
+        return
+    for round in range(config.max_autofix_rounds + 1):
+        with console.status(f"[matrix.accent]> VALIDATING: {escape(config.validate_command)}[/matrix.accent]", spinner="dots"):
+            result = run_validation(config.validate_command, ctx.workspace.root, config.validate_timeout,
+                                    child_env(config.child_env_allowlist))
+        show_validation(result)
+        ctx.last_validation = result
+        if result.passed:
+            ctx.validation_report = None
+            return
+        if round == config.max_autofix_rounds:
+            break
+        console.print(f"[matrix.warning]> Sending the failure to Neo to fix (round {round + 1} of {config.max_autofix_rounds})[/matrix.warning]")
+        writes = ctx.changes.writes
+        response_data = ctx.agent.stream_response(report_for_model(result))
+        if response_data.get("error"):
+            console.print(f"[matrix.error]> SYSTEM ERROR: {response_data['error']}[/matrix.error]\n")
+            return
+        if ctx.changes.writes == writes:
+            console.print("[matrix.dim]> Neo changed no files, so validation was not run again.[/matrix.dim]\n")
+            return
+    ctx.validation_report = report_for_model(result)
+    stopped = f"Stopped after {config.max_autofix_rounds} automatic fix round{'s' if config.max_autofix_rounds != 1 else ''}. " if config.max_autofix_rounds else ""
+    console.print(f"[matrix.dim]> {stopped}The failure will be sent with your next message.[/matrix.dim]\n")
+
+def try_handle_validate_command(ctx: CommandContext, user_input: str) -> bool:
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/validate":
+        return False
+    action = parts[1].lower() if len(parts) > 1 else ""
+    command = ctx.agent.config.validate_command
+    if action in ("on", "run") and refuse_read_only(ctx, f"/validate {action}", "runs a command"):
+        return True
+    if action in ("on", "off", "run") and not command:
+        console.print("[matrix.warning]⚠ No validation command is set.[/matrix.warning] [matrix.dim]Add \"validate_command\" to the config file, e.g. \"go build ./...\".[/matrix.dim]\n")
+    elif action in ("on", "off"):
+        ctx.validating = action == "on"
+        console.print(f"[matrix.success]✓ VALIDATION {action.upper()}:[/matrix.success] [matrix.dim]{escape(command)}[/matrix.dim]\n")
+    elif action == "run":
+        result = run_validation(command, ctx.workspace.root, ctx.agent.config.validate_timeout,
+                                child_env(ctx.agent.config.child_env_allowlist))
+        show_validation(result)
+        ctx.last_validation = result
+        ctx.validation_report = None if result.passed else report_for_model(result)
+        if not result.passed:
+            console.print("[matrix.dim]> The failure will be sent with your next message.[/matrix.dim]")
+            show_hints(ctx, set())
+            console.print()
+    else:
+        state = f"{'on' if ctx.validating else 'off'} ({escape(command)})" if command else "not configured"
+        console.print(f"[matrix.dim]> Validation is {state}. Usage: /validate on|off|run[/matrix.dim]\n")
+    return True
+
+def try_handle_retry_command(ctx: CommandContext, user_input: str) -> bool:
+    """/retry, or an empty line while the last message failed, resends that message."""
+    if user_input.strip().lower() not in ("/retry", ""):
+        return False
+    message = ctx.agent.failed_message
+    if message is None:
+        if user_input.strip():
+            console.print("[matrix.warning]⚠ Nothing to retry: the last message did not fail.[/matrix.warning]\n")
+        return True
+    preview = " ".join(message.split())
+    console.print(f"[matrix.dim]> Retrying: {escape(preview[:70] + ('…' if len(preview) > 70 else ''))}[/matrix.dim]")
+    send_message(ctx, message, retry=True)
+    return True
+
+ASK_USAGE = "/ask [--keep] <question>"
+
+def try_handle_ask_command(ctx: CommandContext, user_input: str) -> bool:
+    """/ask <question>: a side question sent with only the system prompt; --keep adds the exchange to the conversation."""
+    parts = user_input.strip().split(maxsplit=1)
+    if not parts or parts[0].lower() != "/ask":
+        return False
+    question = parts[1].strip() if len(parts) > 1 else ""
+    keep = question == "--keep" or question.startswith("--keep ")
+    if keep:
+        question = question[len("--keep"):].strip()
+    if not question:
+        console.print(f"[matrix.warning]⚠ Usage: {escape(ASK_USAGE)}[/matrix.warning]\n")
+        return True
+    if ctx.agent.client is None:
+        console.print("[matrix.warning]> Offline: chat is unavailable. Restart without --offline to talk to Neo.[/matrix.warning]\n")
+        return True
+    response_data = ctx.agent.ask(question, keep)  # Reports its own errors
+    if keep and not response_data.get("error"):
+        console.print("[matrix.dim]> Kept: the question and answer are now part of the conversation.[/matrix.dim]\n")
+    return True
+
+REVIEW_USAGE = "/review [<ref>] [--json]"
+
+def try_handle_review_command(ctx: CommandContext, user_input: str) -> bool:
+    """/review [ref] reviews the diff against ref (default: the uncommitted changes) in requests of its own."""
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/review":
+        return False
+    as_json = "--json" in parts[1:]
+    refs = [part for part in parts[1:] if part != "--json"]
+    if len(refs) > 1 or any(ref.startswith("-") for ref in refs):
+        console.print(f"[matrix.warning]⚠ Usage: {REVIEW_USAGE}[/matrix.warning]\n")
+        return True
+    if ctx.agent.client is None:
+        console.print("[matrix.warning]> Offline: /review needs the API.[/matrix.warning]\n")
+        return True
+    try:
+        diff = Repository(ctx.workspace.root).diff(refs[0] if refs else None)
+    except GitError as e:
+        console.print(f"[matrix.error]✗ GIT:[/matrix.error] {escape(str(e))}\n")
+        return True
+    if not diff.strip():
+        console.print(f"[matrix.dim]> Nothing to review: no changes against {escape(refs[0] if refs else 'HEAD')}.[/matrix.dim]\n")
+        return True
+
+    diff = ctx.tools.ctx.redactor.redact(diff, "diff")
+    try:
+        with console.status("[matrix.accent]> REVIEWING...[/matrix.accent]", spinner="dots") as status:
+            findings, files = review_diff(diff, ctx.agent.create_chat_stream, ctx.agent.config.resolved_model(),
+                                          lambda i, n: status.update(f"[matrix.accent]> REVIEWING: chunk {i} of {n}...[/matrix.accent]"))
+    except Exception as e:
+        console.print(f"[matrix.error]✗ The review request failed: {escape(str(e))}[/matrix.error]\n")
+        return True
+    if as_json:
+        console.print(escape(findings_json(findings)) + "\n")
+    else:
+        show_findings(findings, files)
+    return True
+
+PATCH_USAGE = "/patch [<file>] [--show]"
+
+def try_handle_patch_command(ctx: CommandContext, user_input: str) -> bool:
+    """/patch writes every file change of the session as one patch that git apply can read."""
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/patch":
+        return False
+    show = "--show" in parts[1:]
+    targets = [part for part in parts[1:] if part != "--show"]
+    if len(targets) > 1 or any(target.startswith("--") for target in targets):
+        console.print(f"[matrix.warning]⚠ Usage: {PATCH_USAGE}[/matrix.warning]\n")
+        return True
+    if refuse_read_only(ctx, "/patch", "writes a patch file"):
+        return True
+
+    patches, skipped = ctx.changes.patches()
+    for path, reason in skipped:
+        console.print(f"[matrix.warning]⚠ Left out of the patch:[/matrix.warning] [matrix.accent]{escape(path)}[/matrix.accent] [matrix.dim]({reason})[/matrix.dim]")
+    changed = [patch for patch in patches if patch.diff]
+    if not changed:
+        console.print("[matrix.dim]> No file changes this session.[/matrix.dim]\n")
+        return True
+
+    target = targets[0] if targets else f"neo-session-{time.strftime('%Y%m%d-%H%M%S')}.patch"
+    path = os.path.join(ctx.workspace.root, os.path.expanduser(target))
+    try:
+        with open(path, "w", encoding="utf-8", newline="") as f:
+            f.write("".join(patch.diff for patch in changed))
+    except OSError as e:
+        console.print(f"[matrix.error]✗ Could not write {escape(target)}: {escape(str(e))}[/matrix.error]\n")
+        return True
+
+    for patch in changed:
+        if show:
+            show_unified_diff(patch.path, patch.old, patch.new)
+        if patch.changed_outside:
+            console.print(f"[matrix.warning]⚠ {escape(patch.path)} was changed outside Neo after its last write;[/matrix.warning] "
+                          "[matrix.dim]the patch includes those changes too.[/matrix.dim]")
+    console.print(f"[matrix.success]✓ PATCH WRITTEN:[/matrix.success] [matrix.accent]{escape(path)}[/matrix.accent] "
+                  f"[matrix.dim]({len(changed)} file{'s' if len(changed) != 1 else ''}; apply with git apply)[/matrix.dim]\n")
+    return True
+
+def try_handle_diff_command(ctx: CommandContext, user_input: str) -> bool:
+    """/diff lists the files Neo changed this session with their line counts; /diff <path> shows its latest change to one."""
+    parts = user_input.strip().split(maxsplit=1)
+    if not parts or parts[0].lower() != "/diff":
+        return False
+    if len(parts) == 1:
+        show_session_changes(ctx)
+        return True
+    path = parts[1].strip()
+    try:
+        normalized_path = ctx.workspace.normalize_path(path)
+        patch = ctx.changes.last_patch(normalized_path)
+    except UnicodeDecodeError:
+        console.print(f"[matrix.warning]⚠ {escape(path)} is not UTF-8 text, so it can't be diffed.[/matrix.warning]\n")
+        return True
+    except (OSError, ValueError) as e:
+        console.print(f"[matrix.error]✗ ERROR:[/matrix.error] {escape(str(e))}\n")
+        return True
+    if patch is None:
+        console.print(f"[matrix.dim]> Neo hasn't changed {escape(path)} this session. /diff lists the files it has.[/matrix.dim]\n")
+        return True
+    added, removed = patch.line_counts()
+    note = {"created": "created by Neo's latest write", "deleted": "deleted by Neo's latest write"}.get(patch.status, "Neo's latest write")
+    console.print(f"[matrix.dim]> {escape(patch.path)}: {note}, +{added} -{removed}[/matrix.dim]")
+    show_unified_diff(patch.path, patch.old, patch.new)
+    if patch.changed_outside:
+        console.print(f"[matrix.warning]⚠ {escape(patch.path)} was changed outside Neo after that write;[/matrix.warning] "
+                      "[matrix.dim]the diff includes those changes too.[/matrix.dim]")
+    console.print()
+    return True
+
+def show_session_changes(ctx: CommandContext) -> None:
+    """Each file Neo changed this session, from how it started to how it is now, with lines added and removed."""
+    patches, skipped = ctx.changes.patches()
+    changed = [patch for patch in patches if patch.diff]
+    if not changed and not skipped:
+        console.print("[matrix.dim]> No file changes this session.[/matrix.dim]\n")
+        return
+    table = Table(title="[matrix.accent][ CHANGED THIS SESSION ][/matrix.accent]", border_style="matrix.border")
+    table.add_column("File", style="matrix.accent")
+    table.add_column("Change", style="matrix.dim")
+    table.add_column("+", style="matrix.success", justify="right")
+    table.add_column("-", style="matrix.error", justify="right")
+    for patch in changed:
+        added, removed = patch.line_counts()
+        status = patch.status + (", also outside Neo" if patch.changed_outside else "")
+        table.add_row(escape(patch.path), status, str(added), str(removed))
+    for path, reason in skipped:
+        table.add_row(escape(path), reason, "", "")
+    console.print(table)
+    unchanged = len(patches) - len(changed)
+    back = f"{unchanged} file{'s' if unchanged != 1 else ''} changed and then put back. " if unchanged else ""
+    console.print(f"[matrix.dim]> {back}/diff <path> shows Neo's latest change to a file; /patch exports the whole session's.[/matrix.dim]\n")
+
+COMMIT_USAGE = "/commit [--remember]"
+MAX_COMMIT_DIFF_CHARS = 40_000  # Larger staged diffs are cut down before asking for a message
+COMMIT_PROMPT = """Write a git commit message for the staged changes below, in the Conventional Commits style:
+a subject line of the form "type(scope): summary" (type is one of feat, fix, refactor, docs, test,
+chore, perf, build, ci or style; the scope is optional), at most 72 characters, in the imperative
+mood and without a trailing period. If the change needs explaining, add a blank line and a short
+body saying what changed and why, wrapped at 72 columns. Reply with the message only, with no code
+fence and no commentary.
+
+Staged files: {files}
+
+{diff}"""
+
+def generate_commit_message(ctx: CommandContext, files: List[str], diff: str) -> str:
+    """Ask the model for a message in a request of its own: no tools and none of the conversation."""
+    diff = ctx.tools.ctx.redactor.redact(diff, "staged diff")
+    if len(diff) > MAX_COMMIT_DIFF_CHARS:
+        diff = diff[:MAX_COMMIT_DIFF_CHARS] + f"\n[... {len(diff) - MAX_COMMIT_DIFF_CHARS} more characters of the diff omitted]"
+    with console.status("[matrix.accent]> COMPOSING COMMIT MESSAGE...[/matrix.accent]", spinner="dots"):
+        content, _ = collect_stream(ctx.agent.create_chat_stream(
+            model=ctx.agent.config.resolved_model(),
+            messages=[{"role": "user", "content": COMMIT_PROMPT.format(files=", ".join(files), diff=diff)}],
+            max_completion_tokens=2000,
+        ), lambda event: None)
+    fenced = re.match(r"^```[^\n]*\n(.*?)\n?```$", content.strip(), re.DOTALL)
+    return (fenced.group(1) if fenced else content).strip()
+
+def stage_neo_changes(ctx: CommandContext, repo: Repository) -> None:
+    """Offer to stage the files Neo created or edited this session that have unstaged changes."""
+    paths = repo.unstaged(sorted(ctx.agent.stats.files_created | ctx.agent.stats.files_edited))
+    if not paths:
+        return
+    console.print(f"[matrix.primary]> {len(paths)} file{'s' if len(paths) != 1 else ''} changed by Neo {'are' if len(paths) != 1 else 'is'} not staged:[/matrix.primary]")
+    for path in paths:
+        console.print(f"  [matrix.accent]{escape(path)}[/matrix.accent]")
+    try:
+        answer = prompt_session.prompt("Stage them? [y/N]: ").strip().lower()
+    except (EOFError, KeyboardInterrupt):
+        answer = ""
+    if answer in ("y", "yes"):
+        repo.stage(paths)
+
+def review_commit_message(message: str) -> Optional[str]:
+    """Show the proposed message and return it, edited or not, or None if the user cancels."""
+    while True:
+        console.print(Panel(escape(message), title="[matrix.accent][ PROPOSED COMMIT MESSAGE ][/matrix.accent]",
+                            border_style="matrix.border", title_align="left"))
+        try:
+            answer = prompt_session.prompt("Commit with this message? [y/e = edit/N]: ").strip().lower()
+            if answer in ("e", "edit"):
+                console.print("[matrix.dim]> Edit the message; Esc then Enter finishes.[/matrix.dim]")
+                message = prompt_session.prompt("", default=message, multiline=True).strip()
+                if not message:
+                    return None
+                continue
+        except (EOFError, KeyboardInterrupt):
+            return None
+        return message if answer in ("y", "yes") else None
+
+def try_handle_commit_command(ctx: CommandContext, user_input: str) -> bool:
+    """/commit: stage what Neo changed, have the model write a message, and commit after review."""
+    parts = user_input.strip().split()
+    if not parts or parts[0].lower() != "/commit":
+        return False
+    if parts[1:] not in ([], ["--remember"]):
+        console.print(f"[matrix.warning]⚠ Usage: {COMMIT_USAGE}[/matrix.warning]\n")
+        return True
+    if refuse_read_only(ctx, "/commit", "stages and commits with git"):
+        return True
+    if ctx.agent.client is None:
+        console.print("[matrix.warning]> Offline: /commit needs the API to write the message.[/matrix.warning]\n")
+        return True
+
+    try:
+        repo = Repository(ctx.workspace.root)
+        if repo.branch() is None:
+            console.print("[matrix.error]✗ HEAD is detached:[/matrix.error] a commit here would belong to no branch. "
+                          "Check out a branch first (git switch <branch>).\n")
+            return True
+        stage_neo_changes(ctx, repo)
+        files = repo.staged_files()
+        if not files:
+            console.print("[matrix.warning]⚠ Nothing is staged.[/matrix.warning] [matrix.dim]Stage changes with git add, then /commit again.[/matrix.dim]\n")
+            return True
+        diff = repo.staged_diff()
+    except GitError as e:
+        console.print(f"[matrix.error]✗ GIT:[/matrix.error] {escape(str(e))}\n")
+        return True
+
+    try:
+        message = generate_commit_message(ctx, files, diff)
+    except Exception as e:
+        console.print(f"[matrix.error]✗ Could not get a commit message: {escape(str(e))}[/matrix.error]\n")
+        return True
+    if not message:
+        console.print("[matrix.warning]⚠ The model returned an empty message; nothing was committed.[/matrix.warning]\n")
+        return True
+    message = review_commit_message(message)
+    if message is None:
+        console.print("[matrix.dim]> Not committed. The changes are still staged.[/matrix.dim]\n")
+        return True
+
+    try:
+        summary = repo.commit(message + "\n")
+    except GitError as e:
+        console.print("[matrix.error]✗ COMMIT FAILED:[/matrix.error] git (or a pre-commit hook) refused it; the changes are still staged.")
+        console.print(f"[matrix.dim]{escape(str(e))}[/matrix.dim]\n")
+        return True
+    console.print(f"[matrix.success]✓ COMMITTED:[/matrix.success] [matrix.dim]{escape(summary.splitlines()[0] if summary else message.splitlines()[0])}[/matrix.dim]\n")
+    if parts[1:] == ["--remember"]:
+        ctx.conversation.add_user("/commit: write a commit message for the staged changes.", "command", "/commit")
+        ctx.conversation.add_assistant(f"Committed with this message:\n\n{message}", origin="command", source="/commit")
+    return True
+
+def run_repl(ctx: CommandContext) -> None:
+    """Read commands and messages until the user leaves the Matrix.
+
+    It returns only when you type an exit command, press Ctrl+D or Ctrl+C at the prompt,
+    or a signal raises Terminated; the caller then runs its one shutdown.
+    """
+    try:
+        while True:
+            ctx.flush_notices()
+            try:
+                # Output from the /watch thread is printed above the prompt instead of through it
+                with patch_stdout(raw=True):
+                    ctx.prompting = True
+                    try:
+                        user_input = prompt_session.prompt(prompt_message(ctx)).strip()
+                    finally:
+                        ctx.prompting = False
+            except (EOFError, KeyboardInterrupt):
+                console.print("\n[matrix.warning]> MATRIX DISCONNECTION DETECTED[/matrix.warning]")
+                display_matrix_exit()
+                break
+
+            if not user_input:
+                try_handle_retry_command(ctx, user_input)
+                continue
+
+            # A pasted transcript is a message, even when a line of it looks like a command or "exit"
+            if prompt_session.pasted and "\n" in user_input:
+                console.print(f"[matrix.dim]> Pasted {len(user_input.splitlines())} lines as a message.[/matrix.dim]")
+                send_message(ctx, user_input)
+                continue
+
+            # Checked on what was typed, before aliases: nothing else ends the session
+            if is_exit_command(user_input):
+                decorate("[matrix.dim]> Disconnecting from the Matrix...[/matrix.dim]")
+                display_matrix_exit()
+                break
+
+            try:
+                user_input = expand_alias(ctx.agent.config.aliases, user_input)
+            except AliasError as e:
+                console.print(f"[matrix.error]✗ {escape(str(e))}[/matrix.error]\n")
+                continue
+
+            # Handle special Matrix commands
+            if user_input.lower() == "/red_pill":
+                console.print("[matrix.error]> You take the red pill...[/matrix.error]")
+                time.sleep(1)
+                console.print("[matrix.primary]> Welcome to the desert of the real.[/matrix.primary]\n")
+                continue
+            elif user_input.lower() == "/blue_pill":
+                console.print("[matrix.accent]> You take the blue pill...[/matrix.accent]")
+                time.sleep(1)
+                console.print("[matrix.dim]> Wake up. Believe whatever you want to believe.[/matrix.dim]\n")
+                continue
+            elif user_input.lower() == "/clear":
+                console.clear()
+                console.print("[matrix.success]> Memory wiped. You are free.[/matrix.success]\n")
+                ctx.conversation.clear()
+                continue
+
+            if try_handle_add_command(ctx, user_input):
+                continue
+
+            if try_handle_tools_command(ctx, user_input):
+                continue
+
+            if try_handle_context_command(ctx, user_input):
+                continue
+
+            if try_handle_project_command(ctx, user_input):
+                continue
+
+            if try_handle_sessions_command(ctx, user_input):
+                continue
+
+            if try_handle_title_command(ctx, user_input):
+                continue
+
+            if try_handle_search_command(ctx, user_input):
+                continue
+
+            if try_handle_when_command(ctx, user_input):
+                continue
+
+            if try_handle_model_command(ctx, user_input):
+                continue
+
+            if try_handle_provider_command(ctx, user_input):
+                continue
+
+            if try_handle_cache_command(ctx, user_input):
+                continue
+
+            if try_handle_restore_command(ctx, user_input):
+                continue
+
+            if try_handle_config_command(ctx, user_input):
+                continue
+
+            if try_handle_set_command(ctx, user_input):
+                continue
+
+            if try_handle_doctor_command(ctx, user_input):
+                continue
+
+            if try_handle_audit_command(ctx, user_input):
+                continue
+
+            if try_handle_stats_command(ctx, user_input):
+                continue
+
+            if try_handle_debug_command(ctx, user_input):
+                continue
+
+            if try_handle_dryrun_command(ctx, user_input):
+                continue
+
+            if try_handle_quiet_command(ctx, user_input):
+                continue
+
+            if try_handle_transcript_command(ctx, user_input):
+                continue
+
+            if try_handle_copy_command(ctx, user_input):
+                continue
+
+            if try_handle_apply_command(ctx, user_input):
+                continue
+
+            if try_handle_last_command(ctx, user_input):
+                continue
+
+            if try_handle_watch_command(ctx, user_input):
+                continue
+
+            if try_handle_branch_command(ctx, user_input):
+                continue
+
+            if try_handle_prompt_command(ctx, user_input):
+                continue
+
+            if try_handle_checkpoint_command(ctx, user_input):
+                continue
+
+            if try_handle_rollback_command(ctx, user_input):
+                continue
+
+            if try_handle_forget_command(ctx, user_input):
+                continue
+
+            if try_handle_validate_command(ctx, user_input):
+                continue
+
+            if try_handle_review_command(ctx, user_input):
+                continue
+
+            if try_handle_patch_command(ctx, user_input):
+                continue
+
+            if try_handle_diff_command(ctx, user_input):
+                continue
+
+            if try_handle_commit_command(ctx, user_input):
+                continue
+
+            if try_handle_retry_command(ctx, user_input):
+                continue
+
+            if try_handle_ask_command(ctx, user_input):
+                continue
+
+            send_message(ctx, user_input)
+
+    except KeyboardInterrupt:
+        # Handle Ctrl+C gracefully with Matrix exit
+        console.print("\n[matrix.warning]> INTERRUPT DETECTED - EMERGENCY MATRIX EXIT[/matrix.warning]")
+        display_matrix_exit()
+    except Exception as e:
+        console.print(f"\n[matrix.error]> CRITICAL ERROR: {escape(str(e))}[/matrix.error]")
+        console.print("[matrix.dim]> Forcing emergency exit...[/matrix.dim]")
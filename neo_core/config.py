@@ -0,0 +1,335 @@
+import os
+import re
+import json
+import argparse
+import subprocess
+from pathlib import Path
+from typing import Dict, Any, List, Optional, Tuple
+from pydantic import BaseModel
+
+from neo_core import __version__, __commit__
+
+PROVIDERS = {
+    "deepseek": {
+        "base_url": "https://api.deepseek.com",
+        "api_key_env": "DEEPSEEK_API_KEY",
+        "default_model": "deepseek-reasoner",
+        "chat_model": "deepseek-chat",  # For messages without code when routing (model "auto")
+    },
+    # Scripted offline backend for development; see neo_core/mock.py
+    "mock": {
+        "base_url": "mock://local",
+        "api_key_env": "",
+        "default_model": "mock",
+        "chat_model": "mock",
+    },
+}
+
+# Approximate USD prices per million (input, output) tokens, for the /stats cost estimate
+MODEL_PRICING = {
+    "deepseek-chat": (0.27, 1.10),
+    "deepseek-reasoner": (0.55, 2.19),
+    "mock": (0.0, 0.0),
+}
+
+DEFAULT_CONFIG_PATH = Path.home() / ".config" / "neo" / "config.json"
+DATA_DIR = Path.home() / ".local" / "share" / "neo"
+CACHE_DIR = Path.home() / ".cache" / "neo"
+DEFAULT_LISTEN = "127.0.0.1:7777"  # Where serve listens without --listen
+
+# Allowed (lowest, highest) values of the size and scan limits, in the config file and with /set
+LIMIT_RANGES: Dict[str, Tuple[int, int]] = {
+    "max_file_size": (1_000, 1_000_000_000),  # Bytes
+    "max_scan_files": (1, 100_000),
+    "max_history_messages": (1, 1_000),
+}
+
+# Environment variables that override values from the config file
+ENV_OVERRIDES = {
+    "NEO_PROVIDER": "provider",
+    "NEO_MODEL": "model",
+    "NEO_WORKDIR": "workdir",
+    "NEO_MOCK_FIXTURE": "mock_fixture",
+}
+
+class NeoConfig(BaseModel):
+    provider: str = "deepseek"
+    providers: Dict[str, Dict[str, Any]] = {}  # More providers, name -> {"base_url", "api_key_env", "default_model", "chat_model", "models"}
+    fallback_providers: List[str] = []  # Tried in order when the provider is down; see neo_core/fallback.py
+    requests_per_minute: int = 0  # Paces API requests to this rate; 0 for no limit (see neo_core/ratelimit.py)
+    max_concurrent_requests: int = 4  # API requests in flight at once, such as a reply and a title; 0 for no cap
+    model: Optional[str] = None  # "auto" picks chat_model or coder_model for each message
+    chat_model: Optional[str] = None  # Routing's model for prose (default: the provider's chat model)
+    coder_model: Optional[str] = None  # Routing's model for code and tools (default: the provider default)
+    route_patterns: List[str] = []  # Regexes that send a message to coder_model; replaces the built-in list
+    model_capabilities: Dict[str, Dict[str, bool]] = {}  # Model -> {"tools": bool, "streaming": bool}, over the built-in table
+    workdir: Optional[str] = None
+    no_intro: bool = False
+    quiet: bool = False  # Only substance: no animations, banners, spinners, progress chatter or blank-line padding
+    no_color: bool = False
+    auto_approve: bool = False
+    dry_run: bool = False  # File-changing tools validate and preview but do not write
+    read_only: bool = False  # --read-only: tools and commands that write files or run commands are gone for the session
+    system_prompt_file: Optional[str] = None
+    offline: bool = False
+    ca_bundle: Optional[str] = None  # PEM file for TLS-intercepting proxies
+    insecure_skip_verify: bool = False
+    debug: bool = False
+    debug_max_content: int = 2000  # Truncate logged message contents beyond this many chars; 0 keeps everything
+    show_stats: bool = True  # Timing and throughput line after each response
+    show_hints: bool = True  # Tips for the command that follows from a response, see neo_core/hints.py
+    dynamic_prompt: bool = True  # Show the model and context usage in the prompt instead of neo@matrix:~$
+    notify_after_seconds: Optional[float] = None  # Bell and desktop notification when a reply takes this long
+    transcript: Optional[str] = None  # Write a plain-text transcript here ("auto" picks a file under the data dir)
+    disabled_tools: List[str] = []
+    aliases: Dict[str, str] = {}  # "/name" (or "name") -> the command it runs; extra words are appended
+    save_branches: bool = True  # Save every /branch with the session, not just the active one
+    max_context_tokens: int = 56_000  # Older exchanges are trimmed beyond this estimate
+    large_request_tokens: int = 32_000  # Ask before sending a request estimated above this; 0 never asks
+    large_file_tokens: int = 8_000  # Warn once when a file in context is estimated above this; 0 never warns
+    unused_file_turns: int = 10  # Warn once when no reply has mentioned a file in context for this many turns; 0 never warns
+    max_backups: int = 5  # Backups kept per file under .neo/backups
+    protected_paths: List[str] = []  # Added to the built-in list of paths tools may never modify
+    project_context: bool = True  # Add go.mod, package.json, pyproject.toml or Cargo.toml to the context at startup
+    refresh_changed_files: str = "ask"  # When files in context change on disk: "ask", "auto" (refresh) or "off"
+    add_mentioned_files: str = "off"  # Files your message names by path: "ask" before adding them, "auto" or "off"
+    follow_symlinks: bool = False  # /add <directory> follows links that resolve inside the workspace
+    truncate_strategy: str = "head-tail"  # Files over the size limit in /add and read_file: "head", "head-tail", "outline" or "skip"
+    truncate_lines: int = 400  # Lines kept of a truncated file, half from each end for head-tail
+    chunk_tokens: int = 6_000  # Largest part of a file added with /add --chunks, each sent as its own message
+    max_file_size: int = 5_000_000  # Bytes read or written whole; larger files are truncated (see truncate_strategy)
+    max_scan_files: int = 1000  # Files /add <directory> reads before stopping, unless --max-files says otherwise
+    max_history_messages: int = 15  # Messages besides the system ones kept before the oldest exchanges are trimmed
+    max_tool_calls_per_turn: int = 25  # Tool calls of any kind per user message
+    max_writes_per_turn: int = 10  # Calls to tools that change files per user message
+    max_bytes_written_per_turn: int = 1_000_000
+    redact_secrets: bool = True  # Replace credentials in files and tool results with [REDACTED:kind] before sending
+    child_env_allowlist: List[str] = []  # Environment variables (globs) passed to commands Neo runs, besides the built-in list
+    redact_patterns: Dict[str, str] = {}  # Extra detectors: name -> regex; a "secret" group limits what is replaced
+    response_cache: bool = False  # Replay the stored answer when the same context and question are sent again
+    response_cache_ttl: int = 86_400  # Seconds a cached answer is replayed for
+    max_tool_result_chars: int = 16_000  # Longer tool results are truncated; 0 keeps everything
+    mock_fixture: Optional[str] = None  # Responses for the mock provider to play back
+    validate_command: Optional[str] = None  # Shell command run after each turn that changed files, e.g. "go build ./..."
+    validate_timeout: int = 300  # Seconds
+    pre_send_hook: Optional[str] = None  # Executable given each message you send as JSON; see neo_core/hooks.py
+    post_receive_hook: Optional[str] = None  # Executable given each final reply as JSON
+    hook_timeout: int = 10  # Seconds a hook may take
+    max_autofix_rounds: int = 2  # Times a failed validation is sent back to the model before waiting for you
+    format_on_write: bool = True  # Run files the tools write through the formatter for their extension
+    formatters: Dict[str, List[str]] = {}  # ".ext" -> command reading stdin and printing the result; [] turns one off
+    check_syntax: bool = True  # Refuse tool writes of JSON, TOML and YAML files that don't parse
+    mcp_servers: Dict[str, Dict[str, Any]] = {}  # name -> {"command": ..., "args": [...], "env": {...}}; tools become name__tool
+
+    def provider_info(self, name: Optional[str] = None) -> Dict[str, Any]:
+        """The built-in or configured provider 'name', by default the one in use."""
+        return self.known_providers()[name or self.provider]
+
+    def known_providers(self) -> Dict[str, Dict[str, Any]]:
+        """PROVIDERS and the configured ones; those may leave out the key (a local server), the chat model and the model map."""
+        configured = {name: {"api_key_env": "", "chat_model": spec["default_model"], "models": {}, **spec}
+                      for name, spec in self.providers.items()}
+        return {**PROVIDERS, **configured}
+
+    def resolved_model(self) -> str:
+        """Return the model to request, falling back to the provider default.
+
+        While routing, this is the coder model, used by one-off requests such as /commit and /review.
+        """
+        if self.model == "auto":
+            return self.resolved_coder_model()
+        return self.model or self.provider_info()["default_model"]
+
+    def resolved_coder_model(self) -> str:
+        return self.coder_model or self.provider_info()["default_model"]
+
+    def resolved_chat_model(self) -> str:
+        return self.chat_model or self.provider_info()["chat_model"]
+
+def build_version_string() -> str:
+    """Return the version line, resolving the commit from git for unstamped builds."""
+    commit = __commit__
+    if commit == "dev":
+        try:
+            commit = subprocess.run(
+                ["git", "rev-parse", "--short", "HEAD"],
+                cwd=Path(__file__).resolve().parent,
+                capture_output=True, text=True, check=True
+            ).stdout.strip() or commit
+        except (OSError, subprocess.CalledProcessError):
+            pass
+    return f"neo {__version__} ({commit})"
+
+def build_arg_parser() -> argparse.ArgumentParser:
+    parser = argparse.ArgumentParser(prog="neo", description="Neo - an AI coding agent based on the Matrix.")
+    parser.add_argument("command", nargs="?", choices=["mcp-serve", "serve", "review", "doctor"],
+                        help="instead of chatting, mcp-serve offers the file tools to MCP clients over stdio, "
+                             "serve runs the HTTP API for editor plugins, review reviews the git diff, "
+                             "and doctor checks the setup")
+    parser.add_argument("ref", nargs="?", help="review: the git ref to diff against (default: the uncommitted changes)")
+    # Boolean flags default to None so that unset flags don't override file/env values
+    parser.add_argument("--model", help="model to request, or \"auto\" to pick one per message (default: provider default)")
+    parser.add_argument("--provider", help=f"API provider to connect to: {', '.join(sorted(PROVIDERS))} or one in \"providers\"")
+    parser.add_argument("--workdir", help="directory to operate in")
+    parser.add_argument("--no-intro", action="store_true", default=None, help="skip the startup animation and banner")
+    parser.add_argument("--no-color", action="store_true", default=None, help="disable colored output")
+    parser.add_argument("--quiet", action="store_true", default=None, help="print replies, confirmations, errors and stats, without decoration")
+    parser.add_argument("--resume", nargs="?", const="latest", metavar="SESSION", help="resume a saved session: a name, a number from /sessions, or \"pick\" to choose from a list (default: the latest)")
+    parser.add_argument("--config", metavar="PATH", help=f"config file to load (default: {DEFAULT_CONFIG_PATH})")
+    parser.add_argument("--auto-approve", action="store_true", default=None, help="apply file changes without asking")
+    parser.add_argument("--dry-run", action="store_true", default=None, help="simulate file changes instead of writing them")
+    parser.add_argument("--read-only", action="store_true", default=None,
+                        help="for repositories you don't trust: no tool or command may write files or run commands")
+    parser.add_argument("--follow-symlinks", action="store_true", default=None, help="follow symlinks inside the workspace when adding a directory")
+    parser.add_argument("--system-prompt-file", metavar="PATH", help="replace the built-in system prompt with a file's contents")
+    parser.add_argument("--offline", action="store_true", default=None, help="run without an API connection; only local commands work")
+    parser.add_argument("--debug", action="store_true", default=None, help=f"log API traffic to a JSONL file under {DATA_DIR / 'logs'}")
+    parser.add_argument("--listen", metavar="HOST:PORT", help=f"serve: the address to listen on (default: {DEFAULT_LISTEN})")
+    parser.add_argument("--json", action="store_true", help="review: print the findings as JSON")
+    parser.add_argument("--version", action="store_true", help="print version and commit, then exit")
+    return parser
+
+def limit_error(field: str, value: Any) -> Optional[str]:
+    """Why 'value' can't be used for one of LIMIT_RANGES, or None if it can."""
+    low, high = LIMIT_RANGES[field]
+    if not isinstance(value, int) or isinstance(value, bool) or not low <= value <= high:
+        return f"{field} must be a whole number from {low:,} to {high:,}, got {value!r}"
+    return None
+
+def build_config(args: argparse.Namespace, parser: argparse.ArgumentParser) -> NeoConfig:
+    """Merge defaults, the config file, environment and flags, in increasing precedence."""
+    values: Dict[str, Any] = {}
+
+    config_path = Path(args.config).expanduser() if args.config else DEFAULT_CONFIG_PATH
+    if args.config and not config_path.is_file():
+        parser.error(f"config file not found: {args.config}")
+    if config_path.is_file():
+        try:
+            with open(config_path, "r", encoding="utf-8") as f:
+                file_values = json.load(f)
+        except (OSError, json.JSONDecodeError) as e:
+            parser.error(f"could not read config file {config_path}: {e}")
+        if not isinstance(file_values, dict):
+            parser.error(f"config file {config_path} must contain a JSON object")
+        unknown = sorted(set(file_values) - set(NeoConfig.model_fields))
+        if unknown:
+            parser.error(f"unknown keys in config file {config_path}: {', '.join(unknown)}")
+        values.update(file_values)
+
+    for env_var, field in ENV_OVERRIDES.items():
+        if os.getenv(env_var):
+            values[field] = os.getenv(env_var)
+
+    for field in NeoConfig.model_fields:
+        flag_value = getattr(args, field, None)
+        if flag_value is not None:
+            values[field] = flag_value
+
+    providers = values.get("providers", {})
+    if not isinstance(providers, dict) or not all(
+            isinstance(spec, dict) and all(isinstance(spec.get(key), str) and spec[key] for key in ("base_url", "default_model"))
+            and isinstance(spec.get("api_key_env", ""), str) and isinstance(spec.get("chat_model", ""), str)
+            and isinstance(spec.get("models", {}), dict) and all(isinstance(v, str) for v in spec.get("models", {}).values())
+            for spec in providers.values()):
+        parser.error("providers must map names to {\"base_url\": ..., \"api_key_env\": ..., \"default_model\": ..., "
+                     "\"chat_model\": ..., \"models\": {...}}, e.g. {\"ollama\": {\"base_url\": \"http://localhost:11434/v1\", "
+                     "\"default_model\": \"qwen2.5-coder\"}}")
+    if isinstance(providers, dict) and set(providers) & set(PROVIDERS):
+        parser.error(f"providers can't redefine the built-in {', '.join(sorted(set(providers) & set(PROVIDERS)))}")
+    known = sorted({**PROVIDERS, **providers})
+    if values.get("provider", "deepseek") not in known:
+        parser.error(f"unknown provider '{values['provider']}' (choose from: {', '.join(known)})")
+    fallbacks = values.get("fallback_providers", [])
+    if not isinstance(fallbacks, list) or not all(isinstance(name, str) for name in fallbacks):
+        parser.error("fallback_providers must be a list of provider names, e.g. [\"openai\", \"ollama\"]")
+    for name in fallbacks:
+        if name not in known or name == "mock":
+            parser.error(f"fallback_providers: unknown provider '{name}' (choose from: {', '.join(n for n in known if n != 'mock')})")
+    if values.get("workdir"):
+        workdir = Path(values["workdir"]).expanduser()
+        if not workdir.is_dir():
+            parser.error(f"--workdir is not a directory: {values['workdir']}")
+        values["workdir"] = str(workdir.resolve())
+    if values.get("mock_fixture") and not Path(values["mock_fixture"]).expanduser().is_file():
+        parser.error(f"mock fixture not found: {values['mock_fixture']}")
+    if values.get("ca_bundle") and not Path(values["ca_bundle"]).expanduser().is_file():
+        parser.error(f"ca_bundle not found: {values['ca_bundle']}")
+    for field in ("pre_send_hook", "post_receive_hook"):
+        if values.get(field):
+            hook = Path(values[field]).expanduser()
+            if not hook.is_file() or not os.access(hook, os.X_OK):
+                parser.error(f"{field} must be an executable file, got {values[field]!r}")
+            values[field] = str(hook.resolve())
+    for field in ("max_backups", "max_tool_calls_per_turn", "max_writes_per_turn", "max_bytes_written_per_turn", "validate_timeout",
+                  "hook_timeout", "response_cache_ttl", "truncate_lines", "chunk_tokens"):
+        if not isinstance(values.get(field, 1), int) or values.get(field, 1) < 1:
+            parser.error(f"{field} must be a positive integer, got {values[field]!r}")
+    for field in LIMIT_RANGES:
+        if field in values:
+            error = limit_error(field, values[field])
+            if error:
+                parser.error(error)
+    for field in ("requests_per_minute", "max_concurrent_requests"):
+        if not isinstance(values.get(field, 0), int) or values.get(field, 0) < 0:
+            parser.error(f"{field} must be a whole number, 0 for no limit, got {values[field]!r}")
+    if not isinstance(values.get("max_autofix_rounds", 0), int) or values.get("max_autofix_rounds", 0) < 0:
+        parser.error(f"max_autofix_rounds must be a whole number, 0 to never fix automatically, got {values['max_autofix_rounds']!r}")
+    if not isinstance(values.get("large_request_tokens", 0), int) or values.get("large_request_tokens", 0) < 0:
+        parser.error(f"large_request_tokens must be a whole number, 0 to never ask, got {values['large_request_tokens']!r}")
+    for field in ("large_file_tokens", "unused_file_turns"):
+        if not isinstance(values.get(field, 0), int) or values.get(field, 0) < 0:
+            parser.error(f"{field} must be a whole number, 0 to never warn, got {values[field]!r}")
+    aliases = values.get("aliases", {})
+    if not isinstance(aliases, dict) or not all(isinstance(k, str) and isinstance(v, str) and k.strip("/") and v.strip()
+                                                for k, v in aliases.items()):
+        parser.error("aliases must map names to non-empty command strings, e.g. {\"t\": \"/add tests --outline\"}")
+    patterns = values.get("redact_patterns", {})
+    if not isinstance(patterns, dict) or not all(isinstance(k, str) and isinstance(v, str) for k, v in patterns.items()):
+        parser.error("redact_patterns must map names to regular expressions, e.g. {\"internal\": \"corp-[0-9]{6}\"}")
+    for name, pattern in patterns.items():
+        try:
+            re.compile(pattern)
+        except re.error as e:
+            parser.error(f"redact_patterns[{name!r}] is not a valid regular expression: {e}")
+    if not isinstance(values.get("child_env_allowlist", []), list) or not all(isinstance(p, str) for p in values.get("child_env_allowlist", [])):
+        parser.error("child_env_allowlist must be a list of variable names or globs, e.g. [\"DATABASE_URL\", \"AWS_REGION\"]")
+    route_patterns = values.get("route_patterns", [])
+    if not isinstance(route_patterns, list) or not all(isinstance(p, str) for p in route_patterns):
+        parser.error("route_patterns must be a list of regular expressions, e.g. [\"(?i)\\\\bsql\\\\b\"]")
+    for pattern in route_patterns:
+        try:
+            re.compile(pattern)
+        except re.error as e:
+            parser.error(f"route_patterns entry {pattern!r} is not a valid regular expression: {e}")
+    capabilities = values.get("model_capabilities", {})
+    if not isinstance(capabilities, dict) or not all(
+            isinstance(model, str) and isinstance(features, dict)
+            and all(feature in ("tools", "streaming") and isinstance(value, bool) for feature, value in features.items())
+            for model, features in capabilities.items()):
+        parser.error("model_capabilities must map model names to {\"tools\": true|false, \"streaming\": true|false}, "
+                     "e.g. {\"my-local-model\": {\"tools\": false}}")
+    formatters = values.get("formatters", {})
+    if not isinstance(formatters, dict) or not all(
+            isinstance(ext, str) and ext.startswith(".") and isinstance(command, list)
+            and all(isinstance(arg, str) for arg in command) for ext, command in formatters.items()):
+        parser.error("formatters must map extensions to commands, e.g. {\".py\": [\"black\", \"-q\", \"-\"]}")
+    servers = values.get("mcp_servers", {})
+    if not isinstance(servers, dict) or not all(
+            isinstance(spec, dict) and isinstance(spec.get("command"), str) and spec["command"].strip()
+            and isinstance(spec.get("args", []), list) and all(isinstance(a, str) for a in spec.get("args", []))
+            and isinstance(spec.get("env", {}), dict) for spec in servers.values()):
+        parser.error("mcp_servers must map names to {\"command\": ..., \"args\": [...], \"env\": {...}}, "
+                     "e.g. {\"github\": {\"command\": \"npx\", \"args\": [\"-y\", \"@modelcontextprotocol/server-github\"]}}")
+    if values.get("refresh_changed_files", "ask") not in ("ask", "auto", "off"):
+        parser.error(f"refresh_changed_files must be \"ask\", \"auto\" or \"off\", got {values['refresh_changed_files']!r}")
+    if values.get("add_mentioned_files", "off") not in ("ask", "auto", "off"):
+        parser.error(f"add_mentioned_files must be \"ask\", \"auto\" or \"off\", got {values['add_mentioned_files']!r}")
+    if values.get("truncate_strategy", "head-tail") not in ("skip", "head", "head-tail", "outline"):
+        parser.error(f"truncate_strategy must be \"head\", \"head-tail\", \"outline\" or \"skip\", got {values['truncate_strategy']!r}")
+    if values.get("system_prompt_file"):
+        prompt_file = Path(values["system_prompt_file"]).expanduser()
+        if not prompt_file.is_file():
+            parser.error(f"--system-prompt-file not found: {values['system_prompt_file']}")
+        values["system_prompt_file"] = str(prompt_file.resolve())
+
+    return NeoConfig(**values)
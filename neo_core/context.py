@@ -0,0 +1,316 @@
+"""Files added to the conversation, and whether the copies there still match the disk."""
+
+import hashlib
+import os
+import threading
+import time
+from dataclasses import dataclass
+from typing import Any, Dict, List, Optional, Set, Tuple
+
+from neo_core.conversation import Conversation
+from neo_core.fetch import Fetched, is_url
+from neo_core.fileops import FileTooLargeError, Workspace
+from neo_core.outline import OUTLINE_NOTE, outline_source
+from neo_core.project import PROJECT_NOTE, manifest_summary
+from neo_core.redact import Redactor
+from neo_core.truncate import DEFAULT_CHUNK_TOKENS, DEFAULT_TRUNCATE_LINES, LABELS, Part, Truncation, split_parts, strategy_of
+
+@dataclass(frozen=True)
+class FileStamp:
+    """What a file looked like when its content was put in context."""
+    mtime_ns: int
+    size: int
+    sha256: str  # Of the text as read_file returns it
+
+    @classmethod
+    def of(cls, path: str, content: str) -> "FileStamp":
+        info = os.stat(path)
+        return cls(info.st_mtime_ns, info.st_size, text_hash(content))
+
+GONE = FileStamp(0, -1, "")  # A deleted file the user chose to keep in context
+
+@dataclass(frozen=True)
+class UrlStamp:
+    """What a URL's server said about the copy put in context, for a conditional fetch later."""
+    etag: Optional[str]
+    last_modified: Optional[str]
+    fetched_at: float
+
+def text_hash(content: str) -> str:
+    return hashlib.sha256(content.encode("utf-8")).hexdigest()
+
+class ContextFiles:
+    """Adds files to the conversation and notices when they change on disk afterwards.
+
+    The check stats each file and only rereads it when the modification time or
+    size moved, so it is cheap enough to run before every message. Methods take a
+    lock, as /watch checks from a background thread.
+    """
+
+    def __init__(self, workspace: Workspace, conversation: Conversation, redactor: Optional[Redactor] = None):
+        self.workspace = workspace
+        self.conversation = conversation
+        self.redactor = redactor or Redactor(enabled=False)
+        self._lock = threading.RLock()
+        self._stamps: Dict[str, FileStamp] = {}
+        self._outlined: Set[str] = set()
+        self._manifests: Set[str] = set()  # Added as project metadata, see neo_core.project
+        self._truncated: Dict[str, Tuple[str, int]] = {}  # Path -> (strategy, lines) for files over the size limit
+        self._preset: Set[str] = set()  # Added by the project preset's context globs, see neo_core.preset
+        self._split: Dict[str, int] = {}  # Path -> the chunk_tokens it was split by, for files added with /add --chunks
+        self._urls: Dict[str, UrlStamp] = {}  # Pages added with /add https://…, which the disk checks leave alone
+
+    def add(self, normalized_path: str, content: Optional[str] = None, outline: bool = False, manifest: bool = False,
+            truncation: Optional[Truncation] = None, preset: bool = False, origin: Optional[str] = None) -> str:
+        """Put a file in context, replacing an older copy; returns what was added.
+
+        With 'outline' the file's outline is added instead, with 'manifest' its project
+        summary, and with 'truncation' the cut-down copy of a file over the size limit.
+        'preset' marks it as added by the project preset rather than by you. 'origin'
+        is what the conversation records as having added it (see conversation.ORIGINS);
+        without one a refreshed copy keeps its own.
+        """
+        if truncation:
+            content = truncation.content
+        if content is None:
+            content = self.workspace.read_file(normalized_path)
+        if manifest or truncation:
+            added, outlined = manifest_summary(normalized_path, content) if manifest else content, False
+        else:
+            added, outlined = outline_source(normalized_path, content) if outline else (content, False)
+        added = self.redactor.redact(added, normalized_path)
+        with self._lock:
+            self.conversation.add_file(normalized_path, added, "project" if manifest else "preset" if preset else origin)
+            self._stamps[normalized_path] = FileStamp.of(normalized_path, content)
+            if outlined:
+                self._outlined.add(normalized_path)
+            else:
+                self._outlined.discard(normalized_path)
+            if manifest:
+                self._manifests.add(normalized_path)
+            else:
+                self._manifests.discard(normalized_path)
+            if truncation:
+                self._truncated[normalized_path] = (truncation.strategy, truncation.max_lines)
+            else:
+                self._truncated.pop(normalized_path, None)
+            if preset:
+                self._preset.add(normalized_path)
+            else:
+                self._preset.discard(normalized_path)
+            self._split.pop(normalized_path, None)
+        return added
+
+    def add_parts(self, normalized_path: str, max_tokens: int = DEFAULT_CHUNK_TOKENS, content: Optional[str] = None) -> List[Part]:
+        """Put a whole file in context as parts of at most 'max_tokens' each (see split_parts); returns the parts.
+
+        The file is redacted before it is split, so a secret can't hide across a boundary.
+        A file that fits in one part is added whole, as by add.
+        """
+        if content is None:
+            content = self.workspace.read_file(normalized_path)
+        parts = split_parts(self.redactor.redact(content, normalized_path), max_tokens)
+        if len(parts) < 2:
+            self.add(normalized_path, content)
+            return parts
+        with self._lock:
+            self.conversation.add_file_parts(normalized_path, [(part.first_line, part.last_line, part.content) for part in parts])
+            self._stamps[normalized_path] = FileStamp.of(normalized_path, content)
+            for kinds in (self._outlined, self._manifests, self._preset):
+                kinds.discard(normalized_path)
+            self._truncated.pop(normalized_path, None)
+            self._split[normalized_path] = max_tokens
+        return parts
+
+    def add_url(self, url: str, fetched: Fetched, origin: Optional[str] = None) -> str:
+        """Put a fetched page in context under 'url', replacing an older copy; returns what was added."""
+        added = self.redactor.redact(fetched.content, url)
+        with self._lock:
+            self.conversation.add_file(url, added, origin)
+            self._urls[url] = UrlStamp(fetched.etag, fetched.last_modified, time.time())
+        return added
+
+    def urls(self) -> List[str]:
+        """The pages in context, in context order."""
+        with self._lock:
+            return [path for path in self.conversation.files() if is_url(path)]
+
+    def url_stamp(self, url: str) -> UrlStamp:
+        with self._lock:
+            return self._urls.get(url) or UrlStamp(None, None, 0.0)
+
+    def url_unchanged(self, url: str) -> None:
+        """A refresh found the page as it was: note when it was checked."""
+        with self._lock:
+            stamp = self.url_stamp(url)
+            self._urls[url] = UrlStamp(stamp.etag, stamp.last_modified, time.time())
+
+    def kind(self, normalized_path: str) -> str:
+        """How a file in context was added: "project", "outline", "truncated (head+tail)", "url" and so on, or "file"."""
+        if is_url(normalized_path):
+            return "url"
+        with self._lock:
+            if normalized_path in self._split:
+                return f"{self.conversation.file_parts(normalized_path)} parts"
+            if normalized_path in self._truncated:
+                return f"truncated ({LABELS[self._truncated[normalized_path][0]]})"
+            return "project" if normalized_path in self._manifests else "outline" if normalized_path in self._outlined else "file"
+
+    def split_files(self) -> Set[str]:
+        """The files in context added in parts with /add --chunks."""
+        with self._lock:
+            return set(self._split)
+
+    def preset_files(self) -> List[str]:
+        """The files in context that the project preset added, in context order."""
+        with self._lock:
+            return [path for path in self.conversation.files() if path in self._preset]
+
+    def state(self) -> Dict[str, Dict[str, Any]]:
+        """The registry, saved with the session: per file in context its stamp, when it was added, and how."""
+        with self._lock:
+            registry: Dict[str, Dict[str, Any]] = {}
+            for path in self.conversation.files():
+                added_at = (self.conversation.file_message(path) or {}).get("ts")
+                if is_url(path):
+                    url = self.url_stamp(path)
+                    registry[path] = {"url": True, "etag": url.etag, "last_modified": url.last_modified,
+                                      "fetched_at": url.fetched_at, "added_at": added_at}
+                    continue
+                stamp = self._stamps.get(path)
+                if stamp is None:
+                    continue
+                entry: Dict[str, Any] = {"mtime_ns": stamp.mtime_ns, "size": stamp.size, "sha256": stamp.sha256,
+                                         "added_at": added_at}
+                for flag, paths in (("outline", self._outlined), ("manifest", self._manifests), ("preset", self._preset)):
+                    if path in paths:
+                        entry[flag] = True
+                if path in self._truncated:
+                    entry["truncated"] = list(self._truncated[path])
+                if path in self._split:
+                    entry["chunk_tokens"] = self._split[path]
+                registry[path] = entry
+            return registry
+
+    def _restore_entry(self, path: str, entry: Dict[str, Any]) -> None:
+        stamp = FileStamp(int(entry["mtime_ns"]), int(entry["size"]), str(entry["sha256"]))
+        truncated = entry.get("truncated")
+        truncated = (str(truncated[0]), int(truncated[1])) if truncated else None
+        chunk_tokens = int(entry["chunk_tokens"]) if entry.get("chunk_tokens") else None
+        self._stamps[path] = stamp
+        for flag, paths in (("outline", self._outlined), ("manifest", self._manifests), ("preset", self._preset)):
+            if entry.get(flag):
+                paths.add(path)
+            else:
+                paths.discard(path)
+        if truncated:
+            self._truncated[path] = truncated
+        else:
+            self._truncated.pop(path, None)
+        if chunk_tokens:
+            self._split[path] = chunk_tokens
+        else:
+            self._split.pop(path, None)
+
+    def adopt(self, saved: Optional[Dict[str, Dict[str, Any]]] = None) -> None:
+        """Start tracking the files already in a resumed conversation.
+
+        'saved' is the registry state() saved with the session. A file in it gets back
+        its stamp and how it was added, so the next check finds exactly the files that
+        changed since then, outlines and truncated copies included. For a session saved
+        without one, a full copy is compared with the disk (after redaction) on the next
+        check; an outline, project summary or truncated copy can't be compared, so the
+        file is taken as it is now. A file split into parts is compared whole, and split
+        again by the default chunk_tokens if it is refreshed.
+        """
+        with self._lock:
+            for path in self.conversation.files():
+                entry = (saved or {}).get(path)
+                if is_url(path):  # Never compared with the disk; /context refresh fetches it again
+                    if isinstance(entry, dict) and entry.get("url") and isinstance(entry.get("fetched_at"), (int, float)):
+                        self._urls[path] = UrlStamp(entry.get("etag"), entry.get("last_modified"), float(entry["fetched_at"]))
+                    continue
+                if isinstance(entry, dict):
+                    try:
+                        self._restore_entry(path, entry)
+                        continue
+                    except (KeyError, TypeError, ValueError, IndexError):
+                        pass  # Damaged; treated as if it had not been saved
+                content = self.conversation.file_content(path) or ""
+                if self.conversation.file_parts(path):
+                    self._split[path] = DEFAULT_CHUNK_TOKENS
+                strategy = strategy_of(content)
+                if strategy:
+                    self._truncated[path] = (strategy, DEFAULT_TRUNCATE_LINES)
+                    self.accept(path)
+                elif content.startswith(OUTLINE_NOTE) or content.startswith(PROJECT_NOTE):
+                    (self._outlined if content.startswith(OUTLINE_NOTE) else self._manifests).add(path)
+                    self.accept(path)
+                else:
+                    self._stamps[path] = FileStamp(0, 0, text_hash(content))  # Never matches a stat, forcing a comparison
+
+    def stale(self, paths: Optional[List[str]] = None) -> List[Tuple[str, str]]:
+        """(path, "changed" or "deleted") for files in context, or just 'paths', that no longer match the disk."""
+        with self._lock:
+            in_context = set(self.conversation.files())
+            for path in set(self._stamps) - in_context:
+                del self._stamps[path]  # Dropped by /clear or a rollback
+            stamps = {path: stamp for path, stamp in self._stamps.items() if paths is None or path in paths}
+        stale = []
+        for path, stamp in stamps.items():
+            try:
+                info = os.stat(path)
+            except OSError:
+                if stamp != GONE:
+                    stale.append((path, "deleted"))
+                continue
+            if (info.st_mtime_ns, info.st_size) == (stamp.mtime_ns, stamp.size):
+                continue
+            try:
+                content = self.workspace.read_file(path)
+            except (OSError, ValueError):
+                stale.append((path, "changed"))
+                continue
+            if stamp.sha256 in (text_hash(content), text_hash(self.redactor.find(content)[0])):
+                with self._lock:
+                    self._stamps[path] = FileStamp(info.st_mtime_ns, info.st_size, stamp.sha256)  # Touched, not changed
+            else:
+                stale.append((path, "changed"))
+        return stale
+
+    def refresh(self, normalized_path: str) -> bool:
+        """Replace a file's copy in context with what is on disk, or drop it if the file is gone.
+
+        Returns False if the file is not in context.
+        """
+        with self._lock:
+            if not self.conversation.has_file(normalized_path):
+                return False
+            if is_url(normalized_path):
+                return True  # Not on disk; /context refresh fetches pages
+            if not os.path.isfile(normalized_path):
+                self.conversation.remove_file(normalized_path)
+                self._stamps.pop(normalized_path, None)
+                return True
+            truncated = self._truncated.get(normalized_path)
+            if normalized_path in self._split:
+                self.add_parts(normalized_path, self._split[normalized_path])
+            elif truncated:  # Cut the same way again, as /add --max-size may have allowed less than read_file does
+                self.add(normalized_path, truncation=self.workspace.read_truncated(normalized_path, *truncated),
+                         preset=normalized_path in self._preset)
+            else:
+                self.add(normalized_path, outline=normalized_path in self._outlined, manifest=normalized_path in self._manifests,
+                         preset=normalized_path in self._preset)
+            return True
+
+    def accept(self, normalized_path: str) -> None:
+        """Stop reporting a change the user chose not to refresh, until the file changes again."""
+        try:
+            stamp = FileStamp.of(normalized_path, self.workspace.read_file(normalized_path))
+        except FileTooLargeError:
+            info = os.stat(normalized_path)
+            stamp = FileStamp(info.st_mtime_ns, info.st_size, "")  # Too large to hash; a change shows in the stat
+        except (OSError, ValueError):
+            stamp = GONE
+        with self._lock:
+            self._stamps[normalized_path] = stamp
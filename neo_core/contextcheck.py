@@ -0,0 +1,80 @@
+"""Files in context that cost more than they are worth: very large ones, and ones the model stopped mentioning.
+
+Runs before every message, so it stays cheap: token counts are cached per file
+copy, and "mentioned" is a substring match of the file's path or name over the
+last few assistant messages, replies and tool calls alike. Each file is flagged
+at most once a session.
+"""
+
+import json
+import os
+from dataclasses import dataclass
+from typing import Any, Dict, List, Optional, Set, Tuple
+
+from neo_core.conversation import Conversation
+from neo_core.tokens import estimate_string
+
+RECENT_REPLIES = 3  # Assistant messages searched for mentions of each file
+UNUSED_MIN_TOKENS = 200  # An unused file smaller than this costs too little to mention
+
+@dataclass
+class Finding:
+    path: str
+    tokens: int
+    reason: str  # "large" or "unused"
+    turns: int = 0  # For "unused": turns since it was added or last mentioned
+
+def reply_text(messages: List[Dict[str, Any]], count: int = RECENT_REPLIES) -> str:
+    """The content and tool call arguments of the last 'count' assistant messages, as one string."""
+    recent = [msg for msg in messages if msg.get("role") == "assistant"][-count:]
+    return "\n".join((msg.get("content") or "") + json.dumps(msg.get("tool_calls") or [], ensure_ascii=False)
+                     for msg in recent)
+
+def mentions(text: str, path: str, root: str) -> bool:
+    """Whether 'text' names the file by its absolute path, its path from the workspace root or its file name."""
+    names = {path, os.path.basename(path)}
+    if path.startswith(root + os.sep):
+        names.add(os.path.relpath(path, root))
+    return any(name in text for name in names)
+
+class ContextCheck:
+    """Remembers, per file in context, when it was last mentioned and whether it was flagged already."""
+
+    def __init__(self) -> None:
+        self.seen: Dict[str, int] = {}  # Path -> the turn it was added or last mentioned
+        self.flagged: Set[str] = set()
+        self._tokens: Dict[str, Tuple[int, int]] = {}  # Path -> (hash of the copy in context, its tokens)
+
+    def tokens(self, conversation: Conversation, path: str) -> int:
+        content = conversation.file_content(path) or ""
+        key = hash(content)
+        cached = self._tokens.get(path)
+        if cached is None or cached[0] != key:
+            cached = self._tokens[path] = (key, estimate_string(content))
+        return cached[1]
+
+    def check(self, conversation: Conversation, root: str, turn: int, large_tokens: int, unused_turns: int,
+              split: Optional[Set[str]] = None) -> List[Finding]:
+        """Files to flag before the message of 'turn' is sent; 0 turns either check off.
+
+        Files in 'split' were added in parts on purpose, so they are never flagged as large.
+        """
+        files = conversation.files()
+        for path in set(self.seen) - set(files):  # Dropped from context
+            del self.seen[path]
+            self._tokens.pop(path, None)
+        recent = reply_text(conversation.messages()) if unused_turns else ""
+        findings = []
+        for path in files:
+            self.seen.setdefault(path, turn)
+            if recent and mentions(recent, path, root):
+                self.seen[path] = turn
+            if path in self.flagged:
+                continue
+            tokens = self.tokens(conversation, path) if large_tokens or unused_turns else 0
+            if large_tokens and tokens > large_tokens and path not in (split or ()):
+                findings.append(Finding(path, tokens, "large"))
+            elif unused_turns and turn - self.seen[path] >= unused_turns and tokens >= UNUSED_MIN_TOKENS:
+                findings.append(Finding(path, tokens, "unused", turn - self.seen[path]))
+        self.flagged.update(finding.path for finding in findings)
+        return findings
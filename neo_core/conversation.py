@@ -0,0 +1,409 @@
+"""The conversation sent to the model, and the rules for growing and trimming it."""
+
+import copy
+import re
+import threading
+import time
+from dataclasses import dataclass
+from typing import Any, Dict, List, Optional, Tuple
+
+from neo_core.tokens import estimate_conversation, estimate_message
+
+FILE_MARKER = "Content of file '{path}'"
+FILE_MARKER_RE = re.compile(r"^Content of file '(.+?)':\n\n", re.DOTALL)
+FILE_PART_MARKER = ("Part {number}/{count} of file '{path}' (lines {first}-{last}; the file is split into {count} "
+                    "messages, so this part is not the whole file)")
+FILE_PART_RE = re.compile(r"^Part (\d+)/(\d+) of file '(.+?)' \(lines [^)]*\):\n\n", re.DOTALL)
+# Kept with each message in the session file, never sent: who wrote it, when, where it came from and in which turn
+METADATA_KEYS = ("model", "ts", "origin", "source", "turn")
+# Where a message came from, in its "origin": typed by you, a reply, a tool result, or context put in by
+# /add, a mention, the project preset, project metadata, the clipboard, a tool (for an edit) or a command
+ORIGINS = ("user", "ai", "tool", "add", "mention", "preset", "project", "clipboard", "command")
+ORIGIN_LABELS = {"user": "you", "add": "/add"}
+MAX_HISTORY_MESSAGES = 15  # Default limit on non-system messages kept by trimming; see max_history_messages
+
+def file_path_of(msg: Dict[str, Any]) -> Optional[str]:
+    """The path of the file a message holds, whole or one part of it; None for any other message."""
+    if msg["role"] != "system":
+        return None
+    match = FILE_MARKER_RE.match(msg.get("content") or "")
+    if match:
+        return match.group(1)
+    match = FILE_PART_RE.match(msg.get("content") or "")
+    return match.group(3) if match else None
+
+@dataclass(frozen=True)
+class TurnSummary:
+    """One of your messages and everything sent in answer to it, for the stats line and the transcript."""
+    turn: int
+    message: str  # Yours, which started the turn; "" if it was forgotten
+    started: Optional[float]
+    replies: int  # Assistant messages with text
+    tool_rounds: int  # Assistant messages that asked for tools
+    tool_calls: int
+    tokens: int
+
+def origin_of(msg: Dict[str, Any]) -> str:
+    """A message's origin; sessions saved before origins were recorded get one from the role."""
+    return msg.get("origin") or {"user": "user", "assistant": "ai", "tool": "tool"}.get(msg["role"], "")
+
+def describe_origin(msg: Dict[str, Any]) -> str:
+    """Where a message came from, for /when and /context: "you", "ai · deepseek-chat", "tool · read_file" and so on."""
+    origin = origin_of(msg)
+    label = ORIGIN_LABELS.get(origin, origin) or "-"
+    if origin == "ai":
+        detail = msg.get("model")
+    else:  # A file's source is its path, which /context shows already
+        detail = msg.get("source") if msg["role"] != "system" else None
+    return f"{label} · {detail}" if detail else label
+
+class Conversation:
+    """Messages in API format, plus the system prompt and the files added as context.
+
+    Invariants kept by every method:
+    - the first message is the system prompt;
+    - system messages (the prompt and file contents) are pinned and never trimmed;
+    - a file is in context once, whole in one message or split into consecutive parts;
+    - tool results always directly follow the assistant message that requested them,
+      and trimming removes an assistant message together with its tool results.
+
+    Each message also carries METADATA_KEYS, which messages() leaves out. "turn" counts
+    your messages: one you send starts the next turn, and the replies, tool results and
+    files that follow it share its number.
+
+    Methods take a lock so the conversation can be shared with background work.
+    """
+
+    def __init__(self, system_prompt: str):
+        self._lock = threading.RLock()
+        self.system_prompt = system_prompt
+        self._messages: List[Dict[str, Any]] = [{"role": "system", "content": system_prompt}]
+        self.turn = 0  # Of the latest user message; files added before any are in turn 0
+
+    # -- reading --------------------------------------------------------------
+
+    def messages(self) -> List[Dict[str, Any]]:
+        """A copy of the messages, safe to send while the conversation keeps changing.
+
+        The model that wrote each reply and when each message was added (METADATA_KEYS)
+        are kept for the session file but left out here.
+        """
+        with self._lock:
+            return [{k: v for k, v in msg.items() if k not in METADATA_KEYS} if any(k in msg for k in METADATA_KEYS) else msg
+                    for msg in self._messages]
+
+    def __len__(self) -> int:
+        with self._lock:
+            return len(self._messages)
+
+    def has_exchanges(self) -> bool:
+        """Whether anything beyond system messages has been added."""
+        with self._lock:
+            return any(msg["role"] != "system" for msg in self._messages)
+
+    def token_count(self) -> int:
+        with self._lock:
+            return estimate_conversation(self._messages)
+
+    def request_tokens(self, user_message: str, max_tokens: int, max_messages: int = MAX_HISTORY_MESSAGES) -> int:
+        """Estimated tokens of the messages sent if 'user_message' were added now and trimmed as usual."""
+        trial = Conversation(self.system_prompt)
+        trial.restore(self.snapshot())
+        trial.add_user(user_message)
+        trial.trim_to_budget(max_tokens, max_messages)
+        return trial.token_count()
+
+    def files(self) -> List[str]:
+        """Paths of the files whose contents are in context, in the order they were added."""
+        with self._lock:
+            paths = []
+            for msg in self._messages:
+                path = file_path_of(msg)
+                if path and path not in paths:
+                    paths.append(path)
+            return paths
+
+    def has_file(self, path: str) -> bool:
+        return path in self.files()
+
+    def file_content(self, path: str) -> Optional[str]:
+        """The content of a file as it was added to context; the parts of a split one joined back together."""
+        with self._lock:
+            indexes = self._file_indexes(path)
+            if not indexes:
+                return None
+            marker = FILE_PART_RE if self.file_parts(path) else FILE_MARKER_RE
+            return "".join(marker.sub("", self._messages[i]["content"], count=1) for i in indexes)
+
+    def file_parts(self, path: str) -> int:
+        """How many parts a file was split into by add_file_parts; 0 if it is whole, or not in context."""
+        with self._lock:
+            indexes = self._file_indexes(path)
+            match = FILE_PART_RE.match(self._messages[indexes[0]]["content"]) if indexes else None
+            return int(match.group(2)) if match else 0
+
+    def _file_indexes(self, path: str) -> List[int]:
+        return [i for i, msg in enumerate(self._messages) if file_path_of(msg) == path]
+
+    def history(self) -> List[Dict[str, Any]]:
+        """The exchanges: every message except the system ones. /forget numbers them from 1."""
+        with self._lock:
+            return [msg for msg in self._messages if msg["role"] != "system"]
+
+    # -- turns ----------------------------------------------------------------
+
+    def last_turn(self) -> Optional[int]:
+        """The turn of your latest message still in the history; None before any."""
+        with self._lock:
+            for msg in reversed(self._messages):
+                if msg["role"] == "user":
+                    return msg.get("turn")
+            return None
+
+    def turn_numbers(self, turn: int) -> List[int]:
+        """History numbers of the messages of 'turn': your message, the replies and the tool calls and results between."""
+        return [number for number, msg in enumerate(self.history(), 1) if msg.get("turn") == turn]
+
+    def turn_summary(self, turn: int) -> Optional[TurnSummary]:
+        """What 'turn' holds, or None when none of its messages are left."""
+        messages = [msg for msg in self.history() if msg.get("turn") == turn]
+        if not messages:
+            return None
+        first = messages[0]
+        replies = [msg for msg in messages if msg["role"] == "assistant"]
+        return TurnSummary(
+            turn=turn,
+            message=(first.get("content") or "") if first["role"] == "user" else "",
+            started=first.get("ts"),
+            replies=sum(bool((msg.get("content") or "").strip()) for msg in replies),
+            tool_rounds=sum(bool(msg.get("tool_calls")) for msg in replies),
+            tool_calls=sum(len(msg.get("tool_calls") or []) for msg in replies),
+            tokens=estimate_conversation(messages),
+        )
+
+    def drop_turn(self, turn: int) -> List[Tuple[int, Dict[str, Any]]]:
+        """Remove every message of 'turn' (files it added stay in context), as forget() does by number."""
+        return self.forget(self.turn_numbers(turn))
+
+    def last_assistant_reply(self) -> Optional[str]:
+        """The most recent assistant message that has text content."""
+        with self._lock:
+            for msg in reversed(self._messages):
+                if msg["role"] == "assistant" and (msg.get("content") or "").strip():
+                    return msg["content"]
+            return None
+
+    # -- adding ---------------------------------------------------------------
+
+    def set_system_prompt(self, system_prompt: str) -> None:
+        with self._lock:
+            self.system_prompt = system_prompt
+            self._messages[0] = {"role": "system", "content": system_prompt}
+
+    def _metadata(self, origin: str, source: Optional[str] = None) -> Dict[str, Any]:
+        metadata: Dict[str, Any] = {"ts": time.time(), "origin": origin, "turn": self.turn}
+        if source:
+            metadata["source"] = source
+        return metadata
+
+    def add_user(self, content: str, origin: str = "user", source: Optional[str] = None) -> None:
+        """Add your message, which starts a new turn; 'origin' "command" marks one a command wrote for you."""
+        with self._lock:
+            self.turn += 1
+            self._messages.append({"role": "user", "content": content, **self._metadata(origin, source)})
+
+    def add_assistant(self, content: Optional[str], tool_calls: Optional[List[Dict[str, Any]]] = None,
+                      model: Optional[str] = None, origin: str = "ai", source: Optional[str] = None) -> None:
+        with self._lock:
+            message: Dict[str, Any] = {"role": "assistant", "content": content, **self._metadata(origin, source)}
+            if tool_calls:
+                message["tool_calls"] = tool_calls
+            if model:
+                message["model"] = model
+            self._messages.append(message)
+
+    def add_tool_result(self, tool_call_id: str, content: str, tool: Optional[str] = None) -> None:
+        """Add the result of a tool call; 'tool' is the tool's name, kept as the message's source."""
+        with self._lock:
+            self._messages.append({"role": "tool", "tool_call_id": tool_call_id, "content": content, **self._metadata("tool", tool)})
+
+    def add_tool_results(self, results: List[Dict[str, str]]) -> None:
+        """Append {"tool_call_id", "content"} results in order."""
+        with self._lock:
+            for result in results:
+                self.add_tool_result(result["tool_call_id"], result["content"])
+
+    def add_file(self, path: str, content: str, origin: Optional[str] = None) -> None:
+        """Add a file's contents as a pinned system message, replacing the copy already there.
+
+        'origin' says what added it (see ORIGINS); without one a new file is from /add and a
+        replaced one keeps the origin it had.
+        """
+        self._put_file(path, [f"{FILE_MARKER.format(path=path)}:\n\n{content}"], origin)
+
+    def add_file_parts(self, path: str, parts: List[Tuple[int, int, str]], origin: Optional[str] = None) -> None:
+        """Add a file as consecutive pinned messages, one per (first line, last line, content) part.
+
+        Each part's header gives its number and lines and says the others exist, so the
+        model doesn't take one part for the whole file. Replaces the copy already there.
+        """
+        count = len(parts)
+        self._put_file(path, [f"{FILE_PART_MARKER.format(number=number, count=count, path=path, first=first, last=last)}:\n\n{content}"
+                              for number, (first, last, content) in enumerate(parts, 1)], origin)
+
+    def _put_file(self, path: str, messages: List[str], origin: Optional[str]) -> None:
+        with self._lock:
+            indexes = self._file_indexes(path)
+            if not indexes:
+                for message in messages:
+                    self.add_system(message, origin or "add", path)
+                return
+            origin = origin or self._messages[indexes[0]].get("origin") or "add"
+            for i in reversed(indexes):
+                del self._messages[i]
+            metadata = self._metadata(origin, path)
+            self._messages[indexes[0]:indexes[0]] = [{"role": "system", "content": message, **metadata} for message in messages]
+
+    def file_message(self, path: str) -> Optional[Dict[str, Any]]:
+        """The (first) message holding a file in context, for its metadata; None if it is not in context."""
+        with self._lock:
+            indexes = self._file_indexes(path)
+            return dict(self._messages[indexes[0]]) if indexes else None
+
+    def add_system(self, content: str, origin: str = "command", source: Optional[str] = None) -> None:
+        """Add pinned context as a system message.
+
+        While tool results are still outstanding the message is placed before the
+        assistant message that requested them, so the results stay adjacent.
+        """
+        with self._lock:
+            self._messages.insert(self._pending_tool_call_index(), {"role": "system", "content": content, **self._metadata(origin, source)})
+
+    def _pending_tool_call_index(self) -> int:
+        """Index of a trailing assistant message still waiting on tool results, else the end."""
+        for i in range(len(self._messages) - 1, -1, -1):
+            msg = self._messages[i]
+            if msg["role"] == "tool":
+                continue
+            if msg["role"] == "assistant" and msg.get("tool_calls"):
+                answered = {m.get("tool_call_id") for m in self._messages[i + 1:]}
+                if any(tc["id"] not in answered for tc in msg["tool_calls"]):
+                    return i
+            break
+        return len(self._messages)
+
+    # -- removing -------------------------------------------------------------
+
+    def remove_file(self, path: str) -> bool:
+        """Drop a file from context, every part of it if it was split."""
+        with self._lock:
+            indexes = self._file_indexes(path)
+            for i in reversed(indexes):
+                del self._messages[i]
+            return bool(indexes)
+
+    def forget(self, numbers: List[int]) -> List[Tuple[int, Dict[str, Any]]]:
+        """Remove messages by history number, returning (number, message) for each one removed.
+
+        An assistant message and its tool results go together: naming either removes
+        the lot, so no tool call is left without its result or the other way round.
+        """
+        with self._lock:
+            wanted = set(numbers)
+            removed: List[Tuple[int, Dict[str, Any]]] = []
+            kept: List[Dict[str, Any]] = []
+            number = 0
+            for unit in self._units([msg for msg in self._messages if msg["role"] != "system"]):
+                numbered = list(enumerate(unit, number + 1))
+                number += len(unit)
+                if any(n in wanted for n, _ in numbered):
+                    removed.extend(numbered)
+                else:
+                    kept.extend(unit)
+            if removed:
+                self._messages = [msg for msg in self._messages if msg["role"] == "system"] + kept
+            return removed
+
+    def drop_unanswered(self, content: str) -> bool:
+        """Remove the latest turn if it is only this user message, still without a reply."""
+        with self._lock:
+            turn = self.last_turn()
+            numbers = self.turn_numbers(turn) if turn is not None else []
+            history = self.history()
+            if len(numbers) == 1 and numbers[0] == len(history) and history[-1]["content"] == content:
+                self.drop_turn(turn)
+                if turn == self.turn:
+                    self.turn -= 1  # The message sent again takes the same turn
+                return True
+            return False
+
+    def clear(self, preserve_system: bool = False) -> None:
+        """Remove the exchanges. With preserve_system, file contents stay; otherwise only the prompt does."""
+        with self._lock:
+            if preserve_system:
+                self._messages = [msg for msg in self._messages if msg["role"] == "system"]
+            else:
+                self._messages = [{"role": "system", "content": self.system_prompt}]
+
+    def _units(self, messages: List[Dict[str, Any]]) -> List[List[Dict[str, Any]]]:
+        """Group non-system messages so each assistant message travels with its tool results."""
+        units: List[List[Dict[str, Any]]] = []
+        for msg in messages:
+            if msg["role"] == "tool" and units and units[-1][0]["role"] == "assistant":
+                units[-1].append(msg)
+            else:
+                units.append([msg])
+        return units
+
+    def trim_to_budget(self, max_tokens: int, max_messages: int = MAX_HISTORY_MESSAGES) -> int:
+        """Drop the oldest exchanges until at most max_messages non-system messages and
+        max_tokens estimated tokens remain. The latest message is always kept.
+
+        Returns the number of messages removed.
+        """
+        with self._lock:
+            system_msgs = [msg for msg in self._messages if msg["role"] == "system"]
+            units = self._units([msg for msg in self._messages if msg["role"] != "system"])
+            pinned_tokens = estimate_conversation(system_msgs)
+
+            def over_budget() -> bool:
+                count = sum(len(unit) for unit in units)
+                tokens = pinned_tokens + sum(estimate_message(msg) for unit in units for msg in unit)
+                return count > max_messages or tokens > max_tokens
+
+            removed = 0
+            while len(units) > 1 and over_budget():
+                removed += len(units.pop(0))
+            # Never start on a tool result or assistant reply without the user message before it
+            while len(units) > 1 and units[0][0]["role"] != "user":
+                removed += len(units.pop(0))
+
+            if removed:
+                self._messages = system_msgs + [msg for unit in units for msg in unit]
+            return removed
+
+    # -- persistence ----------------------------------------------------------
+
+    def snapshot(self) -> Dict[str, Any]:
+        with self._lock:
+            return {"system_prompt": self.system_prompt, "messages": copy.deepcopy(self._messages)}
+
+    def restore(self, snapshot: Dict[str, Any]) -> None:
+        with self._lock:
+            messages = copy.deepcopy(snapshot["messages"])
+            self.system_prompt = snapshot.get("system_prompt") or (messages[0]["content"] if messages else self.system_prompt)
+            if not messages or messages[0]["role"] != "system":
+                messages.insert(0, {"role": "system", "content": self.system_prompt})
+            self._messages = messages
+            if any("turn" not in msg for msg in messages if msg["role"] != "system"):
+                self._number_turns()
+            self.turn = max((msg.get("turn", 0) for msg in messages), default=0)
+
+    def _number_turns(self) -> None:
+        """Give the messages of a session saved before turns were recorded theirs: each user message starts the next."""
+        turn = 0
+        for msg in self._messages:
+            if msg["role"] == "user":
+                turn += 1
+            if msg["role"] != "system" or file_path_of(msg):
+                msg.setdefault("turn", turn)
@@ -0,0 +1,196 @@
+"""`neo doctor` and /doctor: one table of everything a working setup depends on.
+
+Each check passes, warns or fails, with a hint for anything that isn't a pass.
+Only what stops Neo from chatting fails: a config file that doesn't load, a
+missing or rejected API key, an unreachable API and a model the provider doesn't
+offer. `neo doctor` exits 1 when one of those does, so setup scripts can run it.
+Everything else (git, the terminal, the sessions directory, where the workspace
+is) only warns, as Neo works without it, if less well.
+"""
+
+import argparse
+import difflib
+import os
+import shutil
+import sys
+import tempfile
+import time
+from dataclasses import dataclass
+from pathlib import Path
+from typing import List, Optional, Tuple
+
+from openai import APIConnectionError, APIStatusError, AuthenticationError
+from rich.markup import escape
+from rich.table import Table
+
+from neo_core.ai import SESSIONS_DIR, describe_route, initialize_ai_client
+from neo_core.config import DEFAULT_CONFIG_PATH, NeoConfig, build_arg_parser, build_config
+from neo_core.gitops import GitError, Repository
+from neo_core.project import manifest_paths
+from neo_core.redact import scrub
+from neo_core.ui import console
+
+PASS, WARN, FAIL = "pass", "warn", "fail"
+SLOW_API_SECONDS = 2.0
+MIN_TERMINAL_WIDTH = 80
+
+@dataclass
+class Check:
+    name: str
+    status: str  # PASS, WARN or FAIL
+    detail: str
+    hint: str = ""  # What to do about a warning or failure
+
+class ConfigProblem(Exception):
+    pass
+
+class _RaisingParser:
+    """Stands in for the argument parser so build_config's errors become a failed check rather than an exit."""
+
+    def error(self, message: str) -> None:
+        raise ConfigProblem(message)
+
+def load_config(args: argparse.Namespace) -> Tuple[NeoConfig, Check]:
+    """The config as `neo` would build it, and the check saying whether the file loaded.
+
+    When it doesn't, the rest of the checks run on the defaults and the flags' provider.
+    """
+    path = Path(args.config).expanduser() if args.config else DEFAULT_CONFIG_PATH
+    try:
+        config = build_config(args, _RaisingParser())
+    except ConfigProblem as e:
+        return NeoConfig(**({"provider": args.provider} if args.provider else {})), Check(
+            "Config file", FAIL, str(e), f"Fix {path}, or move it aside to start from the defaults.")
+    detail = f"{path} loaded" if path.is_file() else f"no file at {path}, using defaults"
+    return config, Check("Config file", PASS, detail)
+
+def check_config_file(config_path: Optional[str]) -> Check:
+    """Whether the config file the session started with still loads, for /doctor."""
+    args = build_arg_parser().parse_args(["--config", config_path] if config_path else [])
+    return load_config(args)[1]
+
+def check_api(config: NeoConfig) -> List[Check]:
+    """The API key, the base URL and the model, from one models-list request."""
+    if config.provider == "mock":
+        return [Check("API", PASS, "mock provider: no key or network needed")]
+    if config.offline:
+        return [Check("API", WARN, "not checked in offline mode", "Run without --offline to check the key, the API and the model.")]
+    provider = config.provider_info()
+    api_key_env, base_url = provider["api_key_env"], provider["base_url"]
+    if api_key_env and not os.getenv(api_key_env):
+        return [Check("API key", FAIL, f"{api_key_env} is not set", f"Set {api_key_env} in your environment or a .env file."),
+                Check("API", WARN, f"{base_url} not checked without a key", "")]
+
+    client = initialize_ai_client(config).with_options(timeout=15, max_retries=0)
+    started = time.monotonic()
+    try:
+        models = [model.id for model in client.models.list()]
+    except AuthenticationError:
+        seconds = time.monotonic() - started
+        return [Check("API key", FAIL, f"{api_key_env} was rejected by {config.provider}",
+                      f"Create a new key for {config.provider} and put it in {api_key_env}."),
+                Check("API", PASS, f"{base_url} answered in {seconds * 1000:.0f}ms")]
+    except APIConnectionError as e:
+        return [Check("API key", WARN, f"{api_key_env} is set but could not be verified", ""),
+                Check("API", FAIL, scrub(f"{base_url} unreachable ({describe_route(config)}): {e}"),
+                      "Check the network. Behind a proxy, set HTTPS_PROXY; behind a TLS-intercepting one, set ca_bundle.")]
+    except APIStatusError as e:
+        seconds = time.monotonic() - started
+        return [Check("API key", PASS, f"{api_key_env} is set"),
+                Check("API", PASS, f"{base_url} answered in {seconds * 1000:.0f}ms"),
+                Check("Model", WARN, f"the provider did not list its models (HTTP {e.status_code})",
+                      "The model is checked by the first message instead.")]
+    seconds = time.monotonic() - started
+    checks = [Check("API key", PASS, f"{api_key_env} accepted by {config.provider}")]
+    if seconds > SLOW_API_SECONDS:
+        checks.append(Check("API", WARN, f"{base_url} answered in {seconds * 1000:.0f}ms",
+                            "Replies will start slowly; check the network or proxy."))
+    else:
+        checks.append(Check("API", PASS, f"{base_url} answered in {seconds * 1000:.0f}ms"))
+    if not models:
+        checks.append(Check("Model", WARN, "the provider listed no models", "The model is checked by the first message instead."))
+        return checks
+    wanted = [config.resolved_chat_model(), config.resolved_coder_model()] if config.model == "auto" else [config.resolved_model()]
+    for model in dict.fromkeys(wanted):
+        if model in models:
+            checks.append(Check("Model", PASS, f"{model} is available"))
+        else:
+            close = difflib.get_close_matches(model, models, n=3)
+            hint = f"Did you mean {', '.join(close)}?" if close else f"Pick one of the {len(models)} models the provider lists with --model."
+            checks.append(Check("Model", FAIL, f"{model} is not offered by {config.provider}", hint))
+    return checks
+
+def check_git(workdir: str) -> Check:
+    if shutil.which("git") is None:
+        return Check("git", WARN, "not found on PATH", "Install git for /commit, /review, /patch and neo review.")
+    try:
+        return Check("git", PASS, Repository._run(workdir, "--version").strip())
+    except GitError as e:
+        return Check("git", WARN, str(e), "Reinstall git, or check that the one on PATH runs.")
+
+def check_terminal() -> Check:
+    width, height = console.size.width, console.size.height
+    detail = f"{width}x{height}, colors: {console.color_system or 'none'}, TERM={os.getenv('TERM') or 'unset'}"
+    if not sys.stdout.isatty():
+        return Check("Terminal", WARN, f"output is not a terminal; {detail}", "Run Neo in a terminal for the prompt and colors.")
+    if width < MIN_TERMINAL_WIDTH:
+        return Check("Terminal", WARN, detail, f"Widen the window to at least {MIN_TERMINAL_WIDTH} columns; tables and diffs wrap below that.")
+    if not console.color_system:
+        return Check("Terminal", WARN, detail, "Set TERM (e.g. xterm-256color) and unset NO_COLOR for colors.")
+    return Check("Terminal", PASS, detail)
+
+def check_sessions_dir(directory: Path = SESSIONS_DIR) -> Check:
+    try:
+        directory.mkdir(parents=True, exist_ok=True)
+        with tempfile.NamedTemporaryFile(dir=directory):
+            pass
+    except OSError as e:
+        return Check("Sessions", WARN, f"{directory} is not writable: {e.strerror or e}",
+                     "Fix the directory's permissions; until then sessions can't be saved or resumed.")
+    return Check("Sessions", PASS, f"{directory} is writable")
+
+def check_workspace(root: str) -> Check:
+    """Where Neo would work, and whether that looks like the top of a project."""
+    try:
+        top: Optional[str] = Repository(root).top
+    except GitError:
+        top = None
+    found = [os.path.basename(path) for path in manifest_paths(root)]
+    detail = ", ".join([root] + (["git repository"] if top else []) + found)
+    if os.path.normcase(root) in (os.path.normcase(os.path.abspath(os.sep)), os.path.normcase(str(Path.home()))):
+        return Check("Workspace", WARN, detail, "The tools can read and change everything below it; start Neo in a project or pass --workdir.")
+    if top and os.path.normcase(os.path.abspath(top)) != os.path.normcase(root):
+        return Check("Workspace", WARN, f"{detail}, inside the repository at {top}",
+                     f"Files outside this directory are out of reach; start Neo in {top} to work on the whole project.")
+    return Check("Workspace", PASS, detail)
+
+def run_checks(config: NeoConfig, config_check: Check, workdir: str) -> List[Check]:
+    with console.status("[matrix.accent]> RUNNING DIAGNOSTICS...[/matrix.accent]", spinner="dots"):
+        api = check_api(config)
+    return [config_check, *api, check_git(workdir), check_terminal(), check_sessions_dir(), check_workspace(workdir)]
+
+def show_checks(checks: List[Check]) -> None:
+    table = Table(title="[matrix.accent][ DIAGNOSTICS ][/matrix.accent]", show_header=True, header_style="matrix.primary", border_style="matrix.border")
+    table.add_column("", justify="center")
+    table.add_column("Check", style="matrix.accent")
+    table.add_column("Result")
+    marks = {PASS: "[matrix.success]✓ PASS[/matrix.success]", WARN: "[matrix.warning]⚠ WARN[/matrix.warning]", FAIL: "[matrix.error]✗ FAIL[/matrix.error]"}
+    for check in checks:
+        table.add_row(marks[check.status], check.name, escape(check.detail))
+    console.print(table)
+    for check in checks:
+        if check.status != PASS and check.hint:
+            style = "matrix.error" if check.status == FAIL else "matrix.warning"
+            console.print(f"[{style}]> {check.name}:[/{style}] {escape(check.hint)}")
+    failed = sum(check.status == FAIL for check in checks)
+    if failed:
+        console.print(f"\n[matrix.error]> {failed} check(s) failed.[/matrix.error]\n")
+    else:
+        console.print("\n[matrix.success]> All critical checks passed.[/matrix.success]\n")
+
+def run_doctor(args: argparse.Namespace) -> int:
+    """`neo doctor`: print the checks; exits 1 if any failed."""
+    config, config_check = load_config(args)
+    checks = run_checks(config, config_check, os.path.abspath(config.workdir or "."))
+    show_checks(checks)
+    return 1 if any(check.status == FAIL for check in checks) else 0
@@ -0,0 +1,114 @@
+"""Falling back to other providers while the configured one is down.
+
+config.fallback_providers lists providers to try, in order, when a request to the
+one in use fails to connect or gets a 5xx after the client's own retries. The same
+request goes to the next provider, with its model named through that provider's
+"models" map: {"deepseek-reasoner": "gpt-4o"}. A model it doesn't map becomes its
+chat_model when it was the chat model, and its default_model otherwise. Whether
+the mapped model takes tools or streams is up to capabilities.py, like any other.
+
+Once a fallback answers it stays in use: the configured provider is checked again
+in the background every PRIMARY_CHECK_SECONDS, and requests go back to it after it
+answers, or after /provider reset.
+"""
+
+import os
+import threading
+import time
+from typing import Any, Callable, Dict, List, Optional
+
+from openai import APIConnectionError
+
+from neo_core.config import NeoConfig
+
+PRIMARY_CHECK_SECONDS = 300  # Between checks of the configured provider while a fallback is in use
+CHECK_TIMEOUT_SECONDS = 5
+
+def is_outage(error: Exception) -> bool:
+    """Whether 'error' says the provider is unreachable or failing, not that the request was wrong."""
+    status = getattr(error, "status_code", None)
+    return isinstance(error, APIConnectionError) or (isinstance(status, int) and status >= 500)
+
+class ProviderChain:
+    """The configured provider's client and, in order, the fallbacks' clients, made when first needed."""
+
+    def __init__(self, config: NeoConfig, client: Any, connect: Callable[[NeoConfig, str], Any]):
+        self.config = config
+        self.names: List[str] = [config.provider] + [name for name in config.fallback_providers if name != config.provider]
+        self.clients: Dict[str, Any] = {config.provider: client}
+        self.connect = connect  # connect(config, name) -> client; initialize_ai_client
+        self.active = 0  # Index in names of the provider requests go to
+        self.unusable: Dict[str, str] = {}  # Fallback -> why it can't be used, such as a missing key
+        self.recovered = False  # Set by a background check of the configured provider
+        self.last_check = 0.0
+        self._checking = False
+
+    @property
+    def primary(self) -> str:
+        return self.names[0]
+
+    @property
+    def name(self) -> str:
+        return self.names[self.active]
+
+    @property
+    def client(self) -> Any:
+        return self.clients[self.name]
+
+    def model_for(self, model: str) -> str:
+        """'model', as named for the primary provider, as the provider in use names it."""
+        if self.active == 0:
+            return model
+        primary = self.config.provider_info(self.primary)
+        provider = self.config.provider_info(self.name)
+        mapped = provider.get("models", {}).get(model)
+        if mapped:
+            return mapped
+        return provider["chat_model"] if model == (self.config.chat_model or primary["chat_model"]) else provider["default_model"]
+
+    def fail_over(self, error: Exception) -> Optional[str]:
+        """After 'error' from the provider in use, switch to the next usable fallback and return its name; None if none is left."""
+        if not is_outage(error):
+            return None
+        for index in range(self.active + 1, len(self.names)):
+            name = self.names[index]
+            if name not in self.clients:
+                api_key_env = self.config.provider_info(name)["api_key_env"]
+                if api_key_env and not os.getenv(api_key_env):
+                    self.unusable[name] = f"{api_key_env} is not set"
+                    continue
+                self.clients[name] = self.connect(self.config, name)
+            self.active = index
+            self.last_check = time.monotonic()
+            return name
+        return None
+
+    def reset(self) -> bool:
+        """Go back to the configured provider; False if it was already in use."""
+        was_active = self.active
+        self.active = 0
+        self.recovered = False
+        return was_active != 0
+
+    def maybe_recover(self) -> bool:
+        """Before a request on a fallback: go back to the configured provider if a check found it answering,
+        and start another check once PRIMARY_CHECK_SECONDS have passed. True when it went back."""
+        if self.active == 0:
+            return False
+        if self.recovered:
+            self.reset()
+            return True
+        if not self._checking and time.monotonic() - self.last_check >= PRIMARY_CHECK_SECONDS:
+            self.last_check = time.monotonic()
+            self._checking = True
+            threading.Thread(target=self._check_primary, name="neo-provider-check", daemon=True).start()
+        return False
+
+    def _check_primary(self) -> None:
+        try:
+            self.clients[self.primary].with_options(timeout=CHECK_TIMEOUT_SECONDS, max_retries=0).models.list()
+            self.recovered = True
+        except Exception:
+            pass  # Still down; checked again later
+        finally:
+            self._checking = False
@@ -0,0 +1,245 @@
+"""Fetching a web page or raw file for /add https://…, as text fit for the context.
+
+Text and code (text/plain, JSON, YAML, XML and the like) are added as they are.
+HTML is turned into readable text: scripts, styles and navigation chrome are
+left out, headings and list items keep a marker, and <pre> blocks become fenced
+code blocks with their whitespace intact. Anything else is refused, as are
+responses over the size cap and pages that turn out to be a sign-in form.
+
+The ETag and Last-Modified of each fetch are kept, so /context refresh asks the
+server whether the page changed instead of downloading it again.
+"""
+
+import re
+import ssl
+from dataclasses import dataclass
+from html.parser import HTMLParser
+from pathlib import Path
+from typing import List, Optional, Tuple
+from urllib.error import HTTPError, URLError
+from urllib.parse import urlsplit
+from urllib.request import HTTPSHandler, Request, build_opener
+
+from neo_core.config import NeoConfig
+from neo_core.fileops import format_size
+
+FETCH_TIMEOUT_SECONDS = 15
+USER_AGENT = "neo (+https://github.com/DustyPolk/neo)"
+
+URL_RE = re.compile(r"^https?://\S+$", re.IGNORECASE)
+TEXT_TYPE_RE = re.compile(r"^(text/.+|application/(json|xml|javascript|ecmascript|x-yaml|yaml|toml|x-toml|x-sh|x-python|sql|graphql)"
+                          r"|application/[\w.+-]+\+(json|xml)|application/x-(httpd-php|perl|ruby|tex|latex))$")
+# Where sites send a browser that isn't signed in: github.com/login?return_to=…, accounts.google.com/…, sso.corp.example/…
+LOGIN_PATH_RE = re.compile(r"(^|/)(login|log-in|signin|sign-in|sign_in|servicelogin|sso|authorize|oauth2?|session/new|saml)(/|$|\.)", re.IGNORECASE)
+LOGIN_HOST_RE = re.compile(r"^(login|signin|sso|auth|accounts|idp)\.", re.IGNORECASE)
+PASSWORD_INPUT_RE = re.compile(r"<input[^>]+type\s*=\s*[\"']?password", re.IGNORECASE)
+
+class FetchError(Exception):
+    """A URL that can't be added, with a message fit to show as is."""
+
+@dataclass
+class Fetched:
+    url: str  # After redirects
+    content: str = ""
+    content_type: str = ""
+    etag: Optional[str] = None
+    last_modified: Optional[str] = None
+    not_modified: bool = False  # A conditional fetch found the page unchanged; content is empty
+
+def is_url(text: str) -> bool:
+    return bool(URL_RE.match(text))
+
+def _ssl_context(config: NeoConfig) -> ssl.SSLContext:
+    """The same TLS settings as the API client: ca_bundle, or no checks with insecure_skip_verify."""
+    if config.insecure_skip_verify:
+        context = ssl.create_default_context()
+        context.check_hostname = False
+        context.verify_mode = ssl.CERT_NONE
+        return context
+    if config.ca_bundle:
+        return ssl.create_default_context(cafile=str(Path(config.ca_bundle).expanduser()))
+    return ssl.create_default_context()
+
+def _login_redirect(requested: str, final: str) -> bool:
+    if final == requested:
+        return False
+    parts = urlsplit(final)
+    return bool(LOGIN_PATH_RE.search(parts.path) or LOGIN_HOST_RE.match(parts.hostname or "")
+                or re.search(r"(^|&)(return_?to|redirect_(uri|to)|continue|next)=", parts.query or "", re.IGNORECASE))
+
+def fetch_url(url: str, config: NeoConfig, max_bytes: int, etag: Optional[str] = None,
+              last_modified: Optional[str] = None) -> Fetched:
+    """Fetch 'url' as text for the context; raises FetchError with the reason it can't be added.
+
+    With 'etag' or 'last_modified' from an earlier fetch the request is conditional, and
+    an unchanged page comes back as Fetched(not_modified=True). Proxies come from
+    HTTPS_PROXY / HTTP_PROXY / NO_PROXY, as for the API.
+    """
+    headers = {"User-Agent": USER_AGENT, "Accept": "text/*, application/json;q=0.9, */*;q=0.1"}
+    if etag:
+        headers["If-None-Match"] = etag
+    if last_modified:
+        headers["If-Modified-Since"] = last_modified
+    opener = build_opener(HTTPSHandler(context=_ssl_context(config)))
+    try:
+        with opener.open(Request(url, headers=headers), timeout=FETCH_TIMEOUT_SECONDS) as response:
+            final = response.geturl()
+            if _login_redirect(url, final):
+                raise FetchError(f"redirected to a sign-in page ({final}); the page needs a login Neo doesn't have. "
+                                 "Save it from your browser and /add the file")
+            content_type = response.headers.get_content_type()
+            if not TEXT_TYPE_RE.match(content_type):
+                raise FetchError(f"is {content_type}, not text; only web pages, text and code can be added")
+            length = response.headers.get("Content-Length")
+            if length and length.isdigit() and int(length) > max_bytes:
+                raise FetchError(f"is {format_size(int(length))}, over the {format_size(max_bytes)} limit (max_file_size, or --max-size)")
+            body = response.read(max_bytes + 1)
+            if len(body) > max_bytes:
+                raise FetchError(f"is over the {format_size(max_bytes)} limit (max_file_size, or --max-size)")
+            fetched = Fetched(final, "", content_type, response.headers.get("ETag"), response.headers.get("Last-Modified"))
+            charset = response.headers.get_content_charset() or "utf-8"
+    except HTTPError as e:
+        if e.code == 304:
+            return Fetched(url, etag=etag, last_modified=last_modified, not_modified=True)
+        if e.code in (401, 403, 407):
+            raise FetchError(f"needs a login (HTTP {e.code} {e.reason}); save the page from your browser and /add the file") from e
+        raise FetchError(f"HTTP {e.code} {e.reason}") from e
+    except URLError as e:
+        reason = f"timed out after {FETCH_TIMEOUT_SECONDS}s" if isinstance(e.reason, TimeoutError) else e.reason
+        raise FetchError(f"could not connect: {reason}") from e
+    except TimeoutError as e:
+        raise FetchError(f"timed out after {FETCH_TIMEOUT_SECONDS}s") from e
+    except ValueError as e:  # A malformed URL
+        raise FetchError(str(e)) from e
+    if b"\0" in body[:8000]:
+        raise FetchError(f"says it is {content_type}, but its content is binary")
+    try:
+        text = body.decode(charset, errors="replace")
+    except LookupError:
+        text = body.decode("utf-8", errors="replace")
+    if content_type in ("text/html", "application/xhtml+xml"):
+        if PASSWORD_INPUT_RE.search(text):
+            raise FetchError("is a sign-in page, not the content; the page needs a login Neo doesn't have. "
+                             "Save it from your browser and /add the file")
+        text = html_to_text(text)
+        if not text.strip():
+            raise FetchError("has no readable text; it may be built by JavaScript in the browser")
+    fetched.content = text
+    return fetched
+
+SKIPPED_TAGS = {"script", "style", "noscript", "svg", "template", "iframe", "nav", "footer", "form", "button", "select"}
+BLOCK_TAGS = {"p", "div", "section", "article", "main", "header", "aside", "blockquote", "table", "tr", "ul", "ol", "dl",
+              "dt", "dd", "figure", "figcaption", "details", "summary", "hr", "br", "body"}
+HEADING_TAGS = {"h1": "#", "h2": "##", "h3": "###", "h4": "####", "h5": "#####", "h6": "######"}
+VOID_TAGS = {"br", "hr", "img", "input", "meta", "link", "area", "base", "col", "embed", "source", "track", "wbr"}
+
+def code_language(attrs: List[Tuple[str, Optional[str]]]) -> str:
+    """The language a "language-go" or "lang-go" class names, as a fence gives it; "" if none."""
+    for name, value in attrs:
+        match = re.search(r"\blang(?:uage)?-([\w+#-]+)", value or "") if name == "class" else None
+        if match:
+            return match.group(1)
+    return ""
+
+class _TextExtractor(HTMLParser):
+    def __init__(self) -> None:
+        super().__init__(convert_charrefs=True)
+        self.out: List[str] = []
+        self.skipping = 0  # Depth inside SKIPPED_TAGS
+        self.pre = 0
+        self.title = ""
+        self.in_title = False
+        self.started = False  # Some text is out, so block breaks count
+
+    def _newline(self, count: int = 1) -> None:
+        """End the current line, and with 'count' 2 leave a blank one; never at the start or twice."""
+        if not self.started:
+            return
+        tail = "".join(self.out[-3:]).rstrip(" ")
+        have = len(tail) - len(tail.rstrip("\n"))
+        if have < count:
+            self.out.append("\n" * (count - have))
+
+    def handle_starttag(self, tag, attrs) -> None:
+        if tag in SKIPPED_TAGS:
+            if tag not in VOID_TAGS:
+                self.skipping += 1
+            return
+        if self.skipping:
+            return
+        if tag == "title":
+            self.in_title = True
+        elif tag == "pre":
+            self._newline(2)
+            self.out.append(f"```{code_language(attrs)}\n")
+            self.started = True
+            self.pre += 1
+        elif tag == "code" and self.pre:
+            if self.out[-1] == "```\n":  # <pre><code class="language-go">, the usual way to mark it
+                self.out[-1] = f"```{code_language(attrs)}\n"
+        elif tag == "code":
+            self.out.append("`")
+        elif tag in HEADING_TAGS:
+            self._newline(2)
+            self.out.append(HEADING_TAGS[tag] + " ")
+        elif tag == "li":
+            self._newline()
+            self.out.append("- ")
+        elif tag in ("td", "th"):
+            self.out.append(" | ")
+        elif tag in BLOCK_TAGS:
+            self._newline(1 if tag in ("br", "tr", "dt", "dd") else 2)
+
+    def handle_endtag(self, tag) -> None:
+        if tag in SKIPPED_TAGS:
+            self.skipping = max(0, self.skipping - 1)
+            return
+        if self.skipping:
+            return
+        if tag == "title":
+            self.in_title = False
+        elif tag == "pre" and self.pre:
+            self.pre -= 1
+            if not "".join(self.out[-1:]).endswith("\n"):
+                self.out.append("\n")
+            self.out.append("```")
+            self._newline(2)
+        elif tag == "code" and not self.pre:
+            self.out.append("`")
+        elif tag in HEADING_TAGS or tag in BLOCK_TAGS:
+            self._newline(2 if tag in HEADING_TAGS or tag in ("p", "table", "ul", "ol", "pre", "blockquote") else 1)
+
+    def handle_data(self, data) -> None:
+        if self.in_title:
+            self.title += data
+            return
+        if self.skipping:
+            return
+        if self.pre:
+            self.out.append(data)
+            self.started = True
+            return
+        text = re.sub(r"\s+", " ", data)
+        if text.strip():
+            self.out.append(text)
+            self.started = True
+        elif text and self.out and not self.out[-1].endswith((" ", "\n")):
+            self.out.append(" ")
+
+def html_to_text(html: str) -> str:
+    """A page's readable text: headings, paragraphs, lists and code blocks, without markup or scripts."""
+    parser = _TextExtractor()
+    parser.feed(html)
+    parser.close()
+    text = "".join(parser.out)
+    lines = []
+    fenced = False
+    for line in text.split("\n"):
+        if line.startswith("```"):
+            fenced = not fenced
+        lines.append(line if fenced else line.strip())
+    text = re.sub(r"\n{3,}", "\n\n", "\n".join(lines)).strip()
+    title = re.sub(r"\s+", " ", parser.title).strip()
+    if title and not text.startswith(f"# {title}"):
+        text = f"# {title}\n\n{text}"
+    return text + "\n"
@@ -0,0 +1,949 @@
+"""File operations used by the /add command and the AI's file tools.
+
+Everything here is relative to a Workspace root and free of terminal output,
+so callers decide how to report results.
+"""
+
+import codecs
+import errno
+import fnmatch
+import hashlib
+import os
+import re
+import shutil
+import stat
+import tempfile
+from dataclasses import dataclass, field
+from datetime import datetime
+from pathlib import Path
+from typing import Callable, Dict, List, Optional, Set, TextIO, Tuple
+from pydantic import BaseModel
+
+from neo_core.cancel import CancelToken, checked
+from neo_core.formatting import FormatOutcome, Formatters
+from neo_core.syntax import SyntaxProblem, checker_for
+from neo_core.truncate import DEFAULT_TRUNCATE_LINES, Truncation, truncate_lines, truncate_outline
+
+MAX_FILE_SIZE = 5_000_000  # Default limit for files read into or written from the conversation; see max_file_size
+MAX_SCAN_FILES = 1000  # Default limit for files to process when adding a directory; see max_scan_files
+MAX_BACKUPS_PER_FILE = 5
+BACKUP_DIR = os.path.join(".neo", "backups")  # Relative to the workspace root
+EXTENDED_PREFIX = "\\\\?\\"  # Windows' \\?\ long-path prefix, which models copy from error messages
+
+# Paths the AI's tools may never modify, whatever the user approves. A pattern ending in "/" is a
+# directory prefix (relative to the workspace, or absolute when it starts with "~" or "/"), a
+# pattern containing "/" is a glob on the workspace-relative path, and anything else is a glob on
+# the file name.
+PROTECTED_PATHS = [
+    ".env", ".env.*", ".git/", ".neo/",
+    "go.sum", "*.pem", "*.key",
+    "id_rsa*", "id_dsa*", "id_ecdsa*", "id_ed25519*", "authorized_keys", "known_hosts",
+    "~/.ssh/", "~/.gnupg/", "~/.aws/",
+]
+
+EXCLUDED_FILES = {
+    # Python specific
+    ".DS_Store", "Thumbs.db", ".gitignore", ".python-version",
+    "uv.lock", ".uv", "uvenv", ".uvenv", ".venv", "venv",
+    "__pycache__", ".pytest_cache", ".coverage", ".mypy_cache",
+    # Node.js / Web specific
+    "node_modules", "package-lock.json", "yarn.lock", "pnpm-lock.yaml",
+    ".next", ".nuxt", "dist", "build", ".cache", ".parcel-cache",
+    ".turbo", ".vercel", ".output", ".contentlayer",
+    # Build outputs
+    "out", "coverage", ".nyc_output", "storybook-static",
+    # Environment and config
+    ".env", ".env.local", ".env.development", ".env.production",
+    # Misc
+    ".git", ".svn", ".hg", "CVS"
+}
+
+EXCLUDED_EXTENSIONS = {
+    # Binary and media files
+    ".png", ".jpg", ".jpeg", ".gif", ".ico", ".svg", ".webp", ".avif",
+    ".mp4", ".webm", ".mov", ".mp3", ".wav", ".ogg",
+    ".zip", ".tar", ".gz", ".7z", ".rar",
+    ".exe", ".dll", ".so", ".dylib", ".bin",
+    # Documents
+    ".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
+    # Python specific
+    ".pyc", ".pyo", ".pyd", ".egg", ".whl",
+    # UV specific
+    ".uv", ".uvenv",
+    # Database and logs
+    ".db", ".sqlite", ".sqlite3", ".log",
+    # IDE specific
+    ".idea", ".vscode",
+    # Web specific
+    ".map", ".chunk.js", ".chunk.css",
+    ".min.js", ".min.css", ".bundle.js", ".bundle.css",
+    # Cache and temp files
+    ".cache", ".tmp", ".temp",
+    # Font files
+    ".ttf", ".otf", ".woff", ".woff2", ".eot"
+}
+
+class FileToCreate(BaseModel):
+    path: str
+    content: str
+
+class FileToEdit(BaseModel):
+    path: str
+    original_snippet: str
+    new_snippet: str
+
+# --------------------------------------------------------------------------------
+# Errors
+# --------------------------------------------------------------------------------
+
+class FileOperationError(OSError):
+    """Base class for file operation failures the model can act on."""
+
+    def __init__(self, path: str, message: str):
+        super().__init__(message)
+        self.path = path
+
+class FileTooLargeError(FileOperationError):
+    def __init__(self, path: str, size: int, limit: int = MAX_FILE_SIZE):
+        super().__init__(path, f"{path} is {size} bytes, over the {limit} byte limit")
+        self.size = size
+        self.limit = limit
+
+class IsDirectoryError(FileOperationError):
+    def __init__(self, path: str):
+        super().__init__(path, f"{path} is a directory")
+
+class SnippetNotFoundError(FileOperationError):
+    """The edit snippet matched zero times, more than once (see count and lines), or fewer times than 'occurrence'."""
+
+    def __init__(self, path: str, count: int, lines: Optional[List[int]] = None, occurrence: Optional[int] = None):
+        if count == 0:
+            detail = "not found"
+        elif occurrence:
+            detail = f"has no occurrence {occurrence}, only {count}"
+        else:
+            detail = f"ambiguous: {count} matches"
+        at = f" (at lines {', '.join(map(str, lines))})" if lines and count > 1 else ""
+        super().__init__(path, f"Original snippet {detail} in {path}{at}")
+        self.count = count
+        self.lines = lines or []  # Where each match starts, from 1
+        self.occurrence = occurrence
+
+class FileChangedError(FileOperationError):
+    """The file is no longer the version an edit was based on."""
+
+    def __init__(self, path: str, expected: str, actual: str):
+        super().__init__(path, f"{path} has changed since version {expected} was read (it is now {actual})")
+        self.expected = expected
+        self.actual = actual
+        self.changes = ""  # A diff from the version read, when the caller still has it
+
+class InvalidSyntaxError(FileOperationError):
+    """The content of a structured file, like JSON, does not parse, so it was not written."""
+
+    def __init__(self, path: str, problem: SyntaxProblem):
+        super().__init__(path, f"{path} is not valid {problem.language}: {problem.describe()}")
+        self.problem = problem
+
+class ReadOnlyError(FileOperationError):
+    """The session was started with --read-only, so nothing may be written."""
+
+    def __init__(self, path: str):
+        super().__init__(path, f"{path} was not written: this session is read-only")
+
+class OutsideWorkspaceError(FileOperationError):
+    def __init__(self, path: str, workspace: str):
+        super().__init__(path, f"{path} is outside the workspace {workspace}")
+        self.workspace = workspace
+
+class SymlinkEscapeError(OutsideWorkspaceError):
+    """A path inside the workspace that is a symlink, or runs through one, to somewhere outside it."""
+
+    def __init__(self, path: str, target: str, workspace: str):
+        FileOperationError.__init__(self, path, f"{path} is a symlink to {target}, outside the workspace {workspace}")
+        self.target = target
+        self.workspace = workspace
+
+class SymlinkLoopError(FileOperationError):
+    """A path that runs through symlinks pointing back at each other, so it resolves to nothing."""
+
+    def __init__(self, path: str):
+        super().__init__(path, f"{path} is a symlink loop")
+
+class ProtectedPathError(FileOperationError):
+    def __init__(self, path: str, pattern: str):
+        super().__init__(path, f"{path} is protected (matches '{pattern}')")
+        self.pattern = pattern
+
+def describe_error(e: Exception) -> str:
+    """Turn a file operation failure into an actionable message for the model."""
+    if isinstance(e, ProtectedPathError):
+        return (f"Refused: '{e.path}' is a protected path (matches '{e.pattern}') and may not be modified "
+                "by tools. Do not retry; tell the user what change to make themselves.")
+    if isinstance(e, ReadOnlyError):
+        return (f"Refused: this session is read-only, so '{e.path}' can't be written. Do not retry; "
+                "describe the change so the user can make it themselves.")
+    if isinstance(e, SnippetNotFoundError):
+        if e.count == 0:
+            return (f"original_snippet was not found in '{e.path}'. Read the file again and copy the snippet "
+                    "exactly, including whitespace and indentation.")
+        matches = (f"original_snippet appears {e.count} times in '{e.path}'" +
+                   (f", starting at lines {', '.join(map(str, e.lines))}" if e.lines else ""))
+        if e.occurrence:
+            return f"{matches}, so there is no occurrence_index {e.occurrence}. Pass a number from 1 to {e.count}."
+        return (f"{matches}. Send the call again with occurrence_index set to the match to replace, counting "
+                "from 1 in that order, or include more surrounding context so it matches exactly once.")
+    if isinstance(e, FileChangedError):
+        changes = f" What changed since then:\n{e.changes}\n" if e.changes else " "
+        return (f"'{e.path}' has changed since you read version {e.expected} (it is now {e.actual}), so nothing was "
+                f"edited.{changes}Read the file again and base the edit on what it contains now.")
+    if isinstance(e, InvalidSyntaxError):
+        return (f"Refused: the new content of '{e.path}' is not valid {e.problem.language}: {e.problem.describe()}. "
+                "Nothing was written. Fix that and send the whole call again.")
+    if isinstance(e, FileTooLargeError):
+        return f"'{e.path}' is {e.size} bytes, which exceeds the {e.limit} byte limit; work with a smaller file."
+    if isinstance(e, IsDirectoryError):
+        return f"'{e.path}' is a directory, not a file; operate on individual files inside it."
+    if isinstance(e, SymlinkEscapeError):
+        return (f"'{e.path}' is a symlink to '{e.target}', outside the workspace '{e.workspace}'; "
+                "links that leave the workspace are not followed.")
+    if isinstance(e, OutsideWorkspaceError):
+        return f"'{e.path}' is outside the workspace '{e.workspace}'; only files inside it can be used."
+    if isinstance(e, SymlinkLoopError):
+        return f"'{e.path}' is a symlink loop: its links point back at each other, so there is no file to use."
+    if isinstance(e, FileNotFoundError):
+        return f"'{e.filename or e}' does not exist. Check the path, or use create_file to create it."
+    return str(e)
+
+# --------------------------------------------------------------------------------
+# Text encodings
+# --------------------------------------------------------------------------------
+
+@dataclass(frozen=True)
+class TextEncoding:
+    """How a file's text is stored, so it can be written back the same way."""
+    codec: str  # "utf-8", "utf-16-le" or "utf-16-be"
+    bom: bytes = b""
+
+    @property
+    def name(self) -> str:
+        return f"{self.codec} with BOM" if self.bom else self.codec
+
+    def decode(self, raw: bytes) -> str:
+        return raw[len(self.bom):].decode(self.codec)
+
+    def encode(self, text: str) -> bytes:
+        return self.bom + text.encode(self.codec)
+
+UTF8 = TextEncoding("utf-8")
+
+BOMS = [
+    (codecs.BOM_UTF8, "utf-8"),
+    (codecs.BOM_UTF16_LE, "utf-16-le"),
+    (codecs.BOM_UTF16_BE, "utf-16-be"),
+]
+
+def detect_encoding(raw: bytes) -> TextEncoding:
+    """Recognise a BOM, or BOM-less UTF-16 by where its null bytes fall; otherwise assume UTF-8."""
+    for bom, codec in BOMS:
+        if raw.startswith(bom):
+            return TextEncoding(codec, bom)
+    sample = raw[:4096]
+    if len(sample) >= 4:
+        # Mostly-ASCII UTF-16 has a null in every other byte, and UTF-8 text has none
+        even_nulls = sample[0::2].count(0)
+        odd_nulls = sample[1::2].count(0)
+        half = len(sample) // 2
+        if odd_nulls > half * 0.4 and even_nulls < half * 0.1:
+            return TextEncoding("utf-16-le")
+        if even_nulls > half * 0.4 and odd_nulls < half * 0.1:
+            return TextEncoding("utf-16-be")
+    return UTF8
+
+def decode_text(raw: bytes) -> Tuple[str, TextEncoding]:
+    encoding = detect_encoding(raw)
+    return encoding.decode(raw), encoding
+
+def read_text(path: str, limit: Optional[int] = None) -> Tuple[str, TextEncoding]:
+    """Decode a file, keeping its line endings exactly as stored.
+
+    With 'limit', at most that many bytes are read, and FileTooLargeError is raised if
+    there are more: the file may have grown since its size was checked.
+    """
+    with open(path, "rb") as f:
+        raw = f.read() if limit is None else f.read(limit + 1)
+    if limit is not None and len(raw) > limit:
+        raise FileTooLargeError(path, os.path.getsize(path), limit)
+    return decode_text(raw)
+
+def open_text(path: str) -> TextIO:
+    """A file as a text stream in its detected encoding, for going through one too large to load."""
+    with open(path, "rb") as f:
+        encoding = detect_encoding(f.read(4096))
+    stream = open(path, "r", encoding=encoding.codec, errors="replace")
+    if encoding.bom:
+        stream.read(1)  # The BOM, decoded to U+FEFF
+    return stream
+
+def universal_newlines(text: str) -> str:
+    return text.replace("\r\n", "\n").replace("\r", "\n")
+
+# --------------------------------------------------------------------------------
+# Backups
+# --------------------------------------------------------------------------------
+
+@dataclass
+class Backup:
+    path: str  # Where the copy is stored
+    created: datetime
+
+def is_within(path: str, root: str) -> bool:
+    """Whether 'path' is 'root' or below it, both absolute.
+
+    On Windows the comparison ignores case and the kind of separator, and a path on
+    another drive is outside rather than an error.
+    """
+    try:
+        return os.path.normcase(os.path.commonpath([path, root])) == os.path.normcase(os.path.normpath(root))
+    except ValueError:  # Different drives, or a relative path given with an absolute one
+        return False
+
+def fold_case(text: str) -> str:
+    """Text for comparing file names: Windows file systems ignore case."""
+    return text.lower() if os.name == "nt" else text
+
+def portable_path(path_str: str, root: str) -> str:
+    """A path from a tool argument in this platform's terms.
+
+    The \\\\?\\ prefix is dropped (\\\\?\\UNC\\server\\share becomes \\\\server\\share). Elsewhere than
+    Windows, backslashes are taken as separators unless a file is actually named that way.
+    """
+    if path_str.startswith(EXTENDED_PREFIX):
+        path_str = path_str[len(EXTENDED_PREFIX):]
+        if path_str[:4].upper() == "UNC\\":
+            path_str = "\\\\" + path_str[4:]
+    if os.sep == "/" and "\\" in path_str and not os.path.lexists(os.path.join(root, path_str)):
+        path_str = path_str.replace("\\", "/")
+    return path_str
+
+class BackupStore:
+    """Copies of files taken before they are overwritten, kept under <workspace>/.neo/backups.
+
+    A file's backups are named <relative-path>.<timestamp>; only the newest 'keep' are retained.
+    """
+
+    TIMESTAMP_FORMAT = "%Y%m%d-%H%M%S-%f"
+
+    def __init__(self, workspace_root: str, keep: int = MAX_BACKUPS_PER_FILE, directory: str = BACKUP_DIR):
+        self.workspace_root = workspace_root
+        self.root = os.path.join(workspace_root, directory)
+        self.keep = keep
+
+    def _prefix(self, normalized_path: str) -> str:
+        return os.path.join(self.root, os.path.relpath(normalized_path, self.workspace_root)) + "."
+
+    def list(self, normalized_path: str) -> List[Backup]:
+        """Backups of a file, newest first."""
+        prefix = self._prefix(normalized_path)
+        directory = os.path.dirname(prefix)
+        if not os.path.isdir(directory):
+            return []
+        backups = []
+        for name in os.listdir(directory):
+            candidate = os.path.join(directory, name)
+            if not fold_case(candidate).startswith(fold_case(prefix)):
+                continue
+            try:
+                created = datetime.strptime(candidate[len(prefix):], self.TIMESTAMP_FORMAT)
+            except ValueError:
+                continue  # Another file whose name shares the prefix
+            backups.append(Backup(candidate, created))
+        return sorted(backups, key=lambda b: b.created, reverse=True)
+
+    def save(self, normalized_path: str, max_size: int = MAX_FILE_SIZE) -> str:
+        """Copy an existing file into the store and prune old copies. Returns the backup path.
+
+        Raises FileTooLargeError or OutsideWorkspaceError when the file should not be backed up.
+        """
+        if not is_within(normalized_path, self.workspace_root):
+            raise OutsideWorkspaceError(normalized_path, self.workspace_root)
+        size = os.path.getsize(normalized_path)
+        if size > max_size:
+            raise FileTooLargeError(normalized_path, size, max_size)
+
+        backup_path = self._prefix(normalized_path) + datetime.now().strftime(self.TIMESTAMP_FORMAT)
+        Path(backup_path).parent.mkdir(parents=True, exist_ok=True)
+        shutil.copy2(normalized_path, backup_path)
+        for old in self.list(normalized_path)[self.keep:]:
+            os.remove(old.path)
+        return backup_path
+
+# --------------------------------------------------------------------------------
+# Workspace
+# --------------------------------------------------------------------------------
+
+@dataclass
+class WriteResult:
+    path: str  # Normalized path that was written
+    backup: Optional[str] = None  # Where the previous content was saved, if it existed
+    backup_skipped: Optional[str] = None  # Why an existing file could not be backed up
+    size: int = 0  # Bytes written, or that would have been
+    simulated: bool = False  # Nothing was written: see Workspace.create_file(simulate=True)
+    formatting: Optional[FormatOutcome] = None  # What the formatter did, when one ran
+
+@dataclass
+class ScanOptions:
+    """Limits for one scan_directory call, set with /add flags."""
+    max_depth: Optional[int] = None  # Directory levels to read, counting the one being added as 1
+    max_files: int = MAX_SCAN_FILES
+    max_file_size: int = MAX_FILE_SIZE
+    truncate: str = "skip"  # What to do with files over max_file_size, see neo_core.truncate
+    truncate_lines: int = DEFAULT_TRUNCATE_LINES
+
+@dataclass
+class SkippedFile:
+    path: str
+    reason: str  # What /add groups it by: "hidden", "excluded name", "binary", "too large", ...
+    detail: str = ""  # The specifics, when there are any beyond the reason
+
+    def describe(self) -> str:
+        return f"{self.path} ({self.detail or self.reason})"
+
+@dataclass
+class ScanResult:
+    added: List[Tuple[str, int]] = field(default_factory=list)  # (normalized path, size in bytes); contents go to on_file
+    skipped: List[SkippedFile] = field(default_factory=list)
+    limit_reached: bool = False  # max_files stopped the scan with files left unread
+    dirs_beyond_depth: int = 0  # Directories not entered because of max_depth
+    files_too_large: int = 0  # Files skipped for exceeding max_file_size
+    truncated: List[str] = field(default_factory=list)  # Files over max_file_size added cut short, with how
+
+    def skipped_by_reason(self) -> List[Tuple[str, List[SkippedFile]]]:
+        """The skipped files grouped by reason, the most common reason first; files keep their scan order."""
+        groups: Dict[str, List[SkippedFile]] = {}
+        for skipped in self.skipped:
+            groups.setdefault(skipped.reason, []).append(skipped)
+        return sorted(groups.items(), key=lambda group: -len(group[1]))
+
+class Workspace:
+    """File access rooted at a directory; relative paths resolve against the root."""
+
+    def __init__(self, root: str, max_backups: int = MAX_BACKUPS_PER_FILE,
+                 protected_paths: Optional[List[str]] = None, follow_symlinks: bool = False,
+                 max_file_size: int = MAX_FILE_SIZE):
+        self.root = str(Path(root).resolve())
+        self.follow_symlinks = follow_symlinks  # Whether scan_directory follows links that stay inside the root
+        self.max_file_size = max_file_size  # Bytes read or written whole; /set max_file_size changes it
+        self.backups = BackupStore(self.root, max_backups)
+        self.protected_paths = PROTECTED_PATHS + [p for p in protected_paths or [] if p not in PROTECTED_PATHS]
+        self.encodings: Dict[str, TextEncoding] = {}  # Normalized path -> encoding seen when it was last read
+        self.write_listeners: List[Callable[[str], None]] = []  # Called with the normalized path before each write
+        self.written_listeners: List[Callable[[str], None]] = []  # And after it
+        self.formatters: Optional[Formatters] = None  # Applied to writes made with format=True
+        self.check_syntax = False  # Refuse writes made with check=True of structured files that don't parse
+        self.read_only = False  # --read-only: every write raises ReadOnlyError
+
+    def normalize_path(self, path_str: str) -> str:
+        """Return a canonical, absolute version of the path, which must be inside the workspace.
+
+        Symlinks are resolved, and a path that resolves outside the workspace raises
+        OutsideWorkspaceError: SymlinkEscapeError when it names something inside the
+        workspace and a link takes it out. A link loop raises SymlinkLoopError. Either
+        separator is accepted; see portable_path.
+        """
+        unresolved = os.path.normpath(os.path.join(self.root, portable_path(path_str, self.root)))
+        try:
+            path = str(Path(unresolved).resolve())
+        except RuntimeError as e:  # How Path.resolve reports a loop before Python 3.13
+            raise SymlinkLoopError(path_str) from e
+        if os.path.islink(path):  # Since 3.13 it stops at the looping link instead
+            raise SymlinkLoopError(path_str)
+
+        if not self.contains(path):
+            if self.contains(unresolved):
+                raise SymlinkEscapeError(path_str, path, self.root)
+            raise OutsideWorkspaceError(path_str, self.root)
+        return path
+
+    def contains(self, normalized_path: str) -> bool:
+        return is_within(normalized_path, self.root)
+
+    def protected_pattern(self, normalized_path: str) -> Optional[str]:
+        """The first protected pattern matching the path, or None if tools may modify it."""
+        relative = Path(os.path.relpath(normalized_path, self.root)).as_posix() if self.contains(normalized_path) else None
+        relative = None if relative is None else fold_case(relative)
+        name = fold_case(os.path.basename(normalized_path))
+        for pattern in self.protected_paths:
+            folded = fold_case(pattern)
+            if pattern.endswith("/"):
+                if pattern.startswith("~") or os.path.isabs(pattern):
+                    if is_within(normalized_path, os.path.expanduser(pattern.rstrip("/"))):
+                        return pattern
+                elif relative is not None and (relative + "/").startswith(folded):
+                    return pattern
+            elif "/" in pattern:
+                if relative is not None and fnmatch.fnmatchcase(relative, folded):
+                    return pattern
+            elif fnmatch.fnmatchcase(name, folded):
+                return pattern
+        return None
+
+    def check_writable(self, path: str) -> str:
+        """Normalize 'path', raising ProtectedPathError if tools must not modify it, ReadOnlyError if nothing may."""
+        normalized_path = self.normalize_path(path)
+        if self.read_only:
+            raise ReadOnlyError(path)
+        pattern = self.protected_pattern(normalized_path)
+        if pattern:
+            raise ProtectedPathError(path, pattern)
+        return normalized_path
+
+    def read_file(self, file_path: str) -> str:
+        """Return the text content of a file."""
+        normalized_path = self.normalize_path(file_path)
+        if os.path.isdir(normalized_path):
+            raise IsDirectoryError(file_path)
+        size = os.path.getsize(normalized_path)
+        if size > self.max_file_size:
+            raise FileTooLargeError(file_path, size, self.max_file_size)
+        content, encoding = read_text(normalized_path, self.max_file_size)
+        self.encodings[normalized_path] = encoding
+        return universal_newlines(content)
+
+    def read_truncated(self, file_path: str, strategy: str, max_lines: int = DEFAULT_TRUNCATE_LINES,
+                       cancel: Optional[CancelToken] = None) -> Truncation:
+        """A file over the size limit, cut by 'strategy' (see neo_core.truncate).
+
+        Outlines need the whole source, so a file over max_file_size is cut head-tail instead.
+        """
+        normalized_path = self.normalize_path(file_path)
+        if os.path.isdir(normalized_path):
+            raise IsDirectoryError(file_path)
+        if strategy == "outline" and os.path.getsize(normalized_path) <= self.max_file_size:
+            content, _ = read_text(normalized_path, self.max_file_size)
+            return truncate_outline(normalized_path, universal_newlines(content), max_lines)
+        with open_text(normalized_path) as lines:
+            return truncate_lines(checked(lines, cancel), "head" if strategy == "head" else "head-tail", max_lines)
+
+    def read_line_range(self, file_path: str, start: int, end: Optional[int] = None,
+                        cancel: Optional[CancelToken] = None) -> Tuple[str, int, int]:
+        """Lines 'start' to 'end' (from 1, inclusive), the last line returned and the file's line count.
+
+        The file is streamed, so this works on files too large for read_file; at most
+        max_file_size characters are returned. Cancelling 'cancel' stops the read with Cancelled.
+        """
+        normalized_path = self.normalize_path(file_path)
+        if os.path.isdir(normalized_path):
+            raise IsDirectoryError(file_path)
+        kept: List[str] = []
+        chars = last = total = 0
+        with open_text(normalized_path) as lines:
+            for total, line in enumerate(checked(lines, cancel), 1):
+                if total >= start and (end is None or total <= end) and chars + len(line) <= self.max_file_size:
+                    kept.append(line)
+                    chars += len(line)
+                    last = total
+        return "".join(kept), last, total
+
+    def encoding_for(self, normalized_path: str) -> TextEncoding:
+        """The encoding to write a file in: as it was read, as it is on disk, or UTF-8 for new files."""
+        if normalized_path in self.encodings:
+            return self.encodings[normalized_path]
+        if os.path.isfile(normalized_path):
+            with open(normalized_path, "rb") as f:
+                return detect_encoding(f.read(4096))
+        return UTF8
+
+    def create_file(self, path: str, content: str, simulate: bool = False, format: bool = False,
+                    check: bool = False) -> WriteResult:
+        """Create (or overwrite) a file at 'path' with the given 'content'.
+
+        An existing file is backed up first; a backup that cannot be made is
+        reported in the result and never blocks the write. With 'simulate', every
+        check runs but nothing is backed up or written. With 'format', the content
+        goes through the formatter for its extension first, if there is one. With
+        'check', and check_syntax on, content that does not parse as the language of
+        its extension raises InvalidSyntaxError (see syntax_problem).
+        """
+        # Security checks
+        if any(part.startswith('~') for part in Path(path).parts):
+            raise OutsideWorkspaceError(path, self.root)
+        normalized_path = self.check_writable(path)
+        if not self.contains(normalized_path):
+            raise OutsideWorkspaceError(path, self.root)
+        if os.path.isdir(normalized_path):
+            raise IsDirectoryError(path)
+
+        # Validate reasonable file size for operations
+        if len(content) > self.max_file_size:
+            raise FileTooLargeError(path, len(content), self.max_file_size)
+        if check and self.check_syntax:
+            problem = self.syntax_problem(normalized_path, content)
+            if problem:
+                raise InvalidSyntaxError(path, problem)
+
+        result = WriteResult(normalized_path, size=len(content.encode("utf-8")), simulated=simulate)
+        if simulate:
+            return result
+        if format and self.formatters:
+            result.formatting = self.formatters.format(normalized_path, content)
+            if result.formatting:
+                content = result.formatting.content
+                result.size = len(content.encode("utf-8"))
+        for listener in self.write_listeners:
+            listener(normalized_path)
+        if os.path.isfile(normalized_path):
+            try:
+                result.backup = self.backups.save(normalized_path, self.max_file_size)
+            except OSError as e:
+                result.backup_skipped = str(e)
+
+        Path(normalized_path).parent.mkdir(parents=True, exist_ok=True)
+        encoding = self.encoding_for(normalized_path)
+        atomic_write(normalized_path, content, encoding)
+        self.encodings[normalized_path] = encoding
+        for listener in self.written_listeners:
+            listener(normalized_path)
+        return result
+
+    def delete_file(self, path: str, simulate: bool = False) -> WriteResult:
+        """Delete the file at 'path', backing it up first so /restore can bring it back.
+
+        Unlike a write, a file that can't be backed up is not deleted: the backup skipped
+        raises the error explaining why. With 'simulate', every check runs and nothing is deleted.
+        """
+        normalized_path = self.check_writable(path)
+        if os.path.isdir(normalized_path):
+            raise IsDirectoryError(path)
+        if not os.path.isfile(normalized_path):
+            raise FileNotFoundError(errno.ENOENT, "No such file", path)
+        result = WriteResult(normalized_path, size=0, simulated=simulate)
+        if simulate:
+            return result
+        for listener in self.write_listeners:
+            listener(normalized_path)
+        result.backup = self.backups.save(normalized_path, self.max_file_size)
+        os.remove(normalized_path)
+        self.encodings.pop(normalized_path, None)
+        for listener in self.written_listeners:
+            listener(normalized_path)
+        return result
+
+    def syntax_problem(self, normalized_path: str, content: str) -> Optional[SyntaxProblem]:
+        """Why 'content' does not parse, for files with a checker; None if it does.
+
+        A file that exists and did not parse before, such as JSON with comments,
+        is not checked, so it can still be edited.
+        """
+        checker = checker_for(normalized_path)
+        if checker is None:
+            return None
+        problem = checker(content)
+        if problem and os.path.isfile(normalized_path):
+            try:
+                existing, _ = read_text(normalized_path, self.max_file_size)
+            except (OSError, UnicodeError):
+                return problem
+            if checker(existing):
+                return None
+        return problem
+
+    def restore_backup(self, path: str, backup: Backup) -> WriteResult:
+        """Put a backup's content back in place; the current content is backed up first."""
+        content, encoding = read_text(backup.path)
+        self.encodings[self.normalize_path(path)] = encoding
+        return self.create_file(path, content)
+
+    def check_version(self, path: str, expected: str) -> str:
+        """The file's text, if content_version of it is 'expected'; FileChangedError otherwise."""
+        content = self.read_file(path)
+        actual = content_version(content)
+        if actual != expected.strip().lower():
+            raise FileChangedError(path, expected, actual)
+        return content
+
+    def snippet_lines(self, path: str, original_snippet: str) -> List[int]:
+        """The line, from 1, where each match of 'original_snippet' starts, in the order apply_diff_edit numbers them."""
+        content = self.read_file(path)
+        return [content.count("\n", 0, offset) + 1 for offset in snippet_offsets(content, convert_line_endings(original_snippet, "\n"))]
+
+    def apply_diff_edit(self, path: str, original_snippet: str, new_snippet: str,
+                        simulate: bool = False, format: bool = False,
+                        occurrence: Optional[int] = None, replace_all: bool = False,
+                        expected_version: Optional[str] = None, check: bool = False) -> WriteResult:
+        """Replace the single occurrence of 'original_snippet' with 'new_snippet'.
+
+        With 'occurrence' the snippet may match several times and that match, counting
+        from 1, is replaced; with 'replace_all' every match is. Raises SnippetNotFoundError
+        when the snippet doesn't match, matches more than once with neither, or matches
+        fewer times than 'occurrence'. With 'expected_version', FileChangedError is raised
+        instead of editing a file that is no longer that version. 'check' is as for create_file.
+        """
+        self.check_writable(path)
+        if expected_version:
+            self.check_version(path, expected_version)
+        else:
+            self.read_file(path)  # Directory and size checks
+        content, _ = read_text(self.normalize_path(path))
+
+        # Snippets arrive with \n endings; match and write them in the file's own style
+        line_ending = detect_line_ending(content)
+        original_snippet = convert_line_endings(original_snippet, line_ending)
+        new_snippet = convert_line_endings(new_snippet, line_ending)
+
+        # Verify we're replacing the exact intended occurrence
+        offsets = snippet_offsets(content, original_snippet)
+        if not offsets or (len(offsets) > 1 and not occurrence and not replace_all) or (occurrence or 0) > len(offsets):
+            lines = [universal_newlines(content[:offset]).count("\n") + 1 for offset in offsets]
+            raise SnippetNotFoundError(path, len(offsets), lines, occurrence)
+
+        if replace_all:
+            updated_content = content.replace(original_snippet, new_snippet)
+        else:
+            start = offsets[(occurrence or 1) - 1]
+            updated_content = content[:start] + new_snippet + content[start + len(original_snippet):]
+        return self.create_file(path, updated_content, simulate, format, check)
+
+    def symlink_skip_reason(self, path: str) -> Optional[str]:
+        """Why scan_directory should not follow 'path', or None if it is not a link or may be followed."""
+        if not os.path.islink(path):
+            return None
+        target = os.path.realpath(path)
+        if not self.follow_symlinks:
+            return f"symlink -> {target}"
+        if not os.path.exists(target):
+            return f"broken symlink -> {target}"
+        if not self.contains(target):
+            return f"symlink -> {target}, outside the workspace"
+        return None
+
+    def scan_directory(self, directory_path: str, on_directory: Optional[Callable[[str], None]] = None,
+                       options: Optional[ScanOptions] = None,
+                       on_file: Optional[Callable[[str, str, Optional[Truncation]], None]] = None) -> ScanResult:
+        """Read every eligible text file below 'directory_path'.
+
+        Each file's content is handed to on_file(normalized_path, content, truncation) as
+        soon as it is read and not kept, so a large directory is never in memory twice
+        over. 'truncation' is None unless the file was over max_file_size and
+        options.truncate cut it instead of skipping it.
+        Symlinks are reported as skipped unless follow_symlinks is set, in which
+        case those resolving inside the workspace are followed. Directories are
+        tracked by device and inode so a link loop is scanned only once.
+        """
+        options = options or ScanOptions()
+        result = ScanResult()
+        visited_dirs: Set[Tuple[int, int]] = set()
+        added_paths: Set[str] = set()
+
+        top = self.normalize_path(directory_path)
+        for root, dirs, files in os.walk(top, followlinks=self.follow_symlinks):
+            if len(result.added) >= options.max_files:
+                result.limit_reached = True
+                break
+
+            info = os.stat(root)
+            if (info.st_dev, info.st_ino) in visited_dirs:
+                result.skipped.append(SkippedFile(root, "symlink", "symlink loop: already scanned"))
+                dirs[:] = []
+                continue
+            visited_dirs.add((info.st_dev, info.st_ino))
+
+            if on_directory:
+                on_directory(root)
+            # Skip hidden directories and excluded directories
+            for d in list(dirs):
+                reason = "hidden" if d.startswith('.') else "excluded name" if d in EXCLUDED_FILES else None
+                if reason:
+                    result.skipped.append(SkippedFile(os.path.join(root, d) + os.sep, reason))
+                    dirs.remove(d)
+                    continue
+                reason = self.symlink_skip_reason(os.path.join(root, d))
+                if reason:
+                    result.skipped.append(SkippedFile(os.path.join(root, d), "symlink", reason))
+                    dirs.remove(d)
+            depth = 1 if root == top else len(Path(os.path.relpath(root, top)).parts) + 1
+            if options.max_depth is not None and depth >= options.max_depth:
+                result.dirs_beyond_depth += len(dirs)
+                dirs[:] = []
+
+            for file in files:
+                if len(result.added) >= options.max_files:
+                    result.limit_reached = True
+                    break
+
+                full_path = os.path.join(root, file)
+                if file.startswith('.'):
+                    result.skipped.append(SkippedFile(full_path, "hidden"))
+                    continue
+                if file in EXCLUDED_FILES:
+                    result.skipped.append(SkippedFile(full_path, "excluded name"))
+                    continue
+
+                _, ext = os.path.splitext(file)
+                if ext.lower() in EXCLUDED_EXTENSIONS:
+                    result.skipped.append(SkippedFile(full_path, "excluded extension", f"excluded extension {ext.lower()}"))
+                    continue
+
+                reason = self.symlink_skip_reason(full_path)
+                if reason:
+                    result.skipped.append(SkippedFile(full_path, "symlink", reason))
+                    continue
+
+                try:
+                    # Check file size before processing
+                    size = os.path.getsize(full_path)
+                    too_large = size > options.max_file_size
+                    if too_large and options.truncate == "skip":
+                        result.skipped.append(SkippedFile(full_path, "too large", f"over {format_size(options.max_file_size)}"))
+                        result.files_too_large += 1
+                        continue
+
+                    reason = binary_reason(full_path)
+                    if reason:
+                        result.skipped.append(SkippedFile(full_path, "binary", f"binary: {reason}"))
+                        continue
+
+                    normalized_path = self.normalize_path(full_path)
+                    if normalized_path in added_paths:
+                        result.skipped.append(SkippedFile(full_path, "duplicate", f"same file as {normalized_path}, already added"))
+                        continue
+                    truncation = self.read_truncated(normalized_path, options.truncate, options.truncate_lines) if too_large else None
+                    content = truncation.content if truncation else self.read_file(normalized_path)
+                    if truncation:
+                        result.truncated.append(f"{full_path} (over {format_size(options.max_file_size)}, {truncation.label})")
+                    if on_file:
+                        on_file(normalized_path, content, truncation)
+                    result.added.append((normalized_path, size))
+                    added_paths.add(normalized_path)
+
+                except UnicodeDecodeError as e:
+                    result.skipped.append(SkippedFile(full_path, "unreadable", f"not valid {e.encoding}"))
+                except FileTooLargeError as e:  # Grew past the limit while being scanned
+                    result.skipped.append(SkippedFile(full_path, "too large", f"over {format_size(e.limit)}"))
+                    result.files_too_large += 1
+                except OSError as e:
+                    result.skipped.append(SkippedFile(full_path, "unreadable", f"unreadable: {e.strerror or e}"))
+
+        return result
+
+def format_size(size: int) -> str:
+    """A byte count for people: 532B, 1.2KB, 3.4MB."""
+    if size < 1024:
+        return f"{size}B"
+    if size < 1024 * 1024:
+        return f"{size / 1024:.1f}KB"
+    return f"{size / (1024 * 1024):.1f}MB"
+
+SIZE_SUFFIXES = {"": 1, "b": 1, "k": 1024, "kb": 1024, "m": 1024 * 1024, "mb": 1024 * 1024}
+
+def parse_size(text: str) -> int:
+    """The byte count in '256k', '1.5MB' or '4096'; raises ValueError for anything else."""
+    match = re.fullmatch(r"\s*(\d+(?:\.\d+)?)\s*([a-zA-Z]*)\s*", text)
+    if not match or match.group(2).lower() not in SIZE_SUFFIXES:
+        raise ValueError(f"not a size: {text!r} (use e.g. 4096, 256k or 2MB)")
+    return int(float(match.group(1)) * SIZE_SUFFIXES[match.group(2).lower()])
+
+VERSION_LENGTH = 12  # Hex digits of the hash that content_version keeps
+
+def content_version(content: str) -> str:
+    """A short hash of a file's text as read_file returns it, which edit_file can require to be unchanged."""
+    return hashlib.sha256(content.encode("utf-8")).hexdigest()[:VERSION_LENGTH]
+
+def snippet_offsets(content: str, snippet: str) -> List[int]:
+    """Where each match of 'snippet' starts, not overlapping, as str.count and str.replace find them."""
+    offsets: List[int] = []
+    start = content.find(snippet) if snippet else -1
+    while start != -1:
+        offsets.append(start)
+        start = content.find(snippet, start + len(snippet))
+    return offsets
+
+def detect_line_ending(text: str) -> str:
+    """The dominant line ending in 'text': "\r\n" or "\n" (the default when there are none)."""
+    crlf = text.count("\r\n")
+    return "\r\n" if crlf > text.count("\n") - crlf else "\n"
+
+def convert_line_endings(text: str, line_ending: str) -> str:
+    normalized = text.replace("\r\n", "\n")
+    return normalized if line_ending == "\n" else normalized.replace("\n", line_ending)
+
+def atomic_write(path: str, content: str, encoding: TextEncoding = UTF8) -> None:
+    """Write 'content' to a temporary file beside 'path', then rename it into place.
+
+    An interrupted write leaves the original untouched. An existing file's
+    permission bits are carried over; new files get the default mode.
+    """
+    try:
+        mode = stat.S_IMODE(os.stat(path).st_mode)
+    except FileNotFoundError:
+        umask = os.umask(0)
+        os.umask(umask)
+        mode = 0o666 & ~umask
+
+    directory, name = os.path.split(path)
+    fd, tmp_path = tempfile.mkstemp(dir=directory, prefix=f".{name}.", suffix=".tmp")
+    try:
+        with os.fdopen(fd, "wb") as f:
+            f.write(encoding.encode(content))
+            f.flush()
+            os.fsync(f.fileno())
+        os.chmod(tmp_path, mode)
+        os.replace(tmp_path, path)
+    except BaseException:
+        try:
+            os.remove(tmp_path)
+        except FileNotFoundError:
+            pass
+        raise
+
+BINARY_SAMPLE_SIZE = 8192
+MIN_PRINTABLE_RATIO = 0.95
+
+# Leading bytes of common binary formats, some of which begin with a readable text header
+BINARY_SIGNATURES = [
+    (b"SQLite format 3\0", "SQLite database"),
+    (b"\x89PNG\r\n\x1a\n", "PNG image"),
+    (b"GIF87a", "GIF image"),
+    (b"GIF89a", "GIF image"),
+    (b"\xff\xd8\xff", "JPEG image"),
+    (b"%PDF-", "PDF document"),
+    (b"PK\x03\x04", "zip archive"),
+    (b"\x1f\x8b", "gzip archive"),
+    (b"\xfd7zXZ\0", "xz archive"),
+    (b"7z\xbc\xaf\x27\x1c", "7z archive"),
+    (b"\x7fELF", "ELF executable"),
+    (b"\xca\xfe\xba\xbe", "Java class or Mach-O binary"),
+    (b"\xcf\xfa\xed\xfe", "Mach-O binary"),
+    (b"\0asm", "WebAssembly module"),
+    (b"OggS", "Ogg media file"),
+]
+
+def binary_reason(file_path: str, sample_size: int = BINARY_SAMPLE_SIZE) -> Optional[str]:
+    """Why a file looks binary, or None if it reads as text. Only the first 'sample_size' bytes are read."""
+    try:
+        with open(file_path, 'rb') as f:
+            sample = f.read(sample_size)
+    except OSError as e:
+        return f"unreadable: {e.strerror or e}"
+    if not sample:
+        return None
+
+    for signature, kind in BINARY_SIGNATURES:
+        if sample.startswith(signature):
+            return kind
+
+    encoding = detect_encoding(sample)
+    if encoding.codec == "utf-8" and b"\0" in sample:
+        return "contains null bytes"
+    # The sample may end partway through a character; the incremental decoder holds back an incomplete tail
+    text = codecs.getincrementaldecoder(encoding.codec)(errors="replace").decode(sample[len(encoding.bom):])
+    if not text:
+        return None
+    printable = sum(1 for ch in text if (ch.isprintable() and ch != "\ufffd") or ch in "\t\n\r\f\v\x1b")
+    ratio = printable / len(text)
+    if ratio < MIN_PRINTABLE_RATIO:
+        return f"only {ratio:.0%} printable as {encoding.codec}"
+    return None
+
+def is_binary_file(file_path: str, sample_size: int = BINARY_SAMPLE_SIZE) -> bool:
+    return binary_reason(file_path, sample_size) is not None
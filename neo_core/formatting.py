@@ -0,0 +1,58 @@
+"""Formatting of files the tools write, by extension, with external formatters.
+
+A formatter is a command that reads the file on stdin and writes it formatted to
+stdout, such as gofmt; "{path}" in its arguments is replaced with the file's path,
+for formatters like prettier that pick their rules from it. Go files use goimports
+when it is installed, which also fixes imports, and gofmt otherwise. A formatter
+that exits with an error usually means the content does not parse, so the content
+is written as it was and the failure is reported.
+"""
+
+import os
+import shutil
+import subprocess
+from dataclasses import dataclass
+from typing import Dict, List, Optional
+
+FORMAT_TIMEOUT = 10  # Seconds
+
+@dataclass
+class FormatOutcome:
+    content: str  # What to write: formatted, or unchanged if formatting failed
+    note: str  # For the tool result, e.g. "formatted with gofmt"
+    failed: bool = False  # The formatter rejected the content, which likely means a syntax error
+    changed: bool = False
+
+def default_formatters() -> Dict[str, List[str]]:
+    return {".go": ["goimports"] if shutil.which("goimports") else ["gofmt"]}
+
+class Formatters:
+    def __init__(self, overrides: Optional[Dict[str, List[str]]] = None, env: Optional[Dict[str, str]] = None):
+        self.commands = {**default_formatters(), **(overrides or {})}  # An empty command disables an extension
+        self.env = env  # For the formatter processes; None passes Neo's own
+
+    def command_for(self, path: str) -> Optional[List[str]]:
+        return self.commands.get(os.path.splitext(path)[1].lower()) or None
+
+    def format(self, path: str, content: str) -> Optional[FormatOutcome]:
+        """Format content for 'path', or None when no formatter is configured for its extension."""
+        command = self.command_for(path)
+        if command is None:
+            return None
+        name = os.path.basename(command[0])
+        argv = [arg.replace("{path}", path) for arg in command]
+        try:
+            completed = subprocess.run(argv, input=content, capture_output=True, text=True, encoding="utf-8",
+                                       timeout=FORMAT_TIMEOUT, cwd=os.path.dirname(path) or None, env=self.env)
+        except FileNotFoundError:
+            return FormatOutcome(content, f"not formatted: {name} is not installed")
+        except subprocess.TimeoutExpired:
+            return FormatOutcome(content, f"not formatted: {name} took longer than {FORMAT_TIMEOUT}s")
+        if completed.returncode != 0:
+            error = completed.stderr.strip().replace("<standard input>", os.path.basename(path)) or f"exit status {completed.returncode}"
+            return FormatOutcome(content, f"{name} could not parse it: {error}", failed=True)
+        if not completed.stdout.strip() and content.strip():
+            return FormatOutcome(content, f"not formatted: {name} printed nothing")
+        if completed.stdout == content:
+            return FormatOutcome(content, f"already {name}-formatted")
+        return FormatOutcome(completed.stdout, f"formatted with {name}", changed=True)
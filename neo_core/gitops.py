@@ -0,0 +1,78 @@
+"""The git operations behind /commit and /review, run with the git command-line tool.
+
+Paths are relative to the top of the repository, which may be above the workspace.
+"""
+
+import os
+import subprocess
+from typing import Iterable, List, Optional
+
+from neo_core.fileops import is_within
+from neo_core.redact import child_env
+
+class GitError(Exception):
+    """git is missing, the workspace is not a repository, or a git command failed."""
+
+class Repository:
+    def __init__(self, workdir: str):
+        self.top = self._run(workdir, "rev-parse", "--show-toplevel").strip()
+
+    @staticmethod
+    def _run(cwd: str, *args: str, stdin: Optional[str] = None) -> str:
+        try:
+            completed = subprocess.run(["git", *args], cwd=cwd, input=stdin, capture_output=True, env=child_env(["GIT_*"]),
+                                       text=True, encoding="utf-8", errors="replace", timeout=120)
+        except FileNotFoundError:
+            raise GitError("git is not installed") from None
+        except subprocess.TimeoutExpired:
+            raise GitError(f"git {args[0]} timed out") from None
+        if completed.returncode != 0:
+            output = (completed.stderr + completed.stdout).strip()
+            if "not a git repository" in output:
+                raise GitError(f"{cwd} is not inside a git repository")
+            raise GitError(output or f"git {args[0]} exited with status {completed.returncode}")
+        return completed.stdout
+
+    def run(self, *args: str, stdin: Optional[str] = None) -> str:
+        return self._run(self.top, *args, stdin=stdin)
+
+    def branch(self) -> Optional[str]:
+        """The checked-out branch, or None when HEAD is detached."""
+        try:
+            return self.run("symbolic-ref", "--short", "-q", "HEAD").strip() or None
+        except GitError:
+            return None
+
+    def staged_diff(self) -> str:
+        return self.run("diff", "--staged", "--no-color", "--no-ext-diff")
+
+    def diff(self, ref: Optional[str] = None) -> str:
+        """The working tree against 'ref', or against HEAD: the uncommitted changes, staged or not."""
+        return self.run("diff", "--no-color", "--no-ext-diff", ref or "HEAD", "--")
+
+    def staged_files(self) -> List[str]:
+        return self.run("diff", "--staged", "--name-only", "-z").split("\0")[:-1]
+
+    def unstaged(self, paths: Iterable[str]) -> List[str]:
+        """Which of the (absolute) paths have changes or are untracked but not yet staged."""
+        paths = [path for path in paths if is_within(path, self.top)]
+        if not paths:
+            return []
+        entries = self.run("status", "--porcelain=v1", "-z", "--untracked-files=all", "--", *paths).split("\0")
+        unstaged = []
+        i = 0
+        while i < len(entries) - 1:
+            entry = entries[i]
+            if entry[0] in "RC":  # A rename or copy is followed by its source path
+                i += 1
+            if entry[1] != " ":
+                unstaged.append(entry[3:])
+            i += 1
+        return unstaged
+
+    def stage(self, paths: List[str]) -> None:
+        self.run("add", "--", *paths)
+
+    def commit(self, message: str) -> str:
+        """Commit the staged changes and return git's summary; raises GitError with the hooks' output."""
+        return self.run("commit", "--file=-", stdin=message).strip()
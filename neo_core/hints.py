@@ -0,0 +1,121 @@
+"""Tips suggesting the command that follows naturally from a response.
+
+After each message, send_message gathers TurnFacts (the reply's code blocks, the
+files the turn wrote, the latest validation run, how full the context is) and
+prints the tip of every rule in HINT_RULES that has one. A rule is a name, a
+function from the facts to its tip or None, and whether it is shown once per
+session or every time it applies; adding a tip is adding a rule. Set
+"show_hints": false to turn them all off.
+"""
+
+import os
+import re
+from dataclasses import dataclass, field
+from typing import Callable, List, Optional, Set, Tuple
+
+from neo_core.ui import CodeBlock
+from neo_core.validate import ValidationResult
+
+CONTEXT_HINT_FRACTION = 0.7  # Of max_context_tokens
+
+# A path as a fence names it: "```python main.py", "```main.py", "```go title=cmd/main.go"
+PATH_TOKEN_RE = re.compile(r"^(?:(?:title|file|filename|path)=)?[\"']?(?P<path>[\w./~-]*\w\.[A-Za-z0-9]{1,10})[\"']?$")
+# A block whose first line is a comment naming its file: "# main.py", "// File: src/app.ts", "<!-- index.html -->"
+FIRST_LINE_PATH_RE = re.compile(r"^\s*(?:#|//|--|;|/\*|<!--)\s*(?:file(?:name)?:\s*)?(?P<path>[\w./-]*\w\.[A-Za-z0-9]{1,10})\s*(?:\*/|-->)?\s*$",
+                                re.IGNORECASE)
+# Prose just before a block that names the file it changes: "replace run() in `main.go`", "update neo_core/ai.py:"
+LEAD_PATH_RE = re.compile(r"(?:\b(?:in|to|into|of|for|file|update|replace|change|edit|create)\s+`?|`)(?P<path>[\w./-]*\w\.[A-Za-z0-9]{1,10})`?"
+                          r"(?=[\s:,.;)]|$)", re.IGNORECASE)
+
+@dataclass
+class TurnFacts:
+    """What the last turn left behind, for the rules to look at."""
+    root: str  # The workspace root, for relative paths
+    code_blocks: List[CodeBlock] = field(default_factory=list)
+    written: Set[str] = field(default_factory=set)  # Files the turn wrote, normalized
+    validation: Optional[ValidationResult] = None  # Run after the turn, or by /validate run
+    context_tokens: int = 0
+    max_context_tokens: int = 0
+
+@dataclass(frozen=True)
+class HintRule:
+    name: str
+    tip: Callable[[TurnFacts], Optional[str]]
+    once: bool = False  # Shown the first time it applies in a session only
+
+def block_path(block: CodeBlock, root: str) -> Optional[str]:
+    """The file a code block is for, as its fence, its first line or the prose before it names it; None if unnamed.
+
+    A path only the prose names must already exist, as prose names files for other reasons too.
+    """
+    words = block.language.split()
+    for word in words[1:] or words:  # After the language, or in its place
+        match = PATH_TOKEN_RE.match(word)
+        if match:
+            return match.group("path")
+    match = FIRST_LINE_PATH_RE.match(block.lines[0]) if block.lines else None
+    if match:
+        return match.group("path")
+    for match in LEAD_PATH_RE.finditer(block.lead):
+        path = match.group("path")
+        if os.path.isfile(os.path.join(root, path)):
+            return path
+    return None
+
+def apply_tip(facts: TurnFacts) -> Optional[str]:
+    tips = []
+    for number, block in enumerate(facts.code_blocks, 1):
+        path = block_path(block, facts.root)
+        if not path or not block.lines:
+            continue
+        full_path = os.path.normpath(os.path.join(facts.root, path))
+        if full_path in facts.written:
+            continue  # Neo wrote it with a tool already
+        try:
+            with open(full_path, "r", encoding="utf-8") as f:
+                if f.read() == block.content:
+                    continue  # Already what the block says
+        except (OSError, UnicodeDecodeError):
+            pass
+        tips.append(f"/apply {number} {path}")
+    if not tips:
+        return None
+    if len(tips) == 1:
+        return f"{tips[0]} to write this block"
+    return f"{', '.join(tips)} to write these blocks"
+
+def validation_tip(facts: TurnFacts) -> Optional[str]:
+    if facts.validation is None or facts.validation.passed:
+        return None
+    return "ask Neo about the failure, e.g. \"why does this fail?\""
+
+def context_tip(facts: TurnFacts) -> Optional[str]:
+    if not facts.max_context_tokens or facts.context_tokens < CONTEXT_HINT_FRACTION * facts.max_context_tokens:
+        return None
+    percent = round(100 * facts.context_tokens / facts.max_context_tokens)
+    return (f"the context is {percent}% of max_context_tokens (~{facts.context_tokens:,} of {facts.max_context_tokens:,}), "
+            "past which old exchanges are trimmed; /context shows what uses it and /forget drops what you are done with")
+
+HINT_RULES: Tuple[HintRule, ...] = (
+    HintRule("apply", apply_tip),
+    HintRule("validation", validation_tip, once=True),
+    HintRule("context", context_tip, once=True),
+)
+
+class Hints:
+    """The rules and which once-per-session tips were already shown."""
+
+    def __init__(self, rules: Tuple[HintRule, ...] = HINT_RULES):
+        self.rules = rules
+        self.shown: Set[str] = set()
+
+    def tips(self, facts: TurnFacts) -> List[str]:
+        tips = []
+        for rule in self.rules:
+            if rule.once and rule.name in self.shown:
+                continue
+            tip = rule.tip(facts)
+            if tip:
+                tips.append(tip)
+                self.shown.add(rule.name)
+        return tips
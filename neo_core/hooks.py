@@ -0,0 +1,89 @@
+"""Your own scripts, run around each message: pre_send_hook and post_receive_hook.
+
+A hook is an executable named in the config. It runs in the workspace root with
+the filtered environment Neo gives every command it runs (see child_env), gets a
+JSON object on stdin, and has hook_timeout seconds to finish.
+
+pre_send_hook gets {"event": "pre_send", "message": ..., "model": ..., "workspace": ...,
+"turn": ...} before a message you typed is sent. Exiting 0 with nothing on stdout sends
+it as it is. Printing text sends that text instead, and printing {"message": "..."}
+does the same as JSON. A non-zero exit stops the message, with the hook's stderr as
+the reason.
+
+post_receive_hook gets the same fields plus "reply", the final text of the answer,
+after it has been shown. Its stdout is ignored; it is for logging and notifying.
+
+A hook that can't be started, times out or prints a bad JSON object is a failure of the
+hook, not a decision: the message goes as you typed it, and the failure is shown
+with the hook's path.
+"""
+
+import json
+import os
+import signal
+import subprocess
+import time
+from dataclasses import dataclass
+from typing import Any, Dict, Optional
+
+MAX_STDOUT_BYTES = 1_000_000
+MAX_STDERR_SHOWN = 600  # Characters of a hook's stderr shown with its failure
+
+class HookError(Exception):
+    """A hook that could not run to the end, with a message naming it."""
+
+@dataclass
+class HookResult:
+    exit_code: int
+    stdout: str
+    stderr: str
+    seconds: float
+
+    def reason(self) -> str:
+        """Its stderr, shortened, for the message saying why it stopped the turn."""
+        text = self.stderr.strip() or self.stdout.strip() or "(no output)"
+        return text if len(text) <= MAX_STDERR_SHOWN else text[:MAX_STDERR_SHOWN] + "..."
+
+def run_hook(name: str, path: str, payload: Dict[str, Any], cwd: str, timeout: float,
+             env: Optional[Dict[str, str]] = None) -> HookResult:
+    """Run hook 'name' ("pre_send_hook" or "post_receive_hook") with 'payload' on stdin.
+
+    Raises HookError when it can't be started or doesn't finish within 'timeout'.
+    """
+    started = time.monotonic()
+    try:
+        process = subprocess.Popen([os.path.expanduser(path)], cwd=cwd, env=env, stdin=subprocess.PIPE,
+                                   stdout=subprocess.PIPE, stderr=subprocess.PIPE, text=True, encoding="utf-8",
+                                   errors="replace", start_new_session=True)
+    except OSError as e:
+        raise HookError(f"{name} {path} could not be started: {e.strerror or e}") from e
+    try:
+        stdout, stderr = process.communicate(json.dumps(payload, ensure_ascii=False), timeout=timeout)
+    except subprocess.TimeoutExpired:
+        if os.name == "posix":
+            os.killpg(process.pid, signal.SIGKILL)
+        else:
+            process.kill()
+        process.communicate()
+        raise HookError(f"{name} {path} timed out after {timeout:g}s")
+    if len(stdout or "") > MAX_STDOUT_BYTES:
+        raise HookError(f"{name} {path} printed more than {MAX_STDOUT_BYTES:,} characters")
+    return HookResult(process.returncode, stdout or "", stderr or "", time.monotonic() - started)
+
+def rewritten_message(name: str, path: str, result: HookResult) -> Optional[str]:
+    """What pre_send_hook's stdout says to send instead; None to send the message unchanged.
+
+    Raises HookError for a JSON object without a string "message".
+    """
+    text = result.stdout.strip("\n")
+    if not text.strip():
+        return None
+    if text.lstrip().startswith("{"):
+        try:
+            data = json.loads(text)
+        except ValueError:
+            return text  # Text that happens to start with a brace
+        if not isinstance(data, dict) or not isinstance(data.get("message"), str):
+            raise HookError(f'{name} {path} printed a JSON object without a string "message"')
+        return data["message"]
+    return text
@@ -0,0 +1,220 @@
+"""`neo serve`: a long-running Neo behind a small HTTP API, for editor plugins.
+
+    POST /chat                         {"session": "main", "message": "...", "approve": "never"}
+                                       streams the turn's events as server-sent events
+    GET  /sessions                     the sessions and their sizes
+    POST /sessions/{id}/context/add    {"paths": ["src/app.py"]} puts files in a session's context
+
+Every request needs "Authorization: Bearer <token>", with the token printed at startup.
+Sessions share one workspace and API client and are created by the first request
+that names them.
+Requests to the same session wait for each other, so a conversation never runs two
+turns at once. "approve" decides file changes for the request, since there is no
+one at a terminal to ask: "never" (the default), "always", or a list of glob
+patterns that every changed path, taken relative to the workspace, must match.
+"""
+
+import fnmatch
+import hmac
+import json
+import os
+import re
+import secrets
+import threading
+from dataclasses import asdict
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from pathlib import Path
+from typing import Any, Callable, Dict, List, Tuple
+
+from rich.markup import escape
+
+from neo_core.ai import ChatClient
+from neo_core.api import Session, create_client
+from neo_core.config import NeoConfig
+from neo_core.fileops import Workspace, describe_error
+from neo_core.loop import Event
+from neo_core.redact import scrub
+from neo_core.tools import change_targets
+from neo_core.ui import console
+
+SESSION_ID_RE = re.compile(r"^[A-Za-z0-9_.-]{1,64}$")
+CONTEXT_ADD_RE = re.compile(r"^/sessions/([^/]+)/context/add$")
+MAX_BODY_BYTES = 1_000_000
+
+class RequestError(Exception):
+    def __init__(self, status: int, message: str):
+        super().__init__(message)
+        self.status = status
+
+def parse_listen(listen: str) -> Tuple[str, int]:
+    """Split HOST:PORT; raises ValueError."""
+    host, _, port = listen.rpartition(":")
+    if not host or not port.isdigit() or not 0 < int(port) < 65536:
+        raise ValueError(f"--listen must be HOST:PORT, e.g. 127.0.0.1:7777, got {listen!r}")
+    return host.strip("[]"), int(port)
+
+def approval_policy(policy: Any, workspace: Workspace) -> Callable[[str, str], bool]:
+    """The approve(action, target) callback for a request's "approve" value; raises RequestError."""
+    if policy in (None, "never"):
+        return lambda action, target: False
+    if policy == "always":
+        return lambda action, target: True
+    if isinstance(policy, list) and all(isinstance(pattern, str) for pattern in policy):
+        return lambda action, target: all(path_matches(workspace, path, policy) for path in change_targets(target))
+    raise RequestError(400, "approve must be \"never\", \"always\" or a list of glob patterns")
+
+def path_matches(workspace: Workspace, path: str, patterns: List[str]) -> bool:
+    """Whether 'path', resolved inside the workspace and taken relative to it, matches one of 'patterns'."""
+    try:
+        normalized_path = workspace.normalize_path(path)
+    except (OSError, ValueError):
+        return False  # Outside the workspace, a link loop or not a path at all
+    relative = Path(os.path.relpath(normalized_path, workspace.root)).as_posix()
+    return any(fnmatch.fnmatchcase(relative, pattern) for pattern in patterns)
+
+def event_data(event: Event) -> Dict[str, Any]:
+    return {key: value for key, value in asdict(event).items() if value not in (None, "")}
+
+class SessionStore:
+    """The server's sessions by id, each with the lock that serializes its requests."""
+
+    def __init__(self, config: NeoConfig, workdir: str, client: ChatClient):
+        self.config = config
+        self.workdir = workdir
+        self.client = client
+        self._sessions: Dict[str, Tuple[Session, threading.Lock]] = {}
+        self._lock = threading.Lock()
+
+    def get(self, session_id: str, create: bool = False) -> Tuple[Session, threading.Lock]:
+        if not SESSION_ID_RE.match(session_id):
+            raise RequestError(400, "session ids are 1-64 letters, digits, '_', '.' or '-'")
+        with self._lock:
+            if session_id not in self._sessions:
+                if not create:
+                    raise RequestError(404, f"no session {session_id!r}")
+                self._sessions[session_id] = (Session(self.config, self.workdir, self.client), threading.Lock())
+            return self._sessions[session_id]
+
+    def describe(self) -> List[Dict[str, Any]]:
+        with self._lock:
+            sessions = list(self._sessions.items())
+        return [{
+            "id": session_id,
+            "messages": len(session.conversation.history()),
+            "tokens": session.conversation.token_count(),
+            "files": session.conversation.files(),
+            "busy": lock.locked(),
+        } for session_id, (session, lock) in sessions]
+
+class APIHandler(BaseHTTPRequestHandler):
+    store: SessionStore
+    token: str
+    protocol_version = "HTTP/1.1"
+
+    def do_GET(self) -> None:
+        self._handle(lambda: self._send_json(200, {"sessions": self.store.describe()})
+                     if self.path == "/sessions" else self._not_found())
+
+    def do_POST(self) -> None:
+        match = CONTEXT_ADD_RE.match(self.path)
+        if self.path == "/chat":
+            self._handle(self._chat)
+        elif match:
+            self._handle(lambda: self._add_context(match.group(1)))
+        else:
+            self._handle(self._not_found)
+
+    def _handle(self, handler: Callable[[], None]) -> None:
+        if not hmac.compare_digest(self.headers.get("Authorization", ""), f"Bearer {self.token}"):
+            self._send_json(401, {"error": "missing or wrong bearer token"})
+            return
+        try:
+            handler()
+        except RequestError as e:
+            self._send_json(e.status, {"error": scrub(str(e))})
+
+    def _not_found(self) -> None:
+        raise RequestError(404, f"no route for {self.command} {self.path}")
+
+    def _chat(self) -> None:
+        body = self._read_json()
+        message = body.get("message")
+        if not isinstance(message, str) or not message.strip():
+            raise RequestError(400, "message is required")
+        session, lock = self.store.get(str(body.get("session", "default")), create=True)
+        approve = approval_policy(body.get("approve"), session.workspace)
+
+        self.send_response(200)
+        self.send_header("Content-Type", "text/event-stream")
+        self.send_header("Cache-Control", "no-cache")
+        self.send_header("Connection", "close")
+        self.end_headers()
+        self.close_connection = True
+        with lock:
+            session.tools.ctx.approve = approve
+            try:
+                session.send(message, self._send_event)
+            except Exception as e:
+                self._write_event("error", {"error": scrub(str(e))})
+
+    def _send_event(self, event: Event) -> None:
+        self._write_event(event.kind, event_data(event))
+
+    def _write_event(self, kind: str, data: Dict[str, Any]) -> None:
+        try:
+            self.wfile.write(f"event: {kind}\ndata: {json.dumps(data)}\n\n".encode("utf-8"))
+            self.wfile.flush()
+        except OSError:
+            pass  # The client went away; the turn still finishes so the conversation stays whole
+
+    def _add_context(self, session_id: str) -> None:
+        paths = self._read_json().get("paths")
+        if not isinstance(paths, list) or not paths or not all(isinstance(path, str) for path in paths):
+            raise RequestError(400, "paths must be a non-empty list of file paths")
+        session, lock = self.store.get(session_id, create=True)
+        added, errors = [], {}
+        with lock:
+            for path in paths:
+                try:
+                    added.append(session.add_file(path))
+                except Exception as e:
+                    errors[path] = describe_error(e)
+        self._send_json(200 if added else 400, {"added": added, "errors": errors})
+
+    def _read_json(self) -> Dict[str, Any]:
+        length = int(self.headers.get("Content-Length") or 0)
+        if length > MAX_BODY_BYTES:
+            raise RequestError(413, f"request bodies are limited to {MAX_BODY_BYTES} bytes")
+        try:
+            body = json.loads(self.rfile.read(length) or b"{}")
+        except (json.JSONDecodeError, UnicodeDecodeError) as e:
+            raise RequestError(400, f"body is not JSON: {e}") from None
+        if not isinstance(body, dict):
+            raise RequestError(400, "body must be a JSON object")
+        return body
+
+    def _send_json(self, status: int, data: Dict[str, Any]) -> None:
+        payload = json.dumps(data).encode("utf-8")
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def log_message(self, format: str, *args: Any) -> None:
+        console.print(f"[matrix.dim]> {escape(format % args)}[/matrix.dim]")
+
+def serve(config: NeoConfig, workdir: str, listen: str) -> None:
+    """Serve the API until interrupted; raises ValueError for a bad address or a missing API key."""
+    host, port = parse_listen(listen)
+    store = SessionStore(config, workdir, create_client(config))
+    handler = type("Handler", (APIHandler,), {"store": store, "token": secrets.token_urlsafe(24)})
+    server = ThreadingHTTPServer((host, port), handler)
+    console.print(f"[matrix.success]> neo API listening on http://{host}:{port}[/matrix.success] [matrix.dim]({workdir})[/matrix.dim]")
+    console.print(f"> Bearer token: {handler.token}")
+    try:
+        server.serve_forever()
+    except KeyboardInterrupt:
+        pass
+    finally:
+        server.server_close()
@@ -0,0 +1,239 @@
+"""The tool-calling loop, free of any terminal UI.
+
+AgentLoop sends a message, streams the reply, runs the tool calls it asks for and
+streams the follow-up, reporting each step as an Event. The interactive Agent in
+ai.py renders those events to the terminal; api.Session hands them to a caller.
+"""
+
+import time
+from dataclasses import dataclass
+from typing import Any, Callable, Dict, Iterable, List, Optional, Protocol, Tuple
+
+from neo_core.cancel import CANCELLED_RESULT
+from neo_core.config import NeoConfig
+from neo_core.cache import ResponseCache, request_key
+from neo_core.conversation import Conversation
+from neo_core.routing import Route, choose_model
+
+# Event kinds, in the order they occur within a turn
+REQUEST = "request"  # A completion is about to stream; text is "reply" or "follow_up"
+CACHED = "cached"  # Instead of the first request: the turn is replayed from the response cache
+REASONING = "reasoning"  # A piece of the model's reasoning
+CONTENT = "content"  # A piece of the reply text
+TOOL_ARGUMENTS = "tool_arguments"  # A piece of a tool call's streamed arguments
+STREAM_END = "stream_end"  # A completion finished; text is all of its content
+TOOL_CALLS = "tool_calls"  # The reply asked for tools; tool_calls lists them
+TOOL_CALL = "tool_call"  # One tool is about to run; one that changes files asks for approval first
+TOOLS_RUNNING = "tools_running"  # Read-only tools, in tool_calls, still run; text is the seconds so far. Raise Cancelled to stop them
+TOOL_RESULT = "tool_result"  # A tool finished; result is what the model will see
+TOOLS_DONE = "tools_done"  # Every tool call of the reply has a result
+TURN_LIMIT = "turn_limit"  # A per-turn tool limit was hit; text says which
+DONE = "done"  # The turn is complete; text is the whole reply
+
+@dataclass
+class Event:
+    kind: str
+    text: str = ""
+    tool_call: Optional[Dict[str, Any]] = None
+    tool_calls: Optional[List[Dict[str, Any]]] = None
+    result: Optional[str] = None
+
+def collect_stream(stream: Iterable[Any], emit: Callable[[Event], None]) -> Tuple[str, List[Dict[str, Any]]]:
+    """Read a streamed completion, emitting its deltas; returns the content and the tool calls it asked for."""
+    content = ""
+    tool_calls: List[Dict[str, Any]] = []
+    for chunk in stream:
+        delta = chunk.choices[0].delta
+        if getattr(delta, "reasoning_content", None):
+            emit(Event(REASONING, delta.reasoning_content))
+        elif delta.content:
+            content += delta.content
+            emit(Event(CONTENT, delta.content))
+        elif delta.tool_calls:
+            for tool_call_delta in delta.tool_calls:
+                if tool_call_delta.function and tool_call_delta.function.arguments:
+                    emit(Event(TOOL_ARGUMENTS, tool_call_delta.function.arguments))
+                if tool_call_delta.index is None:
+                    continue
+                while len(tool_calls) <= tool_call_delta.index:
+                    tool_calls.append({"id": "", "type": "function", "function": {"name": "", "arguments": ""}})
+                tool_call = tool_calls[tool_call_delta.index]
+                if tool_call_delta.id:
+                    tool_call["id"] = tool_call_delta.id
+                if tool_call_delta.function:
+                    if tool_call_delta.function.name:
+                        tool_call["function"]["name"] += tool_call_delta.function.name
+                    if tool_call_delta.function.arguments:
+                        tool_call["function"]["arguments"] += tool_call_delta.function.arguments
+    content = reply_text(content)
+    emit(Event(STREAM_END, content))
+    return content, complete_tool_calls(tool_calls)
+
+def reply_text(content: Optional[str]) -> str:
+    """A reply as it is kept: trimmed, so one of nothing but whitespace (often "\\n\\n" before a tool call) is empty."""
+    return (content or "").strip()
+
+def complete_tool_calls(tool_calls: List[Dict[str, Any]]) -> List[Dict[str, Any]]:
+    """Drop calls that never got a name and give an id to any that arrived without one."""
+    completed = []
+    for i, tool_call in enumerate(tool_calls):
+        if tool_call["function"]["name"]:
+            completed.append({
+                "id": tool_call["id"] or f"call_{i}_{int(time.time() * 1000)}",
+                "type": "function",
+                "function": dict(tool_call["function"]),
+            })
+    return completed
+
+class ToolExecutor(Protocol):
+    """Implemented by tools.ToolRegistry."""
+
+    def definitions(self) -> List[Dict[str, Any]]:
+        """The function schemas to send with each request."""
+        ...
+
+    def describe(self) -> str:
+        """One line per tool for the system prompt."""
+        ...
+
+    def execute(self, tool_call: Dict[str, Any]) -> str:
+        """Run a tool call in the dictionary format and return the result text."""
+        ...
+
+    def execute_all(self, tool_calls: List[Dict[str, Any]],
+                    on_start: Optional[Callable[[Dict[str, Any]], None]] = None,
+                    on_wait: Optional[Callable[[List[Dict[str, Any]], float], None]] = None) -> List[str]:
+        """Run one response's tool calls, read-only ones concurrently, returning results in request order.
+
+        on_wait(calls, seconds) is called while read-only calls run; Ctrl+C or on_wait raising Cancelled cancels them.
+        """
+        ...
+
+    def begin_turn(self) -> None:
+        """Reset the per-turn tool limits; called for each new user message."""
+        ...
+
+    def turn_limit_reached(self) -> Optional[str]:
+        """The per-turn limit that stopped tool execution this turn, if any."""
+        ...
+
+    def is_mutating(self, name: str) -> bool:
+        """Whether a tool can change files; turns that ran one are never cached."""
+        ...
+
+class AgentLoop:
+    """Runs one user message through the model and its tools, updating the conversation."""
+
+    def __init__(self, config: NeoConfig, tool_executor: ToolExecutor, conversation: Conversation,
+                 create_stream: Callable[..., Iterable[Any]], cache: Optional[ResponseCache] = None):
+        self.config = config
+        self.tool_executor = tool_executor
+        self.conversation = conversation
+        self.create_stream = create_stream  # create_stream(**request) -> chunks
+        self.cache = cache  # Used while config.response_cache is on
+        self.last_route: Optional[Route] = None  # The model that handled the latest message, and why
+        self.last_cached = False  # Whether the latest reply was replayed from the cache
+
+    def send(self, user_message: str, emit: Callable[[Event], None]) -> str:
+        """Send a message and return the reply. API errors are raised after the message is added."""
+        route = self.last_route = choose_model(self.config, self.conversation, user_message)
+        self.conversation.add_user(user_message)
+        self.tool_executor.begin_turn()
+        self.conversation.trim_to_budget(self.config.max_context_tokens, self.config.max_history_messages)
+
+        messages = self.conversation.messages()
+        tools = self.tool_executor.definitions()
+        key = None
+        if self.cache is not None and self.config.response_cache:
+            key = request_key(self.config.provider, route.model, messages, tools, {"max_completion_tokens": 64000})
+            cached = self.cache.get(key, self.config.response_cache_ttl)
+            self.last_cached = cached is not None
+            if cached is not None:
+                return self._replay(cached, route.model, emit)
+        self.last_cached = False
+
+        emit(Event(REQUEST, "reply"))
+        content, tool_calls = collect_stream(self.create_stream(
+            model=route.model,
+            messages=messages,
+            tools=tools,
+            max_completion_tokens=64000,
+        ), emit)
+        if not tool_calls:
+            self._add_reply(content, None, route.model)
+            if key:
+                self.cache.put(key, {"content": content})
+            emit(Event(DONE, content))
+            return content
+
+        self._add_reply(content, tool_calls, route.model)
+        emit(Event(TOOL_CALLS, content, tool_calls=tool_calls))
+        results = self.tool_executor.execute_all(tool_calls, lambda tool_call: emit(Event(TOOL_CALL, tool_call=tool_call)),
+                                                 lambda batch, seconds: emit(Event(TOOLS_RUNNING, f"{seconds:.1f}", tool_calls=batch)))
+        for tool_call, result in zip(tool_calls, results):
+            self.conversation.add_tool_result(tool_call["id"], result, tool_call["function"]["name"])
+            emit(Event(TOOL_RESULT, tool_call=tool_call, result=result))
+        emit(Event(TOOLS_DONE))
+
+        # Once a turn limit is hit the model may only summarize, so no tools are offered
+        follow_up_tools = {"tools": self.tool_executor.definitions()}
+        limit = self.tool_executor.turn_limit_reached()
+        if limit:
+            emit(Event(TURN_LIMIT, limit))
+            follow_up_tools = {}
+
+        emit(Event(REQUEST, "follow_up"))
+        follow_up, _ = collect_stream(self.create_stream(
+            model=route.model,
+            messages=self.conversation.messages(),
+            max_completion_tokens=64000,
+            **follow_up_tools
+        ), emit)
+        self._add_reply(follow_up, None, route.model)
+        cacheable = CANCELLED_RESULT not in results and not any(
+            self.tool_executor.is_mutating(tool_call["function"]["name"]) for tool_call in tool_calls)
+        if key and cacheable:
+            self.cache.put(key, {"content": content, "tool_calls": tool_calls, "results": results, "follow_up": follow_up})
+        reply = "\n".join(part for part in (content, follow_up) if part)
+        emit(Event(DONE, reply))
+        return reply
+
+    def _replay(self, cached: Dict[str, Any], model: str, emit: Callable[[Event], None]) -> str:
+        """Rebuild a cached turn in the conversation, emitting the events it had, without running its tools."""
+        content = reply_text(cached.get("content"))
+        emit(Event(CACHED))
+        if content:
+            emit(Event(CONTENT, content))
+        emit(Event(STREAM_END, content))
+        tool_calls = cached.get("tool_calls") or []
+        if not tool_calls:
+            self._add_reply(content, None, model)
+            emit(Event(DONE, content))
+            return content
+
+        self._add_reply(content, tool_calls, model)
+        emit(Event(TOOL_CALLS, content, tool_calls=tool_calls))
+        for tool_call, result in zip(tool_calls, cached.get("results") or []):
+            emit(Event(TOOL_CALL, tool_call=tool_call))
+            self.conversation.add_tool_result(tool_call["id"], result, tool_call["function"]["name"])
+            emit(Event(TOOL_RESULT, tool_call=tool_call, result=result))
+        emit(Event(TOOLS_DONE))
+        follow_up = reply_text(cached.get("follow_up"))
+        emit(Event(REQUEST, "follow_up"))
+        if follow_up:
+            emit(Event(CONTENT, follow_up))
+        emit(Event(STREAM_END, follow_up))
+        self._add_reply(follow_up, None, model)
+        reply = "\n".join(part for part in (content, follow_up) if part)
+        emit(Event(DONE, reply))
+        return reply
+
+    def _add_reply(self, content: str, tool_calls: Optional[List[Dict[str, Any]]], model: str) -> None:
+        """Add a first reply or follow-up to the conversation, the same way for both.
+
+        Empty content is sent as null, which every provider accepts beside tool calls.
+        An empty reply without tool calls isn't added at all: stricter providers reject
+        an assistant message with no content on the next request.
+        """
+        if content or tool_calls:
+            self.conversation.add_assistant(content or None, tool_calls, model=model)
@@ -0,0 +1,226 @@
+"""Model Context Protocol client: tools from external MCP servers, offered next to the built-in ones.
+
+Each server in the config's "mcp_servers" is started as a subprocess and spoken to
+over stdio with newline-delimited JSON-RPC. Its tools are registered as
+<server>__<tool>, so github's create_issue becomes github__create_issue, and calls
+to them are forwarded to the server.
+"""
+
+import json
+import os
+import queue
+import re
+import subprocess
+import threading
+import time
+from collections import deque
+from typing import Any, Deque, Dict, Iterable, List, Optional, Tuple
+
+from neo_core import __version__
+from neo_core.cancel import Cancelled, CancelToken
+from neo_core.redact import child_env
+from neo_core.tools import Tool, ToolContext, ToolRegistry, confirm_change
+
+PROTOCOL_VERSION = "2024-11-05"
+STARTUP_TIMEOUT = 30.0  # Seconds for a server to answer initialize and tools/list
+CALL_TIMEOUT = 120.0  # Seconds for a tool call
+CANCEL_POLL_SECONDS = 0.1  # How often a request waiting for its reply checks whether it was cancelled
+TOOL_NAME_RE = re.compile(r"[^A-Za-z0-9_-]")
+MAX_TOOL_NAME = 64  # The API's limit on function names
+
+class MCPError(RuntimeError):
+    """A server that failed to start, answered with an error, or stopped answering."""
+
+class MCPServer:
+    """One running server and the JSON-RPC requests in flight to it."""
+
+    def __init__(self, name: str, command: str, args: Optional[List[str]] = None,
+                 env: Optional[Dict[str, str]] = None, cwd: Optional[str] = None, env_allowlist: Iterable[str] = ()):
+        self.name = name
+        self.command = [command] + list(args or [])
+        self.env = child_env(env_allowlist, env)  # Credentials a server needs go in its "env"
+        self.cwd = cwd
+        self.process: Optional[subprocess.Popen] = None
+        self.stderr: Deque[str] = deque(maxlen=20)  # The server's last lines on stderr, for error messages
+        self._next_id = 0
+        self._pending: Dict[int, "queue.Queue[Dict[str, Any]]"] = {}
+        self._lock = threading.Lock()
+
+    def start(self) -> None:
+        try:
+            self.process = subprocess.Popen(self.command, stdin=subprocess.PIPE, stdout=subprocess.PIPE,
+                                            stderr=subprocess.PIPE, env=self.env, cwd=self.cwd,
+                                            text=True, encoding="utf-8", bufsize=1)
+        except OSError as e:
+            raise MCPError(f"could not start {self.command[0]}: {e}") from e
+        threading.Thread(target=self._read_stdout, name=f"mcp-{self.name}", daemon=True).start()
+        threading.Thread(target=self._read_stderr, name=f"mcp-{self.name}-stderr", daemon=True).start()
+        self.request("initialize", {
+            "protocolVersion": PROTOCOL_VERSION,
+            "capabilities": {},
+            "clientInfo": {"name": "neo", "version": __version__},
+        }, STARTUP_TIMEOUT)
+        self.notify("notifications/initialized")
+
+    def list_tools(self) -> List[Dict[str, Any]]:
+        tools: List[Dict[str, Any]] = []
+        cursor = None
+        while True:
+            result = self.request("tools/list", {"cursor": cursor} if cursor else {}, STARTUP_TIMEOUT)
+            tools.extend(result.get("tools", []))
+            cursor = result.get("nextCursor")
+            if not cursor:
+                return tools
+
+    def call_tool(self, name: str, arguments: Dict[str, Any], cancel: Optional[CancelToken] = None) -> str:
+        """Call a tool and return its text content; raises MCPError if the tool reports an error."""
+        result = self.request("tools/call", {"name": name, "arguments": arguments}, CALL_TIMEOUT, cancel)
+        parts = []
+        for item in result.get("content", []):
+            if item.get("type") == "text":
+                parts.append(item.get("text", ""))
+            elif item.get("type") == "resource" and "text" in item.get("resource", {}):
+                parts.append(item["resource"]["text"])
+            else:
+                parts.append(f"[{item.get('type', 'unknown')} content omitted]")
+        text = "\n".join(parts)
+        if result.get("isError"):
+            raise MCPError(text or "the tool reported an error")
+        return text
+
+    def request(self, method: str, params: Dict[str, Any], timeout: float,
+                cancel: Optional[CancelToken] = None) -> Dict[str, Any]:
+        """Send a request and wait for its reply. If 'cancel' is cancelled, or Ctrl+C arrives, while
+        waiting, the server is told with notifications/cancelled and it raises Cancelled or KeyboardInterrupt."""
+        with self._lock:
+            self._next_id += 1
+            request_id = self._next_id
+            replies: "queue.Queue[Dict[str, Any]]" = queue.Queue(maxsize=1)
+            self._pending[request_id] = replies
+        try:
+            self._send({"jsonrpc": "2.0", "id": request_id, "method": method, "params": params})
+            deadline = time.monotonic() + timeout
+            try:
+                while True:
+                    if cancel is not None:
+                        cancel.check()
+                    try:
+                        message = replies.get(timeout=min(CANCEL_POLL_SECONDS, max(deadline - time.monotonic(), 0)))
+                        break
+                    except queue.Empty:
+                        if time.monotonic() >= deadline:
+                            raise MCPError(f"{method} timed out after {timeout:.0f}s{self._stderr_note()}") from None
+            except (Cancelled, KeyboardInterrupt):
+                try:
+                    self.notify("notifications/cancelled", {"requestId": request_id, "reason": "cancelled by the user"})
+                except MCPError:
+                    pass  # The server is gone already
+                raise
+        finally:
+            with self._lock:
+                self._pending.pop(request_id, None)
+        if "error" in message:
+            error = message["error"]
+            raise MCPError(f"{method} failed: {error.get('message', error)}")
+        return message.get("result") or {}
+
+    def notify(self, method: str, params: Optional[Dict[str, Any]] = None) -> None:
+        self._send({"jsonrpc": "2.0", "method": method, **({"params": params} if params else {})})
+
+    def _send(self, message: Dict[str, Any]) -> None:
+        if self.process is None or self.process.poll() is not None:
+            raise MCPError(f"the server is not running{self._stderr_note()}")
+        try:
+            with self._lock:
+                self.process.stdin.write(json.dumps(message) + "\n")
+                self.process.stdin.flush()
+        except OSError as e:
+            raise MCPError(f"could not write to the server: {e}{self._stderr_note()}") from e
+
+    def _read_stdout(self) -> None:
+        for line in self.process.stdout:
+            try:
+                message = json.loads(line)
+            except json.JSONDecodeError:
+                continue  # Servers may log to stdout by mistake; only JSON-RPC counts
+            if not isinstance(message, dict):
+                continue
+            with self._lock:
+                replies = self._pending.get(message.get("id")) if "method" not in message else None
+            if replies is not None:
+                replies.put(message)
+            elif "method" in message and "id" in message:
+                # A request from the server (sampling, roots...): none are supported
+                self._send({"jsonrpc": "2.0", "id": message["id"], "error": {"code": -32601, "message": "not supported"}})
+        with self._lock:
+            pending = list(self._pending.values())
+        for replies in pending:  # The server exited: fail whatever was waiting
+            replies.put({"error": {"message": f"the server exited{self._stderr_note()}"}})
+
+    def _read_stderr(self) -> None:
+        for line in self.process.stderr:
+            self.stderr.append(line.rstrip())
+
+    def _stderr_note(self) -> str:
+        return f" (stderr: {self.stderr[-1]})" if self.stderr else ""
+
+    def stop(self) -> None:
+        if self.process is None:
+            return
+        try:
+            self.process.stdin.close()
+            self.process.wait(timeout=2)
+        except (OSError, subprocess.TimeoutExpired):
+            self.process.kill()
+        self.process = None
+
+class MCPTool(Tool):
+    """A tool provided by an MCP server."""
+
+    def __init__(self, server: MCPServer, definition: Dict[str, Any]):
+        self.server = server
+        self.remote_name = definition["name"]
+        self.name = mcp_tool_name(server.name, self.remote_name)
+        self.source = f"mcp:{server.name}"
+        description = definition.get("description") or f"{self.remote_name} from the {server.name} MCP server"
+        self.summary = description.strip().splitlines()[0] if description.strip() else self.remote_name
+        self.description = description
+        self.parameters = definition.get("inputSchema") or {"type": "object", "properties": {}}
+        # Without a read-only hint, assume a tool may change things and ask before each call
+        self.mutating = not (definition.get("annotations") or {}).get("readOnlyHint", False)
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        if self.mutating and ctx.config.dry_run:
+            return f"Dry run: '{self.name}' was not called, since it may change things outside neo"
+        if self.mutating and not confirm_change(ctx, "call", f"{self.name} {json.dumps(arguments)[:200]}"):
+            return f"User declined to run '{self.name}'"
+        return self.server.call_tool(self.remote_name, arguments, ctx.cancel)
+
+def mcp_tool_name(server: str, tool: str) -> str:
+    return TOOL_NAME_RE.sub("_", f"{server}__{tool}")[:MAX_TOOL_NAME]
+
+def connect_mcp_servers(servers: Dict[str, Dict[str, Any]], registry: ToolRegistry,
+                        cwd: Optional[str] = None, env_allowlist: Iterable[str] = ()) -> Tuple[List[MCPServer], List[str]]:
+    """Start the configured servers and register their tools.
+
+    A server that fails costs only its own tools: returns the running servers and
+    a warning for each problem, for the caller to show once the UI is up.
+    """
+    running: List[MCPServer] = []
+    warnings: List[str] = []
+    for name, spec in servers.items():
+        server = MCPServer(name, spec["command"], spec.get("args"), spec.get("env"), cwd, env_allowlist)
+        try:
+            server.start()
+            definitions = server.list_tools()
+        except MCPError as e:
+            server.stop()
+            warnings.append(f"MCP server '{name}' unavailable: {e}")
+            continue
+        running.append(server)
+        for definition in definitions:
+            try:
+                registry.register(MCPTool(server, definition))
+            except (KeyError, ValueError) as e:
+                warnings.append(f"MCP server '{name}': skipped tool {definition.get('name', '?')!r}: {e}")
+    return running, warnings
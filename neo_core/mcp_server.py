@@ -0,0 +1,122 @@
+"""`neo mcp-serve`: Neo's file tools offered to other MCP clients over stdio.
+
+The tools are the interactive ones plus list_directory, search_files and
+delete_file, rooted at the same workspace, with the same protected paths and the
+same audit log. Every path, read or written, must resolve inside the workspace. Stdout carries only the protocol; anything
+the tools print (previews, diffs, notices) goes to stderr, along with a line per call.
+The client is responsible for asking the user before a tool that changes files runs.
+"""
+
+import json
+import sys
+from typing import Any, Dict, Optional, TextIO
+
+from neo_core import __version__
+from neo_core.audit import AuditLog
+from neo_core.config import NeoConfig
+from neo_core.conversation import Conversation
+from neo_core.fileops import Workspace
+from neo_core.mcp import PROTOCOL_VERSION
+from neo_core.tools import ToolContext, ToolRegistry, create_server_registry
+from neo_core.ui import console
+
+# JSON-RPC error codes
+PARSE_ERROR = -32700
+INVALID_REQUEST = -32600
+METHOD_NOT_FOUND = -32601
+INVALID_PARAMS = -32602
+
+class RequestError(Exception):
+    def __init__(self, code: int, message: str):
+        super().__init__(message)
+        self.code = code
+
+def client_approved(action: str, target: str) -> bool:
+    return True  # The MCP client asked its user before sending the call
+
+class MCPToolServer:
+    """Answers MCP requests with the tools of a registry."""
+
+    def __init__(self, tools: ToolRegistry):
+        self.tools = tools
+
+    def handle(self, message: Any) -> Optional[Dict[str, Any]]:
+        """The response to one JSON-RPC message, or None for a notification."""
+        if not isinstance(message, dict) or not isinstance(message.get("method"), str):
+            return error_response(message.get("id") if isinstance(message, dict) else None, INVALID_REQUEST, "invalid request")
+        request_id = message.get("id")
+        try:
+            result = self.dispatch(message["method"], message.get("params") or {})
+        except RequestError as e:
+            return error_response(request_id, e.code, str(e)) if request_id is not None else None
+        if request_id is None:
+            return None
+        return {"jsonrpc": "2.0", "id": request_id, "result": result}
+
+    def dispatch(self, method: str, params: Dict[str, Any]) -> Dict[str, Any]:
+        if method == "initialize":
+            return {
+                "protocolVersion": PROTOCOL_VERSION,
+                "capabilities": {"tools": {}},
+                "serverInfo": {"name": "neo", "version": __version__},
+            }
+        if method == "ping" or method.startswith("notifications/"):
+            return {}
+        if method == "tools/list":
+            return {"tools": [{
+                "name": tool.name,
+                "description": tool.description,
+                "inputSchema": tool.parameters,
+                "annotations": {"readOnlyHint": not tool.mutating},
+            } for tool in self.tools.all() if self.tools.is_enabled(tool.name)]}
+        if method == "tools/call":
+            return self.call_tool(params)
+        raise RequestError(METHOD_NOT_FOUND, f"method not found: {method}")
+
+    def call_tool(self, params: Dict[str, Any]) -> Dict[str, Any]:
+        name = params.get("name")
+        if not isinstance(name, str) or not self.tools.is_enabled(name):
+            raise RequestError(INVALID_PARAMS, f"unknown tool: {name}")
+        arguments = params.get("arguments") or {}
+        if not isinstance(arguments, dict):
+            raise RequestError(INVALID_PARAMS, "arguments must be an object")
+        self.tools.begin_turn()  # Each call stands alone, so per-turn limits apply to one call
+        status, result = self.tools.execute_with_status({
+            "id": "", "type": "function", "function": {"name": name, "arguments": json.dumps(arguments)},
+        })
+        console.print(f"[matrix.dim]> {name}: {status}[/matrix.dim]")
+        return {"content": [{"type": "text", "text": result}], "isError": status not in ("ok", "declined")}
+
+def error_response(request_id: Any, code: int, message: str) -> Dict[str, Any]:
+    return {"jsonrpc": "2.0", "id": request_id, "error": {"code": code, "message": message}}
+
+def create_server(config: NeoConfig, workdir: str) -> MCPToolServer:
+    workspace = Workspace(workdir, config.max_backups, config.protected_paths, config.follow_symlinks, config.max_file_size)
+    tools = create_server_registry(ToolContext(workspace, config, Conversation(""),
+                                                AuditLog(workspace.root, enabled=not config.read_only), approve=client_approved))
+    if config.read_only:
+        tools.remove_mutating()
+    tools.disable(config.disabled_tools)
+    return MCPToolServer(tools)
+
+def serve(config: NeoConfig, workdir: str, stdin: TextIO = sys.stdin) -> None:
+    """Answer requests on stdin until it closes."""
+    protocol = sys.stdout
+    sys.stdout = sys.stderr  # Nothing but protocol messages may reach the real stdout
+    server = create_server(config, workdir)
+    console.print(f"[matrix.dim]> neo MCP server ready in {server.tools.ctx.workspace.root}[/matrix.dim]")
+    try:
+        for line in stdin:
+            if not line.strip():
+                continue
+            try:
+                message = json.loads(line)
+            except json.JSONDecodeError as e:
+                response: Optional[Dict[str, Any]] = error_response(None, PARSE_ERROR, f"parse error: {e}")
+            else:
+                response = server.handle(message)
+            if response is not None:
+                protocol.write(json.dumps(response) + "\n")
+                protocol.flush()
+    finally:
+        sys.stdout = protocol
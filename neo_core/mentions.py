@@ -0,0 +1,55 @@
+"""Files your message names by path, so they can go into the context before it is sent.
+
+A word counts as a mention when it has a "." or a "/" and names a text file in the
+workspace, relative to the root or absolute: "why does neo_core/ai.py hang?" or
+"see `fileops.py:120`". Bare names are not searched for below the root, so
+"ai.py" only matches a file at the top. Files already in context, binary files
+and files over max_file_size are left out, and at most MAX_MENTIONS are found per
+message. send_message asks before adding them, or adds them, per config.add_mentioned_files.
+"""
+
+import os
+import re
+from typing import Iterable, List
+
+from neo_core.fileops import Workspace, binary_reason
+
+MAX_MENTIONS = 3  # Files found per message
+MAX_WORDS = 500  # Of a message, looked at for mentions
+
+WORD_RE = re.compile(r"[^\s`'\"(),;:<>\[\]{}|*]+")
+TRAILING = ".?!"  # Punctuation after a path that ends a sentence
+
+def candidates(message: str) -> List[str]:
+    """The words of 'message' that could be paths, in order, without sentence punctuation or a :line suffix."""
+    words = []
+    for match in WORD_RE.finditer(message):
+        word = match.group().rstrip(TRAILING)
+        if ("." in word or "/" in word) and word not in words:
+            words.append(word)
+        if len(words) >= MAX_WORDS:
+            break
+    return words
+
+def mentioned_files(message: str, workspace: Workspace, in_context: Iterable[str]) -> List[str]:
+    """Normalized paths of up to MAX_MENTIONS text files in the workspace that 'message' names and the context lacks."""
+    skip = set(in_context)
+    found: List[str] = []
+    for word in candidates(message):
+        if word.startswith("~"):
+            continue
+        try:
+            path = workspace.normalize_path(word)
+        except (OSError, ValueError):
+            continue  # Outside the workspace, through a symlink or otherwise
+        if path in skip or path in found or not workspace.contains(path) or not os.path.isfile(path):
+            continue
+        try:
+            if os.path.getsize(path) > workspace.max_file_size or binary_reason(path):
+                continue
+        except OSError:
+            continue
+        found.append(path)
+        if len(found) == MAX_MENTIONS:
+            break
+    return found
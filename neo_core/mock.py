@@ -0,0 +1,136 @@
+"""A scripted stand-in for the OpenAI client, selected with NEO_PROVIDER=mock.
+
+It implements just enough of client.chat.completions.create(stream=True) for the
+agent, so streaming, formatting and tool dispatch can be exercised offline.
+
+A fixture is a JSON file holding the responses to play back, one per request:
+
+    {"responses": [
+        [{"reasoning": "Looking at the file..."},
+         {"tool_call": {"name": "read_file", "arguments": {"file_path": "a.txt"}, "split": 3}}],
+        [{"content": "It says hello."}, {"error": "connection reset"}]
+    ]}
+
+Events are "content", "reasoning", "tool_call" and "error". A tool call's
+arguments are streamed in pieces of "split" characters (default: all at once),
+and an "error" event raises MockStreamError at that point in the stream. Once the
+fixture is exhausted the client echoes the last user message back.
+"""
+
+import json
+from dataclasses import dataclass, field, asdict
+from pathlib import Path
+from typing import Any, Dict, Iterator, List, Optional
+
+class MockStreamError(Exception):
+    """Raised where a fixture injects an error."""
+
+@dataclass
+class MockFunction:
+    name: Optional[str] = None
+    arguments: Optional[str] = None
+
+@dataclass
+class MockToolCallDelta:
+    index: int
+    id: Optional[str] = None
+    type: Optional[str] = None
+    function: Optional[MockFunction] = None
+
+@dataclass
+class MockDelta:
+    content: Optional[str] = None
+    reasoning_content: Optional[str] = None
+    tool_calls: Optional[List[MockToolCallDelta]] = None
+
+@dataclass
+class MockChoice:
+    delta: MockDelta
+    index: int = 0
+
+@dataclass
+class MockChunk:
+    choices: List[MockChoice] = field(default_factory=list)
+
+    def model_dump(self, exclude_none: bool = False) -> Dict[str, Any]:
+        data = asdict(self)
+        return _drop_none(data) if exclude_none else data
+
+def _drop_none(value: Any) -> Any:
+    if isinstance(value, dict):
+        return {k: _drop_none(v) for k, v in value.items() if v is not None}
+    if isinstance(value, list):
+        return [_drop_none(v) for v in value]
+    return value
+
+def _chunk(**delta: Any) -> MockChunk:
+    return MockChunk(choices=[MockChoice(delta=MockDelta(**delta))])
+
+def load_fixture(path: str) -> List[List[Dict[str, Any]]]:
+    with open(Path(path).expanduser(), "r", encoding="utf-8") as f:
+        return json.load(f)["responses"]
+
+class _Completions:
+    def __init__(self, client: "MockClient"):
+        self._client = client
+
+    def create(self, **request: Any) -> Iterator[MockChunk]:
+        self._client.requests.append(request)
+        if self._client.responses:
+            events = self._client.responses.pop(0)
+        else:
+            events = [{"content": self._client.echo(request.get("messages", []))}]
+        # A leading error fails the request itself rather than the stream
+        if events and "error" in events[0]:
+            raise MockStreamError(events[0]["error"])
+        return self._client.play(events)
+
+class _Chat:
+    def __init__(self, client: "MockClient"):
+        self.completions = _Completions(client)
+
+class MockClient:
+    """Plays back fixture responses as streamed chunks."""
+
+    def __init__(self, responses: Optional[List[List[Dict[str, Any]]]] = None, chunk_size: int = 8):
+        self.responses = list(responses or [])
+        self.chunk_size = chunk_size
+        self.requests: List[Dict[str, Any]] = []
+        self.chat = _Chat(self)
+        self._call_count = 0
+
+    def echo(self, messages: List[Dict[str, Any]]) -> str:
+        last_user = next((m.get("content") or "" for m in reversed(messages) if m.get("role") == "user"), "")
+        return f"[mock] You said: {last_user}"
+
+    def _pieces(self, text: str, size: int) -> List[str]:
+        size = size or len(text) or 1
+        return [text[i:i + size] for i in range(0, len(text), size)] or [""]
+
+    def play(self, events: List[Dict[str, Any]]) -> Iterator[MockChunk]:
+        index = 0
+        for event in events:
+            if "error" in event:
+                raise MockStreamError(event["error"])
+            if "reasoning" in event:
+                for piece in self._pieces(event["reasoning"], self.chunk_size):
+                    yield _chunk(reasoning_content=piece)
+            if "content" in event:
+                for piece in self._pieces(event["content"], self.chunk_size):
+                    yield _chunk(content=piece)
+            if "tool_call" in event:
+                call = event["tool_call"]
+                self._call_count += 1
+                arguments = call.get("arguments", {})
+                if not isinstance(arguments, str):
+                    arguments = json.dumps(arguments)
+                call_index = call.get("index", index)
+                yield _chunk(tool_calls=[MockToolCallDelta(
+                    index=call_index,
+                    id=call.get("id", f"mock_call_{self._call_count}"),
+                    type="function",
+                    function=MockFunction(name=call["name"], arguments="")
+                )])
+                for piece in self._pieces(arguments, call.get("split", 0)):
+                    yield _chunk(tool_calls=[MockToolCallDelta(index=call_index, function=MockFunction(arguments=piece))])
+                index = call_index + 1
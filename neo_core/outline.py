@@ -0,0 +1,151 @@
+"""Declaration-only outlines of source files, for adding a package's API shape without its bodies.
+
+Go files keep their package clause, imports, type, const and var declarations and
+function signatures with doc comments; each function body is dropped. Python
+files keep imports, assignments, classes and def signatures with docstrings.
+Anything else, or a file that fails to parse, is returned in full.
+"""
+
+import ast
+import os
+import re
+from typing import Optional, Tuple
+
+OUTLINE_NOTE = "(bodies omitted — use read_file for full source)"
+
+class OutlineError(ValueError):
+    """The source could not be parsed well enough to outline."""
+
+def outline_source(path: str, content: str) -> Tuple[str, bool]:
+    """The outline of 'content', and whether it is one (False means the content came back unchanged)."""
+    outliner = {".go": outline_go, ".py": outline_python}.get(os.path.splitext(path)[1].lower())
+    if outliner is None:
+        return content, False
+    try:
+        return f"{OUTLINE_NOTE}\n\n{outliner(content)}", True
+    except (OutlineError, SyntaxError, ValueError):
+        return content, False
+
+# --------------------------------------------------------------------------------
+# Go
+# --------------------------------------------------------------------------------
+
+FUNC_START_RE = re.compile(r"^func\b", re.MULTILINE)
+TYPE_LITERAL_RE = re.compile(r"\b(?:struct|interface)\s*$")
+
+def _skip_literal(src: str, i: int) -> int:
+    """Index just past the comment, string or rune literal starting at src[i], or i if there is none."""
+    if src.startswith("//", i):
+        end = src.find("\n", i)
+        return len(src) if end == -1 else end
+    if src.startswith("/*", i):
+        end = src.find("*/", i + 2)
+        if end == -1:
+            raise OutlineError("unterminated block comment")
+        return end + 2
+    quote = src[i]
+    if quote == "`":
+        end = src.find("`", i + 1)
+        if end == -1:
+            raise OutlineError("unterminated raw string")
+        return end + 1
+    if quote in "\"'":
+        j = i + 1
+        while j < len(src) and src[j] != quote:
+            if src[j] == "\n":
+                raise OutlineError("newline in string literal")
+            j += 2 if src[j] == "\\" else 1
+        if j >= len(src):
+            raise OutlineError("unterminated string literal")
+        return j + 1
+    return i
+
+def _matching_brace(src: str, i: int) -> int:
+    """Index just past the '}' closing the '{' at src[i]."""
+    depth = 0
+    while i < len(src):
+        skipped = _skip_literal(src, i)
+        if skipped != i:
+            i = skipped
+            continue
+        if src[i] == "{":
+            depth += 1
+        elif src[i] == "}":
+            depth -= 1
+            if depth == 0:
+                return i + 1
+        i += 1
+    raise OutlineError("unbalanced braces")
+
+def _body_start(src: str, i: int) -> Optional[int]:
+    """Index of the '{' opening the body of the func declared at src[i], or None for a body-less declaration."""
+    parens = 0
+    while i < len(src):
+        skipped = _skip_literal(src, i)
+        if skipped != i:
+            i = skipped
+            continue
+        ch = src[i]
+        if ch in "([":
+            parens += 1
+        elif ch in ")]":
+            parens -= 1
+        elif ch == "{":
+            if parens == 0 and not TYPE_LITERAL_RE.search(src, max(0, i - 32), i):
+                return i
+            i = _matching_brace(src, i)  # A struct{...} or interface{...} literal in the signature
+            continue
+        elif ch == "\n" and parens == 0 and src[i - 1] not in ",(":
+            return None
+        i += 1
+    return None
+
+def outline_go(src: str) -> str:
+    if not re.search(r"^package\s+\w+", src, re.MULTILINE):
+        raise OutlineError("no package clause")
+    out = []
+    pos = 0
+    i = 0
+    while i < len(src):
+        skipped = _skip_literal(src, i)
+        if skipped != i:
+            i = skipped
+            continue
+        if src[i] == "{":
+            i = _matching_brace(src, i)  # Type, const and var blocks are kept whole
+            continue
+        if (i == 0 or src[i - 1] == "\n") and FUNC_START_RE.match(src, i):
+            start = _body_start(src, i)
+            if start is not None:
+                out.append(src[pos:start].rstrip())
+                pos = i = _matching_brace(src, start)
+                continue
+        i += 1
+    out.append(src[pos:])
+    return re.sub(r"\n{3,}", "\n\n", "".join(out)).strip() + "\n"
+
+# --------------------------------------------------------------------------------
+# Python
+# --------------------------------------------------------------------------------
+
+class _StripBodies(ast.NodeTransformer):
+    def _stub(self, node):
+        docstring = ast.get_docstring(node, clean=False)
+        node.body = ([ast.Expr(ast.Constant(docstring))] if docstring else []) + [ast.Expr(ast.Constant(...))]
+        return node
+
+    visit_FunctionDef = visit_AsyncFunctionDef = _stub
+
+    def visit_ClassDef(self, node):
+        node.body = [self.visit(n) for n in node.body if isinstance(n, KEPT_STATEMENTS)] or [ast.Expr(ast.Constant(...))]
+        return node
+
+KEPT_STATEMENTS = (ast.Import, ast.ImportFrom, ast.Assign, ast.AnnAssign, ast.FunctionDef, ast.AsyncFunctionDef,
+                   ast.ClassDef, ast.Expr)
+
+def outline_python(src: str) -> str:
+    tree = ast.parse(src)
+    module_doc = ast.get_docstring(tree, clean=False)
+    body = [n for n in tree.body if isinstance(n, KEPT_STATEMENTS) and not isinstance(n, ast.Expr)]
+    tree.body = ([ast.Expr(ast.Constant(module_doc))] if module_doc else []) + [_StripBodies().visit(n) for n in body]
+    return ast.unparse(tree) + "\n"
@@ -0,0 +1,147 @@
+"""A small full-screen pager for re-reading long replies (/last).
+
+Keys: j/k or arrows scroll a line, space/f and b a page, g/G jump to the ends,
+/ starts a search (Enter runs it, n and N repeat it), q or Escape quits.
+"""
+
+from typing import List, Optional
+
+from prompt_toolkit.application import Application
+from prompt_toolkit.filters import Condition
+from prompt_toolkit.formatted_text import ANSI
+from prompt_toolkit.key_binding import KeyBindings
+from prompt_toolkit.keys import Keys
+from prompt_toolkit.layout import HSplit, Layout, Window
+from prompt_toolkit.layout.controls import FormattedTextControl
+from prompt_toolkit.styles import Style
+
+from neo_core.ai import ANSI_ESCAPE_RE
+
+class Pager:
+    """Pages through pre-rendered lines, which may contain ANSI styling."""
+
+    def __init__(self, lines: List[str], title: str = ""):
+        self.lines = lines or [""]
+        self.plain = [ANSI_ESCAPE_RE.sub("", line).lower() for line in self.lines]
+        self.title = title
+        self.top = 0
+        self.query = ""
+        self.typing: Optional[str] = None  # The search being typed, while "/" is active
+        self.message = ""
+        self.app = Application(layout=self._layout(), key_bindings=self._bindings(), full_screen=True,
+                               style=Style.from_dict({"status": "reverse"}))
+
+    @property
+    def height(self) -> int:
+        return max(self.app.output.get_size().rows - 1, 1)
+
+    def _scroll(self, delta: int) -> None:
+        self.top = max(0, min(self.top + delta, len(self.lines) - self.height))
+
+    def _search(self, forward: bool = True) -> None:
+        if not self.query:
+            return
+        needle = self.query.lower()
+        order = range(self.top + 1, len(self.lines)) if forward else range(self.top - 1, -1, -1)
+        for i in order:
+            if needle in self.plain[i]:
+                self.top = i
+                self._scroll(0)
+                self.message = ""
+                return
+        self.message = f"'{self.query}' not found"
+
+    def _body(self):
+        return ANSI("\n".join(self.lines[self.top:self.top + self.height]))
+
+    def _status(self):
+        if self.typing is not None:
+            return f"/{self.typing}"
+        last = min(self.top + self.height, len(self.lines))
+        status = f" {self.title}  lines {self.top + 1}-{last} of {len(self.lines)}  j/k scroll · space/b page · / search · q quit"
+        return f"{status}  [{self.message}]" if self.message else status
+
+    def _layout(self) -> Layout:
+        return Layout(HSplit([
+            Window(FormattedTextControl(self._body), wrap_lines=False),
+            Window(FormattedTextControl(self._status), height=1, style="class:status"),
+        ]))
+
+    def _bindings(self) -> KeyBindings:
+        kb = KeyBindings()
+        searching = Condition(lambda: self.typing is not None)
+        browsing = Condition(lambda: self.typing is None)
+
+        def on(*keys, when=browsing):
+            return kb.add(*keys, filter=when)
+
+        @on("q")
+        @on("escape")
+        def _(event):
+            event.app.exit()
+
+        @on("j")
+        @on("down")
+        @on("enter")
+        def _(event):
+            self._scroll(1)
+
+        @on("k")
+        @on("up")
+        def _(event):
+            self._scroll(-1)
+
+        @on("space")
+        @on("f")
+        @on("pagedown")
+        def _(event):
+            self._scroll(self.height)
+
+        @on("b")
+        @on("pageup")
+        def _(event):
+            self._scroll(-self.height)
+
+        @on("g")
+        @on("home")
+        def _(event):
+            self.top = 0
+
+        @on("G")
+        @on("end")
+        def _(event):
+            self._scroll(len(self.lines))
+
+        @on("/")
+        def _(event):
+            self.typing = ""
+
+        @on("n")
+        def _(event):
+            self._search(forward=True)
+
+        @on("N")
+        def _(event):
+            self._search(forward=False)
+
+        @on("enter", when=searching)
+        def _(event):
+            self.query, self.typing = self.typing, None
+            self._search(forward=True)
+
+        @on("escape", when=searching)
+        def _(event):
+            self.typing = None
+
+        @on("backspace", when=searching)
+        def _(event):
+            self.typing = self.typing[:-1]
+
+        @on(Keys.Any, when=searching)
+        def _(event):
+            self.typing += event.data
+
+        return kb
+
+    def run(self) -> None:
+        self.app.run()
@@ -0,0 +1,118 @@
+"""Every file change of the session as one patch (/patch), and each file's changes for /diff.
+
+The first time a file is written, its content from before the session's first
+change is remembered, and after each write so is what was written. /patch diffs
+the remembered start of each file against the file as it is now, in the format
+`git apply` reads, so the patch applies to the tree as it was when the session
+started. A file whose content no longer matches Neo's last write was changed
+outside Neo since, and is flagged. The content before the latest write is kept
+too, so /diff <path> can show what that write did.
+"""
+
+import difflib
+import hashlib
+import os
+from dataclasses import dataclass
+from typing import Dict, List, Optional, Tuple
+
+from neo_core.fileops import Workspace
+
+NO_NEWLINE = "\\ No newline at end of file\n"
+
+@dataclass
+class FilePatch:
+    path: str  # Relative to the workspace, with forward slashes
+    diff: str  # Empty when the file is back to how it started
+    old: str
+    new: str
+    changed_outside: bool  # The file differs from Neo's last write to it
+    status: str = "modified"  # Or "created" or "deleted"
+
+    def line_counts(self) -> Tuple[int, int]:
+        """Lines added and removed."""
+        lines = [line for line in self.diff.splitlines() if not line.startswith(("+++", "---"))]
+        return sum(line.startswith("+") for line in lines), sum(line.startswith("-") for line in lines)
+
+def read_bytes(path: str) -> Optional[bytes]:
+    try:
+        with open(path, "rb") as f:
+            return f.read()
+    except FileNotFoundError:
+        return None
+
+def digest(data: Optional[bytes]) -> Optional[str]:
+    return None if data is None else hashlib.sha256(data).hexdigest()
+
+def unified_diff(path: str, old: Optional[str], new: Optional[str]) -> str:
+    """A git-style diff of one file; None for 'old' or 'new' makes it a creation or a deletion."""
+    old_lines = (old or "").splitlines(keepends=True)
+    new_lines = (new or "").splitlines(keepends=True)
+    header = f"diff --git a/{path} b/{path}\n"
+    if old is None:
+        header += "new file mode 100644\n"
+    elif new is None:
+        header += "deleted file mode 100644\n"
+    hunks = list(difflib.unified_diff(old_lines, new_lines, "/dev/null" if old is None else f"a/{path}",
+                                      "/dev/null" if new is None else f"b/{path}"))
+    if not hunks:
+        return header if old is None or new is None else ""
+    # Content lines keep their own endings; a last line without one needs git's marker
+    return header + "".join(line if line.endswith("\n") else line + "\n" + NO_NEWLINE for line in hunks)
+
+class SessionChanges:
+    """The start and last-written state of each file written this session, recorded through the workspace's write listeners."""
+
+    def __init__(self, workspace: Workspace):
+        self.workspace = workspace
+        self.originals: Dict[str, Optional[bytes]] = {}  # Path -> content before the first write, None if it did not exist
+        self.previous: Dict[str, Optional[bytes]] = {}  # Path -> content before the latest write, the same way
+        self.written: Dict[str, Optional[str]] = {}  # Path -> digest of Neo's last write
+        self.writes = 0  # Every write, including repeated ones to the same file
+        workspace.write_listeners.append(self.before_write)
+        workspace.written_listeners.append(self.after_write)
+
+    def before_write(self, normalized_path: str) -> None:
+        self.previous[normalized_path] = read_bytes(normalized_path)
+        if normalized_path not in self.originals:
+            self.originals[normalized_path] = self.previous[normalized_path]
+
+    def after_write(self, normalized_path: str) -> None:
+        self.written[normalized_path] = digest(read_bytes(normalized_path))
+        self.writes += 1
+
+    def patches(self) -> Tuple[List[FilePatch], List[Tuple[str, str]]]:
+        """The change to each file, in path order, and the files that could not be diffed, with why."""
+        patches, skipped = [], []
+        for path in sorted(self.originals):
+            try:
+                patch = self.patch(path, self.originals[path])
+            except UnicodeDecodeError:
+                skipped.append((self.relative(path), "not UTF-8 text"))
+                continue
+            if patch is not None:
+                patches.append(patch)
+        return patches, skipped
+
+    def last_patch(self, normalized_path: str) -> Optional[FilePatch]:
+        """What Neo's latest write to a file did, to the file as it is now; None if Neo has not written it.
+
+        Raises UnicodeDecodeError for a file that is not UTF-8 text.
+        """
+        if normalized_path not in self.previous:
+            return None
+        return self.patch(normalized_path, self.previous[normalized_path])
+
+    def relative(self, normalized_path: str) -> str:
+        return os.path.relpath(normalized_path, self.workspace.root).replace(os.sep, "/")
+
+    def patch(self, normalized_path: str, before: Optional[bytes]) -> Optional[FilePatch]:
+        """The change from 'before' to the file now; None if it didn't exist either time (created and deleted again)."""
+        current = read_bytes(normalized_path)
+        if before is None and current is None:
+            return None
+        old = None if before is None else before.decode("utf-8")
+        new = None if current is None else current.decode("utf-8")
+        relative = self.relative(normalized_path)
+        changed_outside = digest(current) != self.written.get(normalized_path)
+        status = "created" if old is None else "deleted" if new is None else "modified"
+        return FilePatch(relative, unified_diff(relative, old, new), old or "", new or "", changed_outside, status)
@@ -0,0 +1,102 @@
+"""Per-project presets: .neo/project.toml in the workspace root, applied at startup and by /project reload.
+
+    context = ["cmd", "internal/**/*.go", "docs/design.md"]  # Globs from the root; directories are added like /add
+    system_prompt = "Errors are wrapped with fmt.Errorf and %w."  # Appended to the system prompt
+    model = "deepseek-chat"
+    validate_command = "go build ./... && go vet ./..."
+
+Every key is optional. A preset can run a command and steer the model, so the
+first time one is found it is shown and applied only if you agree. The answer is
+remembered per workspace with a hash of the file; an edited file asks again.
+"""
+
+import glob
+import hashlib
+import json
+import os
+import tomllib
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Any, Dict, List, Optional
+
+from neo_core.config import DATA_DIR
+
+PRESET_PATH = os.path.join(".neo", "project.toml")  # Relative to the workspace root
+TRUSTED_PRESETS_FILE = DATA_DIR / "trusted_presets.json"  # Workspace root -> sha256 of the preset you approved
+PROMPT_HEADER = "\n\nPROJECT INSTRUCTIONS (from .neo/project.toml):\n"
+KEYS = {"context": list, "system_prompt": str, "model": str, "validate_command": str}
+
+class PresetError(ValueError):
+    pass
+
+@dataclass
+class Preset:
+    path: str
+    sha256: str
+    context: List[str] = field(default_factory=list)
+    system_prompt: str = ""
+    model: Optional[str] = None
+    validate_command: Optional[str] = None
+
+    @property
+    def root(self) -> str:
+        return os.path.dirname(os.path.dirname(self.path))
+
+    def expand(self, pattern: str) -> List[str]:
+        """The files and directories one context glob matches, sorted; hidden names only if the glob spells them out."""
+        return [os.path.join(self.root, match) for match in sorted(glob.glob(pattern, root_dir=self.root, recursive=True))]
+
+    def matches(self) -> List[str]:
+        """What all the context globs match, in order and without repeats."""
+        return list(dict.fromkeys(match for pattern in self.context for match in self.expand(pattern)))
+
+    def apply_prompt(self, system_prompt: str) -> str:
+        """'system_prompt' with this preset's appendix in place of any earlier one."""
+        base = strip_prompt(system_prompt)
+        return f"{base}{PROMPT_HEADER}{self.system_prompt.strip()}" if self.system_prompt.strip() else base
+
+def strip_prompt(system_prompt: str) -> str:
+    """The system prompt without a preset appendix, e.g. one saved with a resumed session."""
+    return system_prompt.split(PROMPT_HEADER, 1)[0]
+
+def load_preset(root: str) -> Optional[Preset]:
+    """The preset in 'root', or None if there is none; PresetError if it doesn't parse or has unknown keys."""
+    path = os.path.join(root, PRESET_PATH)
+    if not os.path.isfile(path):
+        return None
+    try:
+        raw = Path(path).read_bytes()
+        values: Dict[str, Any] = tomllib.loads(raw.decode("utf-8"))
+    except (OSError, ValueError) as e:  # tomllib.TOMLDecodeError and UnicodeDecodeError both are
+        raise PresetError(f"could not read {PRESET_PATH}: {e}") from e
+    unknown = sorted(set(values) - set(KEYS))
+    if unknown:
+        raise PresetError(f"unknown keys in {PRESET_PATH}: {', '.join(unknown)} (known: {', '.join(KEYS)})")
+    for key, kind in KEYS.items():
+        if key in values and not isinstance(values[key], kind):
+            raise PresetError(f"{key} in {PRESET_PATH} must be a {'list of globs' if kind is list else 'string'}")
+    if not all(isinstance(pattern, str) and pattern.strip() for pattern in values.get("context", [])):
+        raise PresetError(f"context in {PRESET_PATH} must be a list of globs, e.g. [\"src\", \"docs/*.md\"]")
+    for pattern in values.get("context", []):
+        if os.path.isabs(pattern) or ".." in Path(pattern).parts:
+            raise PresetError(f"context glob {pattern!r} in {PRESET_PATH} must stay inside the workspace")
+    return Preset(path, hashlib.sha256(raw).hexdigest(), **{key: value for key, value in values.items() if value != ""})
+
+def _trusted() -> Dict[str, str]:
+    try:
+        with open(TRUSTED_PRESETS_FILE, "r", encoding="utf-8") as f:
+            trusted = json.load(f)
+    except (OSError, ValueError):
+        return {}
+    return trusted if isinstance(trusted, dict) else {}
+
+def is_trusted(preset: Preset) -> bool:
+    return _trusted().get(preset.root) == preset.sha256
+
+def trust(preset: Preset) -> None:
+    """Remember that this workspace's preset, as it is now, may be applied without asking."""
+    trusted = _trusted()
+    trusted[preset.root] = preset.sha256
+    TRUSTED_PRESETS_FILE.parent.mkdir(parents=True, exist_ok=True)
+    with open(TRUSTED_PRESETS_FILE, "w", encoding="utf-8") as f:
+        json.dump(trusted, f, indent=2)
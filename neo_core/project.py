@@ -0,0 +1,61 @@
+"""The project's manifests (go.mod, package.json, pyproject.toml, Cargo.toml), put in context at startup.
+
+Each manifest found in the workspace root is added like a file, so the model knows
+the module path and language version and which dependencies are already in use.
+A line of the facts that matter most comes first, then the file itself, cut to
+MAX_MANIFEST_CHARS: a go.sum-sized require block is not worth its tokens.
+"""
+
+import json
+import os
+import re
+import tomllib
+from typing import Any, Dict, List
+
+MANIFESTS = ("go.mod", "package.json", "pyproject.toml", "Cargo.toml")
+MAX_MANIFEST_CHARS = 2000
+PROJECT_NOTE = "(project metadata, added at startup)"
+GO_DIRECTIVE_RE = re.compile(r"^(module|go|toolchain)\s+(\S+)", re.MULTILINE)
+
+def manifest_paths(root: str) -> List[str]:
+    """The manifests present in 'root', in MANIFESTS order."""
+    return [os.path.join(root, name) for name in MANIFESTS if os.path.isfile(os.path.join(root, name))]
+
+def is_manifest(path: str, root: str) -> bool:
+    return os.path.dirname(path) == root and os.path.basename(path) in MANIFESTS
+
+def manifest_facts(path: str, content: str) -> str:
+    """The one-line summary of a manifest, e.g. "Go module example.com/app, go 1.22"; empty if nothing parses."""
+    name = os.path.basename(path)
+    if name == "go.mod":
+        directives = {key: value.strip('"') for key, value in GO_DIRECTIVE_RE.findall(content)}
+        facts = [f"Go module {directives['module']}" if "module" in directives else "Go module"]
+        facts += [f"{key} {directives[key]}" for key in ("go", "toolchain") if key in directives]
+        return ", ".join(facts)
+    try:
+        data: Dict[str, Any] = json.loads(content) if name == "package.json" else tomllib.loads(content)
+    except ValueError:  # json.JSONDecodeError and tomllib.TOMLDecodeError both are
+        return ""
+    if not isinstance(data, dict):
+        return ""
+    if name == "package.json":
+        node = data.get("engines", {}).get("node") if isinstance(data.get("engines"), dict) else None
+        facts = [f"npm package {data.get('name', '(unnamed)')}", data.get("version"), node and f"node {node}"]
+    elif name == "pyproject.toml":
+        project = data.get("project", {}) or data.get("tool", {}).get("poetry", {})
+        python = project.get("requires-python")
+        facts = [f"Python project {project.get('name', '(unnamed)')}", project.get("version"), python and f"python {python}"]
+    else:
+        package = data.get("package", {})
+        rust = package.get("rust-version")
+        facts = [f"Rust crate {package.get('name', '(workspace)')}", package.get("version"),
+                 package.get("edition") and f"edition {package['edition']}", rust and f"rust {rust}"]
+    return ", ".join(str(fact) for fact in facts if fact)
+
+def manifest_summary(path: str, content: str) -> str:
+    """What goes in context for a manifest: the note, its facts and the file, capped."""
+    facts = manifest_facts(path, content)
+    if len(content) > MAX_MANIFEST_CHARS:
+        cut = content.rfind("\n", 0, MAX_MANIFEST_CHARS) + 1 or MAX_MANIFEST_CHARS
+        content = f"{content[:cut]}... ({len(content) - cut} more characters, use read_file for the rest)\n"
+    return f"{PROJECT_NOTE}\n{facts}\n\n{content}" if facts else f"{PROJECT_NOTE}\n\n{content}"
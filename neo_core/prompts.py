@@ -0,0 +1,65 @@
+"""Reusable message templates (/prompt).
+
+A template is a plain text file named <name>.txt (or .md). Your own are kept under
+~/.config/neo/prompts/; a project can share more in <workspace>/.neo/prompts/,
+which take precedence over yours with the same name.
+"""
+
+import os
+import re
+from pathlib import Path
+from typing import Callable, List, Optional, Tuple
+
+from neo_core.config import DEFAULT_CONFIG_PATH
+
+USER_PROMPTS_DIR = DEFAULT_CONFIG_PATH.parent / "prompts"
+PROJECT_PROMPTS_DIR = os.path.join(".neo", "prompts")  # Relative to the workspace root
+PROMPT_EXTENSIONS = (".txt", ".md")
+PROMPT_NAME_RE = re.compile(r"^[A-Za-z0-9._-]{1,64}$")
+PLACEHOLDER_RE = re.compile(r"\{(input|clipboard)\}")
+
+class PromptLibrary:
+    def __init__(self, workspace_root: str, user_dir: Path = USER_PROMPTS_DIR):
+        self.user_dir = Path(user_dir)
+        self.project_dir = Path(workspace_root, PROJECT_PROMPTS_DIR)
+
+    def list(self) -> List[Tuple[str, Path, str]]:
+        """(name, file, "project" or "user") for every template, project ones shadowing user ones."""
+        found = {}
+        for scope, directory in (("user", self.user_dir), ("project", self.project_dir)):
+            if not directory.is_dir():
+                continue
+            for path in sorted(directory.iterdir()):
+                if path.suffix in PROMPT_EXTENSIONS and path.is_file():
+                    found[path.stem] = (path.stem, path, scope)
+        return sorted(found.values())
+
+    def find(self, name: str) -> Optional[Path]:
+        return next((path for stem, path, _ in self.list() if stem == name), None)
+
+    def load(self, name: str) -> str:
+        path = self.find(name)
+        if path is None:
+            raise FileNotFoundError(f"no prompt template named '{name}'")
+        return path.read_text(encoding="utf-8").strip()
+
+    def save(self, name: str, text: str) -> Path:
+        """Store a template among your own prompts, replacing one with the same name."""
+        if not PROMPT_NAME_RE.match(name):
+            raise ValueError(f"template names may only use letters, digits, '.', '_' and '-': {name!r}")
+        self.user_dir.mkdir(parents=True, exist_ok=True)
+        path = self.user_dir / f"{name}.txt"
+        path.write_text(text.strip() + "\n", encoding="utf-8")
+        return path
+
+def expand_template(template: str, extra: str, clipboard: Callable[[], str]) -> str:
+    """Fill in {input} with 'extra' and {clipboard} with the clipboard's contents.
+
+    Without an {input} placeholder, extra text is appended after a blank line. The
+    clipboard is only read when the template asks for it.
+    """
+    pasted = clipboard() if "{clipboard}" in template else ""
+    text = PLACEHOLDER_RE.sub(lambda m: extra if m.group(1) == "input" else pasted, template)
+    if "{input}" in template or not extra:
+        return text
+    return f"{text}\n\n{extra}"
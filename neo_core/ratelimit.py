@@ -0,0 +1,113 @@
+"""Pacing API requests, so bursts from one turn stay under the provider's limits.
+
+A turn can send several requests close together: the reply, the follow-up after
+tools, a title in the background, an autofix round after validation. Every one
+goes through Agent.create_chat_stream, which holds a slot of the session's one
+RateLimiter while it runs. The limiter lets at most max_concurrent_requests run
+at once and, with requests_per_minute set, starts them from a token bucket that
+refills at that rate and holds a tenth of it, so a burst is spread out rather
+than sent at once. A 429 response pauses every request for its Retry-After.
+"""
+
+import threading
+import time
+from contextlib import contextmanager
+from email.utils import parsedate_to_datetime
+from typing import Callable, Iterator, Optional
+
+BURST_FRACTION = 10  # The bucket holds requests_per_minute / BURST_FRACTION requests, at least one
+DEFAULT_BACKOFF_SECONDS = 10.0  # After a 429 without a usable Retry-After
+MAX_BACKOFF_SECONDS = 120.0
+
+def retry_after(error: Exception) -> float:
+    """Seconds a 429 'error' asks to wait, from its Retry-After (or retry-after-ms) header."""
+    headers = getattr(getattr(error, "response", None), "headers", None) or {}
+    try:
+        if headers.get("retry-after-ms"):
+            return min(float(headers["retry-after-ms"]) / 1000, MAX_BACKOFF_SECONDS)
+        value = headers.get("retry-after")
+        if value:
+            try:
+                seconds = float(value)
+            except ValueError:
+                seconds = parsedate_to_datetime(value).timestamp() - time.time()
+            return min(max(seconds, 0.0), MAX_BACKOFF_SECONDS)
+    except (TypeError, ValueError):
+        pass
+    return DEFAULT_BACKOFF_SECONDS
+
+def is_rate_limited(error: Exception) -> bool:
+    return getattr(error, "status_code", None) == 429
+
+class RateLimiter:
+    """A token bucket and a cap on requests in flight, shared by every request of the session.
+
+    Zero for either limit turns it off; a 429 still pauses requests for its Retry-After.
+    """
+
+    def __init__(self, requests_per_minute: int = 0, max_concurrent: int = 0, clock: Callable[[], float] = time.monotonic):
+        self.requests_per_minute = requests_per_minute
+        self.max_concurrent = max_concurrent
+        self.clock = clock
+        self.capacity = max(1.0, requests_per_minute / BURST_FRACTION) if requests_per_minute else 0.0
+        self._tokens = self.capacity
+        self._updated = clock()
+        self._cond = threading.Condition()
+        self.active = 0  # Requests in flight
+        self.waiting = 0  # Requests queued for a slot
+        self.paused_until = 0.0  # Set by a 429
+
+    def _delay(self, now: float) -> Optional[float]:
+        """Seconds until a request may start: 0 for now, None until one in flight finishes."""
+        if now < self.paused_until:
+            return self.paused_until - now
+        if self.max_concurrent and self.active >= self.max_concurrent:
+            return None
+        if self.requests_per_minute:
+            self._tokens = min(self.capacity, self._tokens + (now - self._updated) * self.requests_per_minute / 60)
+            self._updated = now
+            if self._tokens < 1:
+                return (1 - self._tokens) * 60 / self.requests_per_minute
+        return 0.0
+
+    @contextmanager
+    def slot(self, on_queued: Callable[[int], None] = lambda ahead: None) -> Iterator[None]:
+        """Hold a slot for one request while the block runs, waiting for one first if need be.
+
+        'on_queued' is called once, with the number of requests ahead, when this one has to wait.
+        """
+        with self._cond:
+            ahead = self.active + self.waiting
+            self.waiting += 1
+            queued = False
+            try:
+                while True:
+                    delay = self._delay(self.clock())
+                    if delay == 0:
+                        break
+                    if not queued:
+                        on_queued(ahead)
+                        queued = True
+                    self._cond.wait(delay)
+            finally:
+                self.waiting -= 1
+            if self.requests_per_minute:
+                self._tokens -= 1
+            self.active += 1
+        try:
+            yield
+        finally:
+            with self._cond:
+                self.active -= 1
+                self._cond.notify_all()
+
+    def back_off(self, seconds: float) -> None:
+        """After a 429: no request starts for 'seconds', and the bucket starts empty again."""
+        with self._cond:
+            self.paused_until = max(self.paused_until, self.clock() + seconds)
+            self._tokens = 0.0
+            self._updated = self.paused_until
+            self._cond.notify_all()
+
+def describe_queue(ahead: int) -> str:
+    return f"queued behind {ahead} request{'s' if ahead != 1 else ''}" if ahead else "waiting for the rate limit"
@@ -0,0 +1,144 @@
+"""Redaction of credentials before file contents and tool results reach the API.
+
+Each detector is a regular expression for one credential format. Where only part of
+a match is secret (the value of KEY=value, the token after "Bearer"), a group named
+"secret" marks it and only that part is replaced, so the model still sees what kind
+of value was there.
+
+The values of environment variables named like secrets (*KEY*, *TOKEN*, *SECRET*),
+the API key among them, are caught by value wherever they appear. scrub() applies
+the same to debug logs, transcripts and error messages, and child_env() keeps them
+out of the commands Neo runs.
+"""
+
+import fnmatch
+import os
+import re
+from typing import Dict, Iterable, List, Optional, Pattern, Tuple
+
+from rich.markup import escape
+
+from neo_core.ui import console
+
+PLACEHOLDER = "[REDACTED:{name}]"
+PLACEHOLDER_RE = re.compile(r"\[REDACTED:[A-Za-z0-9_.-]+\]")
+
+DETECTORS: List[Tuple[str, Pattern[str]]] = [
+    ("private_key", re.compile(r"-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----.*?-----END (?:[A-Z0-9]+ )*PRIVATE KEY-----", re.DOTALL)),
+    ("aws_key", re.compile(r"\b(?:AKIA|ASIA)[0-9A-Z]{16}\b")),
+    ("github_token", re.compile(r"\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{40,})\b")),
+    ("slack_token", re.compile(r"\bxox[abposr]-[A-Za-z0-9-]{10,}")),
+    ("api_key", re.compile(r"\bsk-(?:[A-Za-z0-9]+-)*[A-Za-z0-9]{20,}")),
+    ("bearer_token", re.compile(r"\b[Bb]earer[ \t]+(?P<secret>[A-Za-z0-9._~+/-]{20,}=*)")),
+    # KEY=value in .env files and shell scripts, and "key": "value" in JSON and YAML
+    ("env_secret", re.compile(
+        r"""^[ \t]*(?:export[ \t]+)?["']?[A-Za-z0-9_.-]*(?:key|secret|token|passw(?:or)?d|pwd|credentials?)[A-Za-z0-9_.-]*["']?"""
+        r"""[ \t]*[=:][ \t]*["']?(?P<secret>[^\s"'#,]{16,})""", re.IGNORECASE | re.MULTILINE)),
+]
+
+SENSITIVE_NAME_RE = re.compile(r"KEY|TOKEN|SECRET|PASSW(?:OR)?D|CREDENTIAL", re.IGNORECASE)
+MIN_ENV_SECRET_LENGTH = 8  # Shorter values would match ordinary text
+AUTHORIZATION_RE = re.compile(r"""(?i)(["']?(?:authorization|x-api-key|api-key)["']?\s*[:=]\s*["']?)(?:bearer\s+)?[^\s"',}]+""")
+
+# Passed to child processes: what compilers, formatters, git and clipboard tools need, no credentials
+DEFAULT_ENV_ALLOWLIST = [
+    "PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "COLORTERM", "TZ", "LANG", "LANGUAGE", "LC_*",
+    "TMPDIR", "TMP", "TEMP", "XDG_*", "DISPLAY", "WAYLAND_DISPLAY", "XAUTHORITY", "DBUS_SESSION_BUS_ADDRESS", "SSH_AUTH_SOCK",
+    "GO*", "CGO_*", "NODE_PATH", "NVM_DIR", "PYTHONPATH", "VIRTUAL_ENV", "CONDA_PREFIX", "CARGO_HOME", "RUSTUP_HOME", "JAVA_HOME",
+    "SYSTEMROOT", "SYSTEMDRIVE", "COMSPEC", "PATHEXT", "WINDIR", "APPDATA", "LOCALAPPDATA", "USERPROFILE", "PROGRAMDATA",
+]
+
+def is_sensitive_name(name: str) -> bool:
+    return bool(SENSITIVE_NAME_RE.search(name))
+
+def env_secrets() -> Dict[str, str]:
+    """Value -> name of the environment variables that are named like secrets, longest value first."""
+    found = {value: name for name, value in os.environ.items() if is_sensitive_name(name) and len(value) >= MIN_ENV_SECRET_LENGTH}
+    return dict(sorted(found.items(), key=lambda item: -len(item[0])))
+
+def scrub(text: str) -> str:
+    """Remove secret environment values and authorization headers from text bound for a log or the screen."""
+    for value, name in env_secrets().items():
+        text = text.replace(value, PLACEHOLDER.format(name=name))
+    return AUTHORIZATION_RE.sub(lambda match: match.group(1) + PLACEHOLDER.format(name="authorization"), text)
+
+def child_env(allowlist: Iterable[str] = (), extra: Optional[Dict[str, str]] = None) -> Dict[str, str]:
+    """The environment for a command Neo runs: allowed variables not named like secrets, plus 'extra' as given.
+
+    'allowlist' adds glob patterns to DEFAULT_ENV_ALLOWLIST. 'extra' is set explicitly in the config (an MCP
+    server's env), so it is passed unfiltered.
+    """
+    patterns = DEFAULT_ENV_ALLOWLIST + list(allowlist)
+    env = {name: value for name, value in os.environ.items()
+           if not is_sensitive_name(name) and any(fnmatch.fnmatchcase(name.upper(), pattern.upper()) for pattern in patterns)}
+    return {**env, **(extra or {})}
+
+def looks_random(value: str) -> bool:
+    """Whether an assigned value looks like a credential rather than a placeholder or a word.
+
+    Secrets mix at least two of lowercase, uppercase and digits; values such as
+    "your-api-key-here" or "${API_KEY}" do not.
+    """
+    if PLACEHOLDER_RE.search(value) or value.startswith(("$", "<", "{", "%")):
+        return False
+    classes = sum((any(c.islower() for c in value), any(c.isupper() for c in value), any(c.isdigit() for c in value)))
+    return classes >= 2
+
+def compile_patterns(patterns: Dict[str, str]) -> List[Tuple[str, Pattern[str]]]:
+    """Compile custom detectors from the config file; raises ValueError naming the bad one."""
+    compiled = []
+    for name, pattern in patterns.items():
+        try:
+            compiled.append((name, re.compile(pattern, re.MULTILINE)))
+        except re.error as e:
+            raise ValueError(f"redact_patterns[{name!r}] is not a valid regular expression: {e}") from e
+    return compiled
+
+def describe_counts(counts: Dict[str, int]) -> str:
+    total = sum(counts.values())
+    kinds = ", ".join(f"{name} ×{n}" for name, n in counts.items())
+    return f"{total} secret{'s' if total != 1 else ''} ({kinds})"
+
+class Redactor:
+    """Replaces credentials with placeholders, announcing how many it found in each source."""
+
+    def __init__(self, enabled: bool = True, patterns: Optional[Dict[str, str]] = None):
+        self.enabled = enabled
+        self.detectors = DETECTORS + compile_patterns(patterns or {})
+        self.total = 0  # Secrets redacted this session
+
+    def find(self, text: str) -> Tuple[str, Dict[str, int]]:
+        """The redacted text, and how many matches each detector replaced."""
+        counts: Dict[str, int] = {}
+        if not self.enabled or not text:
+            return text, counts
+        for name, pattern in self.detectors:
+            def replace(match: "re.Match[str]") -> str:
+                secret = "secret" in pattern.groupindex and match.group("secret")
+                if name == "env_secret" and not looks_random(secret):
+                    return match.group(0)
+                counts[name] = counts.get(name, 0) + 1
+                if not secret:
+                    return PLACEHOLDER.format(name=name)
+                start, end = match.span("secret")
+                offset = match.start()
+                return match.group(0)[:start - offset] + PLACEHOLDER.format(name=name) + match.group(0)[end - offset:]
+            text = pattern.sub(replace, text)
+        for value, name in env_secrets().items():
+            if value in text:
+                counts[f"${name}"] = counts.get(f"${name}", 0) + text.count(value)
+                text = text.replace(value, PLACEHOLDER.format(name=name))
+        return text, counts
+
+    def redact(self, text: str, source: Optional[str] = None) -> str:
+        """Redact 'text', printing a notice naming 'source' if anything was found."""
+        redacted, counts = self.find(text)
+        self.total += sum(counts.values())
+        if counts and source:
+            console.print(f"[matrix.warning]🔒 REDACTED[/matrix.warning] [matrix.dim]{describe_counts(counts)} in {escape(source)}[/matrix.dim]")
+        if not self.enabled and source:
+            leaked = sorted(name for value, name in env_secrets().items() if value in text)
+            if leaked:
+                console.print(f"[matrix.error]⚠ SECRET IN CONTEXT:[/matrix.error] [matrix.dim]{escape(source)} contains the value of "
+                              f"{escape(', '.join('$' + name for name in leaked))}, and redact_secrets is off[/matrix.dim]")
+        return redacted
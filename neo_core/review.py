@@ -0,0 +1,214 @@
+"""Code review of a git diff (/review and `neo review`).
+
+The diff is split per file and sent in chunks of at most MAX_CHUNK_CHARS, each in a
+request of its own with no tools and none of the conversation. The model answers
+with a JSON list of findings, which are merged across chunks and checked against
+the diff: a finding about a file that is not in its chunk is dropped, and its line
+is matched to the hunk it falls in so the hunk can be shown above the comment.
+"""
+
+import json
+import re
+import sys
+from dataclasses import asdict, dataclass, field
+from typing import Any, Callable, Dict, Iterable, List, Optional, Tuple
+
+from rich.markup import escape
+from rich.panel import Panel
+
+from neo_core.api import create_client
+from neo_core.config import NeoConfig
+from neo_core.gitops import GitError, Repository
+from neo_core.loop import collect_stream
+from neo_core.redact import Redactor, scrub
+from neo_core.ui import console
+
+MAX_CHUNK_CHARS = 30_000  # Diff text per review request; a larger file is split between its hunks
+SEVERITIES = ("error", "warning", "info")  # The levels CI annotations use, most serious first
+SEVERITY_STYLES = {"error": "matrix.error", "warning": "matrix.warning", "info": "matrix.accent"}
+FILE_HEADER_RE = re.compile(r"^diff --git a/(.*) b/(.*)$", re.MULTILINE)
+HUNK_HEADER_RE = re.compile(r"^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@")
+JSON_LIST_RE = re.compile(r"\[.*\]", re.DOTALL)
+
+REVIEW_PROMPT = """Review the change below as an experienced reviewer would. Look for bugs, security
+problems, race conditions, unhandled errors, and code that is hard to maintain; skip style nits that
+a formatter would fix. Comment only on lines the diff adds or changes, using their line numbers in
+the new version of the file (the + side of each hunk header).
+
+Reply with only a JSON list, and an empty list if there is nothing worth saying:
+[{{"file": "path/as/in/the/diff", "line": 42, "severity": "error|warning|info", "comment": "..."}}]
+"error" is for bugs and security problems, "warning" for likely problems, "info" for suggestions.
+
+{diff}"""
+
+@dataclass
+class Hunk:
+    start: int  # First line in the new version of the file
+    length: int
+    text: str
+
+    def covers(self, line: int) -> bool:
+        return self.start <= line < self.start + max(self.length, 1)
+
+@dataclass
+class FileDiff:
+    path: str
+    header: str  # From "diff --git" up to the first hunk
+    hunks: List[Hunk] = field(default_factory=list)
+
+    @property
+    def text(self) -> str:
+        return self.header + "".join(hunk.text for hunk in self.hunks)
+
+    def hunk_for(self, line: int) -> Optional[Hunk]:
+        return next((hunk for hunk in self.hunks if hunk.covers(line)), None)
+
+@dataclass
+class Finding:
+    file: str
+    line: int
+    severity: str
+    comment: str
+
+def parse_diff(diff: str) -> List[FileDiff]:
+    """Split a git diff into files and their hunks."""
+    files = []
+    starts = [match.start() for match in FILE_HEADER_RE.finditer(diff)] + [len(diff)]
+    for start, end in zip(starts, starts[1:]):
+        section = diff[start:end]
+        match = FILE_HEADER_RE.match(section)
+        file_diff = FileDiff(match.group(2), "")
+        lines = section.splitlines(keepends=True)
+        for line in lines:
+            hunk_header = HUNK_HEADER_RE.match(line)
+            if hunk_header:
+                file_diff.hunks.append(Hunk(int(hunk_header.group(1)), int(hunk_header.group(2) or 1), line))
+            elif file_diff.hunks:
+                file_diff.hunks[-1].text += line
+            else:
+                file_diff.header += line
+        files.append(file_diff)
+    return files
+
+def chunk_files(files: List[FileDiff], max_chars: int = MAX_CHUNK_CHARS) -> List[List[FileDiff]]:
+    """Group whole files into chunks, splitting a file that alone exceeds the limit between hunks."""
+    pieces: List[FileDiff] = []
+    for file_diff in files:
+        if len(file_diff.text) <= max_chars:
+            pieces.append(file_diff)
+            continue
+        part = FileDiff(file_diff.path, file_diff.header)
+        for hunk in file_diff.hunks:
+            if part.hunks and len(part.text) + len(hunk.text) > max_chars:
+                pieces.append(part)
+                part = FileDiff(file_diff.path, file_diff.header)
+            part.hunks.append(hunk)  # A single hunk over the limit is still sent whole
+        pieces.append(part)
+
+    chunks: List[List[FileDiff]] = []
+    size = 0
+    for piece in pieces:
+        if chunks and size + len(piece.text) <= max_chars:
+            chunks[-1].append(piece)
+            size += len(piece.text)
+        else:
+            chunks.append([piece])
+            size = len(piece.text)
+    return chunks
+
+def parse_findings(reply: str, files: Dict[str, FileDiff]) -> List[Finding]:
+    """The findings in a reply; entries that are malformed or name a file outside the chunk are dropped."""
+    match = JSON_LIST_RE.search(reply)
+    if not match:
+        return []
+    try:
+        entries = json.loads(match.group(0))
+    except json.JSONDecodeError:
+        return []
+    findings = []
+    for entry in entries if isinstance(entries, list) else []:
+        if not isinstance(entry, dict) or not isinstance(entry.get("comment"), str) or entry.get("file") not in files:
+            continue
+        try:
+            line = int(entry.get("line", 0))
+        except (TypeError, ValueError):
+            line = 0
+        severity = str(entry.get("severity", "info")).lower()
+        findings.append(Finding(entry["file"], line, severity if severity in SEVERITIES else "info", entry["comment"].strip()))
+    return findings
+
+def review_diff(diff: str, create_stream: Callable[..., Iterable[Any]], model: str,
+                on_chunk: Optional[Callable[[int, int], None]] = None) -> Tuple[List[Finding], List[FileDiff]]:
+    """Review a diff chunk by chunk; returns the merged findings, ordered by file and line, and the parsed files."""
+    files = parse_diff(diff)
+    chunks = chunk_files(files)
+    findings: List[Finding] = []
+    for i, chunk in enumerate(chunks, 1):
+        if on_chunk:
+            on_chunk(i, len(chunks))
+        reply, _ = collect_stream(create_stream(
+            model=model,
+            messages=[{"role": "user", "content": REVIEW_PROMPT.format(diff="".join(piece.text for piece in chunk))}],
+            max_completion_tokens=8000,
+        ), lambda event: None)
+        findings.extend(parse_findings(reply, {piece.path: piece for piece in chunk}))
+    order = {file_diff.path: i for i, file_diff in enumerate(files)}
+    findings.sort(key=lambda f: (order.get(f.file, len(order)), f.line))
+    return findings, files
+
+def findings_json(findings: List[Finding]) -> str:
+    return json.dumps({"findings": [asdict(finding) for finding in findings]}, indent=2)
+
+def show_findings(findings: List[Finding], files: List[FileDiff]) -> None:
+    """Findings grouped by file, each under the diff hunk its line falls in."""
+    by_path = {file_diff.path: file_diff for file_diff in files}
+    counts = {severity: sum(f.severity == severity for f in findings) for severity in SEVERITIES}
+    summary = ", ".join(f"{n} {severity}" for severity, n in counts.items() if n) or "no findings"
+    console.print(f"[matrix.primary]> REVIEW:[/matrix.primary] [matrix.dim]{len(files)} file{'s' if len(files) != 1 else ''}, {summary}[/matrix.dim]")
+    current = None
+    shown_hunk = None
+    for finding in findings:
+        if finding.file != current:
+            current = finding.file
+            shown_hunk = None
+            console.print(f"\n[matrix.accent]{escape(finding.file)}[/matrix.accent]")
+        hunk = by_path[finding.file].hunk_for(finding.line)
+        if hunk is not None and hunk is not shown_hunk:
+            styled = []
+            for line in hunk.text.rstrip("\n").splitlines():
+                style = "matrix.success" if line.startswith("+") else "matrix.error" if line.startswith("-") else "matrix.accent" if line.startswith("@@") else "matrix.dim"
+                styled.append(f"[{style}]{escape(line)}[/{style}]")
+            console.print(Panel("\n".join(styled), border_style="matrix.border"))
+            shown_hunk = hunk
+        style = SEVERITY_STYLES[finding.severity]
+        location = f"line {finding.line}" if finding.line else "file"
+        console.print(f"  [{style}]{finding.severity.upper()}[/{style}] [matrix.dim]{location}:[/matrix.dim] {escape(finding.comment)}")
+    console.print()
+
+def run_review(config: NeoConfig, workdir: str, ref: Optional[str], as_json: bool) -> int:
+    """`neo review`: review the diff and print the findings. Exits 1 if any is an error, 2 if the review failed."""
+    def fail(message: str) -> int:
+        print(f"neo review: {scrub(message)}", file=sys.stderr)
+        return 2
+
+    console.quiet = console.quiet or as_json  # With --json, stdout carries the findings and nothing else
+    try:
+        client = create_client(config)
+        diff = Repository(workdir).diff(ref)
+    except (GitError, ValueError) as e:
+        return fail(str(e))
+    diff = Redactor(config.redact_secrets, config.redact_patterns).redact(diff, "diff")
+    if not diff.strip():
+        findings, files = [], []
+    else:
+        try:
+            findings, files = review_diff(diff, lambda **request: client.chat.completions.create(stream=True, **request),
+                                          config.resolved_model(),
+                                          lambda i, n: print(f"neo review: chunk {i} of {n}", file=sys.stderr) if n > 1 else None)
+        except Exception as e:
+            return fail(f"the review request failed: {e}")
+    if as_json:
+        print(findings_json(findings))
+    else:
+        show_findings(findings, files)
+    return 1 if any(finding.severity == "error" for finding in findings) else 0
@@ -0,0 +1,63 @@
+"""Choosing the model per message when "model" is "auto" (/model auto).
+
+A message goes to the coder model when the previous turn used tools, when it names
+a file that is in context, or when one of the route patterns matches it, which by
+default catch code blocks, file names and words like "fix" or "refactor".
+Everything else goes to the chat model, which is cheaper and better at prose.
+"""
+
+import os
+import re
+from dataclasses import dataclass
+from typing import List
+
+from neo_core.config import NeoConfig
+from neo_core.conversation import Conversation
+
+AUTO = "auto"
+DEFAULT_ROUTE_PATTERNS = [
+    r"```",
+    r"\b[\w/-]+\.(?:go|py|js|jsx|ts|tsx|rs|java|kt|c|h|cc|cpp|rb|php|cs|swift|sh|sql|json|toml|ya?ml|mod)\b",
+    r"(?i)\b(?:fix|debug|refactor|implement|rewrite|compile|build|test|bug|error|panic|stack ?trace|function|method|struct|class)\b",
+]
+
+@dataclass
+class Route:
+    model: str
+    reason: str  # Why this model, for the stats line; empty when the model is pinned
+
+def route_patterns(config: NeoConfig) -> List[str]:
+    return config.route_patterns or DEFAULT_ROUTE_PATTERNS
+
+def mentions_file(message: str, paths: List[str]) -> str:
+    """The first file in context that the message names, by its file name; empty if none."""
+    for path in paths:
+        name = os.path.basename(path)
+        if re.search(rf"(?<![\w.-]){re.escape(name)}(?![\w-])", message):
+            return name
+    return ""
+
+def previous_turn_used_tools(conversation: Conversation) -> bool:
+    history = conversation.history()
+    for msg in reversed(history):
+        if msg["role"] == "user":
+            return False
+        if msg.get("tool_calls"):
+            return True
+    return False
+
+def choose_model(config: NeoConfig, conversation: Conversation, message: str) -> Route:
+    """The model for the next message, before it is added to the conversation."""
+    if config.model != AUTO:
+        return Route(config.resolved_model(), "")
+    coder, chat = config.resolved_coder_model(), config.resolved_chat_model()
+    if previous_turn_used_tools(conversation):
+        return Route(coder, "the last turn used tools")
+    name = mentions_file(message, conversation.files())
+    if name:
+        return Route(coder, f"mentions {name}")
+    for pattern in route_patterns(config):
+        match = re.search(pattern, message)
+        if match:
+            return Route(coder, f"matched {match.group(0).strip()[:30]!r}")
+    return Route(chat, "no code signals")
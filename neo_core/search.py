@@ -0,0 +1,76 @@
+"""Searching the conversation and saved sessions (/search).
+
+A plain linear scan: the current conversation first, then every session file,
+newest first, each read and searched in turn. Hits are yielded as they are
+found, so the first ones show while a large sessions directory is still being
+read. Only what was said is searched, your messages and Neo's replies, not the
+file contents in context or tool results. Messages are numbered as /forget
+numbers them.
+"""
+
+import json
+import re
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any, Dict, Iterable, Iterator, List, Optional, Tuple
+
+SEARCHED_ROLES = ("user", "assistant")
+SNIPPET_CONTEXT = 40  # Characters kept on each side of a match
+CURRENT = "current"  # The source of hits in the conversation being had
+
+@dataclass
+class SearchHit:
+    source: str  # CURRENT, or the session file's name without .json
+    path: Optional[Path]  # The session file; None for the current conversation
+    branch: Optional[str]  # The branch the message is on, for sessions saved with several
+    number: int  # In the history, from 1, as /forget counts
+    role: str
+    snippet: Tuple[str, str, str]  # Text before the match, the match, and text after it
+
+def compile_query(query: str, regex: bool = False) -> "re.Pattern[str]":
+    """Case-insensitive; the text is literal unless 'regex'. Raises re.error for a bad pattern."""
+    return re.compile(query if regex else re.escape(query), re.IGNORECASE)
+
+def _one_line(text: str) -> str:
+    return re.sub(r"\s+", " ", text)
+
+def snippet(text: str, match: "re.Match[str]", context: int = SNIPPET_CONTEXT) -> Tuple[str, str, str]:
+    """The match with some text either side, on one line, with … where it was cut."""
+    start, end = match.span()
+    before = ("…" if start > context else "") + _one_line(text[max(start - context, 0):start]).lstrip()
+    after = _one_line(text[end:end + context]).rstrip() + ("…" if end + context < len(text) else "")
+    return before, _one_line(text[start:end]), after
+
+def search_messages(messages: Iterable[Dict[str, Any]], pattern: "re.Pattern[str]", source: str,
+                    path: Optional[Path] = None, branch: Optional[str] = None) -> Iterator[SearchHit]:
+    """A hit for the first match in each searched message; system messages are skipped but not numbered."""
+    history = [msg for msg in messages if msg.get("role") != "system"]
+    for number, msg in enumerate(history, 1):
+        content = msg.get("content")
+        if msg.get("role") not in SEARCHED_ROLES or not isinstance(content, str):
+            continue
+        match = pattern.search(content)
+        if match:
+            yield SearchHit(source, path, branch, number, msg["role"], snippet(content, match))
+
+def session_files(directory: Path) -> List[Path]:
+    """Saved sessions, newest first."""
+    return sorted(directory.glob("*.json"), reverse=True) if directory.is_dir() else []
+
+def search_session(path: Path, pattern: "re.Pattern[str]") -> Iterator[SearchHit]:
+    """Hits in one saved session, its active branch first, then the others it was saved with.
+
+    A file that can't be read or isn't a session yields nothing.
+    """
+    try:
+        with open(path, "r", encoding="utf-8") as f:
+            session = json.load(f)
+    except (OSError, ValueError):
+        return
+    if not isinstance(session, dict):
+        return
+    active = session.get("branch")
+    yield from search_messages(session.get("messages") or [], pattern, path.stem, path, active)
+    for name, snapshot in sorted((session.get("branches") or {}).items()):
+        if isinstance(snapshot, dict):
+            yield from search_messages(snapshot.get("messages") or [], pattern, path.stem, path, name)
@@ -0,0 +1,146 @@
+"""Timing and throughput figures for responses, their totals for the session, and how long ago messages were."""
+
+import time
+from collections import Counter
+from dataclasses import dataclass, field
+from typing import Dict, List, Optional, Set
+
+from neo_core.config import MODEL_PRICING
+from neo_core.tokens import estimate_string
+
+GAP_SECONDS = 15 * 60  # A pause at least this long is marked in /when and transcripts
+
+def describe_span(seconds: float) -> str:
+    """A duration in the largest whole unit: "42 minutes", "3 hours", "2 days"."""
+    for unit, size in (("day", 86_400), ("hour", 3600), ("minute", 60)):
+        if seconds >= size:
+            count = int(seconds // size)
+            return f"{count} {unit}{'s' if count != 1 else ''}"
+    return f"{int(seconds)} second{'s' if int(seconds) != 1 else ''}"
+
+def describe_age(ts: Optional[float], now: Optional[float] = None) -> str:
+    """How long ago a message was added, e.g. "42 minutes ago"; "-" for one saved before messages had times."""
+    if ts is None:
+        return "-"
+    seconds = (now or time.time()) - ts
+    return "just now" if seconds < 60 else f"{describe_span(seconds)} ago"
+
+def describe_time(ts: Optional[float], now: Optional[float] = None) -> str:
+    """The clock time of a message, with the date unless it was today, to match against git log."""
+    if ts is None:
+        return "-"
+    same_day = time.localtime(ts)[:3] == time.localtime(now or time.time())[:3]
+    return time.strftime("%H:%M:%S" if same_day else "%Y-%m-%d %H:%M:%S", time.localtime(ts))
+
+def gap_separator(previous: Optional[float], ts: Optional[float]) -> Optional[str]:
+    """"— 42 minutes later —" between two messages at least GAP_SECONDS apart, else None."""
+    if previous is None or ts is None or ts - previous < GAP_SECONDS:
+        return None
+    return f"— {describe_span(ts - previous)} later —"
+
+@dataclass
+class ResponseTiming:
+    """Collected while one response streams: time to first token, generated text and time spent in tools."""
+    started: float = field(default_factory=time.monotonic)
+    first_token: Optional[float] = None
+    finished: Optional[float] = None
+    generated: List[str] = field(default_factory=list)  # The streamed text, counted as a whole when asked
+    tool_seconds: float = 0.0
+
+    def on_token(self, text: Optional[str]) -> None:
+        if self.first_token is None:
+            self.first_token = time.monotonic()
+        if text:
+            self.generated.append(text)
+
+    def finish(self) -> None:
+        self.finished = time.monotonic()
+
+    @property
+    def time_to_first_token(self) -> Optional[float]:
+        return None if self.first_token is None else self.first_token - self.started
+
+    @property
+    def total_seconds(self) -> float:
+        return (self.finished or time.monotonic()) - self.started
+
+    @property
+    def tokens(self) -> int:
+        return estimate_string("".join(self.generated))
+
+    @property
+    def tokens_per_second(self) -> Optional[float]:
+        # Time spent waiting on tools or on the first token is not generation time
+        generating = self.total_seconds - self.tool_seconds - (self.time_to_first_token or 0)
+        return self.tokens / generating if generating > 0 and self.tokens else None
+
+    def summary(self) -> str:
+        parts = []
+        if self.time_to_first_token is not None:
+            parts.append(f"first token {self.time_to_first_token:.1f}s")
+        parts.append(f"{self.total_seconds:.1f}s total")
+        parts.append(f"~{self.tokens} tokens")
+        if self.tokens_per_second:
+            parts.append(f"{self.tokens_per_second:.0f} tok/s")
+        if self.tool_seconds:
+            parts.append(f"tools {self.tool_seconds:.1f}s")
+        return " · ".join(parts)
+
+@dataclass
+class SessionStats:
+    """Totals for the session, updated by the agent, the tool registry and /add."""
+    responses: int = 0
+    total_seconds: float = 0.0
+    first_token_seconds: float = 0.0
+    generated_tokens: int = 0
+    tool_seconds: float = 0.0
+    turns: int = 0  # User messages sent
+    requests: int = 0
+    prompt_tokens: int = 0  # Estimated from the messages sent with each request
+    cached_replies: int = 0  # Turns replayed from the response cache, which sent no request
+    tool_calls: Counter = field(default_factory=Counter)  # By tool name
+    files_added: Dict[str, int] = field(default_factory=dict)  # Path -> bytes, via /add
+    files_created: Set[str] = field(default_factory=set)
+    files_edited: Set[str] = field(default_factory=set)
+    files_deleted: Set[str] = field(default_factory=set)
+    turns_by_model: Counter = field(default_factory=Counter)  # Responses per model, which routing varies
+    prompt_tokens_by_model: Counter = field(default_factory=Counter)
+    generated_tokens_by_model: Counter = field(default_factory=Counter)
+
+    def record_request(self, prompt_tokens: int, model: str = "") -> None:
+        self.requests += 1
+        self.prompt_tokens += prompt_tokens
+        self.prompt_tokens_by_model[model] += prompt_tokens
+
+    def record(self, timing: ResponseTiming, model: str = "") -> None:
+        self.responses += 1
+        self.total_seconds += timing.total_seconds
+        self.first_token_seconds += timing.time_to_first_token or 0
+        self.generated_tokens += timing.tokens
+        self.tool_seconds += timing.tool_seconds
+        self.turns_by_model[model] += 1
+        self.generated_tokens_by_model[model] += timing.tokens
+
+    @property
+    def average_first_token(self) -> Optional[float]:
+        return self.first_token_seconds / self.responses if self.responses else None
+
+    @staticmethod
+    def prompt_cost(model: str, prompt_tokens: int) -> Optional[float]:
+        """USD at list prices for sending 'prompt_tokens', or None when the model's pricing is unknown."""
+        if model not in MODEL_PRICING:
+            return None
+        return prompt_tokens * MODEL_PRICING[model][0] / 1_000_000
+
+    def estimated_cost(self, model: str) -> Optional[float]:
+        """USD at list prices, each model's tokens at its own (tokens without a model at 'model's).
+
+        None when the pricing of any of them is unknown.
+        """
+        cost = 0.0
+        for used in set(self.prompt_tokens_by_model) | set(self.generated_tokens_by_model):
+            pricing = MODEL_PRICING.get(used or model)
+            if pricing is None:
+                return None
+            cost += (self.prompt_tokens_by_model[used] * pricing[0] + self.generated_tokens_by_model[used] * pricing[1]) / 1_000_000
+        return cost
@@ -0,0 +1,89 @@
+"""Parse checks for structured files the tools write, by extension: JSON, TOML and, with PyYAML, YAML.
+
+A checker takes the file's text and returns the first SyntaxProblem, or None if
+it parses. Only extensions in CHECKERS are checked, so writes of other files cost
+a dictionary lookup. register() adds or replaces a checker; passing None turns an
+extension off.
+"""
+
+import json
+import os
+import re
+import tomllib
+from dataclasses import dataclass
+from typing import Callable, Dict, Optional
+
+try:
+    import yaml
+except ImportError:
+    yaml = None
+
+@dataclass
+class SyntaxProblem:
+    language: str  # "JSON", "TOML" or "YAML"
+    message: str
+    line: int = 0  # From 1; 0 when the parser did not say
+    column: int = 0
+
+    def describe(self) -> str:
+        where = f" at line {self.line}, column {self.column}" if self.line else ""
+        return f"{self.message}{where}"
+
+Checker = Callable[[str], Optional[SyntaxProblem]]
+
+def check_json(content: str) -> Optional[SyntaxProblem]:
+    try:
+        json.loads(content)
+    except json.JSONDecodeError as e:
+        return SyntaxProblem("JSON", e.msg, e.lineno, e.colno)
+    return None
+
+TOML_POSITION = re.compile(r"\s*\(at line (\d+), column (\d+)\)$")
+
+def check_toml(content: str) -> Optional[SyntaxProblem]:
+    try:
+        tomllib.loads(content)
+    except tomllib.TOMLDecodeError as e:
+        message = str(e)
+        position = TOML_POSITION.search(message)
+        if position:
+            return SyntaxProblem("TOML", message[:position.start()], int(position.group(1)), int(position.group(2)))
+        return SyntaxProblem("TOML", message)
+    return None
+
+if yaml is not None:
+    class TaggedLoader(yaml.SafeLoader):
+        """SafeLoader that accepts application tags, like CloudFormation's !Ref, without constructing them."""
+
+    TaggedLoader.add_multi_constructor("!", lambda loader, suffix, node: None)
+
+def check_yaml(content: str) -> Optional[SyntaxProblem]:
+    try:
+        for _ in yaml.load_all(content, Loader=TaggedLoader):  # Every document of a multi-document file
+            pass
+    except yaml.YAMLError as e:
+        mark = getattr(e, "problem_mark", None)
+        message = getattr(e, "problem", None) or str(e)
+        if mark is None:
+            return SyntaxProblem("YAML", message)
+        return SyntaxProblem("YAML", message, mark.line + 1, mark.column + 1)
+    return None
+
+CHECKERS: Dict[str, Checker] = {".json": check_json, ".toml": check_toml}
+if yaml is not None:
+    CHECKERS.update({".yaml": check_yaml, ".yml": check_yaml})
+
+def register(extension: str, checker: Optional[Checker]) -> None:
+    """Check files ending in 'extension' (".ext") with 'checker', or stop checking them if it is None."""
+    if checker is None:
+        CHECKERS.pop(extension.lower(), None)
+    else:
+        CHECKERS[extension.lower()] = checker
+
+def checker_for(path: str) -> Optional[Checker]:
+    return CHECKERS.get(os.path.splitext(path)[1].lower())
+
+def check_syntax(path: str, content: str) -> Optional[SyntaxProblem]:
+    """The first problem parsing 'content' as the language of 'path', or None if it parses or is not checked."""
+    checker = checker_for(path)
+    return checker(content) if checker else None
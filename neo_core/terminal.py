@@ -0,0 +1,132 @@
+"""Leaving the terminal usable when Neo stops on a signal or an uncaught error.
+
+The terminal settings are saved at startup. SIGTERM and SIGHUP are turned into a
+Terminated exception, like Ctrl+C is into KeyboardInterrupt, so they unwind through
+main's cleanup (saving the session, closing the debug log and transcript) instead
+of killing the process where it stands, in the middle of an animation or with the
+prompt in raw mode. restore_terminal() then puts back the saved settings, the
+cursor, the colors and the main screen.
+
+SIGWINCH, where there is one, marks the console's size stale so the next line is
+wrapped for the new width; see neo_core.ui.TerminalSize.
+
+EscapeKey notices Esc while tools run, for cancelling them; see neo_core.cancel.
+"""
+
+import os
+import select
+import signal
+import sys
+import threading
+from typing import Any, Optional
+
+from neo_core.ui import console, terminal_size
+
+try:
+    import termios
+    import tty
+except ImportError:  # Windows
+    termios = tty = None
+
+RESET_SEQUENCE = "\033[0m\033[?25h\033[?1049l"  # Attributes off, cursor shown, alternate screen left
+
+_saved_attributes: Optional[Any] = None
+
+class Terminated(SystemExit):
+    """A termination signal arrived; exits with the conventional 128 + signal number."""
+
+    def __init__(self, signum: int):
+        super().__init__(128 + signum)
+        self.signal_name = signal.Signals(signum).name
+
+def save_terminal_state() -> None:
+    global _saved_attributes
+    if termios is not None and sys.stdin.isatty():
+        try:
+            _saved_attributes = termios.tcgetattr(sys.stdin.fileno())
+        except termios.error:
+            _saved_attributes = None
+
+def restore_terminal() -> None:
+    """Undo raw mode and stuck styles; a terminal that is already gone (SIGHUP) is left alone."""
+    if termios is not None and _saved_attributes is not None:
+        try:
+            termios.tcsetattr(sys.stdin.fileno(), termios.TCSADRAIN, _saved_attributes)
+        except (termios.error, OSError, ValueError):
+            pass
+    if sys.stdout.isatty():
+        try:
+            sys.stdout.write(RESET_SEQUENCE)
+            sys.stdout.flush()
+        except (OSError, ValueError):
+            pass
+
+def _terminate(signum: int, frame: Any) -> None:
+    # A second signal while cleaning up must not cut the cleanup short
+    for name in ("SIGTERM", "SIGHUP"):
+        if hasattr(signal, name):
+            signal.signal(getattr(signal, name), signal.SIG_IGN)
+    raise Terminated(signum)
+
+def _resized(signum: int, frame: Any) -> None:
+    terminal_size.mark_stale()
+
+def install_resize_handler() -> None:
+    """Follow terminal resizes (no SIGWINCH on Windows); also re-installed after every prompt."""
+    if hasattr(signal, "SIGWINCH") and threading.current_thread() is threading.main_thread():
+        signal.signal(signal.SIGWINCH, _resized)
+        terminal_size.reinstall = install_resize_handler
+    terminal_size.mark_stale()  # The console starts at 120 columns, whatever the terminal has
+
+def install_signal_handlers() -> None:
+    """Raise Terminated on SIGTERM and SIGHUP; SIGINT already raises KeyboardInterrupt. Follow resizes."""
+    for name in ("SIGTERM", "SIGHUP"):
+        if hasattr(signal, name):  # There is no SIGHUP on Windows
+            signal.signal(getattr(signal, name), _terminate)
+    install_resize_handler()
+
+class EscapeKey:
+    """Whether Esc was pressed, without waiting for Enter; never, when stdin is not a terminal or on Windows.
+
+    stdin is in cbreak mode until close(): no echo and no line buffering, while Ctrl+C
+    still raises KeyboardInterrupt. Other keys typed meanwhile are read and dropped.
+    """
+
+    def __init__(self) -> None:
+        self._fd: Optional[int] = None
+        self._saved: Optional[Any] = None
+        if termios is None or not sys.stdin.isatty():
+            return
+        try:
+            fd = sys.stdin.fileno()
+            self._saved = termios.tcgetattr(fd)
+            tty.setcbreak(fd, termios.TCSANOW)
+            self._fd = fd
+        except (termios.error, OSError, ValueError):
+            self._saved = None
+
+    def pressed(self) -> bool:
+        """Reads what was typed since the last call; True if it was Esc alone, not a key like an arrow that starts with it."""
+        if self._fd is None:
+            return False
+        try:
+            readable, _, _ = select.select([self._fd], [], [], 0)
+            typed = os.read(self._fd, 64) if readable else b""
+        except OSError:
+            return False
+        return bool(typed) and not typed.strip(b"\x1b")
+
+    def close(self) -> None:
+        if self._fd is not None:
+            try:
+                termios.tcsetattr(self._fd, termios.TCSADRAIN, self._saved)
+            except (termios.error, OSError, ValueError):
+                pass
+            self._fd = None
+
+def say_goodbye(reason: str) -> None:
+    """The short exit line, instead of the rain animation, when Neo is stopped rather than exited."""
+    try:
+        console.print(f"\n[matrix.warning]> {reason} - EXITING THE MATRIX[/matrix.warning]")
+    except (OSError, ValueError):
+        pass  # The terminal went away with SIGHUP
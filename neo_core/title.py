@@ -0,0 +1,93 @@
+"""Short titles for sessions, so /sessions and --resume pick can tell them apart.
+
+After TITLE_AFTER_EXCHANGES exchanges, one small request, with no tools and a few
+output tokens, asks the chat model for the topic in under eight words. It runs in
+the background, so the prompt comes back at once, and it is never added to the
+conversation. A failed request leaves the session untitled; it is not retried.
+The mock provider is never asked, so fixtures play back as written. /title <text>
+sets a title by hand, which is then kept.
+"""
+
+import re
+import threading
+from typing import Any, Callable, Dict, Iterable, List, Optional
+
+from neo_core.loop import collect_stream
+
+TITLE_AFTER_EXCHANGES = 2  # Your messages answered before a title is asked for
+TITLE_MAX_TOKENS = 24
+MAX_TITLE_WORDS = 8
+MAX_TITLE_CHARS = 80
+TITLE_WAIT_SECONDS = 3  # At exit, for a title request still running
+EXCERPT_CHARS = 500  # Of each message, in the title request
+TITLE_PROMPT = ("You name chat sessions. Reply with only a title of at most eight words for the topic of the "
+                "conversation below: no quotes, no trailing punctuation, no explanation.")
+
+def exchanges(history: List[Dict[str, Any]]) -> int:
+    """Your messages that got a reply with text."""
+    answered = 0
+    waiting = False
+    for msg in history:
+        if msg["role"] == "user":
+            waiting = True
+        elif msg["role"] == "assistant" and waiting and (msg.get("content") or "").strip():
+            answered += 1
+            waiting = False
+    return answered
+
+def title_request(history: List[Dict[str, Any]], model: str) -> Dict[str, Any]:
+    """The request for a title: the start of each of your messages and Neo's replies, and nothing else."""
+    lines = [f"{msg['role']}: {(msg.get('content') or '').strip()[:EXCERPT_CHARS]}"
+             for msg in history if msg["role"] in ("user", "assistant") and (msg.get("content") or "").strip()]
+    return {"model": model, "max_tokens": TITLE_MAX_TOKENS,
+            "messages": [{"role": "system", "content": TITLE_PROMPT}, {"role": "user", "content": "\n\n".join(lines)}]}
+
+def clean_title(text: str) -> Optional[str]:
+    """The title in a reply or in /title's text: one line, unquoted, at most MAX_TITLE_WORDS words; None if empty."""
+    lines = [line for line in text.strip().splitlines() if line.strip()]
+    if not lines:
+        return None
+    title = re.sub(r"^(title:\s*)", "", lines[0].strip(), flags=re.IGNORECASE).strip(" \t\"'`*#").rstrip(".!")
+    title = " ".join(title.split()[:MAX_TITLE_WORDS])[:MAX_TITLE_CHARS].strip()
+    return title or None
+
+class SessionTitle:
+    """The session's title, asked for once in the background or set with /title."""
+
+    def __init__(self, text: Optional[str] = None):
+        self.text = text
+        self.requested = text is not None  # A resumed session with a title keeps it
+        self._thread: Optional[threading.Thread] = None
+
+    def set(self, text: str) -> Optional[str]:
+        """/title <text>: replaces any title, and no title is asked for after it; None if 'text' has no title in it."""
+        title = clean_title(text)
+        if title:
+            self.text = title
+            self.requested = True
+        return title
+
+    def due(self, history: List[Dict[str, Any]]) -> bool:
+        return not self.requested and exchanges(history) >= TITLE_AFTER_EXCHANGES
+
+    def request(self, create_stream: Callable[..., Iterable[Any]], history: List[Dict[str, Any]], model: str) -> None:
+        """Ask for a title in the background; 'create_stream' is Agent.create_chat_stream."""
+        self.requested = True
+        request = title_request(history, model)
+
+        def run() -> None:
+            try:
+                content, _ = collect_stream(create_stream(**request), lambda event: None)
+            except Exception:
+                return  # Only a nicety; the session stays untitled
+            title = clean_title(content)
+            if title and self.text is None:  # /title may have been used meanwhile
+                self.text = title
+
+        self._thread = threading.Thread(target=run, name="neo-title", daemon=True)
+        self._thread.start()
+
+    def wait(self, timeout: float) -> None:
+        """Give a request still running a moment to finish, before the session is saved."""
+        if self._thread is not None:
+            self._thread.join(timeout)
@@ -0,0 +1,85 @@
+"""Token estimates, shared by trimming, /add, /context, /stats and the large-request check.
+
+Every count goes through the active TokenCounter. With tiktoken installed it is the
+BPE encoding of the active model, or cl100k_base for a model tiktoken doesn't know,
+which is close for most chat models; without it, about four characters per token.
+A provider that can count exactly installs its own counter with set_counter().
+Messages add the overhead chat formats wrap around the content: the role and
+separators, and the id and type of each tool call.
+"""
+
+from functools import lru_cache
+from typing import Any, Dict, Iterable, Optional, Protocol
+
+try:
+    import tiktoken
+except ImportError:
+    tiktoken = None
+
+MESSAGE_OVERHEAD = 4  # Role and separators around every message
+TOOL_CALL_OVERHEAD = 8  # Id, type and the function wrapper of each tool call
+FALLBACK_ENCODING = "cl100k_base"
+
+class TokenCounter(Protocol):
+    name: str  # Shown in /stats
+
+    def count(self, text: str) -> int: ...
+
+class CharCounter:
+    """About four characters per token, rounded up; the estimate when there is no tokenizer."""
+    name = "~4 characters per token"
+
+    def count(self, text: str) -> int:
+        return (len(text) + 3) // 4
+
+class BPECounter:
+    """Exact counts for one tiktoken encoding, memoized because trimming recounts the same messages."""
+
+    def __init__(self, encoding: Any):
+        self.encoding = encoding
+        self.name = f"{encoding.name} tokenizer"
+        self.count = lru_cache(maxsize=2048)(self._count)
+
+    def _count(self, text: str) -> int:
+        return len(self.encoding.encode(text, disallowed_special=()))
+
+@lru_cache(maxsize=None)
+def counter_for_model(model: str) -> TokenCounter:
+    """The BPE counter for 'model' when tiktoken and its encoding files are available, else CharCounter."""
+    if tiktoken is None:
+        return CharCounter()
+    try:
+        try:
+            encoding = tiktoken.encoding_for_model(model)
+        except KeyError:
+            encoding = tiktoken.get_encoding(FALLBACK_ENCODING)
+    except Exception:  # The encoding file is downloaded on first use and may be unreachable
+        return CharCounter()
+    return BPECounter(encoding)
+
+_counter: TokenCounter = CharCounter()
+
+def set_counter(counter: TokenCounter) -> None:
+    global _counter
+    _counter = counter
+
+def use_model(model: str) -> None:
+    """Count for 'model' from now on; called at startup and by /model."""
+    set_counter(counter_for_model(model))
+
+def active_counter() -> TokenCounter:
+    return _counter
+
+def estimate_string(text: Optional[str]) -> int:
+    return _counter.count(text) if text else 0
+
+def estimate_message(msg: Dict[str, Any]) -> int:
+    """A message in API format: content, tool calls and tool result id, plus their overhead."""
+    tokens = estimate_string(msg.get("content")) + estimate_string(msg.get("tool_call_id")) + MESSAGE_OVERHEAD
+    for tool_call in msg.get("tool_calls") or []:
+        function = tool_call["function"]
+        tokens += estimate_string(function["name"]) + estimate_string(function["arguments"]) + TOOL_CALL_OVERHEAD
+    return tokens
+
+def estimate_conversation(messages: Iterable[Dict[str, Any]]) -> int:
+    return sum(estimate_message(msg) for msg in messages)
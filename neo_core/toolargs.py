@@ -0,0 +1,134 @@
+"""Lenient parsing of the JSON arguments the model streams for a tool call.
+
+Models sometimes send arguments that are almost JSON: a trailing comma, single
+quoted strings, a code fence around the object, or an object cut off when the
+response hit its token limit. parse_arguments repairs those cases and says which
+repairs it made, so a caller can refuse to act on arguments that were cut off.
+"""
+
+import json
+import re
+from dataclasses import dataclass, field
+from typing import Any, Dict, List, Optional
+
+TRAILING_COMMA_RE = re.compile(r",(\s*[}\]])")
+CODE_FENCE_RE = re.compile(r"^```(?:json)?\s*(.*?)\s*```$", re.DOTALL)
+UNESCAPED_QUOTE_RE = re.compile(r'(?<!\\)"')
+
+class ArgumentsError(ValueError):
+    """Arguments that could not be parsed, even leniently."""
+
+    def __init__(self, message: str, raw: str):
+        super().__init__(message)
+        self.raw = raw
+
+@dataclass
+class ParsedArguments:
+    value: Dict[str, Any]
+    repairs: List[str] = field(default_factory=list)  # What was fixed, empty for valid JSON
+
+    @property
+    def truncated(self) -> bool:
+        """Whether the text was cut off and closed by the repair, so values may be incomplete."""
+        return "closed truncated JSON" in self.repairs
+
+def parse_arguments(raw: str) -> ParsedArguments:
+    """Parse tool call arguments, repairing common mistakes; raises ArgumentsError."""
+    text = (raw or "").strip() or "{}"
+    try:
+        return ParsedArguments(_as_object(json.loads(text), raw))
+    except json.JSONDecodeError as e:
+        first_error = e
+
+    repairs = []
+    fenced = CODE_FENCE_RE.match(text)
+    if fenced:
+        text = fenced.group(1)
+        repairs.append("removed code fence")
+    if "'" in text:
+        requoted = _requote(text)
+        if requoted != text:
+            text = requoted
+            repairs.append("converted single quotes")
+    closed = _close_truncated(text)
+    if closed != text:
+        text = closed
+        repairs.append("closed truncated JSON")
+    without_commas = TRAILING_COMMA_RE.sub(r"\1", text)
+    if without_commas != text:
+        text = without_commas
+        repairs.append("removed trailing commas")
+
+    try:
+        return ParsedArguments(_as_object(json.loads(text), raw), repairs)
+    except json.JSONDecodeError:
+        raise ArgumentsError(f"{first_error.msg} at line {first_error.lineno} column {first_error.colno}", raw) from None
+
+def _as_object(value: Any, raw: str) -> Dict[str, Any]:
+    if not isinstance(value, dict):
+        raise ArgumentsError(f"arguments must be a JSON object, got {type(value).__name__}", raw)
+    return value
+
+def _requote(text: str) -> str:
+    """Turn single-quoted strings into JSON strings, leaving double-quoted ones alone."""
+    out = []
+    i = 0
+    while i < len(text):
+        char = text[i]
+        if char not in "'\"":
+            out.append(char)
+            i += 1
+            continue
+        end = _string_end(text, i, char)
+        if char == '"':
+            out.append(text[i:end])
+        else:
+            terminated = end is not None
+            body = text[i + 1:end - 1] if terminated else text[i + 1:]
+            body = UNESCAPED_QUOTE_RE.sub(r'\\"', body.replace("\\'", "'"))
+            out.append(f'"{body}"' if terminated else f'"{body}')  # Left open for _close_truncated
+        i = end if end is not None else len(text)
+    return "".join(out)
+
+def _string_end(text: str, start: int, quote: str) -> Optional[int]:
+    """Index just past the string that opens at 'start', or None if it never closes."""
+    i = start + 1
+    while i < len(text):
+        if text[i] == "\\":
+            i += 2
+            continue
+        if text[i] == quote:
+            return i + 1
+        i += 1
+    return None
+
+def _close_truncated(text: str) -> str:
+    """Close an unterminated string and any brackets still open at the end of the text."""
+    stack = []
+    i = 0
+    in_string = False
+    while i < len(text):
+        char = text[i]
+        if in_string:
+            if char == "\\":
+                i += 1
+            elif char == '"':
+                in_string = False
+        elif char == '"':
+            in_string = True
+        elif char in "{[":
+            stack.append("}" if char == "{" else "]")
+        elif char in "}]" and stack and stack[-1] == char:
+            stack.pop()
+        i += 1
+    if not in_string and not stack:
+        return text
+    closed = text
+    if in_string:
+        if i > len(text):
+            closed = closed[:-1]  # Cut off after a backslash, which would escape the closing quote
+        closed += '"'
+    closed = closed.rstrip().rstrip(",")
+    if closed.endswith(":"):
+        closed += " null"
+    return closed + "".join(reversed(stack))
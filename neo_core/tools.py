@@ -0,0 +1,1006 @@
+"""Tools the model can call, and the registry that exposes them.
+
+A tool is defined once here: its schema, its system prompt summary and its
+implementation. The request's tools list, the dispatch of tool calls and the
+"available tools" section of the system prompt are all derived from the registry.
+"""
+
+import difflib
+import hashlib
+import json
+import os
+import re
+import threading
+import time
+from concurrent.futures import ThreadPoolExecutor, wait
+from dataclasses import dataclass, field
+from typing import Any, Callable, Dict, Iterable, List, Optional, Set, Tuple
+
+from rich.markup import escape
+from rich.panel import Panel
+
+from neo_core.audit import AuditLog
+from neo_core.cancel import CANCELLED_RESULT, CancelToken, Cancelled
+from neo_core.config import NeoConfig
+from neo_core.context import ContextFiles
+from neo_core.conversation import Conversation
+from neo_core.stats import SessionStats
+from neo_core.fileops import (
+    FileChangedError, FileToEdit, FileTooLargeError, InvalidSyntaxError, ProtectedPathError, ReadOnlyError, ScanOptions, SnippetNotFoundError, Workspace, WriteResult, content_version, describe_error, format_size,
+)
+from neo_core.formatting import Formatters
+from neo_core.outline import outline_source
+from neo_core.redact import PLACEHOLDER_RE, Redactor, child_env
+from neo_core.syntax import checker_for
+from neo_core.toolargs import ArgumentsError, parse_arguments
+from neo_core.ui import ALL_OCCURRENCES, choose_occurrence, console, confirm_file_change, show_diff_table, show_file_preview
+
+@dataclass
+class ToolContext:
+    """What tools operate on."""
+    workspace: Workspace
+    config: NeoConfig
+    conversation: Conversation
+    audit: Optional[AuditLog] = None
+    approval: Optional[str] = None  # How the current call's change was approved, for the audit log
+    stats: SessionStats = field(default_factory=SessionStats)
+    files: Optional[ContextFiles] = None  # Defaults to tracking the files of 'conversation'
+    redactor: Optional[Redactor] = None  # Defaults to the config's redaction settings
+    approve: Optional[Callable[[str, str], bool]] = None  # approve(action, target) instead of asking at the terminal
+    versions: Dict[str, Tuple[str, str]] = field(default_factory=dict)  # Path -> (version, text) last shown by read_file
+    cancel: CancelToken = field(default_factory=CancelToken)  # The running calls'; see neo_core.cancel
+
+    def __post_init__(self):
+        if self.redactor is None:
+            self.redactor = Redactor(self.config.redact_secrets, self.config.redact_patterns)
+        if self.files is None:
+            self.files = ContextFiles(self.workspace, self.conversation, self.redactor)
+        if self.config.format_on_write and self.workspace.formatters is None:
+            self.workspace.formatters = Formatters(self.config.formatters, child_env(self.config.child_env_allowlist))
+        self.workspace.check_syntax = self.config.check_syntax
+
+class Tool:
+    """Base class for a function the model can call."""
+
+    name: str = ""
+    summary: str = ""  # One line for the system prompt
+    description: str = ""  # Sent in the function schema
+    parameters: Dict[str, Any] = {}
+    mutating: bool = False  # Whether the tool changes the filesystem
+    source: str = "built-in"  # Or "mcp:<server>" for a tool an MCP server provides
+
+    def definition(self) -> Dict[str, Any]:
+        return {
+            "type": "function",
+            "function": {
+                "name": self.name,
+                "description": self.description,
+                "parameters": self.parameters,
+            }
+        }
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        """Run the tool and return the result text for the model. Errors are raised."""
+        raise NotImplementedError
+
+    def bytes_to_write(self, arguments: Dict[str, Any]) -> int:
+        """How many bytes a call would write, counted against the per-turn budget."""
+        return 0
+
+MAX_PARSE_FAILURES = 3
+
+@dataclass
+class TurnBudget:
+    """Limits on the tool calls made for a single user message."""
+    max_tool_calls: int
+    max_writes: int
+    max_bytes_written: int
+    tool_calls: int = 0
+    writes: int = 0
+    bytes_written: int = 0
+    parse_failures: int = 0  # Calls in a row whose arguments could not be parsed or lacked required fields
+    exceeded: Optional[str] = None  # The first limit hit this turn; later calls are refused too
+    answered: Set[str] = field(default_factory=set)  # call_key() of the read-only calls run since files last changed
+
+    @classmethod
+    def from_config(cls, config: NeoConfig) -> "TurnBudget":
+        return cls(config.max_tool_calls_per_turn, config.max_writes_per_turn, config.max_bytes_written_per_turn)
+
+    def reset(self) -> None:
+        self.tool_calls = self.writes = self.bytes_written = self.parse_failures = 0
+        self.exceeded = None
+        self.answered.clear()
+
+    def parse_failed(self) -> None:
+        """Count a call with invalid arguments; too many in a row stop the turn's tools."""
+        self.parse_failures += 1
+        if self.parse_failures >= MAX_PARSE_FAILURES and not self.exceeded:
+            self.exceeded = f"at most {MAX_PARSE_FAILURES} tool calls in a row with invalid arguments"
+
+    def charge(self, tool: Tool, arguments: Dict[str, Any]) -> Optional[str]:
+        """Count a call against the budget, or return the limit it would break."""
+        if not self.exceeded:
+            size = tool.bytes_to_write(arguments)
+            if self.tool_calls + 1 > self.max_tool_calls:
+                self.exceeded = f"at most {self.max_tool_calls} tool calls per message"
+            elif tool.mutating and self.writes + 1 > self.max_writes:
+                self.exceeded = f"at most {self.max_writes} file-changing tool calls per message"
+            elif self.bytes_written + size > self.max_bytes_written:
+                self.exceeded = f"at most {self.max_bytes_written} bytes written per message"
+            else:
+                self.tool_calls += 1
+                self.writes += tool.mutating
+                self.bytes_written += size
+                return None
+        return self.exceeded
+
+    def repeated(self, tool: Tool, arguments: Dict[str, Any]) -> bool:
+        """Whether a read-only call is identical to one already answered this turn; records it if not.
+
+        A call that can change files is never a repeat, and it forgets the calls before
+        it, since reading the same file again afterwards is how the model sees the change.
+        """
+        if tool.mutating:
+            self.answered.clear()
+            return False
+        key = call_key(tool.name, arguments)
+        if key in self.answered:
+            return True
+        self.answered.add(key)
+        return False
+
+def call_key(name: str, arguments: Dict[str, Any]) -> str:
+    """The same for calls whose arguments are equal, however they were spaced or ordered."""
+    canonical = json.dumps(arguments, sort_keys=True, separators=(",", ":"), ensure_ascii=False)
+    return f"{name}:{hashlib.sha256(canonical.encode('utf-8')).hexdigest()}"
+
+MAX_PARALLEL_READS = 4  # Read-only calls from one response run at most this many at a time
+WAIT_TICK_SECONDS = 0.1  # How often execute_all's on_wait is called while read-only calls run
+
+class ToolRegistry:
+    """Tools by name, executed against a shared context."""
+
+    def __init__(self, ctx: ToolContext, tools: Iterable[Tool] = ()):
+        self.ctx = ctx
+        self._tools: Dict[str, Tool] = {}
+        self.disabled: Set[str] = set()
+        self.read_only = False  # See remove_mutating
+        self.removed: Set[str] = set()  # Tools remove_mutating dropped
+        self.budget = TurnBudget.from_config(ctx.config)
+        self.truncated_results = 0  # Results cut down to max_tool_result_chars this session
+        self.omitted_chars = 0
+        self._lock = threading.Lock()  # Bookkeeping shared by read-only calls running concurrently
+        for tool in tools:
+            self.register(tool)
+
+    def register(self, tool: Tool) -> None:
+        """Add a tool; in a read-only registry, one that can change anything is left out."""
+        if tool.name in self._tools:
+            raise ValueError(f"Tool '{tool.name}' is already registered")
+        if self.read_only and tool.mutating:
+            self.removed.add(tool.name)
+            return
+        self._tools[tool.name] = tool
+
+    def get(self, name: str) -> Optional[Tool]:
+        return self._tools.get(name)
+
+    def names(self) -> List[str]:
+        return list(self._tools)
+
+    def all(self) -> List[Tool]:
+        return list(self._tools.values())
+
+    def is_enabled(self, name: str) -> bool:
+        return name in self._tools and name not in self.disabled
+
+    def is_mutating(self, name: str) -> bool:
+        """Whether a tool can change files; an unknown name counts as one that can."""
+        tool = self._tools.get(name)
+        return tool is None or tool.mutating
+
+    def _check_names(self, names: Iterable[str]) -> List[str]:
+        names = list(names)
+        removed = [name for name in names if name in self.removed]
+        if removed:
+            raise ValueError(f"Not available in a read-only session: {', '.join(removed)}")
+        unknown = [name for name in names if name not in self._tools]
+        if unknown:
+            raise ValueError(f"Unknown tool(s): {', '.join(unknown)}. Available: {', '.join(self.names())}")
+        return names
+
+    def enable(self, names: Iterable[str]) -> None:
+        self.disabled.difference_update(self._check_names(names))
+
+    def disable(self, names: Iterable[str]) -> None:
+        """Disable 'names'; ones removed by remove_mutating are off already and are skipped."""
+        self.disabled.update(self._check_names(name for name in names if name not in self.removed))
+
+    def set_read_only(self) -> None:
+        """Disable every tool that can change the filesystem."""
+        self.disabled = {tool.name for tool in self._tools.values() if tool.mutating}
+
+    def remove_mutating(self) -> None:
+        """--read-only: drop every tool that can change anything, now and when registered later (MCP tools).
+
+        Unlike set_read_only they are gone, not disabled: /tools enable can't bring them
+        back, and the model is never sent their schemas.
+        """
+        self.read_only = True
+        for tool in [tool for tool in self._tools.values() if tool.mutating]:
+            del self._tools[tool.name]
+            self.disabled.discard(tool.name)
+            self.removed.add(tool.name)
+        self.ctx.workspace.read_only = True
+
+    def definitions(self) -> List[Dict[str, Any]]:
+        """Schemas for the enabled tools only."""
+        return [tool.definition() for tool in self._tools.values() if tool.name not in self.disabled]
+
+    def describe(self) -> str:
+        """The tool list for the system prompt, naming each tool's optional fields from its schema."""
+        lines = []
+        for tool in self._tools.values():
+            required = tool.parameters.get("required", [])
+            optional = [name for name in tool.parameters.get("properties", {}) if name not in required]
+            lines.append(f"- {tool.name}: {tool.summary}" + (f" (optional: {', '.join(optional)})" if optional else ""))
+        return "\n".join(lines)
+
+    def begin_turn(self) -> None:
+        self.budget.reset()
+
+    def turn_limit_reached(self) -> Optional[str]:
+        return self.budget.exceeded
+
+    def is_read_only(self, tool_call: Dict[str, Any]) -> bool:
+        tool = self.get(tool_call["function"]["name"])
+        return tool is not None and not tool.mutating
+
+    def execute_all(self, tool_calls: List[Dict[str, Any]],
+                    on_start: Optional[Callable[[Dict[str, Any]], None]] = None,
+                    on_wait: Optional[Callable[[List[Dict[str, Any]], float], None]] = None) -> List[str]:
+        """Execute the tool calls of one response, returning the results in request order.
+
+        Consecutive read-only calls run concurrently, on worker threads, while this thread
+        calls on_wait(those calls, seconds since they started) every WAIT_TICK_SECONDS. A call that can
+        change files waits for everything before it and runs alone, here, so confirmations
+        come one at a time and in the order the model asked.
+
+        Ctrl+C while calls run, or on_wait raising Cancelled, cancels them: each call that
+        has not finished, and each one after it, gets CANCELLED_RESULT.
+        """
+        cancel = self.ctx.cancel = CancelToken()
+        results: List[str] = []
+        start = 0
+        while start < len(tool_calls):
+            end = start + 1
+            if self.is_read_only(tool_calls[start]):
+                while end < len(tool_calls) and self.is_read_only(tool_calls[end]):
+                    end += 1
+            batch = tool_calls[start:end]
+            start = end
+            if cancel.cancelled:
+                results.extend(self._cancelled(tool_call) for tool_call in batch)
+                continue
+            for tool_call in batch:
+                if on_start:
+                    on_start(tool_call)
+            if self.is_read_only(batch[0]):
+                results.extend(self._execute_waiting(batch, on_wait))
+                continue
+            try:
+                results.append(self._execute_reporting(batch[0]))
+            except KeyboardInterrupt:
+                cancel.cancel()
+                results.append(self._cancelled(batch[0]))
+        return results
+
+    def _execute_waiting(self, batch: List[Dict[str, Any]],
+                         on_wait: Optional[Callable[[List[Dict[str, Any]], float], None]]) -> List[str]:
+        """Run read-only calls on worker threads and wait for them, or for the user to cancel them."""
+        pool = ThreadPoolExecutor(max_workers=min(MAX_PARALLEL_READS, len(batch)), thread_name_prefix="neo-tool")
+        futures = [pool.submit(self._execute_reporting, tool_call) for tool_call in batch]
+        started = time.monotonic()
+        try:
+            while wait(futures, timeout=WAIT_TICK_SECONDS).not_done:
+                if on_wait:
+                    on_wait(batch, time.monotonic() - started)
+        except (KeyboardInterrupt, Cancelled):
+            self.ctx.cancel.cancel()
+        finally:
+            pool.shutdown(wait=False)  # A call that never checks the token is left to finish on its own
+        results = []
+        for future, tool_call in zip(futures, batch):
+            if future.done() and not future.cancelled():
+                results.append(future.result())
+            else:
+                # One that never started is recorded here; one still running records itself when it ends
+                results.append(self._cancelled(tool_call, record=future.cancel()))
+        return results
+
+    def _cancelled(self, tool_call: Dict[str, Any], record: bool = True) -> str:
+        """CANCELLED_RESULT for a call, counted and audited as "cancelled" unless 'record' is False."""
+        try:
+            arguments = parse_arguments(tool_call["function"]["arguments"]).value
+        except ArgumentsError:
+            arguments = None
+        with self._lock:
+            if arguments is not None:
+                self.budget.answered.discard(call_key(tool_call["function"]["name"], arguments))  # Calling it again is no repeat
+            if record:
+                self.ctx.stats.tool_calls[tool_call["function"]["name"]] += 1
+                if self.ctx.audit:
+                    self.ctx.audit.record(tool_call["function"]["name"], tool_call["function"]["arguments"], "cancelled")
+        return CANCELLED_RESULT
+
+    def _execute_reporting(self, tool_call: Dict[str, Any]) -> str:
+        """execute(), turning an unexpected failure into an error result the model can see."""
+        try:
+            return self.execute(tool_call)
+        except Exception as e:
+            console.print(f"[red]Error executing {tool_call['function']['name']}: {escape(str(e))}[/red]")
+            return f"Error: {str(e)}"
+
+    def execute(self, tool_call: Dict[str, Any]) -> str:
+        """Execute a function call from a dictionary format and return the result as a string."""
+        return self.execute_with_status(tool_call)[1]
+
+    def execute_with_status(self, tool_call: Dict[str, Any]) -> Tuple[str, str]:
+        """execute(), also returning the status the audit log records: "ok", "declined", "refused", "duplicate", "invalid", "unknown", "cancelled" or "error"."""
+        function_name = tool_call["function"]["name"]
+        self.ctx.approval = None
+        status, arguments, result = self._dispatch(tool_call)
+        result = self.ctx.redactor.redact(result, f"{function_name} result")
+        with self._lock:
+            self.ctx.stats.tool_calls[function_name] += 1
+            result = self._cap(result)
+            if status == "ok" and self.ctx.approval == "declined":
+                status = "declined"
+            if self.ctx.audit:
+                tool = self.get(function_name)
+                written = tool.bytes_to_write(arguments) if status == "ok" and tool.mutating and not self.ctx.config.dry_run else 0
+                self.ctx.audit.record(function_name, arguments, status, written, self.ctx.approval)
+        return status, result
+
+    def _cap(self, result: str) -> str:
+        """Truncate a result to max_tool_result_chars, saying how much was left out and how to get it."""
+        limit = self.ctx.config.max_tool_result_chars
+        if not limit or len(result) <= limit:
+            return result
+        kept = result[:limit]
+        omitted = len(result) - limit
+        self.truncated_results += 1
+        self.omitted_chars += omitted
+        last_line = kept.count("\n") + 1
+        return (f"{kept}\n\n[TRUNCATED: {omitted} of {len(result)} characters omitted after line {last_line} of this result. "
+                "To see more, call read_file with start_line/end_line for the part you need.]")
+
+    def _dispatch(self, tool_call: Dict[str, Any]) -> Tuple[str, Any, str]:
+        """Run a tool call, returning (status, parsed arguments, result text)."""
+        function_name = tool_call["function"]["name"]
+        arguments: Any = tool_call["function"]["arguments"]
+        tool = self.get(function_name)
+        if tool is None:
+            return "unknown", arguments, json.dumps({
+                "error": f"no such tool: {function_name}",
+                "available": [name for name in self.names() if self.is_enabled(name)],
+            })
+        if function_name in self.disabled:
+            return "refused", arguments, (f"Refused: the user has disabled the '{function_name}' tool for this session. "
+                                          "Do not call it again; describe the change for the user to make instead.")
+        try:
+            parsed = parse_arguments(arguments)
+        except ArgumentsError as e:
+            return "invalid", arguments, self._invalid_arguments(tool, str(e), e.raw)
+        if parsed.truncated and tool.mutating:
+            return "invalid", arguments, self._invalid_arguments(
+                tool, "the arguments were cut off before the end, so their values are incomplete", arguments,
+                "Nothing was changed. Call the tool again with the complete arguments; split large content "
+                "into several smaller calls if it does not fit in one response.")
+        missing = [name for name in tool.parameters.get("required", []) if name not in parsed.value]
+        if missing:
+            return "invalid", parsed.value, self._invalid_arguments(
+                tool, f"missing required field(s): {', '.join(missing)}", arguments,
+                "Send the call again with every field listed under required in expected_schema.")
+        with self._lock:
+            self.budget.parse_failures = 0
+        if parsed.repairs:
+            console.print(f"[matrix.dim]↺ repaired {function_name} arguments: {', '.join(parsed.repairs)}[/matrix.dim]")
+        arguments = parsed.value
+        try:
+            with self._lock:
+                limit = self.budget.charge(tool, arguments)  # A repeat still counts towards the limit
+                repeated = not limit and self.budget.repeated(tool, arguments)
+            if limit:
+                return "refused", arguments, (f"Refused: this turn allows {limit}, and that limit has been reached. No more tools "
+                                              "will run until the user's next message; summarize what you did and what remains.")
+            if repeated:
+                console.print(f"[matrix.dim]↺ {function_name}: identical call already answered this turn[/matrix.dim]")
+                return "duplicate", arguments, (f"Identical call already answered above: {function_name} was called with these "
+                                                "same arguments earlier in this turn, and no files have changed since. "
+                                                "Use that result instead of calling it again.")
+            return "ok", arguments, tool.execute(self.ctx, arguments)
+        except (ProtectedPathError, InvalidSyntaxError, ReadOnlyError) as e:
+            return "refused", arguments, describe_error(e)
+        except Cancelled:
+            with self._lock:
+                self.budget.answered.discard(call_key(function_name, arguments))  # Calling it again is no repeat
+            return "cancelled", arguments, CANCELLED_RESULT
+        except Exception as e:
+            return "error", arguments, f"Error executing {function_name}: {describe_error(e)}"
+
+    def _invalid_arguments(self, tool: Tool, error: str, raw: str, hint: str = "") -> str:
+        """A result that quotes the parse error and the schema, so the model can send the call again correctly."""
+        with self._lock:
+            self.budget.parse_failed()
+        return json.dumps({
+            "error": f"invalid arguments for {tool.name}: {error}",
+            "received": raw if len(raw) <= 300 else raw[:300] + "...",
+            "expected_schema": tool.parameters,
+            "hint": hint or "Send the call again with arguments that are a single JSON object matching expected_schema, "
+                            "using double quotes and no trailing commas.",
+        }, indent=2)
+
+# --------------------------------------------------------------------------------
+# Shared helpers
+# --------------------------------------------------------------------------------
+
+TARGET_SEPARATOR = ", "  # Between the paths of a change to several files
+
+def change_targets(target: str) -> List[str]:
+    """The paths a confirm_change target names."""
+    return target.split(TARGET_SEPARATOR)
+
+def confirm_change(ctx: ToolContext, action: str, target: str) -> bool:
+    """Ask before a change, noting for the audit log whether it was confirmed, auto-approved or declined."""
+    if ctx.config.dry_run:
+        ctx.approval = "simulated"  # Nothing will be written, so there is nothing to approve
+        return True
+    if ctx.approve is not None and not ctx.config.auto_approve:
+        approved = ctx.approve(action, target)
+    else:
+        approved = confirm_file_change(action, target, ctx.config.auto_approve)
+    ctx.approval = ("auto-approved" if ctx.config.auto_approve else "confirmed") if approved else "declined"
+    return approved
+
+def report_write(label: str, path: str, result: WriteResult) -> None:
+    """Print the success line for a write, with where the previous content was backed up."""
+    if result.simulated:
+        console.print(f"[matrix.warning]◌ SIMULATED {label}:[/matrix.warning] [matrix.accent]{path}[/matrix.accent] "
+                      f"[matrix.dim](dry run: {format_size(result.size)} not written)[/matrix.dim]")
+        return
+    backup = f" [matrix.dim](backup: {result.backup})[/matrix.dim]" if result.backup else ""
+    console.print(f"[matrix.success]✓ {label}:[/matrix.success] [matrix.accent]{path}[/matrix.accent]{backup}")
+    if result.backup_skipped:
+        console.print(f"[matrix.warning]⚠ No backup made: {result.backup_skipped}[/matrix.warning]")
+    if result.formatting and result.formatting.failed:
+        console.print(f"[matrix.error]✗ FORMAT FAILED:[/matrix.error] [matrix.accent]{path}[/matrix.accent] "
+                      f"[matrix.error]{escape(result.formatting.note)}[/matrix.error]")
+    elif result.formatting and result.formatting.changed:
+        console.print(f"[matrix.dim]↺ {result.formatting.note}[/matrix.dim]")
+
+def describe_formatting(result: WriteResult) -> str:
+    """What formatting did, appended to a tool result; a failure is spelled out since it likely means broken code."""
+    if not result.formatting:
+        return ""
+    if result.formatting.failed:
+        return (f"\nWARNING: {result.formatting.note}\nThe file was written exactly as you sent it and probably "
+                "has a syntax error. Read it back and fix it.")
+    return f" ({result.formatting.note})"
+
+def report_created(path: str, result: WriteResult) -> None:
+    report_write("FILE CREATED", path, result)
+
+def simulated(path: str, result: WriteResult) -> str:
+    return f"SIMULATED: would have written {format_size(result.size)} to {path}"
+
+def check_no_placeholders(file_path: str, content: str) -> None:
+    """Refuse to write a redaction placeholder, which would replace the real secret on disk."""
+    match = PLACEHOLDER_RE.search(content)
+    if match:
+        raise ValueError(f"the content for '{file_path}' contains {match.group(0)}; secrets are hidden from you, so "
+                         "leave the lines holding them unchanged instead of writing the placeholder")
+
+def check_parses(ctx: ToolContext, file_path: str, content: str) -> None:
+    """Refuse, before anyone is asked to approve it, a write of a structured file that doesn't parse."""
+    if not ctx.workspace.check_syntax:
+        return
+    problem = ctx.workspace.syntax_problem(ctx.workspace.normalize_path(file_path), content)
+    if problem:
+        refuse_invalid(file_path, InvalidSyntaxError(file_path, problem))
+
+def refuse_invalid(file_path: str, e: InvalidSyntaxError) -> None:
+    console.print(f"[matrix.warning]⚠ INVALID {e.problem.language}:[/matrix.warning] [matrix.accent]{file_path}[/matrix.accent] "
+                  f"[matrix.dim]{e.problem.describe()}; nothing written, the model is asked to fix it[/matrix.dim]")
+    raise e
+
+def check_edit_parses(ctx: ToolContext, file_path: str, original_snippet: str, new_snippet: str,
+                      occurrence: Optional[int]) -> None:
+    """check_parses for an edit whose match is known: the edit is simulated, so only the result's syntax is checked."""
+    if not ctx.workspace.check_syntax or checker_for(file_path) is None:
+        return
+    try:
+        ctx.workspace.apply_diff_edit(file_path, original_snippet, new_snippet, simulate=True, occurrence=occurrence or None,
+                                      replace_all=occurrence == ALL_OCCURRENCES, check=True)
+    except InvalidSyntaxError as e:
+        refuse_invalid(file_path, e)
+
+def ensure_file_in_context(ctx: ToolContext, file_path: str) -> bool:
+    try:
+        normalized_path = ctx.workspace.normalize_path(file_path)
+        if not ctx.conversation.has_file(normalized_path):
+            ctx.files.add(normalized_path, origin="tool")
+        return True
+    except OSError:
+        console.print(f"[bold red]✗[/bold red] Could not read file '[bright_cyan]{file_path}[/bright_cyan]' for editing context")
+        return False
+
+# --------------------------------------------------------------------------------
+# File tools
+# --------------------------------------------------------------------------------
+
+def read_or_truncate(ctx: ToolContext, normalized_path: str) -> str:
+    """A file's content headed by its version, or its truncated copy (see truncate_strategy) when it is over the size limit."""
+    try:
+        content = ctx.workspace.read_file(normalized_path)
+    except FileTooLargeError:
+        if ctx.config.truncate_strategy == "skip":
+            raise
+        truncated = ctx.workspace.read_truncated(normalized_path, ctx.config.truncate_strategy, ctx.config.truncate_lines,
+                                                 ctx.cancel).content
+        return f"Content of file '{normalized_path}':\n\n{truncated}"
+    return f"Content of file '{normalized_path}' ({remember_version(ctx, normalized_path, content)}):\n\n{content}"
+
+def remember_version(ctx: ToolContext, normalized_path: str, content: str) -> str:
+    """Note the version of a file the model is shown, so a conflicting edit can say what changed; returns "version <hash>"."""
+    version = content_version(content)
+    ctx.versions[normalized_path] = (version, content)
+    return f"version {version}"
+
+CONFLICT_DIFF_LINES = 40  # Of the diff a conflicting edit_file result includes
+
+def describe_conflict(ctx: ToolContext, normalized_path: str, error: FileChangedError) -> None:
+    """Fill in what changed since the version the edit expected, if that is the version read_file last showed."""
+    version, seen = ctx.versions.get(normalized_path, ("", ""))
+    if version != error.expected.strip().lower():
+        return
+    diff = list(difflib.unified_diff(seen.splitlines(), ctx.workspace.read_file(normalized_path).splitlines(),
+                                     f"version {error.expected}", f"version {error.actual}", lineterm="", n=1))
+    if len(diff) > CONFLICT_DIFF_LINES:
+        diff = diff[:CONFLICT_DIFF_LINES] + [f"... ({len(diff) - CONFLICT_DIFF_LINES} more diff lines)"]
+    error.changes = "\n".join(diff)
+
+class ReadFileTool(Tool):
+    name = "read_file"
+    summary = "Read a single file's content"
+    description = "Read the content of a single file from the filesystem"
+    parameters = {
+        "type": "object",
+        "properties": {
+            "file_path": {
+                "type": "string",
+                "description": "The path to the file to read (relative or absolute)",
+            },
+            "start_line": {
+                "type": "integer",
+                "description": "First line to return, counting from 1 (default: the start of the file)",
+            },
+            "end_line": {
+                "type": "integer",
+                "description": "Last line to return, inclusive (default: the end of the file)",
+            }
+        },
+        "required": ["file_path"]
+    }
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        normalized_path = ctx.workspace.normalize_path(arguments["file_path"])
+        start_line, end_line = arguments.get("start_line"), arguments.get("end_line")
+        if start_line is None and end_line is None:
+            return read_or_truncate(ctx, normalized_path)
+        try:
+            content = ctx.workspace.read_file(normalized_path)
+        except FileTooLargeError:
+            # Ranges of a file too large to load are streamed, which is how the truncation note says to read the rest
+            start = max(int(start_line or 1), 1)
+            text, end, total = ctx.workspace.read_line_range(normalized_path, start, None if end_line is None else int(end_line),
+                                                             ctx.cancel)
+            return f"Lines {start}-{end} of {total} in file '{normalized_path}':\n\n{text}"
+        lines = content.splitlines(keepends=True)
+        start = max(int(start_line or 1), 1)
+        end = min(int(end_line or len(lines)), len(lines))
+        return (f"Lines {start}-{end} of {len(lines)} in file '{normalized_path}' ({remember_version(ctx, normalized_path, content)}):"
+                f"\n\n{''.join(lines[start - 1:end])}")
+
+class ReadMultipleFilesTool(Tool):
+    name = "read_multiple_files"
+    summary = "Read multiple files at once"
+    description = "Read the content of multiple files from the filesystem"
+    parameters = {
+        "type": "object",
+        "properties": {
+            "file_paths": {
+                "type": "array",
+                "items": {"type": "string"},
+                "description": "Array of file paths to read (relative or absolute)",
+            }
+        },
+        "required": ["file_paths"]
+    }
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        results = []
+        for file_path in arguments["file_paths"]:
+            ctx.cancel.check()
+            try:
+                normalized_path = ctx.workspace.normalize_path(file_path)
+                results.append(read_or_truncate(ctx, normalized_path))
+            except OSError as e:
+                results.append(f"Error reading '{file_path}': {describe_error(e)}")
+        return ("\n\n" + "=" * 50 + "\n\n").join(results)
+
+class OutlineFileTool(Tool):
+    name = "outline_file"
+    summary = "Show a Go or Python file's declarations and signatures without function bodies"
+    description = ("Return the package, imports, types and function signatures with doc comments of a Go or "
+                   "Python file, without function bodies. Other files are returned in full. Use read_file "
+                   "for the full source of the parts you need.")
+    parameters = {
+        "type": "object",
+        "properties": {
+            "file_path": {
+                "type": "string",
+                "description": "The path to the file to outline (relative or absolute)",
+            }
+        },
+        "required": ["file_path"]
+    }
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        normalized_path = ctx.workspace.normalize_path(arguments["file_path"])
+        content, outlined = outline_source(normalized_path, ctx.workspace.read_file(normalized_path))
+        if outlined:
+            return f"Outline of file '{normalized_path}':\n\n{content}"
+        return f"No outline available; content of file '{normalized_path}':\n\n{content}"
+
+class CreateFileTool(Tool):
+    name = "create_file"
+    summary = "Create or overwrite a single file"
+    description = "Create a new file or overwrite an existing file with the provided content"
+    parameters = {
+        "type": "object",
+        "properties": {
+            "file_path": {
+                "type": "string",
+                "description": "The path where the file should be created",
+            },
+            "content": {
+                "type": "string",
+                "description": "The content to write to the file",
+            }
+        },
+        "required": ["file_path", "content"]
+    }
+    mutating = True
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        file_path = arguments["file_path"]
+        ctx.workspace.check_writable(file_path)  # Refuse before asking the user
+        check_no_placeholders(file_path, arguments["content"])
+        check_parses(ctx, file_path, arguments["content"])
+        if not confirm_change(ctx, "creation", file_path):
+            return f"User declined to create file '{file_path}'"
+        result = ctx.workspace.create_file(file_path, arguments["content"], ctx.config.dry_run, format=True, check=True)
+        if result.simulated:
+            show_file_preview(file_path, arguments["content"])
+        report_created(file_path, result)
+        if result.simulated:
+            return simulated(file_path, result)
+        ctx.stats.files_created.add(result.path)
+        ctx.files.refresh(result.path)
+        return f"Successfully created file '{file_path}'{describe_formatting(result)}"
+
+    def bytes_to_write(self, arguments: Dict[str, Any]) -> int:
+        return len(arguments.get("content", "").encode("utf-8"))
+
+class CreateMultipleFilesTool(Tool):
+    name = "create_multiple_files"
+    summary = "Create multiple files at once"
+    description = "Create multiple files at once"
+    parameters = {
+        "type": "object",
+        "properties": {
+            "files": {
+                "type": "array",
+                "items": {
+                    "type": "object",
+                    "properties": {
+                        "path": {"type": "string"},
+                        "content": {"type": "string"}
+                    },
+                    "required": ["path", "content"]
+                },
+                "description": "Array of files to create with their paths and content",
+            }
+        },
+        "required": ["files"]
+    }
+    mutating = True
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        files = arguments["files"]
+        for file_info in files:
+            ctx.workspace.check_writable(file_info["path"])
+            check_no_placeholders(file_info["path"], file_info["content"])
+            check_parses(ctx, file_info["path"], file_info["content"])  # All of them, before any is written
+        if not confirm_change(ctx, "creation", TARGET_SEPARATOR.join(f["path"] for f in files)):
+            return "User declined to create the requested files"
+        created_files = []
+        simulations = []
+        for file_info in files:
+            result = ctx.workspace.create_file(file_info["path"], file_info["content"], ctx.config.dry_run, format=True, check=True)
+            if result.simulated:
+                show_file_preview(file_info["path"], file_info["content"])
+                simulations.append(simulated(file_info["path"], result))
+            report_created(file_info["path"], result)
+            if not result.simulated:
+                ctx.stats.files_created.add(result.path)
+                ctx.files.refresh(result.path)
+            created_files.append(file_info["path"] + describe_formatting(result))
+        if simulations:
+            return "\n".join(simulations)
+        return f"Successfully created {len(created_files)} files: {', '.join(created_files)}"
+
+    def bytes_to_write(self, arguments: Dict[str, Any]) -> int:
+        return sum(len(f.get("content", "").encode("utf-8")) for f in arguments.get("files", []))
+
+class EditFileTool(Tool):
+    name = "edit_file"
+    summary = "Make precise edits to existing files using snippet replacement; pass the version read_file reported as expected_version"
+    description = "Edit an existing file by replacing a specific snippet with new content"
+    parameters = {
+        "type": "object",
+        "properties": {
+            "file_path": {
+                "type": "string",
+                "description": "The path to the file to edit",
+            },
+            "original_snippet": {
+                "type": "string",
+                "description": "The exact text snippet to find and replace",
+            },
+            "new_snippet": {
+                "type": "string",
+                "description": "The new text to replace the original snippet with",
+            },
+            "occurrence_index": {
+                "type": "integer",
+                "description": ("Which match to replace, counting from 1, when original_snippet appears more than "
+                                "once; an ambiguous edit's error lists the matches by line (default: it must match once)"),
+            },
+            "expected_version": {
+                "type": "string",
+                "description": ("The version read_file reported for the file; if the file has changed since, "
+                                "nothing is edited and the result says what changed"),
+            }
+        },
+        "required": ["file_path", "original_snippet", "new_snippet"]
+    }
+    mutating = True
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        file_path = arguments["file_path"]
+        original_snippet = arguments["original_snippet"]
+        new_snippet = arguments["new_snippet"]
+        occurrence = arguments.get("occurrence_index")
+        if occurrence is not None and (not isinstance(occurrence, int) or isinstance(occurrence, bool) or occurrence < 1):
+            return f"Error: occurrence_index must be a whole number from 1, got {occurrence!r}"
+        ctx.workspace.check_writable(file_path)
+        check_no_placeholders(file_path, new_snippet)
+
+        # Ensure file is in context first
+        if not ensure_file_in_context(ctx, file_path):
+            return f"Error: Could not read file '{file_path}' for editing"
+
+        expected_version = arguments.get("expected_version") or None
+        normalized_path = ctx.workspace.normalize_path(file_path)
+        if expected_version:
+            try:
+                ctx.workspace.check_version(file_path, expected_version)
+            except FileChangedError as e:
+                describe_conflict(ctx, normalized_path, e)
+                console.print(f"[matrix.warning]⚠ CONFLICT:[/matrix.warning] [matrix.accent]{file_path}[/matrix.accent] "
+                              "[matrix.dim]changed since the model read it; no changes made, the model is told what changed[/matrix.dim]")
+                raise
+
+        # An ambiguous snippet is yours to resolve at the terminal; otherwise the model is told where the matches are
+        starts = ctx.workspace.snippet_lines(file_path, original_snippet)
+        if not starts:  # Nothing to show or approve
+            console.print(f"[matrix.warning]⚠ The snippet was not found in[/matrix.warning] [matrix.accent]{escape(file_path)}[/matrix.accent]. "
+                          "[matrix.warning]No changes made.[/matrix.warning]")
+            raise SnippetNotFoundError(file_path, 0, [])
+        if occurrence and occurrence > len(starts):
+            raise SnippetNotFoundError(file_path, len(starts), starts, occurrence)
+        ambiguous = len(starts) > 1 and not occurrence
+        if ambiguous and (ctx.approve is not None or ctx.config.auto_approve):
+            console.print(f"[matrix.warning]⚠ The snippet matches {len(starts)} times in[/matrix.warning] [matrix.accent]{file_path}[/matrix.accent] "
+                          f"[matrix.dim](lines {', '.join(map(str, starts))}); no changes made, the model is asked to pick one[/matrix.dim]")
+            raise SnippetNotFoundError(file_path, len(starts), starts)
+        if len(starts) == 1 or occurrence:
+            check_edit_parses(ctx, file_path, original_snippet, new_snippet, occurrence)
+        show_diff_table([FileToEdit(path=file_path, original_snippet=original_snippet, new_snippet=new_snippet)])
+        if ambiguous:
+            occurrence = choose_occurrence(file_path, ctx.workspace.read_file(file_path), starts, original_snippet)
+            ctx.approval = "declined" if occurrence is None else "simulated" if ctx.config.dry_run else "confirmed"
+            if occurrence is None:
+                return f"User declined to edit file '{file_path}'"
+        elif not confirm_change(ctx, "edit", file_path):
+            return f"User declined to edit file '{file_path}'"
+
+        try:
+            result = ctx.workspace.apply_diff_edit(file_path, original_snippet, new_snippet, ctx.config.dry_run, format=True,
+                                                   occurrence=occurrence or None, replace_all=occurrence == ALL_OCCURRENCES,
+                                                   expected_version=expected_version, check=True)
+        except InvalidSyntaxError as e:  # For the match you picked
+            refuse_invalid(file_path, e)
+        except FileChangedError as e:  # Changed while you were being asked
+            describe_conflict(ctx, normalized_path, e)
+            raise
+        except FileNotFoundError:
+            console.print(f"[matrix.error]✗ FILE NOT FOUND:[/matrix.error] [matrix.accent]{escape(file_path)}[/matrix.accent]")
+            raise
+        except SnippetNotFoundError as e:
+            console.print(f"[matrix.warning]⚠ {escape(str(e))} in[/matrix.warning] [matrix.accent]{escape(file_path)}[/matrix.accent]. [matrix.warning]No changes made.[/matrix.warning]")
+            console.print("\n[matrix.primary]Expected snippet:[/matrix.primary]")
+            console.print(Panel(original_snippet, title="[matrix.accent][ EXPECTED ][/matrix.accent]", border_style="matrix.border", title_align="left"))
+            console.print("\n[matrix.primary]Actual file content:[/matrix.primary]")
+            console.print(Panel(ctx.workspace.read_file(file_path), title="[matrix.warning][ ACTUAL ][/matrix.warning]", border_style="matrix.warning", title_align="left"))
+            raise
+        report_write("MODIFICATION APPLIED", file_path, result)
+        if result.simulated:
+            return simulated(file_path, result)
+        ctx.stats.files_edited.add(result.path)
+        ctx.files.refresh(result.path)  # We wrote it, so the copy in context is updated without asking
+        version = remember_version(ctx, result.path, ctx.workspace.read_file(result.path))
+        return f"Successfully edited file '{file_path}', now {version}{describe_formatting(result)}"
+
+    def bytes_to_write(self, arguments: Dict[str, Any]) -> int:
+        return len(arguments.get("new_snippet", "").encode("utf-8"))
+
+MAX_LISTED_ENTRIES = 500  # Entries list_directory returns before saying how many more there are
+MAX_SEARCH_RESULTS = 200  # Matching lines search_files returns at most
+
+class ListDirectoryTool(Tool):
+    name = "list_directory"
+    summary = "List the files and subdirectories of a directory"
+    description = "List the entries of a directory in the workspace: subdirectories end with /, files show their size"
+    parameters = {
+        "type": "object",
+        "properties": {
+            "path": {
+                "type": "string",
+                "description": "The directory to list (relative or absolute; default: the workspace root)",
+            }
+        },
+    }
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        path = arguments.get("path") or "."
+        normalized_path = ctx.workspace.normalize_path(path)
+        entries = []
+        with os.scandir(normalized_path) as scan:
+            for entry in sorted(scan, key=lambda entry: entry.name):
+                if entry.is_symlink():
+                    target = os.path.realpath(entry.path)
+                    where = os.path.relpath(target, ctx.workspace.root) if ctx.workspace.contains(target) else "outside the workspace"
+                    entries.append(f"{entry.name} (symlink -> {where})")
+                elif entry.is_dir():
+                    entries.append(entry.name + "/")
+                else:
+                    entries.append(f"{entry.name} ({format_size(entry.stat().st_size)})")
+        more = len(entries) - MAX_LISTED_ENTRIES
+        listed = "\n".join(entries[:MAX_LISTED_ENTRIES]) or "(empty)"
+        return f"Directory '{normalized_path}':\n\n{listed}" + (f"\n... and {more} more" if more > 0 else "")
+
+class SearchFilesTool(Tool):
+    name = "search_files"
+    summary = "Search the text files below a directory for a regular expression"
+    description = ("Search the text files below a directory for lines matching a regular expression, skipping hidden, "
+                   "binary and excluded files as /add does. Returns each match as path:line: text.")
+    parameters = {
+        "type": "object",
+        "properties": {
+            "pattern": {
+                "type": "string",
+                "description": "The regular expression to search for (Python syntax)",
+            },
+            "path": {
+                "type": "string",
+                "description": "The directory to search (default: the workspace root)",
+            },
+            "ignore_case": {
+                "type": "boolean",
+                "description": "Match without regard to case (default: false)",
+            }
+        },
+        "required": ["pattern"]
+    }
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        try:
+            pattern = re.compile(arguments["pattern"], re.IGNORECASE if arguments.get("ignore_case") else 0)
+        except re.error as e:
+            return f"Error: invalid regular expression {arguments['pattern']!r}: {e}"
+        directory = ctx.workspace.normalize_path(arguments.get("path") or ".")
+        matches: List[str] = []
+
+        def search(normalized_path: str, content: str, truncation: Any) -> None:
+            ctx.cancel.check()
+            relative = os.path.relpath(normalized_path, ctx.workspace.root)
+            for number, line in enumerate(content.splitlines(), 1):
+                if len(matches) > MAX_SEARCH_RESULTS:
+                    return
+                if pattern.search(line):
+                    matches.append(f"{relative}:{number}: {line.strip()[:200]}")
+
+        options = ScanOptions(max_files=ctx.config.max_scan_files, max_file_size=ctx.config.max_file_size)
+        if os.path.isfile(directory):
+            search(directory, ctx.workspace.read_file(directory), None)
+        else:
+            ctx.workspace.scan_directory(directory, options=options, on_file=search)
+        if not matches:
+            return f"No matches for {arguments['pattern']!r} in '{directory}'"
+        shown = "\n".join(matches[:MAX_SEARCH_RESULTS])
+        more = f"\n... more matches not shown; narrow the pattern or the path" if len(matches) > MAX_SEARCH_RESULTS else ""
+        return f"Matches for {arguments['pattern']!r} in '{directory}':\n\n{shown}{more}"
+
+class DeleteFileTool(Tool):
+    name = "delete_file"
+    summary = "Delete a file, keeping a backup"
+    description = "Delete a single file from the workspace. A backup is kept, so the user can bring it back with /restore."
+    parameters = {
+        "type": "object",
+        "properties": {
+            "file_path": {
+                "type": "string",
+                "description": "The path to the file to delete",
+            }
+        },
+        "required": ["file_path"]
+    }
+    mutating = True
+
+    def execute(self, ctx: ToolContext, arguments: Dict[str, Any]) -> str:
+        file_path = arguments["file_path"]
+        normalized_path = ctx.workspace.check_writable(file_path)  # Refuse before asking the user
+        if not os.path.isfile(normalized_path):
+            ctx.workspace.delete_file(file_path, simulate=True)  # Raises the error that says why
+        if not confirm_change(ctx, "deletion", file_path):
+            return f"User declined to delete file '{file_path}'"
+        result = ctx.workspace.delete_file(file_path, ctx.config.dry_run)
+        if result.simulated:
+            console.print(f"[matrix.warning]◌ SIMULATED FILE DELETED:[/matrix.warning] [matrix.accent]{escape(file_path)}[/matrix.accent] "
+                          "[matrix.dim](dry run: not deleted)[/matrix.dim]")
+            return f"SIMULATED: would have deleted {file_path}"
+        console.print(f"[matrix.success]✓ FILE DELETED:[/matrix.success] [matrix.accent]{escape(file_path)}[/matrix.accent] "
+                      f"[matrix.dim](backup: {escape(result.backup or '')})[/matrix.dim]")
+        ctx.stats.files_deleted.add(result.path)
+        ctx.conversation.remove_file(result.path)
+        return f"Successfully deleted file '{file_path}'; a backup was kept at '{result.backup}'"
+
+def create_default_registry(ctx: ToolContext) -> ToolRegistry:
+    return ToolRegistry(ctx, [
+        ReadFileTool(),
+        ReadMultipleFilesTool(),
+        OutlineFileTool(),
+        CreateFileTool(),
+        CreateMultipleFilesTool(),
+        EditFileTool(),
+    ])
+
+def create_server_registry(ctx: ToolContext) -> ToolRegistry:
+    """The tools `neo mcp-serve` offers: the default ones, plus listing, searching and deleting files."""
+    registry = create_default_registry(ctx)
+    for tool in (ListDirectoryTool(), SearchFilesTool(), DeleteFileTool()):
+        registry.register(tool)
+    return registry
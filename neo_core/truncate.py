@@ -0,0 +1,137 @@
+"""What to add instead of a file over the size limit (truncate_strategy, /add --truncate).
+
+"head" keeps the first lines, "head-tail" the first and last, and "outline" the
+declarations of a Go or Python file (see neo_core.outline), falling back to
+head-tail for anything that doesn't outline. "skip" leaves the file out, as Neo
+always used to. The lines are streamed, so only what is kept is ever in memory,
+and the result starts with a note saying what was cut and how to read the rest.
+
+/add --chunks is for when you want such a file whole: split_parts cuts it into
+parts of at most chunk_tokens each, on line breaks where it can, and each part
+goes in context as its own message.
+"""
+
+from collections import deque
+from dataclasses import dataclass
+from typing import Deque, Iterable, List, Optional, Tuple
+
+from neo_core.outline import outline_source
+from neo_core.tokens import estimate_string
+
+STRATEGIES = ("skip", "head", "head-tail", "outline")
+LABELS = {"head": "head", "head-tail": "head+tail", "outline": "outline"}
+DEFAULT_TRUNCATE_LINES = 400  # Lines kept by head; head-tail keeps half from each end
+DEFAULT_CHUNK_TOKENS = 6_000  # Largest part of a file added with /add --chunks
+MAX_TRUNCATED_CHARS = 32_000  # However short the lines, so minified files stay small too
+TRUNCATED_NOTE = "(truncated"
+
+@dataclass
+class Part:
+    """One part of a file split by split_parts."""
+    content: str
+    first_line: int  # From 1
+    last_line: int
+
+@dataclass
+class Truncation:
+    content: str  # What goes in context, starting with the note
+    strategy: str  # The one applied: outline becomes head-tail for files it can't outline
+    max_lines: int = DEFAULT_TRUNCATE_LINES
+
+    @property
+    def label(self) -> str:
+        return f"truncated ({LABELS[self.strategy]})"
+
+def _head(lines: Iterable[str], max_lines: int, max_chars: int) -> Tuple[List[str], int]:
+    """The first lines within both budgets, and how many lines there are in all."""
+    kept: List[str] = []
+    chars = total = 0
+    for line in lines:
+        total += 1
+        if len(kept) < max_lines and chars < max_chars:
+            line = line[:max_chars - chars]
+            kept.append(line if line.endswith("\n") else line + "\n")
+            chars += len(line)
+    return kept, total
+
+def _head_tail(lines: Iterable[str], max_lines: int, max_chars: int) -> Tuple[List[str], List[str], int]:
+    """The first and last lines, each within half of both budgets, and the total line count."""
+    head: List[str] = []
+    tail: Deque[str] = deque()
+    head_chars = tail_chars = total = 0
+    half_lines, half_chars = max(max_lines // 2, 1), max_chars // 2
+    for line in lines:
+        total += 1
+        if len(head) < half_lines and head_chars < half_chars:
+            line = line[:half_chars - head_chars]
+            head.append(line if line.endswith("\n") else line + "\n")
+            head_chars += len(line)
+            continue
+        line = line[-half_chars:]
+        tail.append(line if line.endswith("\n") else line + "\n")
+        tail_chars += len(line)
+        while len(tail) > half_lines or tail_chars > half_chars:
+            tail_chars -= len(tail.popleft())
+    return head, list(tail), total
+
+def _note(label: str, shown: str, total: int) -> str:
+    return (f"{TRUNCATED_NOTE} ({label}): the file is over the size limit and has {total} lines; showing {shown}. "
+            "Use read_file with start_line and end_line for the rest.)\n\n")
+
+def truncate_lines(lines: Iterable[str], strategy: str, max_lines: int = DEFAULT_TRUNCATE_LINES) -> Truncation:
+    """Cut a file, given as its lines, by 'head' or 'head-tail'."""
+    if strategy == "head":
+        kept, total = _head(lines, max_lines, MAX_TRUNCATED_CHARS)
+        content = "".join(kept)
+        if total > len(kept):
+            content += f"... [lines {len(kept) + 1}-{total} omitted] ...\n"
+        return Truncation(_note("head", f"lines 1-{len(kept)}", total) + content, "head", max_lines)
+    head, tail, total = _head_tail(lines, max_lines, MAX_TRUNCATED_CHARS)
+    first_tail = total - len(tail) + 1
+    shown = f"lines 1-{len(head)} and {first_tail}-{total}" if tail else f"lines 1-{len(head)}"
+    gap = f"... [lines {len(head) + 1}-{first_tail - 1} omitted] ...\n" if first_tail > len(head) + 1 else ""
+    return Truncation(_note("head+tail", shown, total) + "".join(head) + gap + "".join(tail), "head-tail", max_lines)
+
+def truncate_outline(path: str, content: str, max_lines: int = DEFAULT_TRUNCATE_LINES) -> Truncation:
+    """The outline of a file's full 'content', itself cut to the head if it is still too long."""
+    outline, outlined = outline_source(path, content)
+    if not outlined:
+        return truncate_lines(content.splitlines(keepends=True), "head-tail", max_lines)
+    total = content.count("\n") + (not content.endswith("\n"))
+    kept, outline_total = _head(outline.splitlines(keepends=True), max_lines * 2, MAX_TRUNCATED_CHARS)
+    rest = f"... [last {outline_total - len(kept)} outline lines omitted] ...\n" if outline_total > len(kept) else ""
+    return Truncation(_note("outline", "the declarations without function bodies", total) + "".join(kept) + rest, "outline", max_lines)
+
+def strategy_of(content: str) -> Optional[str]:
+    """The strategy that cut 'content', from its note; None if it isn't truncated."""
+    for strategy, label in LABELS.items():
+        if content.startswith(f"{TRUNCATED_NOTE} ({label})"):
+            return strategy
+    return None
+
+def split_parts(content: str, max_tokens: int = DEFAULT_CHUNK_TOKENS) -> List[Part]:
+    """Cut 'content' into parts of at most about 'max_tokens' each, between lines.
+
+    A single line longer than that, as in a minified file or a dump, is cut within
+    the line, so one part may end and the next start in the middle of it.
+    """
+    pieces: List[Tuple[int, str]] = []  # (line number, text): whole lines, or slices of the long ones
+    for number, line in enumerate(content.splitlines(keepends=True), 1):
+        if estimate_string(line) > max_tokens:
+            width = max_tokens * 2  # Characters, at a conservative two per token
+            pieces.extend((number, line[i:i + width]) for i in range(0, len(line), width))
+        else:
+            pieces.append((number, line))
+    parts: List[Part] = []
+    kept: List[Tuple[int, str]] = []
+    tokens = 0
+    for piece in pieces:
+        piece_tokens = estimate_string(piece[1])
+        if kept and tokens + piece_tokens > max_tokens:
+            parts.append(Part("".join(text for _, text in kept), kept[0][0], kept[-1][0]))
+            kept, tokens = [], 0
+        kept.append(piece)
+        tokens += piece_tokens
+    if kept:
+        parts.append(Part("".join(text for _, text in kept), kept[0][0], kept[-1][0]))
+    return parts
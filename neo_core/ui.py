@@ -0,0 +1,742 @@
+import random
+import re
+import time
+import difflib
+import io
+import os
+import shutil
+import sys
+import threading
+from dataclasses import dataclass, field
+from typing import Callable, Dict, List, Optional, TextIO
+from rich.console import Console
+from rich.markup import escape
+from rich.table import Table
+from rich.panel import Panel
+from rich.theme import Theme
+from rich.align import Align
+from rich.text import Text
+from prompt_toolkit import PromptSession
+from prompt_toolkit.key_binding import KeyBindings
+from prompt_toolkit.keys import Keys
+
+from neo_core.codelang import GUESS_LINES, guess_language
+from neo_core.fileops import FileToEdit
+
+# Matrix theme
+MATRIX_THEME = Theme({
+    "matrix.primary": "bright_green",
+    "matrix.secondary": "green", 
+    "matrix.dim": "dim green",
+    "matrix.accent": "bright_cyan",
+    "matrix.error": "bright_red",
+    "matrix.warning": "yellow",
+    "matrix.success": "bright_green",
+    "matrix.code": "bright_green on grey15",
+    "matrix.inline_code": "bright_cyan on grey19",
+    "matrix.border": "green",
+    "matrix.rain": "dim green",
+    "matrix.match": "bold black on bright_green",  # What /search found, inside its snippet
+    "matrix.removed": "dim bright_red",  # Theme names can't be combined in a style string
+    "matrix.quote": "dim green italic",
+})
+
+# Matrix rain characters
+MATRIX_CHARS = "ｱｲｳｴｵｶｷｸｹｺｻｼｽｾｿﾀﾁﾂﾃﾄﾅﾆﾇﾈﾉﾊﾋﾌﾍﾎﾏﾐﾑﾒﾓﾔﾕﾖﾗﾘﾙﾚﾛﾜﾝ0123456789"
+
+# ASCII Art
+NEO_ASCII = """
+███╗   ██╗███████╗ ██████╗ 
+████╗  ██║██╔════╝██╔═══██╗
+██╔██╗ ██║█████╗  ██║   ██║
+██║╚██╗██║██╔══╝  ██║   ██║
+██║ ╚████║███████╗╚██████╔╝
+╚═╝  ╚═══╝╚══════╝ ╚═════╝ 
+"""
+
+MATRIX_QUOTES = [
+    "Welcome to the real world...",
+    "There is no spoon.",
+    "Follow the white rabbit.",
+    "The Matrix has you...",
+    "Wake up, Neo...",
+    "I know kung fu.",
+    "Free your mind.",
+]
+
+# Verbosity levels: QUIET keeps replies, confirmations, errors and the stats line, and drops the
+# animations, banners, spinners, progress chatter and blank-line padding around them
+QUIET, NORMAL = 0, 1
+
+class _SilentStatus:
+    """What console.status returns in quiet mode: a spinner that never draws."""
+
+    def __enter__(self) -> "_SilentStatus":
+        return self
+
+    def __exit__(self, *exc) -> None:
+        pass
+
+    def update(self, *args, **kwargs) -> None:
+        pass
+
+STREAM_FLUSH_SECONDS = 0.05  # While a reply streams, output reaches the terminal at most this often
+
+class TerminalWriter:
+    """The console's output stream, which batches writes while a reply streams.
+
+    Rich writes and flushes once per print, so a reply reached the terminal as one
+    write per line and reasoning as one per chunk. Over a slow SSH link each write
+    is a packet, and the screen flickers with half-drawn lines. Between
+    begin_stream() and end_stream(), output is held and written in one go at most
+    every STREAM_FLUSH_SECONDS, up to the last complete line when there is one. A
+    timer writes whatever is left if the stream pauses. Outside a stream every
+    flush goes straight through. sys.stdout is looked up on each write, so
+    patch_stdout and mcp-serve's redirect to stderr still apply.
+    """
+
+    def __init__(self, interval: float = STREAM_FLUSH_SECONDS):
+        self.interval = interval
+        self.writes = 0  # Writes that reached the terminal, to measure the batching
+        self._lock = threading.RLock()
+        self._pending: List[str] = []
+        self._streaming = False
+        self._last_write = 0.0
+        self._timer: Optional[threading.Timer] = None
+
+    @property
+    def target(self) -> TextIO:
+        return sys.stdout
+
+    @property
+    def encoding(self) -> str:
+        return getattr(self.target, "encoding", None) or "utf-8"
+
+    def isatty(self) -> bool:
+        return self.target.isatty()
+
+    def fileno(self) -> int:
+        return self.target.fileno()
+
+    def write(self, text: str) -> int:
+        with self._lock:
+            self._pending.append(text)
+        return len(text)
+
+    def flush(self) -> None:
+        with self._lock:
+            if not self._streaming:
+                self._write_pending()
+            elif time.monotonic() - self._last_write >= self.interval:
+                self._write_pending(whole_lines=True)
+            else:
+                self._schedule()
+
+    def begin_stream(self) -> None:
+        with self._lock:
+            self._streaming = True
+
+    def end_stream(self) -> None:
+        """Write everything held back and go back to writing through; safe to call when not streaming."""
+        with self._lock:
+            self._streaming = False
+            if self._timer:
+                self._timer.cancel()
+                self._timer = None
+            self._write_pending()
+
+    def _schedule(self) -> None:
+        if self._timer is None and self._pending:
+            delay = max(self.interval - (time.monotonic() - self._last_write), 0.0)
+            self._timer = threading.Timer(delay, self._on_timer)
+            self._timer.daemon = True
+            self._timer.start()
+
+    def _on_timer(self) -> None:
+        with self._lock:
+            self._timer = None
+            self._write_pending()
+
+    def _write_pending(self, whole_lines: bool = False) -> None:
+        text = "".join(self._pending)
+        cut = (text.rfind("\n") + 1 or len(text)) if whole_lines else len(text)
+        self._pending = [text[cut:]] if cut < len(text) else []
+        if cut:
+            target = self.target
+            target.write(text[:cut])
+            target.flush()
+            self.writes += 1
+            self._last_write = time.monotonic()
+        if self._streaming:
+            self._schedule()  # The rest of a line, written if nothing follows it in time
+
+class TerminalSize:
+    """The console's width and height, following the terminal as it is resized.
+
+    Wrapping, centering and tables all use the console's width, which used to stay
+    at 120 columns. The SIGWINCH handler (see neo_core.terminal) only marks the
+    size stale, as a signal handler must not print or take locks. The console reads
+    the new size before it starts its next line, never in the middle of one, so no
+    line is wrapped for two widths. prompt_toolkit takes SIGWINCH over while a
+    prompt is up and drops the handler afterwards, so each prompt puts it back on
+    return (see MatrixPromptSession). Output that isn't a terminal keeps 120.
+    """
+
+    def __init__(self) -> None:
+        self.stale = False
+        self.changes = 0  # Sizes applied, so animations can tell the size moved
+        self.reinstall: Optional[Callable[[], None]] = None  # Puts the SIGWINCH handler back after a prompt
+
+    def mark_stale(self) -> None:
+        self.stale = True
+
+    def refresh(self, console: Console) -> bool:
+        """Read the terminal's size if it was marked stale; whether the console's size changed."""
+        if not self.stale:
+            return False
+        self.stale = False
+        if not console.file.isatty():
+            return False
+        columns, lines = shutil.get_terminal_size((console.width, console.height))
+        if (columns, lines) == (console.width, console.height):
+            return False
+        console.width, console.height = columns, lines
+        self.changes += 1
+        return True
+
+    def after_prompt(self) -> None:
+        if self.reinstall:
+            self.reinstall()
+        self.stale = True  # It may have been resized while the prompt had the signal
+
+terminal_size = TerminalSize()
+
+class MatrixConsole(Console):
+    """The Rich console with a verbosity level; see decorate() for output that quiet mode drops."""
+
+    verbosity = NORMAL
+    _line_open = False  # The last print ended mid-line (end=""), so a bare print() finishes that line
+
+    def print(self, *objects, **kwargs) -> None:
+        if not self._line_open:
+            terminal_size.refresh(self)  # Between lines only; see TerminalSize
+        end = kwargs.get("end", "\n")
+        if self.verbosity == QUIET and end == "\n" and all(isinstance(o, str) for o in objects):
+            objects = tuple(o.strip("\n") for o in objects)  # The padding around messages, not their lines
+            if not any(objects) and not self._line_open:
+                return
+        super().print(*objects, **kwargs)
+        text = "".join(str(o) for o in objects) if end == "" else None
+        if text is None:
+            self._line_open = False
+        elif text:
+            self._line_open = not text.endswith("\n")
+
+    def status(self, *args, **kwargs):
+        return _SilentStatus() if self.verbosity == QUIET else super().status(*args, **kwargs)
+
+# Initialize Rich console with Matrix theme
+terminal = TerminalWriter()
+console = MatrixConsole(file=terminal, theme=MATRIX_THEME, width=120, soft_wrap=True)
+
+def set_verbosity(level: int) -> None:
+    console.verbosity = level
+
+def is_quiet() -> bool:
+    return console.verbosity == QUIET
+
+def decorate(*objects, **kwargs) -> None:
+    """Print output that is atmosphere or progress chatter rather than substance; quiet mode skips it."""
+    if not is_quiet():
+        console.print(*objects, **kwargs)
+class MatrixPromptSession(PromptSession):
+    """The prompt session, handing SIGWINCH back to the console after every prompt.
+
+    It also notes whether the line just read had a paste of several lines in it. With
+    bracketed paste, which prompt_toolkit turns on, the terminal sends a paste as one
+    event and its newlines land in the line instead of submitting it line by line;
+    run_repl then sends that line as a message, whatever it starts with.
+    """
+
+    def __init__(self, **kwargs) -> None:
+        super().__init__(key_bindings=self._paste_bindings(), **kwargs)
+        self.pasted = False  # The last prompt's answer had a multi-line paste in it
+
+    def _paste_bindings(self) -> KeyBindings:
+        kb = KeyBindings()
+
+        @kb.add(Keys.BracketedPaste)
+        def _(event):
+            data = event.data.replace("\r\n", "\n").replace("\r", "\n")
+            if "\n" in data.strip("\n"):
+                self.pasted = True
+            event.current_buffer.insert_text(data)
+
+        return kb
+
+    def prompt(self, *args, **kwargs):
+        self.pasted = False
+        try:
+            return super().prompt(*args, **kwargs)
+        finally:
+            terminal_size.after_prompt()
+
+prompt_session = MatrixPromptSession()
+
+# Inline markdown spans, earliest match first: `code`, **bold**, *italics*. Markers must hug their
+# text (so "2 * 3 * 4" is left alone), and anything unmatched prints literally. Bold takes the
+# last of a run of stars, so ***both*** is bold around italics.
+INLINE_SPAN_RE = re.compile(
+    r"(?P<ticks>`+)(?P<code>.+?)(?P=ticks)"
+    r"|\*\*(?P<bold>\S(?:.*?\S)??)\*\*(?!\*)"
+    r"|(?<![*\w])\*(?P<italic>[^\s*](?:[^*]*?[^\s*])?)\*(?![*\w])"
+)
+
+def format_inline(text: str, style: str = "matrix.primary") -> Text:
+    """Style inline code, bold and italics within a line; nested spans combine their styles."""
+    result = Text()
+    position = 0
+    for match in INLINE_SPAN_RE.finditer(text):
+        if match.start() > position:
+            result.append(text[position:match.start()], style=style)
+        if match.group("code") is not None:
+            result.append(match.group("code").strip() or match.group("code"), style="matrix.inline_code")
+        else:
+            # Theme names can't be combined in a style string, so bold and italics go on as spans of their own
+            start = len(result)
+            result.append(format_inline(match.group("bold") or match.group("italic"), style))
+            result.stylize("bold" if match.group("bold") is not None else "italic", start, len(result))
+        position = match.end()
+    if position < len(text):
+        result.append(text[position:], style=style)
+    return result
+
+TABLE_SEPARATOR_RE = re.compile(r"^\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?$")
+
+def is_table_row(line: str) -> bool:
+    stripped = line.strip()
+    return len(stripped) > 1 and stripped.startswith("|")
+
+def split_table_row(line: str) -> List[str]:
+    """The cells of a markdown table row, honouring escaped pipes."""
+    stripped = line.strip()
+    if stripped.startswith("|"):
+        stripped = stripped[1:]
+    if stripped.endswith("|") and not stripped.endswith("\\|"):
+        stripped = stripped[:-1]
+    return [cell.replace("\\|", "|") for cell in re.split(r"(?<!\\)\|", stripped)]
+
+@dataclass
+class CodeBlock:
+    language: str  # The rest of the opening fence, such as "python" or "go main.go"
+    lines: List[str] = field(default_factory=list)
+    lead: str = ""  # The line of prose just before the block, which often says what it is for
+
+    @property
+    def content(self) -> str:
+        return "\n".join(self.lines) + "\n" if self.lines else ""
+
+class MatrixTextFormatter:
+    """Formats streaming text for better readability in Matrix theme."""
+    
+    def __init__(self, console: Console):
+        self.console = console
+        self.buffer = ""
+        self.in_code_block = False
+        self.code_language = ""
+        self.current_line = ""
+        self.table_lines: List[str] = []  # A markdown table is buffered until its last row
+        self.code_blocks: List[CodeBlock] = []  # Every code block so far, numbered from 1 for /apply
+        self.last_text = ""  # The latest line of prose, kept as the lead of a block that follows it
+        self.held_lines: Optional[List[str]] = None  # The first lines of a bare ``` block, until its language is guessed
+        
+    def process_chunk(self, chunk: str) -> None:
+        """Process a chunk of streaming text with proper formatting."""
+        self.buffer += chunk
+        
+        # Check for complete lines or sentences
+        lines = self.buffer.split('\n')
+        
+        # Process all complete lines except the last (which might be incomplete)
+        for line in lines[:-1]:
+            self._format_and_print_line(line)
+        
+        # Keep the last incomplete line in buffer
+        self.buffer = lines[-1]
+    
+    def _format_and_print_line(self, line: str) -> None:
+        """Format and print a complete line."""
+        if self.table_lines:
+            if is_table_row(line):
+                self.table_lines.append(line)
+                return
+            self._flush_table()
+
+        if not line.strip():
+            return
+            
+        # Handle code blocks
+        if line.strip().startswith('```'):
+            if not self.in_code_block:
+                # Starting code block
+                self.in_code_block = True
+                self.code_language = line.strip()[3:].strip()
+                self.code_blocks.append(CodeBlock(self.code_language or "text", lead=self.last_text))
+                if self.code_language:
+                    self._print_code_header()
+                else:
+                    self.held_lines = []  # The header waits for a guess at the language
+            else:
+                # Ending code block
+                self._release_held_lines()
+                self.in_code_block = False
+                self._print_code_footer()
+            return
+            
+        if self.in_code_block:
+            # Format code with syntax highlighting
+            self.code_blocks[-1].lines.append(line)
+            if self.held_lines is None:
+                self.console.print(f"[matrix.code]│ {escape(line)}[/matrix.code]")
+                return
+            self.held_lines.append(line)
+            language = guess_language(self.held_lines)
+            if language or len(self.held_lines) >= GUESS_LINES:
+                self._release_held_lines(language)
+        elif is_table_row(line):
+            self.table_lines.append(line)
+        else:
+            # Regular text - wrap and format nicely
+            self.last_text = line
+            self._print_formatted_text(line)
+
+    def _print_code_header(self) -> None:
+        self.console.print(f"\n[matrix.accent]┌─ Code [{len(self.code_blocks)}] ({escape(self.code_blocks[-1].language)}) ─[/matrix.accent]")
+
+    def _release_held_lines(self, language: Optional[str] = None) -> None:
+        """Print the header of a bare block as 'language' ("text" when None), then the lines held for it."""
+        if self.held_lines is None:
+            return
+        lines, self.held_lines = self.held_lines, None
+        self.code_blocks[-1].language = language or "text"
+        self._print_code_header()
+        for line in lines:
+            self.console.print(f"[matrix.code]│ {escape(line)}[/matrix.code]")
+
+    def _print_code_footer(self) -> None:
+        block = self.code_blocks[-1]
+        count = len(block.lines)
+        self.console.print(f"[matrix.accent]└─ {escape(block.language)} · {count} line{'s' if count != 1 else ''} ─[/matrix.accent] "
+                           f"[matrix.dim]/apply {len(self.code_blocks)} <path>[/matrix.dim]\n")
+
+    def _flush_table(self) -> None:
+        """Render the buffered table, or print the lines as text if they turned out not to be one."""
+        lines, self.table_lines = self.table_lines, []
+        if len(lines) < 2 or not TABLE_SEPARATOR_RE.match(lines[1].strip()):
+            for line in lines:
+                self._print_formatted_text(line)
+            return
+
+        headers = split_table_row(lines[0])
+        alignments = [cell.strip() for cell in split_table_row(lines[1])]
+        alignments += [""] * (len(headers) - len(alignments))
+        table = Table(show_header=True, header_style="matrix.accent", border_style="matrix.dim", show_lines=False)
+        for header, alignment in zip(headers, alignments):
+            justify = "center" if alignment.startswith(":") and alignment.endswith(":") else "right" if alignment.endswith(":") else "left"
+            table.add_column(format_inline(header.strip(), "matrix.accent"), justify=justify, overflow="ellipsis")
+        columns = len(headers)
+        for line in lines[2:]:
+            cells = [cell.strip() for cell in split_table_row(line)][:columns]
+            cells += [""] * (columns - len(cells))
+            table.add_row(*(format_inline(cell) for cell in cells))
+        # Wider tables shrink their columns to fit, truncating cells with an ellipsis
+        self.console.print(table)
+    
+    BULLET_MARKERS = ["•", "◦", "▪"]
+    BULLET_STYLES = ["matrix.accent", "matrix.secondary", "matrix.dim"]
+
+    def _print_formatted_text(self, text: str) -> None:
+        """Print formatted regular text, keeping its indentation."""
+        expanded = text.expandtabs(4)
+        stripped = expanded.lstrip()
+        indent = len(expanded) - len(stripped)
+
+        # Handle bullets and lists, detected on the trimmed line
+        bullet = re.match(r'^[-*•+]\s+', stripped)
+        numbered = re.match(r'^(\d+[.)])\s+', stripped)
+        if bullet:
+            level = indent // 2
+            marker = self.BULLET_MARKERS[min(level, len(self.BULLET_MARKERS) - 1)]
+            style = self.BULLET_STYLES[min(level, len(self.BULLET_STYLES) - 1)]
+            self._print_wrapped(stripped[bullet.end():], "  " * (level + 1), marker, style)
+        elif numbered:
+            self._print_wrapped(stripped[numbered.end():], " " * indent, numbered.group(1), "matrix.accent")
+        else:
+            # Regular paragraph text
+            self._print_wrapped(stripped, " " * indent)
+
+    def _print_wrapped(self, text: str, indent: str, marker: str = "", marker_style: str = "") -> None:
+        """Print text wrapped to the console width, continuation lines aligned under the text, not the marker."""
+        prefix = indent + (marker + " " if marker else "")
+        hanging = Text(" " * len(prefix))
+        width = max(self.console.width - len(prefix), 20)
+        lines = format_inline(text).wrap(self.console, width) or [Text()]
+        styled_marker = Text.assemble(indent, (marker, marker_style), " ") if marker else Text(indent)
+        for i, line in enumerate(lines):
+            lead = styled_marker if i == 0 else hanging
+            self.console.print(Text.assemble(lead, line), soft_wrap=True)
+    
+    def finalize(self) -> None:
+        """Process any remaining buffer content. Calling it again prints nothing more."""
+        buffer, self.buffer = self.buffer, ""
+        if buffer.strip():
+            self._format_and_print_line(buffer)
+        # A table cut off at the end of the stream renders with the rows received
+        if self.table_lines:
+            self._flush_table()
+        
+        # Close any open code blocks
+        if self.in_code_block:
+            self._release_held_lines()
+            self.in_code_block = False
+            self._print_code_footer()
+        self.reset()
+
+    def reset(self) -> None:
+        """Start the next stream outside any code block or table. Code blocks stay numbered for /apply."""
+        self.buffer = ""
+        self.in_code_block = False
+        self.code_language = ""
+        self.held_lines = None
+        self.current_line = ""
+        self.table_lines = []
+
+def render_reply(text: str, width: int) -> List[str]:
+    """Render a reply through the stream formatter, returning ANSI-styled lines for the pager."""
+    recorder = Console(theme=MATRIX_THEME, width=width, record=True, force_terminal=True, file=io.StringIO())
+    formatter = MatrixTextFormatter(recorder)
+    formatter.process_chunk(text)
+    formatter.finalize()
+    return recorder.export_text(styles=True).splitlines()
+
+RAIN_WIDTH = 80  # Columns of rain, or fewer in a narrower terminal
+
+class MatrixRain:
+    """Matrix-style digital rain effect"""
+    
+    def __init__(self, width: int = RAIN_WIDTH, height: int = 5):
+        self.width = width
+        self.height = height
+        self.columns = {}
+        self.speeds = {}
+
+    def fit(self, width: int) -> None:
+        """Follow a resized terminal: columns past the new width are dropped, and new ones start empty."""
+        if width == self.width:
+            return
+        self.width = width
+        for col in [col for col in self.columns if col >= width]:
+            del self.columns[col]
+            del self.speeds[col]
+        
+    def update(self):
+        """Update rain animation"""
+        # Add new columns
+        for col in range(self.width):
+            if col not in self.columns:
+                if random.random() < 0.02:  # Spawn rate
+                    self.columns[col] = []
+                    self.speeds[col] = random.uniform(0.5, 1.5)
+        
+        # Update existing columns
+        for col in list(self.columns.keys()):
+            if random.random() < self.speeds[col] * 0.1:
+                self.columns[col].append(random.choice(MATRIX_CHARS))
+            
+            # Limit column length
+            if len(self.columns[col]) > self.height:
+                self.columns[col].pop(0)
+            
+            # Remove empty columns randomly
+            if len(self.columns[col]) == 0 and random.random() < 0.1:
+                del self.columns[col]
+                del self.speeds[col]
+    
+    def render(self) -> Text:
+        """Render the rain effect, one line per row and a column per character of the width"""
+        self.update()
+        
+        # Create grid
+        grid = [[' ' for _ in range(self.width)] for _ in range(self.height)]
+        
+        # Fill with rain
+        for col, chars in self.columns.items():
+            for i, char in enumerate(chars):
+                row = self.height - len(chars) + i
+                if 0 <= row < self.height:
+                    grid[row][col] = char
+        
+        # Convert to string with styling
+        lines = []
+        for row in grid:
+            line = Text()
+            for char in row:
+                # Spaces keep every drop in its column
+                line.append(char, style="matrix.rain" if char != ' ' else "")
+            lines.append(line)
+        
+        return Text("\n").join(lines)
+
+
+def rain_width() -> int:
+    return max(min(RAIN_WIDTH, console.width), 1)
+
+def display_matrix_exit():
+    """Display Matrix rain exit sequence."""
+    if is_quiet():
+        return
+    console.print("\n[matrix.dim]> Exiting the Matrix...[/matrix.dim]")
+    rain = MatrixRain(width=rain_width(), height=10)
+    for _ in range(20):
+        terminal_size.refresh(console)
+        rain.fit(rain_width())
+        console.clear()
+        console.print(rain.render())
+        time.sleep(0.1)
+    console.print("\n[matrix.primary]> Remember... there is no spoon.[/matrix.primary]")
+
+def display_intro():
+    """Show the rain, ASCII art and system info banner."""
+    if is_quiet():
+        return
+    # Show ASCII art with rain effect
+    terminal_size.refresh(console)
+    rain = MatrixRain(width=rain_width(), height=3)
+    console.print(rain.render())
+    
+    # Show NEO ASCII
+    console.print(Align.center(Text(NEO_ASCII, style="matrix.primary")))
+    
+    # Show random quote
+    quote = random.choice(MATRIX_QUOTES)
+    console.print(Align.center(Text(quote, style="matrix.quote")))
+    
+    console.print("\n" * 2)
+    
+    # System info
+    info = Panel(
+        Text.from_markup(
+            "[matrix.primary]SYSTEM: NEO v1.0[/matrix.primary]\n"
+            "[matrix.secondary]STATUS: ONLINE[/matrix.secondary]\n"
+            "[matrix.dim]REALITY: SIMULATED[/matrix.dim]"
+        ),
+        title="[matrix.accent][ SYSTEM INFO ][/matrix.accent]",
+        border_style="matrix.border",
+        width=40
+    )
+    console.print(Align.center(info))
+
+def show_diff_table(files_to_edit: List[FileToEdit]) -> None:
+    if not files_to_edit:
+        return
+    
+    table = Table(title="[matrix.accent][ PROPOSED MODIFICATIONS ][/matrix.accent]", show_header=True, header_style="matrix.primary", show_lines=True, border_style="matrix.border")
+    table.add_column("File Path", style="matrix.accent", no_wrap=True)
+    table.add_column("Original", style="matrix.removed")
+    table.add_column("New", style="matrix.success")
+
+    for edit in files_to_edit:
+        table.add_row(edit.path, edit.original_snippet, edit.new_snippet)
+    
+    console.print(table)
+
+ALL_OCCURRENCES = 0  # choose_occurrence's answer for "all"
+OCCURRENCE_CONTEXT_LINES = 2  # Shown before and after each match
+
+def choose_occurrence(path: str, content: str, starts: List[int], snippet: str) -> Optional[int]:
+    """Show each match of an edit's snippet with the lines around it and ask which one to change.
+
+    Returns the match's number from 1, ALL_OCCURRENCES, or None to cancel.
+    """
+    lines = content.splitlines()
+    length = snippet.rstrip("\n").count("\n") + 1
+    width = len(str(min(starts[-1] + length - 1 + OCCURRENCE_CONTEXT_LINES, len(lines))))
+    console.print(f"[matrix.warning]⚠ The snippet matches {len(starts)} times in[/matrix.warning] [matrix.accent]{escape(path)}[/matrix.accent]")
+    for number, start in enumerate(starts, 1):
+        end = start + length - 1
+        rows = []
+        for line in range(max(start - OCCURRENCE_CONTEXT_LINES, 1), min(end + OCCURRENCE_CONTEXT_LINES, len(lines)) + 1):
+            matched = start <= line <= end
+            style = "matrix.primary" if matched else "matrix.dim"
+            rows.append(f"[{style}]{'>' if matched else ' '} {line:>{width}} │ {escape(lines[line - 1])}[/{style}]")
+        console.print(Panel("\n".join(rows), title=f"[matrix.accent][ {number}: LINE {start} ][/matrix.accent]",
+                            border_style="matrix.border", title_align="left"))
+    while True:
+        try:
+            answer = prompt_session.prompt(f"Edit which occurrence? [1-{len(starts)}, all, cancel]: ").strip().lower()
+        except (EOFError, KeyboardInterrupt):
+            return None
+        if answer in ("", "cancel", "c", "n", "no"):
+            return None
+        if answer in ("all", "a"):
+            return ALL_OCCURRENCES
+        if answer.isdigit() and 1 <= int(answer) <= len(starts):
+            return int(answer)
+        console.print(f"[matrix.warning]⚠ Not an occurrence: {escape(answer)}[/matrix.warning]")
+
+def show_unified_diff(path: str, old: str, new: str) -> None:
+    """Show the change from 'old' to 'new' as a unified diff."""
+    diff = list(difflib.unified_diff(old.splitlines(), new.splitlines(), f"a/{path}", f"b/{path}", lineterm=""))
+    if not diff:
+        console.print(f"[matrix.dim]> No changes to {path}[/matrix.dim]")
+        return
+    styled = []
+    for line in diff:
+        style = "matrix.success" if line.startswith("+") else "matrix.error" if line.startswith("-") else "matrix.accent" if line.startswith("@@") else "matrix.dim"
+        styled.append(f"[{style}]{escape(line)}[/{style}]")
+    console.print(Panel("\n".join(styled), title=f"[matrix.accent][ DIFF: {escape(path)} ][/matrix.accent]", border_style="matrix.border", title_align="left"))
+
+def show_file_preview(path: str, content: str, max_lines: int = 20) -> None:
+    """Show the start of a file that is about to be written."""
+    lines = content.splitlines()
+    preview = "\n".join(lines[:max_lines])
+    if len(lines) > max_lines:
+        preview += f"\n... ({len(lines) - max_lines} more lines)"
+    console.print(Panel(preview, title=f"[matrix.accent][ PREVIEW: {path} ][/matrix.accent]", border_style="matrix.border", title_align="left"))
+
+def confirm_file_change(action: str, target: str, auto_approve: bool = False) -> bool:
+    """Ask the user before a tool touches the filesystem, unless auto-approve is on."""
+    if auto_approve:
+        return True
+    try:
+        answer = prompt_session.prompt(f"Allow {action} of '{target}'? [y/N]: ")
+    except (EOFError, KeyboardInterrupt):
+        return False
+    return answer.strip().lower() in ("y", "yes")
+
+def notify_finished(summary: str) -> None:
+    """Ring the terminal bell and, on terminals known to support it, raise a desktop notification."""
+    if not sys.stdout.isatty():
+        return
+    message = summary.replace("\a", "").replace("\033", "")
+    sequence = "\a"
+    if os.getenv("TERM_PROGRAM") in ("iTerm.app", "WezTerm") or os.getenv("WT_SESSION"):
+        sequence += f"\033]9;{message}\a"  # OSC 9
+    elif os.getenv("VTE_VERSION") or os.getenv("TERM", "").startswith(("rxvt", "foot")):
+        sequence += f"\033]777;notify;NEO;{message}\a"  # OSC 777
+    sys.stdout.write(sequence)
+    sys.stdout.flush()
+
+def show_credentials_diagnostic(provider_name: str, provider: Dict[str, str], problem: str, hint: str) -> None:
+    """Explain a credentials problem, naming the provider and the env var that was checked."""
+    console.print(Panel(
+        Text.from_markup(
+            f"[matrix.error]{problem}[/matrix.error]\n\n"
+            f"[matrix.primary]PROVIDER:[/matrix.primary] [matrix.accent]{provider_name}[/matrix.accent] [matrix.dim]({provider['base_url']})[/matrix.dim]\n"
+            f"[matrix.primary]ENV VAR:[/matrix.primary]  [matrix.accent]{provider['api_key_env']}[/matrix.accent]\n\n"
+            f"[matrix.dim]{hint}[/matrix.dim]"
+        ),
+        title="[matrix.error][ ACCESS DENIED ][/matrix.error]",
+        border_style="matrix.error",
+        title_align="left"
+    ))
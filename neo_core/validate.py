@@ -0,0 +1,66 @@
+"""The validation command run after turns that change files (validate_command, /validate).
+
+The command runs through the shell in the workspace root, in its own process group
+so a timeout stops everything it started. Its output is trimmed to the first and
+last lines, where compilers and test runners put what matters.
+"""
+
+import os
+import signal
+import subprocess
+import time
+from dataclasses import dataclass
+from typing import Dict, Optional
+
+MAX_OUTPUT_LINES = 60  # Half from the start of the output, half from the end
+MAX_OUTPUT_CHARS = 6000
+
+@dataclass
+class ValidationResult:
+    command: str
+    exit_code: int
+    output: str  # Trimmed combined stdout and stderr
+    seconds: float
+    timed_out: bool = False
+
+    @property
+    def passed(self) -> bool:
+        return self.exit_code == 0 and not self.timed_out
+
+    def describe(self) -> str:
+        if self.timed_out:
+            return f"`{self.command}` timed out after {self.seconds:.0f}s"
+        return f"`{self.command}` {'passed' if self.passed else f'failed with exit code {self.exit_code}'} in {self.seconds:.1f}s"
+
+def trim_output(output: str) -> str:
+    lines = output.rstrip().splitlines()
+    if len(lines) > MAX_OUTPUT_LINES:
+        half = MAX_OUTPUT_LINES // 2
+        lines = lines[:half] + [f"... ({len(lines) - MAX_OUTPUT_LINES} lines omitted) ..."] + lines[-half:]
+    text = "\n".join(lines)
+    if len(text) > MAX_OUTPUT_CHARS:
+        half = MAX_OUTPUT_CHARS // 2
+        text = f"{text[:half]}\n... ({len(text) - MAX_OUTPUT_CHARS} characters omitted) ...\n{text[-half:]}"
+    return text
+
+def run_validation(command: str, cwd: str, timeout: float, env: Optional[Dict[str, str]] = None) -> ValidationResult:
+    started = time.monotonic()
+    process = subprocess.Popen(command, shell=True, cwd=cwd, env=env, stdout=subprocess.PIPE, stderr=subprocess.STDOUT,
+                               stdin=subprocess.DEVNULL, text=True, encoding="utf-8", errors="replace",
+                               start_new_session=True)
+    try:
+        output, _ = process.communicate(timeout=timeout)
+        timed_out = False
+    except subprocess.TimeoutExpired:
+        if os.name == "posix":
+            os.killpg(process.pid, signal.SIGKILL)
+        else:
+            process.kill()
+        output, _ = process.communicate()
+        timed_out = True
+    return ValidationResult(command, process.returncode, trim_output(output or ""), time.monotonic() - started, timed_out)
+
+def report_for_model(result: ValidationResult) -> str:
+    """The message telling the model its changes broke validation."""
+    return (f"[validation] After your file changes, {result.describe()}:\n\n```\n{result.output or '(no output)'}\n```\n\n"
+            "Fix the cause of this failure. If it is unrelated to your changes, say so instead of changing more files.")
@@ -0,0 +1,85 @@
+"""Live refresh of files in context while you edit them elsewhere (/watch).
+
+A background thread polls the watched files' modification times, which needs no
+platform file-event support and costs one stat per file per interval. A change is
+only applied once the file has stopped changing for one interval, so an editor's
+save-in-several-writes lands as a single refresh.
+"""
+
+import os
+import threading
+from typing import Callable, Dict, List, Optional, Set, Tuple
+
+from neo_core.context import ContextFiles
+
+POLL_INTERVAL = 0.5  # Seconds
+
+class FileWatcher:
+    """Refreshes the context copies of files under the watched paths as they change on disk."""
+
+    def __init__(self, files: ContextFiles, on_change: Callable[[str], None], interval: float = POLL_INTERVAL):
+        self.files = files
+        self.on_change = on_change  # Called from the watcher thread with a one-line notice
+        self.interval = interval
+        self.paths: Set[str] = set()  # Normalized files and directories
+        self._lock = threading.Lock()
+        self._stop = threading.Event()
+        self._thread: Optional[threading.Thread] = None
+        self._settling: Dict[str, Tuple[int, int]] = {}  # Path -> stat seen on the previous poll
+
+    @property
+    def active(self) -> bool:
+        return self._thread is not None and self._thread.is_alive()
+
+    def watch(self, normalized_path: str) -> None:
+        with self._lock:
+            self.paths.add(normalized_path)
+        if not self.active:
+            self._stop.clear()
+            self._thread = threading.Thread(target=self._run, name="neo-watch", daemon=True)
+            self._thread.start()
+
+    def stop(self) -> None:
+        """Stop watching everything and wait for the thread to finish."""
+        with self._lock:
+            self.paths.clear()
+        self._stop.set()
+        if self._thread is not None:
+            self._thread.join(timeout=self.interval * 4)
+            self._thread = None
+        self._settling.clear()
+
+    def watched_files(self) -> List[str]:
+        """The files in context that fall under a watched path."""
+        with self._lock:
+            paths = set(self.paths)
+        return [f for f in self.files.conversation.files()
+                if f in paths or any(f.startswith(os.path.join(p, "")) for p in paths)]
+
+    def _run(self) -> None:
+        while not self._stop.wait(self.interval):
+            try:
+                self.poll()
+            except Exception as e:  # Never let one bad poll end the watcher
+                self.on_change(f"watch error: {e}")
+
+    def poll(self) -> None:
+        """Refresh any watched file that changed and has since held still for a poll."""
+        for path, change in self.files.stale(self.watched_files()):
+            signature = stat_signature(path)
+            if change == "changed" and self._settling.get(path) != signature:
+                self._settling[path] = signature  # Still being written; look again next poll
+                continue
+            self._settling.pop(path, None)
+            self.files.refresh(path)
+            if change == "deleted":
+                self.on_change(f"{path} was deleted or renamed; removed from context")
+            else:
+                self.on_change(f"{path} changed; context refreshed")
+
+def stat_signature(path: str) -> Tuple[int, int]:
+    try:
+        info = os.stat(path)
+    except OSError:
+        return (0, -1)
+    return (info.st_mtime_ns, info.st_size)
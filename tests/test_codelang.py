@@ -0,0 +1,113 @@
+import unittest
+
+from neo_core.codelang import GUESS_LINES, guess_language
+from neo_core.ui import MatrixTextFormatter
+
+# Each snippet is guessed after every line, as the stream formatter asks while it holds a bare block
+SNIPPETS = {
+    "go": [
+        ["package main", "", "import \"fmt\""],
+        ["func main() {", "    x := 1", "    fmt.Println(x)", "}"],
+        ["if err != nil {", "    return err", "}"],
+    ],
+    "python": [
+        ["def add(a, b):", "    return a + b"],
+        ["import os", "for name in os.listdir('.'):", "    print(f'{name}')"],
+        ["class Point:", "    def __init__(self, x):", "        self.x = x"],
+    ],
+    "javascript": [
+        ["const add = (a, b) => {", "  return a + b;", "};"],
+        ["function greet(name) {", "  console.log(`hi ${name}`);", "}"],
+        ["import React from 'react';", "export default function App() {"],
+    ],
+    "bash": [
+        ["$ npm install", "$ npm test"],
+        ["cd project && make build", "ls -la | grep neo"],
+        ["if [ -f .env ]; then", "  source .env", "fi"],
+        ["#!/bin/bash", "echo hi"],
+    ],
+}
+
+class RecordingConsole:
+    """Keeps what the formatter prints instead of rendering it."""
+
+    width = 80
+
+    def __init__(self):
+        self.printed = []
+
+    def print(self, *renderables, **kwargs):
+        self.printed.extend(renderables)
+
+class GuessLanguageTest(unittest.TestCase):
+
+    def test_snippets_are_recognised_from_their_first_line(self):
+        for language, snippets in SNIPPETS.items():
+            for lines in snippets:
+                with self.subTest(lines[0]):
+                    self.assertEqual([guess_language(lines[:count]) for count in range(1, len(lines) + 1)],
+                                     [language] * len(lines))
+
+    def test_json(self):
+        self.assertEqual(guess_language(["[1, 2, 3]"]), "json")
+        self.assertEqual(guess_language(['{"a": {"b": [true, null]}}']), "json")
+
+    def test_json_still_being_streamed(self):
+        lines = ["{", '  "name": "neo",', '  "version": 1']
+        self.assertEqual([guess_language(lines[:count]) for count in range(1, 4)], [None, "json", "json"])
+
+    def test_shebangs(self):
+        self.assertEqual(guess_language(["#!/usr/bin/env python3", "print(1)"]), "python")
+        self.assertEqual(guess_language(["#!/usr/bin/python3.11"]), "python")
+        self.assertEqual(guess_language(["#!/usr/bin/env node"]), "javascript")
+        self.assertEqual(guess_language(["#!/bin/sh", "echo $HOME"]), "sh")
+
+    def test_unknown_shebang_is_not_guessed_further(self):
+        self.assertIsNone(guess_language(["#!/usr/bin/env fish", "echo hi"]))
+        self.assertIsNone(guess_language(["#!/opt/custom/tool"]))
+
+    def test_ambiguous_snippets_stay_unknown(self):
+        for lines in [
+            ["x = 1"],
+            ["return a + b"],
+            ["print(x)"],
+            ["}"],
+            ["{ foo: bar }"],
+            ["if x:", "  y"],
+            ["x := 1", "y = 2"],
+            ["Hello world", "this is prose"],
+            ["", "   "],
+            [],
+        ]:
+            with self.subTest(lines=lines):
+                self.assertIsNone(guess_language(lines))
+
+class BareBlockTest(unittest.TestCase):
+    """The formatter holds a bare block's first lines until it has a guess for the header."""
+
+    def format(self, text):
+        console = RecordingConsole()
+        formatter = MatrixTextFormatter(console)
+        formatter.process_chunk(text)
+        formatter.finalize()
+        return formatter, console.printed
+
+    def test_guessed_language_names_the_block(self):
+        formatter, printed = self.format("```\npackage main\nfunc main() {}\n```\n")
+        self.assertEqual(formatter.code_blocks[0].language, "go")
+        self.assertEqual(printed[:2], ["\n[matrix.accent]┌─ Code [1] (go) ─[/matrix.accent]",
+                                       "[matrix.code]│ package main[/matrix.code]"])
+
+    def test_unclear_block_is_text_after_its_first_lines(self):
+        lines = [f"line {number}" for number in range(GUESS_LINES + 1)]
+        formatter, printed = self.format("```\n" + "\n".join(lines) + "\n```\n")
+        self.assertEqual(formatter.code_blocks[0].language, "text")
+        self.assertEqual(printed[0], "\n[matrix.accent]┌─ Code [1] (text) ─[/matrix.accent]")
+        self.assertEqual(len(printed), len(lines) + 2)
+
+    def test_named_fence_is_not_guessed(self):
+        formatter, _ = self.format("```python\npackage main\n```\n")
+        self.assertEqual(formatter.code_blocks[0].language, "python")
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,77 @@
+import unittest
+
+from neo_core.commands import MAX_ALIAS_EXPANSIONS, AliasError, expand_alias, parse_add_arguments
+from neo_core.config import NeoConfig
+from neo_core.fileops import MAX_SCAN_FILES
+
+class ParseAddArgumentsTest(unittest.TestCase):
+
+    def test_path_and_flags_in_any_order(self):
+        path, options, outline, chunks, verbose = parse_add_arguments("--depth 2 src --outline --max-files 40 --verbose")
+        self.assertEqual(path, "src")
+        self.assertEqual((options.max_depth, options.max_files), (2, 40))
+        self.assertEqual((outline, chunks, verbose), (True, False, True))
+
+    def test_paths_with_spaces_are_joined(self):
+        self.assertEqual(parse_add_arguments("My Documents/notes.txt --chunks")[0], "My Documents/notes.txt")
+
+    def test_sizes_and_truncation(self):
+        _, options, _, _, _ = parse_add_arguments("logs --max-size 256k --truncate head")
+        self.assertEqual((options.max_file_size, options.truncate), (256 * 1024, "head"))
+
+    def test_defaults_come_from_the_config(self):
+        config = NeoConfig(max_scan_files=12, truncate_strategy="outline")
+        _, options, _, _, _ = parse_add_arguments("src", config)
+        self.assertEqual((options.max_files, options.truncate, options.max_depth), (12, "outline", None))
+        _, options, _, _, _ = parse_add_arguments("src --max-files 3", config)
+        self.assertEqual(options.max_files, 3)
+        self.assertEqual(parse_add_arguments("src")[1].max_files, MAX_SCAN_FILES)
+
+    def test_bad_arguments(self):
+        for text, message in (
+            ("", "no path given"),
+            ("--outline", "no path given"),
+            ("src --depth", "--depth needs a value"),
+            ("src --depth 0", "--depth must be a positive whole number"),
+            ("src --max-files lots", "--max-files must be a positive whole number"),
+            ("src --max-size huge", "not a size"),
+            ("src --truncate middle", "--truncate must be one of"),
+            ("src --outline --chunks", "can't be used together"),
+        ):
+            with self.subTest(text=text), self.assertRaisesRegex(ValueError, message):
+                parse_add_arguments(text)
+
+class ExpandAliasTest(unittest.TestCase):
+
+    def test_leading_alias_keeps_its_arguments(self):
+        aliases = {"t": "/add tests --outline", "/r": "/run pytest"}
+        self.assertEqual(expand_alias(aliases, "/t unit"), "/add tests --outline unit")
+        self.assertEqual(expand_alias(aliases, "/r"), "/run pytest")
+        self.assertEqual(expand_alias(aliases, "  /t  "), "/add tests --outline")
+
+    def test_other_input_is_left_alone(self):
+        aliases = {"t": "/add tests"}
+        for text in ("/tests", "t", "run /t", "/add t", ""):
+            with self.subTest(text=text):
+                self.assertEqual(expand_alias(aliases, text), text)
+
+    def test_aliases_nest(self):
+        self.assertEqual(expand_alias({"a": "/b x", "b": "/add"}, "/a y"), "/add x y")
+
+    def test_an_alias_of_its_own_name_runs_the_command(self):
+        self.assertEqual(expand_alias({"add": "/add --outline"}, "/add src"), "/add --outline src")
+
+    def test_loops_and_deep_nesting_are_errors(self):
+        with self.assertRaisesRegex(AliasError, "alias loop: /a -> /b -> /a"):
+            expand_alias({"a": "/b", "b": "/a"}, "/a")
+        chain = {f"a{i}": f"/a{i + 1}" for i in range(MAX_ALIAS_EXPANSIONS + 1)}
+        with self.assertRaisesRegex(AliasError, "nest more than"):
+            expand_alias(chain, "/a0")
+
+    def test_exit_must_be_typed(self):
+        for command in ("/exit", "quit", "/QUIT now"):
+            with self.subTest(command=command), self.assertRaisesRegex(AliasError, "type /exit"):
+                expand_alias({"q": command}, "/q")
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,113 @@
+import io
+import json
+import os
+import tempfile
+import unittest
+from contextlib import redirect_stderr
+from pathlib import Path
+from unittest import mock
+
+import neo
+from neo_core import config
+from neo_core.config import DEFAULT_LISTEN, build_arg_parser, build_config
+
+class ConfigTest(unittest.TestCase):
+    """Flags, environment and config file merge in that order of precedence, and bad values stop Neo with a message."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        self.config_path = Path(self.tmp.name) / "config.json"
+        for patcher in (mock.patch.object(config, "DEFAULT_CONFIG_PATH", self.config_path),
+                        mock.patch.dict(os.environ, {name: "" for name in config.ENV_OVERRIDES})):
+            patcher.start()
+            self.addCleanup(patcher.stop)
+
+    def build(self, argv=(), file_values=None):
+        if file_values is not None:
+            self.config_path.write_text(json.dumps(file_values), encoding="utf-8")
+        parser = build_arg_parser()
+        return build_config(parser.parse_args(list(argv)), parser)
+
+    def assertRejected(self, message, argv=(), file_values=None):
+        stderr = io.StringIO()
+        with redirect_stderr(stderr), self.assertRaises(SystemExit) as caught:
+            self.build(argv, file_values)
+        self.assertEqual(caught.exception.code, 2)
+        self.assertIn(message, stderr.getvalue())
+
+    def test_precedence(self):
+        self.assertEqual(self.build(file_values={"model": "from-file"}).model, "from-file")
+        with mock.patch.dict(os.environ, {"NEO_MODEL": "from-env"}):
+            self.assertEqual(self.build().model, "from-env")
+            self.assertEqual(self.build(["--model", "from-flag"]).model, "from-flag")
+
+    def test_unset_boolean_flags_keep_file_values(self):
+        self.assertTrue(self.build(file_values={"no_intro": True}).no_intro)
+        self.assertTrue(self.build(["--auto-approve"]).auto_approve)
+
+    def test_bad_config_files(self):
+        self.assertRejected("config file not found", ["--config", str(Path(self.tmp.name) / "missing.json")])
+        self.config_path.write_text("{not json", encoding="utf-8")
+        self.assertRejected("could not read config file", [])
+        self.assertRejected("must contain a JSON object", file_values=["model"])
+        self.assertRejected("unknown keys in config file", file_values={"modle": "x"})
+
+    def test_bad_values(self):
+        for values, message in (
+            ({"provider": "nope"}, "unknown provider 'nope'"),
+            ({"max_backups": 0}, "max_backups must be a positive integer"),
+            ({"aliases": {"t": ""}}, "aliases must map names to non-empty command strings"),
+            ({"redact_patterns": {"bad": "("}}, "redact_patterns['bad'] is not a valid regular expression"),
+            ({"refresh_changed_files": "sometimes"}, "refresh_changed_files must be"),
+            ({"formatters": {"py": ["black"]}}, "formatters must map extensions"),
+            ({"fallback_providers": ["mock"]}, "fallback_providers: unknown provider 'mock'"),
+            ({"providers": {"deepseek": {"base_url": "http://x", "default_model": "m"}}}, "can't redefine the built-in deepseek"),
+        ):
+            with self.subTest(values=values):
+                self.assertRejected(message, file_values=values)
+
+    def test_workdir_must_exist_and_is_resolved(self):
+        self.assertRejected("--workdir is not a directory", ["--workdir", str(Path(self.tmp.name) / "missing")])
+        self.assertEqual(self.build(["--workdir", self.tmp.name]).workdir, str(Path(self.tmp.name).resolve()))
+
+class CommandFlagsTest(unittest.TestCase):
+    """Flags that belong to one command are refused with any other, before anything runs."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        patcher = mock.patch.object(config, "DEFAULT_CONFIG_PATH", Path(self.tmp.name) / "config.json")
+        patcher.start()
+        self.addCleanup(patcher.stop)
+
+    def assertRefused(self, argv, message):
+        stderr = io.StringIO()
+        with redirect_stderr(stderr), self.assertRaises(SystemExit) as caught:
+            neo.main(argv)
+        self.assertEqual(caught.exception.code, 2)
+        self.assertIn(message, stderr.getvalue())
+
+    def test_json_only_with_review(self):
+        for argv in (["--json"], ["serve", "--json"], ["mcp-serve", "--json"], ["doctor", "--json"]):
+            with self.subTest(argv=argv):
+                self.assertRefused(argv, "--json only applies to review")
+
+    def test_listen_only_with_serve(self):
+        for argv in (["--listen", "127.0.0.1:9000"], ["review", "--listen", "0.0.0.0:80"], ["mcp-serve", "--listen", DEFAULT_LISTEN],
+                     ["doctor", "--listen", "x"]):
+            with self.subTest(argv=argv):
+                self.assertRefused(argv, "--listen only applies to serve")
+
+    def test_stray_ref(self):
+        self.assertRefused(["serve", "main"], "unexpected argument: main")
+        self.assertRefused(["doctor", "someref"], "unexpected argument: someref")
+
+    def test_serve_listens_on_the_default_or_the_given_address(self):
+        with mock.patch.object(neo, "serve_http") as serve:
+            neo.main(["serve", "--workdir", self.tmp.name])
+            neo.main(["serve", "--workdir", self.tmp.name, "--listen", "127.0.0.1:9000"])
+        self.assertEqual([call.args[2] for call in serve.call_args_list], [DEFAULT_LISTEN, "127.0.0.1:9000"])
+
+if __name__ == "__main__":
+    unittest.main()
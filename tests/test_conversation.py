@@ -0,0 +1,222 @@
+import unittest
+from types import SimpleNamespace
+from unittest import mock
+
+from neo_core.commands import try_handle_forget_command
+from neo_core.conversation import Conversation
+from neo_core.ui import console
+
+def call(id, name="read_file"):
+    return {"id": id, "type": "function", "function": {"name": name, "arguments": "{}"}}
+
+def check_invariants(test, conversation):
+    """The rules every Conversation method keeps, checked on the messages it would send."""
+    messages = conversation.messages()
+    test.assertEqual(messages[0], {"role": "system", "content": conversation.system_prompt})
+    for i, msg in enumerate(messages):
+        if msg["role"] == "tool":
+            owner = next(m for m in reversed(messages[:i]) if m["role"] != "tool")
+            test.assertEqual(owner["role"], "assistant", f"tool result {i} does not follow its assistant message")
+            test.assertIn(msg["tool_call_id"], [tc["id"] for tc in owner["tool_calls"]])
+        if msg["role"] == "assistant" and msg.get("tool_calls") and i + 1 < len(messages):
+            results = []
+            for after in messages[i + 1:]:
+                if after["role"] != "tool":
+                    break
+                results.append(after["tool_call_id"])
+            if results:
+                test.assertEqual(results, [tc["id"] for tc in msg["tool_calls"]])
+    history = conversation.history()
+    if history:
+        test.assertEqual(history[0]["role"], "user", "the history starts on a reply or tool result")
+
+class ConversationTest(unittest.TestCase):
+
+    def setUp(self):
+        self.conversation = Conversation("You are Neo.")
+
+    def exchange(self, n, tool_calls=0):
+        self.conversation.add_user(f"question {n}")
+        if tool_calls:
+            ids = [f"c{n}_{i}" for i in range(tool_calls)]
+            self.conversation.add_assistant("", [call(id) for id in ids])
+            for id in ids:
+                self.conversation.add_tool_result(id, f"result {id}", "read_file")
+        self.conversation.add_assistant(f"answer {n}")
+
+    def test_trim_by_message_count_keeps_pairs_and_files(self):
+        self.conversation.add_file("/w/a.py", "x = 1")
+        for n in range(6):
+            self.exchange(n, tool_calls=n % 3)
+        removed = self.conversation.trim_to_budget(10_000_000, max_messages=6)
+        self.assertGreater(removed, 0)
+        self.assertLessEqual(len(self.conversation.history()), 6)
+        self.assertEqual(self.conversation.files(), ["/w/a.py"])
+        self.assertEqual(self.conversation.history()[-1]["content"], "answer 5")
+        check_invariants(self, self.conversation)
+
+    def test_trim_by_tokens_never_splits_a_tool_call_from_its_results(self):
+        for n in range(8):
+            self.exchange(n, tool_calls=2)
+        for budget in (50, 100, 150, 200, 400):
+            with self.subTest(budget=budget):
+                trial = Conversation("You are Neo.")
+                trial.restore(self.conversation.snapshot())
+                trial.add_user("next question")  # As AgentLoop trims: right after adding the new message
+                trial.trim_to_budget(budget, max_messages=1000)
+                check_invariants(self, trial)
+
+    def test_the_latest_message_is_kept_even_over_budget(self):
+        self.exchange(0)
+        self.conversation.add_user("x" * 10_000)
+        self.conversation.trim_to_budget(10, max_messages=1)
+        self.assertEqual([m["content"] for m in self.conversation.history()], ["x" * 10_000])
+
+    def test_system_context_added_mid_call_goes_before_the_pending_call(self):
+        self.conversation.add_user("read both")
+        self.conversation.add_assistant("", [call("a"), call("b")])
+        self.conversation.add_tool_result("a", "first")
+        self.conversation.add_file("/w/b.py", "y = 2")
+        self.conversation.add_tool_result("b", "second")
+        roles = [m["role"] for m in self.conversation.messages()]
+        self.assertEqual(roles, ["system", "user", "system", "assistant", "tool", "tool"])
+        check_invariants(self, self.conversation)
+
+    def test_forget_removes_a_call_with_its_results(self):
+        self.exchange(0, tool_calls=2)
+        self.exchange(1)
+        removed = self.conversation.forget([3])  # A tool result of the first exchange
+        self.assertEqual([n for n, _ in removed], [2, 3, 4])
+        self.assertEqual([m["content"] for m in self.conversation.history()],
+                         ["question 0", "answer 0", "question 1", "answer 1"])
+        check_invariants(self, self.conversation)
+
+    def test_files_are_replaced_in_place(self):
+        self.conversation.add_file("/w/a.py", "old")
+        self.exchange(0)
+        self.conversation.add_file("/w/a.py", "new")
+        self.assertEqual(self.conversation.file_content("/w/a.py"), "new")
+        self.assertEqual(sum(m["role"] == "system" for m in self.conversation.messages()), 2)
+        self.conversation.add_file_parts("/w/a.py", [(1, 2, "a\nb\n"), (3, 4, "c\nd\n")])
+        self.assertEqual(self.conversation.file_parts("/w/a.py"), 2)
+        self.assertEqual(self.conversation.file_content("/w/a.py"), "a\nb\nc\nd\n")
+        self.assertTrue(self.conversation.remove_file("/w/a.py"))
+        self.assertEqual(self.conversation.files(), [])
+
+    def test_clear(self):
+        self.conversation.add_file("/w/a.py", "x = 1")
+        self.exchange(0)
+        self.conversation.clear(preserve_system=True)
+        self.assertEqual(self.conversation.files(), ["/w/a.py"])
+        self.assertFalse(self.conversation.has_exchanges())
+        self.conversation.clear()
+        self.assertEqual(self.conversation.messages(), [{"role": "system", "content": "You are Neo."}])
+
+    def test_snapshot_round_trip(self):
+        self.conversation.add_file("/w/a.py", "x = 1")
+        self.exchange(0, tool_calls=1)
+        restored = Conversation("other prompt")
+        restored.restore(self.conversation.snapshot())
+        self.assertEqual(restored.messages(), self.conversation.messages())
+        self.assertEqual(restored.turn, 1)
+
+    def test_metadata_is_not_sent(self):
+        self.exchange(0)
+        self.assertTrue(all(set(m) <= {"role", "content", "tool_calls", "tool_call_id"} for m in self.conversation.messages()))
+        self.assertTrue(all("turn" in m for m in self.conversation.history()))
+
+class TurnTest(unittest.TestCase):
+    """A turn is your message, every tool round it set off and the reply, however many rounds there were."""
+
+    def setUp(self):
+        patcher = mock.patch.object(console, "quiet", True)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+        self.conversation = Conversation("You are Neo.")
+
+    def turn(self, n, *rounds):
+        """Turn n: a question, one tool round per count in 'rounds' with that many calls, then the answer."""
+        self.conversation.add_user(f"question {n}")
+        for r, count in enumerate(rounds):
+            ids = [f"c{n}_{r}_{i}" for i in range(count)]
+            self.conversation.add_assistant("", [call(id) for id in ids])
+            for id in ids:
+                self.conversation.add_tool_result(id, f"result {id}", "read_file")
+        self.conversation.add_assistant(f"answer {n}")
+
+    def three_turns(self):
+        self.turn(1)
+        self.turn(2, 2)
+        self.turn(3, 1, 3, 2)
+
+    def forget(self, command, *answers):
+        with mock.patch("neo_core.ui.prompt_session.prompt", side_effect=list(answers)) as prompt:
+            self.assertTrue(try_handle_forget_command(SimpleNamespace(conversation=self.conversation), command))
+        return prompt.call_count
+
+    def contents(self):
+        return [m["content"] for m in self.conversation.history() if m["role"] != "tool" and m["content"]]
+
+    def test_turn_numbers(self):
+        self.three_turns()
+        self.assertEqual(self.conversation.turn_numbers(1), [1, 2])
+        self.assertEqual(self.conversation.turn_numbers(2), [3, 4, 5, 6, 7])
+        self.assertEqual(self.conversation.turn_numbers(3), list(range(8, 8 + 1 + 2 + 4 + 3 + 1)))
+        self.assertEqual(self.conversation.turn_numbers(4), [])
+
+    def test_turn_summary_counts_rounds_and_calls(self):
+        self.three_turns()
+        counts = [(summary.replies, summary.tool_rounds, summary.tool_calls)
+                  for summary in map(self.conversation.turn_summary, (1, 2, 3))]
+        self.assertEqual(counts, [(1, 0, 0), (1, 1, 2), (1, 3, 6)])
+        self.assertEqual(self.conversation.turn_summary(3).message, "question 3")
+
+    def test_last_turn(self):
+        self.assertIsNone(self.conversation.last_turn())
+        self.three_turns()
+        self.assertEqual(self.conversation.last_turn(), 3)
+        self.conversation.add_user("still unanswered")
+        self.assertEqual(self.conversation.last_turn(), 4)
+        self.conversation.drop_turn(4)
+        self.conversation.drop_turn(3)
+        self.assertEqual(self.conversation.last_turn(), 2)
+
+    def test_numbers_follow_a_dropped_turn(self):
+        self.three_turns()
+        self.conversation.drop_turn(2)
+        self.assertEqual(self.conversation.turn_numbers(1), [1, 2])
+        self.assertEqual(self.conversation.turn_numbers(3), list(range(3, 14)))
+        check_invariants(self, self.conversation)
+
+    def test_forget_turn_removes_every_round(self):
+        self.three_turns()
+        self.assertEqual(self.forget("/forget turn 3", "y"), 1)
+        self.assertEqual(self.contents(), ["question 1", "answer 1", "question 2", "answer 2"])
+        self.assertEqual(self.conversation.turn_numbers(2), [3, 4, 5, 6, 7])
+        check_invariants(self, self.conversation)
+
+    def test_forget_turn_without_tools(self):
+        self.three_turns()
+        self.forget("/forget turn 1", "y")
+        self.assertEqual(self.conversation.turn_numbers(2), [1, 2, 3, 4, 5])
+        self.assertEqual(self.contents(), ["question 2", "answer 2", "question 3", "answer 3"])
+        check_invariants(self, self.conversation)
+
+    def test_forget_last_takes_the_latest_turn(self):
+        self.turn(1, 2)
+        self.turn(2, 1, 1)
+        self.forget("/forget last", "y")
+        self.assertEqual(self.contents(), ["question 1", "answer 1"])
+        self.assertEqual(self.conversation.last_turn(), 1)
+        check_invariants(self, self.conversation)
+
+    def test_forget_turn_declined_or_missing_changes_nothing(self):
+        self.three_turns()
+        before = self.conversation.history()
+        self.assertEqual(self.forget("/forget turn 2", "n"), 1)
+        self.assertEqual(self.forget("/forget turn 9"), 0)  # Nothing to ask about
+        self.assertEqual(self.forget("/forget turn two"), 0)
+        self.assertEqual(self.conversation.history(), before)
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,51 @@
+import os
+import re
+import subprocess
+import sys
+import tempfile
+import unittest
+
+EXAMPLES = os.path.join(os.path.dirname(os.path.dirname(os.path.abspath(__file__))), "examples")
+
+class HeadlessExampleTest(unittest.TestCase):
+    """examples/headless.py runs as documented, against the mock provider with no API key."""
+
+    def test_runs_both_turns(self):
+        with tempfile.TemporaryDirectory() as home:
+            env = {name: value for name, value in os.environ.items() if not name.endswith("_API_KEY")}
+            env["HOME"] = home  # Logs and transcripts stay out of the real one
+            run = subprocess.run([sys.executable, os.path.join(EXAMPLES, "headless.py")], cwd=home, env=env,
+                                 stdin=subprocess.DEVNULL, capture_output=True, text=True, timeout=60)
+        self.assertEqual(run.returncode, 0, run.stderr)
+        output = re.sub(r"'[^']*notes\.txt' \(version \w+\)", "'notes.txt' (version v)", run.stdout)
+        self.assertEqual(output.splitlines(), [
+            "> What do my notes say?",
+            "[request] reply",
+            "[stream_end]",
+            "[tool_calls]",
+            "[tool_call] read_file",
+            "[tool_result] read_file -> Content of file 'notes.txt' (version v):",
+            "[tools_done]",
+            "[request] follow_up",
+            "[stream_end] Your notes ask for a TODO file that says: ship it.",
+            "[done] Your notes ask for a TODO file that says: ship it.",
+            "reply: Your notes ask for a TODO file that says: ship it.",
+            "",
+            "> Do what they ask",
+            "[request] reply",
+            "[stream_end]",
+            "[tool_calls]",
+            "[tool_call] create_file",
+            "  approve creation of TODO.md? yes",
+            "[tool_result] create_file -> Successfully created file 'TODO.md'",
+            "[tools_done]",
+            "[request] follow_up",
+            "[stream_end] Created TODO.md from your notes.",
+            "[done] Created TODO.md from your notes.",
+            "reply: Created TODO.md from your notes.",
+            "",
+            "TODO.md now holds: - ship it",
+        ])
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,55 @@
+import unittest
+
+from neo_core.fetch import html_to_text, is_url
+
+PAGE = """<html><head><title>Guide</title><style>body { color: red }</style></head><body>
+<nav>Home | Docs</nav>
+<h1>Install</h1>
+<p>Run   the <code>setup</code>
+   script.</p>
+<ul><li>one</li><li>two</li></ul>
+<pre><code class="language-go">func main() {
+    fmt.Println("hi")
+}</code></pre>
+<script>alert("never shown")</script>
+<p>A &amp; B</p>
+<table><tr><th>key</th><td>value</td></tr></table>
+<footer>Copyright</footer>
+</body></html>"""
+
+class HtmlToTextTest(unittest.TestCase):
+
+    def test_page(self):
+        self.assertEqual(html_to_text(PAGE), (
+            "# Guide\n\n"
+            "# Install\n\n"
+            "Run the `setup` script.\n\n"
+            "- one\n- two\n\n"
+            "```go\nfunc main() {\n    fmt.Println(\"hi\")\n}\n```\n\n"
+            "A & B\n\n"
+            "| key | value\n"
+        ))
+
+    def test_scripts_navigation_and_footers_are_dropped(self):
+        text = html_to_text(PAGE)
+        for hidden in ("alert", "color: red", "Home | Docs", "Copyright"):
+            self.assertNotIn(hidden, text)
+
+    def test_code_keeps_its_indentation(self):
+        text = html_to_text("<pre>  indented\n    more</pre><p>after</p>")
+        self.assertEqual(text, "```\n  indented\n    more\n```\n\nafter\n")
+
+    def test_title_is_not_repeated(self):
+        self.assertEqual(html_to_text("<title>Same</title><h1>Same</h1><p>x</p>"), "# Same\n\nx\n")
+        self.assertEqual(html_to_text("<p>Body only</p>"), "Body only\n")
+
+class IsUrlTest(unittest.TestCase):
+
+    def test_only_http_urls(self):
+        for text, expected in (("https://example.com/docs", True), ("HTTP://example.com", True), ("ftp://example.com", False),
+                               ("src/app.py", False), ("https://example.com/a b", False)):
+            with self.subTest(text=text):
+                self.assertEqual(is_url(text), expected)
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,339 @@
+import ntpath
+import os
+import posixpath
+import tempfile
+import stat
+import tracemalloc
+import unittest
+from pathlib import Path
+from types import SimpleNamespace
+from unittest import mock
+
+from neo_core import fileops
+from neo_core.fileops import (
+    FileOperationError, FileTooLargeError, IsDirectoryError, OutsideWorkspaceError, SnippetNotFoundError,
+    ScanOptions, SymlinkEscapeError, SymlinkLoopError, Workspace, describe_error, fold_case, is_within, portable_path,
+)
+
+class NormalizePathTest(unittest.TestCase):
+    """normalize_path refuses every path that resolves outside the workspace."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        base = Path(self.tmp.name).resolve()
+        self.root = base / "workspace"
+        self.outside = base / "outside"
+        (self.root / "src").mkdir(parents=True)
+        self.outside.mkdir()
+        (self.root / "src" / "main.py").write_text("print('hi')\n")
+        (self.outside / "secret.txt").write_text("secret\n")
+        self.workspace = Workspace(str(self.root))
+
+    def tearDown(self):
+        self.tmp.cleanup()
+
+    def test_paths_inside_resolve_against_the_root(self):
+        main = str(self.root / "src" / "main.py")
+        self.assertEqual(self.workspace.normalize_path("src/main.py"), main)
+        self.assertEqual(self.workspace.normalize_path("src/../src/main.py"), main)
+        self.assertEqual(self.workspace.normalize_path(main), main)
+        self.assertEqual(self.workspace.normalize_path("."), str(self.root))
+
+    def test_parent_references_out_of_the_root_are_refused(self):
+        for path in ("../outside/secret.txt", "src/../../outside/secret.txt", "../../../../etc/hostname"):
+            with self.subTest(path=path), self.assertRaises(OutsideWorkspaceError) as caught:
+                self.workspace.normalize_path(path)
+            self.assertNotIsInstance(caught.exception, SymlinkEscapeError)
+
+    def test_absolute_paths_outside_are_refused(self):
+        for path in (str(self.outside / "secret.txt"), "/etc/hostname"):
+            with self.subTest(path=path), self.assertRaises(OutsideWorkspaceError):
+                self.workspace.normalize_path(path)
+
+    def test_a_sibling_with_the_root_as_prefix_is_outside(self):
+        sibling = Path(str(self.root) + "-other")
+        sibling.mkdir()
+        with self.assertRaises(OutsideWorkspaceError):
+            self.workspace.normalize_path(str(sibling))
+
+    def test_symlink_to_a_file_outside_is_an_escape(self):
+        os.symlink(self.outside / "secret.txt", self.root / "link.txt")
+        with self.assertRaises(SymlinkEscapeError) as caught:
+            self.workspace.normalize_path("link.txt")
+        self.assertEqual(caught.exception.target, str(self.outside / "secret.txt"))
+
+    def test_path_through_a_linked_directory_outside_is_an_escape(self):
+        os.symlink(self.outside, self.root / "linked", target_is_directory=True)
+        with self.assertRaises(SymlinkEscapeError):
+            self.workspace.normalize_path("linked/secret.txt")
+
+    def test_symlink_inside_the_workspace_resolves_to_its_target(self):
+        os.symlink(self.root / "src" / "main.py", self.root / "alias.py")
+        self.assertEqual(self.workspace.normalize_path("alias.py"), str(self.root / "src" / "main.py"))
+
+    def test_symlink_loop_is_refused(self):
+        os.symlink(self.root / "b", self.root / "a")
+        os.symlink(self.root / "a", self.root / "b")
+        with self.assertRaises(SymlinkLoopError):
+            self.workspace.normalize_path("a")
+
+    def test_reads_outside_are_refused(self):
+        with self.assertRaises(OutsideWorkspaceError):
+            self.workspace.read_file(str(self.outside / "secret.txt"))
+
+class ScanSymlinksTest(unittest.TestCase):
+    """scan_directory reports links it doesn't follow, and scans a loop once."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        base = Path(self.tmp.name).resolve()
+        self.root = base / "workspace"
+        (self.root / "pkg").mkdir(parents=True)
+        (self.root / "pkg" / "mod.py").write_text("x = 1\n")
+        (base / "outside").mkdir()
+        (base / "outside" / "secret.txt").write_text("secret\n")
+        os.symlink(base / "outside", self.root / "escape", target_is_directory=True)
+        os.symlink(self.root, self.root / "pkg" / "loop", target_is_directory=True)
+
+    def tearDown(self):
+        self.tmp.cleanup()
+
+    def scan(self, follow_symlinks):
+        workspace = Workspace(str(self.root), follow_symlinks=follow_symlinks)
+        return workspace.scan_directory(str(self.root), on_file=lambda path, content, truncation: None)
+
+    def test_links_are_skipped_and_listed_by_default(self):
+        result = self.scan(False)
+        self.assertEqual([path for path, _ in result.added], [str(self.root / "pkg" / "mod.py")])
+        skipped = {os.path.basename(skipped.path) for skipped in result.skipped}
+        self.assertLessEqual({"escape", "loop"}, skipped)
+
+    def test_followed_links_stay_inside_and_a_loop_is_scanned_once(self):
+        result = self.scan(True)
+        added = [path for path, _ in result.added]
+        self.assertEqual(added, [str(self.root / "pkg" / "mod.py")])
+        self.assertFalse(any("secret" in path for path in added))
+        escapes = [skipped for skipped in result.skipped if os.path.basename(skipped.path) == "escape"]
+        self.assertEqual(len(escapes), 1)
+        self.assertIn("outside the workspace", escapes[0].describe())
+
+class FileErrorsTest(unittest.TestCase):
+    """Failures raise typed errors that describe_error turns into instructions for the model."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.root = Path(self.tmp.name).resolve()
+        (self.root / "pkg").mkdir()
+        (self.root / "app.py").write_text("x = 1\ny = 2\nx = 1\n")
+        self.workspace = Workspace(str(self.root), max_file_size=100)
+
+    def tearDown(self):
+        self.tmp.cleanup()
+
+    def test_too_large(self):
+        (self.root / "big.txt").write_text("a" * 101)
+        with self.assertRaises(FileTooLargeError) as caught:
+            self.workspace.read_file("big.txt")
+        self.assertEqual((caught.exception.size, caught.exception.limit), (101, 100))
+        self.assertIn("exceeds the 100 byte limit", describe_error(caught.exception))
+
+    def test_directory(self):
+        with self.assertRaises(IsDirectoryError) as caught:
+            self.workspace.read_file("pkg")
+        self.assertIsInstance(caught.exception, FileOperationError)
+        self.assertIn("is a directory", describe_error(caught.exception))
+
+    def test_missing_file_is_the_builtin_error(self):
+        with self.assertRaises(FileNotFoundError) as caught:
+            self.workspace.read_file("missing.py")
+        self.assertIn("create_file", describe_error(caught.exception))
+
+    def test_snippet_not_found(self):
+        with self.assertRaises(SnippetNotFoundError) as caught:
+            self.workspace.apply_diff_edit("app.py", "z = 3", "z = 4")
+        self.assertEqual(caught.exception.count, 0)
+        self.assertIn("was not found", describe_error(caught.exception))
+
+    def test_ambiguous_snippet_gives_the_count_and_lines(self):
+        with self.assertRaises(SnippetNotFoundError) as caught:
+            self.workspace.apply_diff_edit("app.py", "x = 1", "x = 3")
+        self.assertEqual((caught.exception.count, caught.exception.lines), (2, [1, 3]))
+        message = describe_error(caught.exception)
+        self.assertIn("appears 2 times", message)
+        self.assertIn("occurrence_index", message)
+        self.assertEqual((self.root / "app.py").read_text(), "x = 1\ny = 2\nx = 1\n")
+
+    def test_missing_occurrence(self):
+        with self.assertRaises(SnippetNotFoundError) as caught:
+            self.workspace.apply_diff_edit("app.py", "x = 1", "x = 3", occurrence=3)
+        self.assertIn("no occurrence_index 3", describe_error(caught.exception))
+
+    def test_outside_the_workspace(self):
+        with self.assertRaises(OutsideWorkspaceError) as caught:
+            self.workspace.read_file("../elsewhere.txt")
+        self.assertIn("outside the workspace", describe_error(caught.exception))
+
+class AtomicWriteTest(unittest.TestCase):
+    """Writes go through a renamed temporary file and keep the file's mode and line endings."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.root = Path(self.tmp.name).resolve()
+        self.workspace = Workspace(str(self.root))
+
+    def tearDown(self):
+        self.tmp.cleanup()
+
+    def test_executable_bit_is_kept(self):
+        script = self.root / "run.sh"
+        script.write_text("#!/bin/sh\necho one\n")
+        script.chmod(0o755)
+        self.workspace.apply_diff_edit("run.sh", "echo one", "echo two")
+        self.assertEqual(script.read_text(), "#!/bin/sh\necho two\n")
+        self.assertEqual(stat.S_IMODE(script.stat().st_mode), 0o755)
+
+    def test_crlf_file_stays_crlf(self):
+        path = self.root / "win.txt"
+        path.write_bytes(b"first\r\nsecond\r\nthird\r\n")
+        self.workspace.apply_diff_edit("win.txt", "second\nthird", "2nd\n3rd\n4th")
+        self.assertEqual(path.read_bytes(), b"first\r\n2nd\r\n3rd\r\n4th\r\n")
+
+    def test_lf_file_stays_lf_when_the_snippet_has_crlf(self):
+        path = self.root / "unix.txt"
+        path.write_bytes(b"a\nb\n")
+        self.workspace.apply_diff_edit("unix.txt", "a\r\nb", "c\r\nd")
+        self.assertEqual(path.read_bytes(), b"c\nd\n")
+
+    def test_failure_before_the_rename_leaves_the_original(self):
+        path = self.root / "keep.txt"
+        path.write_text("original\n")
+        with mock.patch("neo_core.fileops.os.replace", side_effect=OSError("disk full")):
+            with self.assertRaises(OSError):
+                self.workspace.create_file("keep.txt", "replacement\n")
+        self.assertEqual(path.read_text(), "original\n")
+        self.assertEqual(sorted(p.name for p in self.root.iterdir() if p.name != ".neo"), ["keep.txt"])
+
+def platform(name):
+    """Patch fileops' view of os to Windows ("nt") or POSIX path rules, whatever this machine runs."""
+    path, sep = (ntpath, "\\") if name == "nt" else (posixpath, "/")
+    return mock.patch.object(fileops, "os", SimpleNamespace(name=name, sep=sep, path=path))
+
+class SeparatorTest(unittest.TestCase):
+    """portable_path and is_within under each platform's rules, then on the real one."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        self.root = str(Path(self.tmp.name).resolve())
+
+    def test_backslashes_are_separators_on_posix(self):
+        with platform("posix"):
+            self.assertEqual(portable_path("src\\app\\main.py", self.root), "src/app/main.py")
+            self.assertEqual(portable_path("src/app\\main.py", self.root), "src/app/main.py")
+            self.assertEqual(portable_path("src/main.py", self.root), "src/main.py")
+
+    def test_extended_prefix_is_dropped(self):
+        for name in ("nt", "posix"):
+            with self.subTest(platform=name), platform(name):
+                self.assertEqual(portable_path("\\\\?\\C:\\work\\a.py", "C:\\work"),
+                                 "C:\\work\\a.py" if name == "nt" else "C:/work/a.py")
+                self.assertEqual(portable_path("\\\\?\\UNC\\server\\share\\a.py", "C:\\work"),
+                                 "\\\\server\\share\\a.py" if name == "nt" else "//server/share/a.py")
+
+    def test_backslashes_are_kept_on_windows(self):
+        with platform("nt"):
+            self.assertEqual(portable_path("src\\main.py", "C:\\work"), "src\\main.py")
+            self.assertEqual(portable_path("src/main.py", "C:\\work"), "src/main.py")
+
+    def test_windows_containment_ignores_case_and_separators(self):
+        with platform("nt"):
+            self.assertTrue(is_within("C:\\Work\\src\\a.py", "c:\\work"))
+            self.assertTrue(is_within("C:/work/src/a.py", "C:\\work\\"))
+            self.assertTrue(is_within("C:\\work", "C:\\work"))
+            self.assertFalse(is_within("C:\\workspace\\a.py", "C:\\work"))
+            self.assertFalse(is_within("D:\\work\\a.py", "C:\\work"))  # Another drive
+            self.assertFalse(is_within("\\\\server\\share\\a.py", "C:\\work"))
+            self.assertEqual(fold_case("Src/Main.PY"), "src/main.py")
+
+    def test_posix_containment_is_exact(self):
+        with platform("posix"):
+            self.assertTrue(is_within("/work/src/a.py", "/work"))
+            self.assertTrue(is_within("/work", "/work/"))
+            self.assertFalse(is_within("/Work/src/a.py", "/work"))
+            self.assertFalse(is_within("/workspace/a.py", "/work"))
+            self.assertFalse(is_within("src/a.py", "/work"))  # Relative against absolute
+            self.assertEqual(fold_case("Src/Main.PY"), "Src/Main.PY")
+
+    @unittest.skipIf(os.name == "nt", "backslashes can't be in a Windows file name")
+    def test_a_file_named_with_a_backslash_is_kept_on_posix(self):
+        Path(self.root, "odd\\name.txt").write_text("x\n")
+        self.assertEqual(portable_path("odd\\name.txt", self.root), "odd\\name.txt")
+        self.assertEqual(Workspace(self.root).normalize_path("odd\\name.txt"), os.path.join(self.root, "odd\\name.txt"))
+
+    @unittest.skipIf(os.name == "nt", "POSIX paths")
+    def test_backslash_paths_resolve_on_posix(self):
+        Path(self.root, "src").mkdir()
+        self.assertEqual(Workspace(self.root).normalize_path("src\\main.py"), os.path.join(self.root, "src", "main.py"))
+
+    @unittest.skipUnless(os.name == "nt", "Windows paths")
+    def test_windows_paths_resolve(self):
+        workspace = Workspace(self.root)
+        expected = os.path.join(self.root, "src", "main.py")
+        for path in ("src/main.py", "src\\main.py", "SRC\\Main.py", "\\\\?\\" + expected):
+            with self.subTest(path=path):
+                self.assertEqual(os.path.normcase(workspace.normalize_path(path)), os.path.normcase(expected))
+        other_drive = "D:\\" if not self.root.upper().startswith("D:") else "E:\\"
+        with self.assertRaises(OutsideWorkspaceError):
+            workspace.normalize_path(other_drive + "a.py")
+
+BENCHMARK_FILES = int(os.environ.get("NEO_BENCHMARK_FILES", "50"))  # 500 for the full-size tree
+BENCHMARK_FILE_SIZE = 1_000_000
+
+class ScanMemoryTest(unittest.TestCase):
+    """Peak memory of scanning a synthetic tree of 1MB files, which scan_directory hands on one at a time.
+
+    The "before" figure keeps every file's content until the scan ends, as /add once did.
+    """
+
+    @classmethod
+    def setUpClass(cls):
+        cls.tmp = tempfile.TemporaryDirectory()
+        root = Path(cls.tmp.name).resolve()
+        line = "x" * 79 + "\n"
+        for n in range(BENCHMARK_FILES):
+            directory = root / f"d{n // 10}"
+            directory.mkdir(exist_ok=True)
+            (directory / f"f{n}.txt").write_text(line * (BENCHMARK_FILE_SIZE // len(line)))
+        cls.workspace = Workspace(str(root))
+
+    @classmethod
+    def tearDownClass(cls):
+        cls.tmp.cleanup()
+
+    def peak(self, on_file, options=None):
+        tracemalloc.start()
+        try:
+            result = self.workspace.scan_directory(".", options=options, on_file=on_file)
+            return tracemalloc.get_traced_memory()[1], result
+        finally:
+            tracemalloc.stop()
+
+    def test_handing_files_on_keeps_the_peak_near_one_file(self):
+        read = []
+        streamed, result = self.peak(lambda path, content, truncation: read.append(len(content)))
+        kept = {}
+        slurped, _ = self.peak(lambda path, content, truncation: kept.update({path: content}))
+        self.assertEqual(len(result.added), BENCHMARK_FILES)
+        self.assertEqual(sum(read), sum(size for _, size in result.added))
+        self.assertLess(streamed, 10 * BENCHMARK_FILE_SIZE)  # A few copies of one file as it is read and decoded
+        self.assertGreater(slurped, BENCHMARK_FILES * BENCHMARK_FILE_SIZE)
+        self.assertLess(streamed * 5, slurped)
+
+    def test_truncated_files_are_not_read_whole(self):
+        options = ScanOptions(max_file_size=100_000, truncate="head")
+        peak, result = self.peak(lambda path, content, truncation: None, options)
+        self.assertEqual(len(result.truncated), BENCHMARK_FILES)
+        self.assertLess(peak, BENCHMARK_FILE_SIZE)
+
+if __name__ == "__main__":
+    unittest.main()
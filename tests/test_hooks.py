@@ -0,0 +1,86 @@
+import json
+import os
+import sys
+import tempfile
+import textwrap
+import unittest
+
+from neo_core.hooks import HookError, HookResult, rewritten_message, run_hook
+
+def result(stdout, exit_code=0):
+    return HookResult(exit_code, stdout, "", 0.0)
+
+class RewrittenMessageTest(unittest.TestCase):
+
+    def test_nothing_printed_sends_the_message_as_typed(self):
+        for stdout in ("", "\n", "  \n\n"):
+            with self.subTest(stdout=stdout):
+                self.assertIsNone(rewritten_message("pre_send_hook", "hook", result(stdout)))
+
+    def test_text_replaces_the_message(self):
+        self.assertEqual(rewritten_message("pre_send_hook", "hook", result("use the new API\n")), "use the new API")
+        self.assertEqual(rewritten_message("pre_send_hook", "hook", result("  indented\nsecond\n\n")), "  indented\nsecond")
+
+    def test_json_message(self):
+        self.assertEqual(rewritten_message("pre_send_hook", "hook", result('{"message": "from json"}\n')), "from json")
+
+    def test_text_starting_with_a_brace_is_text(self):
+        self.assertEqual(rewritten_message("pre_send_hook", "hook", result("{ not json")), "{ not json")
+
+    def test_json_without_a_message_is_a_hook_failure(self):
+        for stdout in ('{"text": "x"}', '{"message": 3}'):
+            with self.subTest(stdout=stdout), self.assertRaisesRegex(HookError, 'without a string "message"'):
+                rewritten_message("pre_send_hook", "hook", result(stdout))
+
+class RunHookTest(unittest.TestCase):
+    """Hooks as real executables in a temporary directory."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+
+    def hook(self, body):
+        path = os.path.join(self.tmp.name, "hook.py")
+        with open(path, "w", encoding="utf-8") as f:
+            f.write(f"#!{sys.executable}\n" + textwrap.dedent(body))
+        os.chmod(path, 0o755)
+        return path
+
+    def test_payload_on_stdin_and_output(self):
+        path = self.hook("""
+            import json, os, sys
+            payload = json.load(sys.stdin)
+            print(json.dumps({"message": payload["message"].upper(), "cwd": os.getcwd()}))
+            print("note", file=sys.stderr)
+        """)
+        outcome = run_hook("pre_send_hook", path, {"event": "pre_send", "message": "hello"}, self.tmp.name, 10)
+        self.assertEqual(outcome.exit_code, 0)
+        self.assertEqual(json.loads(outcome.stdout)["message"], "HELLO")
+        self.assertEqual(os.path.realpath(json.loads(outcome.stdout)["cwd"]), os.path.realpath(self.tmp.name))
+        self.assertEqual(outcome.stderr, "note\n")
+        self.assertEqual(rewritten_message("pre_send_hook", path, outcome), "HELLO")
+
+    def test_non_zero_exit_gives_the_reason(self):
+        path = self.hook("""
+            import sys
+            print("secrets in the message", file=sys.stderr)
+            sys.exit(1)
+        """)
+        outcome = run_hook("pre_send_hook", path, {}, self.tmp.name, 10)
+        self.assertEqual(outcome.exit_code, 1)
+        self.assertEqual(outcome.reason(), "secrets in the message")
+
+    def test_timeout(self):
+        path = self.hook("""
+            import time
+            time.sleep(30)
+        """)
+        with self.assertRaisesRegex(HookError, "timed out after 0.5s"):
+            run_hook("post_receive_hook", path, {}, self.tmp.name, 0.5)
+
+    def test_missing_hook(self):
+        with self.assertRaisesRegex(HookError, "could not be started"):
+            run_hook("pre_send_hook", os.path.join(self.tmp.name, "missing"), {}, self.tmp.name, 10)
+
+if __name__ == "__main__":
+    unittest.main()
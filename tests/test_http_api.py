@@ -0,0 +1,70 @@
+import os
+import tempfile
+import unittest
+from pathlib import Path
+
+from neo_core.config import NeoConfig
+from neo_core.conversation import Conversation
+from neo_core.fileops import Workspace
+from neo_core.http_api import RequestError, approval_policy
+from neo_core.tools import CreateMultipleFilesTool, ToolContext
+
+class ApprovalPolicyTest(unittest.TestCase):
+    """Glob patterns approve a change only when every path it touches matches."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        base = Path(self.tmp.name).resolve()
+        self.root = base / "workspace"
+        (self.root / "docs").mkdir(parents=True)
+        (base / "outside").mkdir()
+        os.symlink(base / "outside", self.root / "docs" / "linked")
+        self.workspace = Workspace(str(self.root))
+
+    def tearDown(self):
+        self.tmp.cleanup()
+
+    def approve(self, patterns, target):
+        return approval_policy(patterns, self.workspace)("creation", target)
+
+    def test_matching_paths_are_approved(self):
+        self.assertTrue(self.approve(["*.md"], "README.md"))
+        self.assertTrue(self.approve(["docs/*"], "docs/guide.md"))
+        self.assertTrue(self.approve(["docs/*"], "./docs/guide.md"))
+        self.assertTrue(self.approve(["docs/*"], str(self.root / "docs" / "guide.md")))
+        self.assertTrue(self.approve(["*.md", "docs/*"], "README.md, docs/setup.sh"))
+
+    def test_every_path_of_a_multi_file_change_must_match(self):
+        self.assertFalse(self.approve(["*.md"], "evil.sh, README.md"))
+        self.assertFalse(self.approve(["*.md"], "README.md, evil.sh"))
+        self.assertFalse(self.approve(["*.md"], "README.md, docs/../evil.sh"))
+
+    def test_paths_are_normalized_before_matching(self):
+        self.assertFalse(self.approve(["docs/*"], "docs/../run.sh"))
+        self.assertTrue(self.approve(["*.sh"], "docs/../run.sh"))
+        self.assertFalse(self.approve(["*.md"], "README.md/../run.sh"))
+
+    def test_paths_outside_the_workspace_never_match(self):
+        for target in ("../notes.md", "/tmp/notes.md", "docs/linked/notes.md"):
+            with self.subTest(target=target):
+                self.assertFalse(self.approve(["*", "*/*", "**"], target))
+
+    def test_fixed_policies(self):
+        self.assertFalse(self.approve(None, "README.md"))
+        self.assertFalse(self.approve("never", "README.md"))
+        self.assertTrue(self.approve("always", "evil.sh, README.md"))
+        for policy in ("sometimes", [1], {"*.md": True}):
+            with self.subTest(policy=policy), self.assertRaises(RequestError):
+                approval_policy(policy, self.workspace)
+
+    def test_create_multiple_files_is_declined_unless_every_file_matches(self):
+        ctx = ToolContext(self.workspace, NeoConfig(), Conversation("system"),
+                          approve=approval_policy(["*.md"], self.workspace))
+        arguments = {"files": [{"path": "evil.sh", "content": "echo hi\n"},
+                               {"path": "README.md", "content": "# Hi\n"}]}
+        self.assertIn("declined", CreateMultipleFilesTool().execute(ctx, arguments))
+        self.assertFalse((self.root / "evil.sh").exists())
+        self.assertFalse((self.root / "README.md").exists())
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,194 @@
+import json
+import unittest
+from types import SimpleNamespace
+from unittest import mock
+
+from neo_core.ai import ReplyRenderer
+from neo_core.config import NeoConfig
+from neo_core.conversation import Conversation
+from neo_core.loop import DONE, STREAM_END, TOOL_RESULT, AgentLoop, collect_stream
+from neo_core.stats import ResponseTiming
+from neo_core.ui import MatrixTextFormatter, console
+
+def chunk(content=None, tool_calls=None):
+    delta = SimpleNamespace(content=content, tool_calls=tool_calls, reasoning_content=None)
+    return SimpleNamespace(choices=[SimpleNamespace(delta=delta)])
+
+def call_delta(index, id=None, name=None, arguments=None):
+    return SimpleNamespace(index=index, id=id, type="function", function=SimpleNamespace(name=name, arguments=arguments))
+
+class FakeTools:
+    """A ToolExecutor that records its calls and answers each with a fixed result."""
+
+    def __init__(self):
+        self.calls = []
+
+    def definitions(self):
+        return [{"type": "function", "function": {"name": "read_file", "parameters": {"type": "object"}}}]
+
+    def describe(self):
+        return "- read_file"
+
+    def execute(self, tool_call):
+        self.calls.append(tool_call)
+        return f"contents of {json.loads(tool_call['function']['arguments'])['file_path']}"
+
+    def execute_all(self, tool_calls, on_start=None, on_wait=None):
+        return [self.execute(tool_call) for tool_call in tool_calls]
+
+    def begin_turn(self):
+        pass
+
+    def turn_limit_reached(self):
+        return None
+
+    def is_mutating(self, name):
+        return False
+
+class CollectStreamTest(unittest.TestCase):
+
+    def test_tool_call_deltas_are_joined_by_index(self):
+        events = []
+        content, tool_calls = collect_stream([
+            chunk(content="\n\n"),
+            chunk(tool_calls=[call_delta(0, id="a", name="read_file", arguments="")]),
+            chunk(tool_calls=[call_delta(1, id="b", name="read_file", arguments='{"file_path": "b.txt"}')]),
+            chunk(tool_calls=[call_delta(0, arguments='{"file_pa')]),
+            chunk(tool_calls=[call_delta(0, arguments='th": "a.txt"}')]),
+        ], events.append)
+        self.assertEqual(content, "")
+        self.assertEqual([(c["id"], c["function"]["arguments"]) for c in tool_calls],
+                         [("a", '{"file_path": "a.txt"}'), ("b", '{"file_path": "b.txt"}')])
+
+    def test_calls_without_a_name_are_dropped_and_missing_ids_filled(self):
+        _, tool_calls = collect_stream([
+            chunk(tool_calls=[call_delta(0, arguments="{}")]),
+            chunk(tool_calls=[call_delta(1, name="read_file", arguments="{}")]),
+        ], lambda event: None)
+        self.assertEqual(len(tool_calls), 1)
+        self.assertEqual(tool_calls[0]["function"]["name"], "read_file")
+        self.assertTrue(tool_calls[0]["id"])
+
+class AgentLoopTest(unittest.TestCase):
+    """The loop against a fake client and fake tools, as the seams allow."""
+
+    def setUp(self):
+        self.conversation = Conversation("system")
+        self.tools = FakeTools()
+        self.requests = []
+        self.responses = []
+
+    def create_stream(self, **request):
+        self.requests.append(request)
+        return iter(self.responses.pop(0))
+
+    def loop(self):
+        return AgentLoop(NeoConfig(), self.tools, self.conversation, self.create_stream)
+
+    def test_plain_reply(self):
+        self.responses = [[chunk(content="Hello"), chunk(content=" there ")]]
+        events = []
+        self.assertEqual(self.loop().send("hi", events.append), "Hello there")
+        self.assertEqual([m["role"] for m in self.conversation.messages()], ["system", "user", "assistant"])
+        self.assertEqual(events[-1].kind, DONE)
+        self.assertEqual(len(self.requests), 1)
+        self.assertEqual(self.tools.calls, [])
+
+    def test_tool_round_trip(self):
+        self.responses = [
+            [chunk(content="Reading."), chunk(tool_calls=[call_delta(0, id="c1", name="read_file",
+                                                                     arguments='{"file_path": "a.txt"}')])],
+            [chunk(content="It is fine.")],
+        ]
+        events = []
+        self.assertEqual(self.loop().send("check a.txt", events.append), "Reading.\nIt is fine.")
+        self.assertEqual(len(self.tools.calls), 1)
+
+        messages = self.conversation.messages()
+        self.assertEqual([m["role"] for m in messages], ["system", "user", "assistant", "tool", "assistant"])
+        self.assertEqual(messages[3], {"role": "tool", "tool_call_id": "c1", "content": "contents of a.txt"})
+        self.assertEqual(self.requests[1]["messages"], messages[:4])
+        self.assertIn(TOOL_RESULT, [event.kind for event in events])
+
+    def test_a_failed_request_leaves_the_message_in_the_conversation(self):
+        def failing(**request):
+            raise ConnectionError("reset")
+        with self.assertRaises(ConnectionError):
+            AgentLoop(NeoConfig(), self.tools, self.conversation, failing).send("hi", lambda event: None)
+        self.assertEqual(self.conversation.messages()[-1]["content"], "hi")
+
+class RecordingConsole:
+    """Keeps what the formatter prints instead of rendering it."""
+
+    width = 80
+
+    def __init__(self):
+        self.printed = []
+
+    def print(self, *renderables, **kwargs):
+        self.printed.extend(str(getattr(renderable, "plain", renderable)) for renderable in renderables)
+
+class TwoPhaseRenderTest(unittest.TestCase):
+    """The reply before a tool round and the follow-up after it go through one renderer and its formatter."""
+
+    def setUp(self):
+        patcher = mock.patch.object(console, "quiet", True)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+        self.conversation = Conversation("system")
+        self.responses = []
+        self.output = RecordingConsole()
+        agent = SimpleNamespace(transcript=SimpleNamespace(write=lambda *args: None),
+                                debug_log=SimpleNamespace(record=lambda *args: None))
+        self.renderer = ReplyRenderer(agent, ResponseTiming())
+        self.renderer.formatter = MatrixTextFormatter(self.output)
+        self.stream_ends = []
+
+    def create_stream(self, **request):
+        return iter(self.responses.pop(0))
+
+    def emit(self, event):
+        self.renderer(event)
+        if event.kind == STREAM_END:
+            self.stream_ends.append(len(self.output.printed))
+
+    def send(self, first, follow_up):
+        self.responses = [
+            [chunk(content=text) for text in first] + [chunk(tool_calls=[
+                call_delta(0, id="c1", name="read_file", arguments='{"file_path": "a.txt"}')])],
+            [chunk(content=text) for text in follow_up],
+        ]
+        AgentLoop(NeoConfig(), FakeTools(), self.conversation, self.create_stream).send("check a.txt", self.emit)
+        return self.output.printed[:self.stream_ends[0]], self.output.printed[self.stream_ends[0]:]
+
+    def test_follow_up_after_an_unclosed_block_is_prose(self):
+        reply, follow_up = self.send(["Let me look.\n```py", "thon\nx = 1\nprint(x"], ["Done: it", " prints 1.\n- fine\n"])
+        self.assertEqual(reply, [
+            "Let me look.",
+            "\n[matrix.accent]┌─ Code [1] (python) ─[/matrix.accent]",
+            "[matrix.code]│ x = 1[/matrix.code]",
+            "[matrix.code]│ print(x[/matrix.code]",
+            "[matrix.accent]└─ python · 2 lines ─[/matrix.accent] [matrix.dim]/apply 1 <path>[/matrix.dim]\n",
+        ])
+        self.assertEqual(follow_up, ["Done: it prints 1.", "  • fine"])
+        self.assertEqual(len(self.stream_ends), 2)
+
+    def test_follow_up_after_an_unfinished_table_starts_fresh(self):
+        reply, follow_up = self.send(["| a | b |\n|---|---|\n| 1 |"], ["All good.\n"])
+        self.assertEqual(len(reply), 1)  # The table, with the rows it got
+        self.assertEqual(follow_up, ["All good."])
+
+    def test_finalize_again_prints_nothing(self):
+        self.send(["```\nstill open"], ["Done.\n"])
+        printed = list(self.output.printed)
+        formatter = self.renderer.formatter
+        formatter.finalize()
+        formatter.reset()
+        formatter.finalize()
+        self.assertEqual(self.output.printed, printed)
+        self.assertFalse(formatter.in_code_block)
+        self.assertEqual((formatter.buffer, formatter.table_lines, formatter.held_lines), ("", [], None))
+        self.assertEqual(len(formatter.code_blocks), 1)  # Still numbered for /apply
+
+if __name__ == "__main__":
+    unittest.main()
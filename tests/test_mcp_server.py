@@ -0,0 +1,112 @@
+import json
+import os
+import subprocess
+import sys
+import tempfile
+import unittest
+from pathlib import Path
+
+NEO = str(Path(__file__).resolve().parent.parent / "neo.py")
+
+class MCPServeTest(unittest.TestCase):
+    """`neo mcp-serve` run as a subprocess, spoken to over its stdin and stdout."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        base = Path(self.tmp.name).resolve()
+        self.root = base / "workspace"
+        self.root.mkdir()
+        (self.root / "main.py").write_text("def greet():\n    return 'hello'\n")
+        (self.root / "notes.txt").write_text("todo: greet people\n")
+        (base / "outside.txt").write_text("not yours\n")
+        self.outside = base / "outside.txt"
+        home = base / "home"
+        home.mkdir()
+        env = dict(os.environ, HOME=str(home), NO_COLOR="1")
+        self.log = open(base / "stderr.log", "w+")  # A file, so a chatty server never blocks on a full pipe
+        self.server = subprocess.Popen([sys.executable, NEO, "mcp-serve", "--workdir", str(self.root)], env=env,
+                                       stdin=subprocess.PIPE, stdout=subprocess.PIPE, stderr=self.log, text=True)
+        self.next_id = 0
+
+    def tearDown(self):
+        if not self.server.stdin.closed:
+            self.server.stdin.close()
+        self.server.wait(timeout=10)
+        self.server.stdout.close()
+        self.log.close()
+        self.tmp.cleanup()
+
+    def request(self, method, params=None):
+        self.next_id += 1
+        self.server.stdin.write(json.dumps({"jsonrpc": "2.0", "id": self.next_id, "method": method, "params": params or {}}) + "\n")
+        self.server.stdin.flush()
+        line = self.server.stdout.readline()
+        if not line:
+            self.server.kill()
+            self.log.seek(0)
+            self.fail(f"the server closed stdout; stderr:\n{self.log.read()}")
+        response = json.loads(line)
+        self.assertEqual(response["id"], self.next_id)
+        return response
+
+    def call(self, name, **arguments):
+        result = self.request("tools/call", {"name": name, "arguments": arguments})["result"]
+        return result["isError"], result["content"][0]["text"]
+
+    def test_handshake_and_tool_list(self):
+        info = self.request("initialize", {"protocolVersion": "2024-11-05", "capabilities": {}})["result"]
+        self.assertEqual(info["serverInfo"]["name"], "neo")
+        names = {tool["name"] for tool in self.request("tools/list")["result"]["tools"]}
+        self.assertLessEqual({"read_file", "list_directory", "search_files", "create_file", "edit_file", "delete_file"}, names)
+
+    def test_read_and_edit(self):
+        self.request("initialize")
+        failed, text = self.call("read_file", file_path="main.py")
+        self.assertFalse(failed)
+        self.assertIn("return 'hello'", text)
+        failed, text = self.call("edit_file", file_path="main.py", original_snippet="'hello'", new_snippet="'hi there'")
+        self.assertFalse(failed, text)
+        self.assertEqual((self.root / "main.py").read_text(), "def greet():\n    return 'hi there'\n")
+
+    def test_reads_outside_the_workspace_are_refused(self):
+        self.request("initialize")
+        for path in (str(self.outside), "../outside.txt", "../../../../../../etc/hostname"):
+            with self.subTest(path=path):
+                failed, text = self.call("read_file", file_path=path)
+                self.assertTrue(failed)
+                self.assertIn("outside the workspace", text)
+                self.assertNotIn("not yours", text)
+        failed, text = self.call("read_multiple_files", file_paths=["notes.txt", str(self.outside)])
+        self.assertIn("todo: greet people", text)
+        self.assertNotIn("not yours", text)
+
+    def test_list_search_and_delete(self):
+        self.request("initialize")
+        failed, text = self.call("list_directory")
+        self.assertFalse(failed)
+        self.assertIn("main.py (", text)
+        self.assertIn("notes.txt (", text)
+        failed, text = self.call("search_files", pattern="greet")
+        self.assertFalse(failed)
+        self.assertIn("main.py:1: def greet():", text)
+        self.assertIn("notes.txt:1: todo: greet people", text)
+        failed, text = self.call("delete_file", file_path="notes.txt")
+        self.assertFalse(failed, text)
+        self.assertFalse((self.root / "notes.txt").exists())
+        backups = list((self.root / ".neo" / "backups").glob("notes.txt.*"))
+        self.assertEqual(len(backups), 1)
+        failed, text = self.call("delete_file", file_path=str(self.outside))
+        self.assertTrue(failed)
+        self.assertTrue(self.outside.exists())
+
+    def test_stdout_carries_only_the_protocol(self):
+        self.request("initialize")
+        self.call("edit_file", file_path="main.py", original_snippet="'hello'", new_snippet="'hey'")
+        self.server.stdin.close()
+        for line in self.server.stdout.read().splitlines():
+            json.loads(line)  # Anything the tools printed would fail to parse
+        self.log.seek(0)
+        self.assertIn("edit_file: ok", self.log.read())
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,135 @@
+import json
+import os
+import tempfile
+import unittest
+
+from neo_core.api import Session, create_client
+from neo_core.config import NeoConfig
+from neo_core.loop import DONE, TOOL_RESULT, reply_text
+from neo_core.mock import MockClient, MockStreamError
+
+class MockProviderTest(unittest.TestCase):
+    """Sessions against scripted responses, with no API in the way."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.workdir = self.tmp.name
+        with open(os.path.join(self.workdir, "notes.txt"), "w", encoding="utf-8") as f:
+            f.write("remember the milk\n")
+
+    def tearDown(self):
+        self.tmp.cleanup()
+
+    def session(self, responses, **kwargs):
+        self.client = MockClient(responses, chunk_size=3)
+        return Session(NeoConfig(provider="mock"), workdir=self.workdir, client=self.client, **kwargs)
+
+    def test_tool_round_trip_with_arguments_split_mid_token(self):
+        session = self.session([
+            [{"reasoning": "Reading the notes."},
+             {"tool_call": {"name": "read_file", "arguments": {"file_path": "notes.txt"}, "split": 1}}],
+            [{"content": "They say to remember the milk."}],
+        ])
+        events = []
+        reply = session.send("What do my notes say?", events.append)
+        self.assertEqual(reply, "They say to remember the milk.")
+
+        results = [event.result for event in events if event.kind == TOOL_RESULT]
+        self.assertEqual(len(results), 1)
+        self.assertIn("remember the milk", results[0])
+        roles = [m["role"] for m in session.conversation.messages()]
+        self.assertEqual(roles[-4:], ["user", "assistant", "tool", "assistant"])
+        self.assertIn("remember the milk", str(self.client.requests[1]["messages"][-1]["content"]))
+
+    def test_two_calls_in_one_response(self):
+        session = self.session([
+            [{"tool_call": {"name": "read_file", "arguments": {"file_path": "notes.txt"}, "split": 2}},
+             {"tool_call": {"name": "read_file", "arguments": {"file_path": "missing.txt"}, "split": 5}}],
+            [{"content": "One of them is missing."}],
+        ])
+        events = []
+        session.send("Read both", events.append)
+        results = [event.result for event in events if event.kind == TOOL_RESULT]
+        self.assertEqual(len(results), 2)
+        self.assertIn("remember the milk", results[0])
+        self.assertIn("does not exist", results[1])
+
+    def test_a_change_is_written_only_when_approved(self):
+        script = [[{"tool_call": {"name": "create_file", "arguments": {"file_path": "TODO.md", "content": "- milk\n"}}}],
+                  [{"content": "Done."}]]
+        self.session(list(script)).send("Make a TODO")
+        self.assertFalse(os.path.exists(os.path.join(self.workdir, "TODO.md")))
+        self.session(list(script), approve=lambda action, target: target == "TODO.md").send("Make a TODO")
+        with open(os.path.join(self.workdir, "TODO.md"), encoding="utf-8") as f:
+            self.assertEqual(f.read(), "- milk\n")
+
+    def test_mid_stream_failure(self):
+        session = self.session([[{"content": "Half a rep"}, {"error": "connection reset"}]])
+        with self.assertRaisesRegex(MockStreamError, "connection reset"):
+            session.send("Tell me something")
+        history = session.conversation.history()
+        self.assertEqual(history[-1]["content"], "Tell me something")
+        self.assertNotIn("assistant", [m["role"] for m in history])
+
+    def test_failure_in_the_follow_up_keeps_the_tool_result(self):
+        session = self.session([
+            [{"tool_call": {"name": "read_file", "arguments": {"file_path": "notes.txt"}}}],
+            [{"error": "rate limited"}],
+        ])
+        with self.assertRaises(MockStreamError):
+            session.send("What do my notes say?")
+        self.assertEqual([m["role"] for m in session.conversation.history()][-3:], ["user", "assistant", "tool"])
+
+    def test_whitespace_before_a_tool_call_is_sent_as_null(self):
+        for content in ("", "\n\n", " \t\n "):
+            with self.subTest(content=content):
+                session = self.session([
+                    [{"content": content}, {"tool_call": {"name": "read_file", "arguments": {"file_path": "notes.txt"}}}],
+                    [{"content": "  Remember the milk.\n"}],
+                ])
+                self.assertEqual(session.send("What do my notes say?"), "Remember the milk.")
+                asking, _, answer = session.conversation.history()[-3:]
+                self.assertIsNone(asking["content"])
+                self.assertEqual(len(asking["tool_calls"]), 1)
+                self.assertEqual(answer["content"], "Remember the milk.")
+                self.assertIsNone(self.client.requests[1]["messages"][-2]["content"])
+
+    def test_an_empty_reply_is_not_added(self):
+        for response in ([], [{"content": ""}], [{"content": "   \n\n"}]):
+            with self.subTest(response=response):
+                session = self.session([response, [{"content": "Now I can."}]])
+                events = []
+                self.assertEqual(session.send("Say nothing", events.append), "")
+                self.assertEqual(events[-1].kind, DONE)
+                self.assertEqual(events[-1].text, "")
+                self.assertEqual([m["role"] for m in session.conversation.history()], ["user"])
+                self.assertEqual(session.send("Say something"), "Now I can.")
+                self.assertEqual([m["role"] for m in self.client.requests[1]["messages"][1:]], ["user", "user"])
+
+    def test_an_empty_follow_up_keeps_the_first_reply(self):
+        session = self.session([
+            [{"content": "Reading.\n"}, {"tool_call": {"name": "read_file", "arguments": {"file_path": "notes.txt"}}}],
+            [{"content": " \n"}],
+        ])
+        self.assertEqual(session.send("What do my notes say?"), "Reading.")
+        self.assertEqual([m["role"] for m in session.conversation.history()], ["user", "assistant", "tool"])
+        self.assertEqual(session.conversation.history()[1]["content"], "Reading.")
+
+    def test_reply_text(self):
+        self.assertEqual(reply_text(None), "")
+        self.assertEqual(reply_text("\n\n \t"), "")
+        self.assertEqual(reply_text("\n  Hello\n"), "Hello")
+
+    def test_echoes_once_the_script_runs_out(self):
+        self.assertEqual(self.session([]).send("ping"), "[mock] You said: ping")
+
+    def test_fixture_file(self):
+        fixture = os.path.join(self.workdir, "fixture.json")
+        with open(fixture, "w", encoding="utf-8") as f:
+            json.dump({"responses": [[{"content": "From the fixture."}]]}, f)
+        client = create_client(NeoConfig(provider="mock", mock_fixture=fixture))
+        session = Session(NeoConfig(provider="mock"), workdir=self.workdir, client=client)
+        self.assertEqual(session.send("hi"), "From the fixture.")
+
+if __name__ == "__main__":
+    unittest.main()
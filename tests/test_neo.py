@@ -0,0 +1,71 @@
+import json
+import tempfile
+import unittest
+from pathlib import Path
+from unittest import mock
+
+import neo
+from neo_core import ai
+from neo_core.ai import Agent, DebugLogger, TranscriptWriter
+from neo_core.audit import AuditLog
+from neo_core.commands import CommandContext
+from neo_core.config import NeoConfig
+from neo_core.conversation import Conversation
+from neo_core.fileops import Workspace
+from neo_core.title import SessionTitle
+from neo_core.tools import ToolContext, create_default_registry
+from neo_core.ui import console
+
+class ShutDownTest(unittest.TestCase):
+    """However the session ends, shutting down stops background work and saves the session to resume."""
+
+    def setUp(self):
+        patcher = mock.patch.object(console, "quiet", True)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        self.sessions = Path(self.tmp.name) / "sessions_dir"
+        for name in ("SESSIONS_DIR", "LOGS_DIR", "TRANSCRIPTS_DIR"):
+            patcher = mock.patch.object(ai, name, Path(self.tmp.name) / name.lower())
+            patcher.start()
+            self.addCleanup(patcher.stop)
+        workspace = Workspace(self.tmp.name)
+        config = NeoConfig()
+        conversation = Conversation("You are Neo.")
+        registry = create_default_registry(ToolContext(workspace, config, conversation, AuditLog(workspace.root, enabled=False)))
+        debug_log = DebugLogger(config)
+        agent = Agent(None, config, registry, conversation, debug_log, TranscriptWriter())
+        self.commands = CommandContext(agent, workspace, registry, debug_log)
+
+    def saved(self):
+        return sorted(self.sessions.glob("*.json")) if self.sessions.is_dir() else []
+
+    def test_saves_the_session(self):
+        conversation = self.commands.agent.conversation
+        conversation.add_user("read the notes")
+        conversation.add_assistant("They say: ship it.")
+        self.commands.title = SessionTitle("Shipping the notes")
+        self.commands.tools.disable(["edit_file"])
+        neo.shut_down(self.commands, [])
+        [path] = self.saved()
+        with open(path, encoding="utf-8") as f:
+            session = json.load(f)
+        self.assertEqual([(msg["role"], msg["content"]) for msg in session["messages"] if msg["role"] != "system"],
+                         [("user", "read the notes"), ("assistant", "They say: ship it.")])
+        self.assertEqual(session["title"], "Shipping the notes")
+        self.assertEqual(session["disabled_tools"], ["edit_file"])
+        self.assertEqual(session["context_files"], {})
+
+    def test_nothing_to_save_without_an_exchange(self):
+        neo.shut_down(self.commands, [])
+        self.assertEqual(self.saved(), [])
+
+    def test_mcp_servers_are_stopped(self):
+        servers = [mock.Mock(), mock.Mock()]
+        neo.shut_down(self.commands, servers)
+        for server in servers:
+            server.stop.assert_called_once_with()
+
+if __name__ == "__main__":
+    unittest.main()
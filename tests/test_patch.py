@@ -0,0 +1,88 @@
+import filecmp
+import shutil
+import subprocess
+import tempfile
+import unittest
+from pathlib import Path
+
+from neo_core.fileops import Workspace
+from neo_core.patch import SessionChanges
+
+STARTING_TREE = {
+    "src/app.py": "def main():\n    return 1\n",
+    "README.md": "# App\n\nIt runs.\n",
+    "no_newline.txt": "last line",
+    "old.txt": "remove me\n",
+    "crlf.txt": "one\r\ntwo\r\n",
+}
+
+@unittest.skipUnless(shutil.which("git"), "git is not installed")
+class SessionPatchTest(unittest.TestCase):
+    """The session's patch applies with git apply to the tree as it was when the session started."""
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        base = Path(self.tmp.name).resolve()
+        self.root = base / "workspace"
+        self.start = base / "start"
+        for path, content in STARTING_TREE.items():
+            (self.root / path).parent.mkdir(parents=True, exist_ok=True)
+            (self.root / path).write_bytes(content.encode("utf-8"))
+        shutil.copytree(self.root, self.start)
+        self.workspace = Workspace(str(self.root))
+        self.changes = SessionChanges(self.workspace)
+
+    def write_patch(self):
+        patches, skipped = self.changes.patches()
+        self.assertEqual(skipped, [])
+        patch_file = Path(self.tmp.name) / "session.patch"
+        patch_file.write_text("".join(patch.diff for patch in patches if patch.diff), encoding="utf-8")
+        return patches, patch_file
+
+    def git_apply(self, patch_file):
+        return subprocess.run(["git", "apply", str(patch_file)], cwd=self.start,
+                              capture_output=True, text=True)
+
+    def assertSameTree(self, left, right):
+        def files(root):
+            return sorted(str(p.relative_to(root)) for p in root.rglob("*") if p.is_file() and ".neo" not in p.parts)
+        self.assertEqual(files(left), files(right))
+        for path in files(left):
+            self.assertTrue(filecmp.cmp(left / path, right / path, shallow=False), path)
+
+    def test_patch_applies_to_the_starting_tree(self):
+        self.workspace.apply_diff_edit("src/app.py", "return 1", "return 2")
+        self.workspace.apply_diff_edit("src/app.py", "return 2", "return 3")  # Two writes, one diff from the start
+        self.workspace.create_file("docs/guide.md", "# Guide\n")
+        self.workspace.create_file("no_newline.txt", "last line\nand another")
+        self.workspace.apply_diff_edit("crlf.txt", "two", "three")
+        self.workspace.delete_file("old.txt")
+        self.workspace.create_file("scratch.txt", "temporary\n")
+        self.workspace.delete_file("scratch.txt")  # Created and deleted again: not in the patch
+
+        patches, patch_file = self.write_patch()
+        self.assertEqual({patch.path: patch.status for patch in patches}, {
+            "crlf.txt": "modified", "docs/guide.md": "created", "no_newline.txt": "modified",
+            "old.txt": "deleted", "src/app.py": "modified",
+        })
+        result = self.git_apply(patch_file)
+        self.assertEqual(result.returncode, 0, result.stderr)
+        self.assertSameTree(self.start, self.root)
+
+    def test_a_file_back_to_its_start_has_an_empty_diff(self):
+        self.workspace.apply_diff_edit("README.md", "It runs.", "It flies.")
+        self.workspace.apply_diff_edit("README.md", "It flies.", "It runs.")
+        patches, _ = self.write_patch()
+        self.assertEqual([(patch.path, patch.diff) for patch in patches], [("README.md", "")])
+
+    def test_changes_made_outside_neo_are_flagged_and_included(self):
+        self.workspace.apply_diff_edit("README.md", "It runs.", "It flies.")
+        (self.root / "README.md").write_text("# App\n\nIt flies, fast.\n")
+        patches, patch_file = self.write_patch()
+        self.assertTrue(patches[0].changed_outside)
+        self.assertEqual(self.git_apply(patch_file).returncode, 0)
+        self.assertEqual((self.start / "README.md").read_text(), "# App\n\nIt flies, fast.\n")
+
+if __name__ == "__main__":
+    unittest.main()
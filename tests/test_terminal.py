@@ -0,0 +1,33 @@
+import signal
+import unittest
+from unittest import mock
+
+from neo_core import terminal
+from neo_core.ui import TerminalSize
+
+@unittest.skipUnless(hasattr(signal, "SIGWINCH"), "no SIGWINCH on Windows")
+class ResizeHandlerTest(unittest.TestCase):
+    """SIGWINCH only marks the size stale; the console reads it before its next line."""
+
+    def setUp(self):
+        self.addCleanup(signal.signal, signal.SIGWINCH, signal.getsignal(signal.SIGWINCH))
+        self.size = TerminalSize()
+        patcher = mock.patch.object(terminal, "terminal_size", self.size)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+
+    def test_install_marks_the_size_stale_and_can_be_put_back(self):
+        terminal.install_resize_handler()
+        self.assertIs(signal.getsignal(signal.SIGWINCH), terminal._resized)
+        self.assertTrue(self.size.stale)
+        self.assertIs(self.size.reinstall, terminal.install_resize_handler)
+
+    def test_signal_marks_the_size_stale(self):
+        terminal.install_resize_handler()
+        self.size.stale = False
+        signal.raise_signal(signal.SIGWINCH)
+        self.assertTrue(self.size.stale)
+        self.assertEqual(self.size.changes, 0)  # Nothing is read in the handler
+
+if __name__ == "__main__":
+    unittest.main()
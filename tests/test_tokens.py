@@ -0,0 +1,113 @@
+import unittest
+from unittest import mock
+
+from neo_core import tokens
+from neo_core.tokens import (MESSAGE_OVERHEAD, TOOL_CALL_OVERHEAD, BPECounter, CharCounter, active_counter, counter_for_model,
+                             estimate_conversation, estimate_message, estimate_string, set_counter)
+
+# Known cl100k_base counts, from OpenAI's tiktoken examples
+CL100K_COUNTS = {
+    "tiktoken is great!": 6,
+    "antidisestablishmentarianism": 6,
+    "2 + 2 = 4": 7,
+    "お誕生日おめでとう": 9,
+    "hello world": 2,
+}
+
+def cl100k_counter():
+    """A BPECounter for cl100k_base, or None without tiktoken or its encoding file."""
+    if tokens.tiktoken is None:
+        return None
+    try:
+        return BPECounter(tokens.tiktoken.get_encoding("cl100k_base"))
+    except Exception:  # Downloaded on first use
+        return None
+
+class WordEncoding:
+    """Stands in for a tiktoken encoding: one token per word, counting how often it is asked."""
+
+    name = "words"
+
+    def __init__(self):
+        self.calls = 0
+
+    def encode(self, text, disallowed_special=None):
+        self.calls += 1
+        return text.split()
+
+class CounterTest(unittest.TestCase):
+
+    def setUp(self):
+        self.addCleanup(set_counter, active_counter())
+
+    def test_four_characters_per_token_rounded_up(self):
+        counter = CharCounter()
+        self.assertEqual([counter.count(text) for text in ("", "a", "abcd", "abcde", "x" * 400)], [0, 1, 1, 2, 100])
+
+    def test_bpe_counts_are_memoized(self):
+        encoding = WordEncoding()
+        counter = BPECounter(encoding)
+        self.assertEqual(counter.name, "words tokenizer")
+        self.assertEqual([counter.count("one two three") for _ in range(3)], [3, 3, 3])
+        self.assertEqual(encoding.calls, 1)
+
+    def test_without_tiktoken_the_estimate_is_used(self):
+        with mock.patch.object(tokens, "tiktoken", None):
+            self.assertIsInstance(counter_for_model.__wrapped__("gpt-4"), CharCounter)
+
+    def test_an_unreachable_encoding_falls_back_to_the_estimate(self):
+        fake = mock.Mock()
+        fake.encoding_for_model.side_effect = KeyError("deepseek-chat")
+        fake.get_encoding.side_effect = OSError("no network")
+        with mock.patch.object(tokens, "tiktoken", fake):
+            self.assertIsInstance(counter_for_model.__wrapped__("deepseek-chat"), CharCounter)
+        fake.get_encoding.assert_called_once_with("cl100k_base")
+
+    def test_set_counter(self):
+        set_counter(BPECounter(WordEncoding()))
+        self.assertEqual(estimate_string("a b c"), 3)
+        self.assertEqual(estimate_string(""), 0)
+        self.assertEqual(estimate_string(None), 0)
+
+@unittest.skipIf(cl100k_counter() is None, "needs tiktoken and its cl100k_base encoding")
+class AccuracyTest(unittest.TestCase):
+    """The tokenizer's counts against known ones."""
+
+    def test_known_counts(self):
+        counter = cl100k_counter()
+        for text, count in CL100K_COUNTS.items():
+            with self.subTest(text=text):
+                self.assertEqual(counter.count(text), count)
+
+    def test_special_tokens_are_counted_as_text(self):
+        self.assertGreater(cl100k_counter().count("<|endoftext|>"), 1)
+
+    def test_unknown_model_uses_cl100k(self):
+        counter = counter_for_model.__wrapped__("deepseek-chat")
+        self.assertEqual(counter.name, "cl100k_base tokenizer")
+        self.assertEqual(counter.count("tiktoken is great!"), 6)
+
+class EstimateMessageTest(unittest.TestCase):
+    """Message estimates add the chat format's overhead to the content's count."""
+
+    def setUp(self):
+        self.addCleanup(set_counter, active_counter())
+        set_counter(BPECounter(WordEncoding()))
+
+    def test_plain_message(self):
+        self.assertEqual(estimate_message({"role": "user", "content": "read the notes"}), 3 + MESSAGE_OVERHEAD)
+        self.assertEqual(estimate_message({"role": "assistant", "content": None}), MESSAGE_OVERHEAD)
+
+    def test_tool_calls_and_results(self):
+        asking = {"role": "assistant", "content": None, "tool_calls": [
+            {"id": "c1", "type": "function", "function": {"name": "read_file", "arguments": '{"file_path": "a.txt"}'}},
+            {"id": "c2", "type": "function", "function": {"name": "read_file", "arguments": '{"file_path": "b.txt"}'}},
+        ]}
+        self.assertEqual(estimate_message(asking), MESSAGE_OVERHEAD + 2 * (1 + 2 + TOOL_CALL_OVERHEAD))
+        result = {"role": "tool", "tool_call_id": "c1", "content": "remember the milk"}
+        self.assertEqual(estimate_message(result), 3 + 1 + MESSAGE_OVERHEAD)
+        self.assertEqual(estimate_conversation([asking, result]), estimate_message(asking) + estimate_message(result))
+        self.assertEqual(estimate_conversation([]), 0)
+
+if __name__ == "__main__":
+    unittest.main()
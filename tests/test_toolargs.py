@@ -0,0 +1,111 @@
+import json
+import os
+import tempfile
+import unittest
+from unittest import mock
+
+from neo_core.config import NeoConfig
+from neo_core.conversation import Conversation
+from neo_core.fileops import Workspace
+from neo_core.toolargs import ArgumentsError, parse_arguments
+from neo_core.tools import MAX_PARSE_FAILURES, ToolContext, create_default_registry
+from neo_core.ui import console
+
+# Broken arguments as models have streamed them, with what they should parse to and the repairs made
+REPAIRABLE = [
+    ('{"file_path": "a.py",}', {"file_path": "a.py"}, ["removed trailing commas"]),
+    ('{"files": [{"path": "a", "content": "x"},],}', {"files": [{"path": "a", "content": "x"}]}, ["removed trailing commas"]),
+    ("{'file_path': 'a.py'}", {"file_path": "a.py"}, ["converted single quotes"]),
+    ("{'content': 'say \"hi\"', \"file_path\": \"it's.txt\"}", {"content": 'say "hi"', "file_path": "it's.txt"},
+     ["converted single quotes"]),
+    ("{'content': 'don\\'t'}", {"content": "don't"}, ["converted single quotes"]),
+    ('```json\n{"file_path": "a.py"}\n```', {"file_path": "a.py"}, ["removed code fence"]),
+    ('{"file_path": "a.py", "content": "def main():\\n    pri', {"file_path": "a.py", "content": "def main():\n    pri"},
+     ["closed truncated JSON"]),
+    ('{"files": [{"path": "a", "content": "x"}, {"path": "b"', {"files": [{"path": "a", "content": "x"}, {"path": "b"}]},
+     ["closed truncated JSON"]),
+    ('{"file_path": "a.py", "content":', {"file_path": "a.py", "content": None}, ["closed truncated JSON"]),
+    ('{"file_path": "a.py", "content": "ends in \\', {"file_path": "a.py", "content": "ends in "}, ["closed truncated JSON"]),
+    ("{'file_path': 'a.py',", {"file_path": "a.py"}, ["converted single quotes", "closed truncated JSON"]),
+]
+UNREPAIRABLE = [
+    '{"file_path" "a.py"}',
+    "file_path=a.py",
+    '{"a": 1} {"b": 2}',
+    '["a.py"]',
+    '"a.py"',
+]
+
+class ParseArgumentsTest(unittest.TestCase):
+
+    def test_valid_json_needs_no_repair(self):
+        for raw, expected in (('{"file_path": "a.py"}', {"file_path": "a.py"}), ("", {}), ("  ", {})):
+            parsed = parse_arguments(raw)
+            self.assertEqual((parsed.value, parsed.repairs), (expected, []))
+
+    def test_repairable_corpus(self):
+        for raw, expected, repairs in REPAIRABLE:
+            with self.subTest(raw=raw):
+                parsed = parse_arguments(raw)
+                self.assertEqual(parsed.value, expected)
+                self.assertEqual(parsed.repairs, repairs)
+                self.assertEqual(parsed.truncated, "closed truncated JSON" in repairs)
+
+    def test_unrepairable_corpus(self):
+        for raw in UNREPAIRABLE:
+            with self.subTest(raw=raw), self.assertRaises(ArgumentsError) as caught:
+                parse_arguments(raw)
+            self.assertEqual(caught.exception.raw, raw)
+
+class InvalidArgumentsResultTest(unittest.TestCase):
+    """What the model gets back for arguments that can't be used, and the limit on retries."""
+
+    def setUp(self):
+        patcher = mock.patch.object(console, "quiet", True)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+        self.tmp = tempfile.TemporaryDirectory()
+        with open(os.path.join(self.tmp.name, "a.py"), "w", encoding="utf-8") as f:
+            f.write("x = 1\n")
+        ctx = ToolContext(Workspace(self.tmp.name), NeoConfig(), Conversation("system"))
+        self.registry = create_default_registry(ctx)
+        self.registry.begin_turn()
+
+    def tearDown(self):
+        self.tmp.cleanup()
+
+    def call(self, name, arguments):
+        return self.registry.execute({"id": "c", "type": "function", "function": {"name": name, "arguments": arguments}})
+
+    def test_result_quotes_the_error_and_schema(self):
+        result = json.loads(self.call("read_file", '{"file_path" "a.py"}'))
+        self.assertIn("invalid arguments for read_file", result["error"])
+        self.assertIn("line 1 column", result["error"])
+        self.assertEqual(result["received"], '{"file_path" "a.py"}')
+        self.assertEqual(result["expected_schema"], self.registry.get("read_file").parameters)
+
+    def test_truncated_arguments_never_change_files(self):
+        result = json.loads(self.call("create_file", '{"file_path": "b.py", "content": "x = '))
+        self.assertIn("cut off", result["error"])
+        self.assertFalse(os.path.exists(os.path.join(self.tmp.name, "b.py")))
+
+    def test_repaired_arguments_run(self):
+        self.assertIn("x = 1", self.call("read_file", "{'file_path': 'a.py',}"))
+
+    def test_consecutive_failures_stop_the_turn(self):
+        for _ in range(MAX_PARSE_FAILURES):
+            self.call("read_file", "not json")
+        self.assertIn("in a row with invalid arguments", self.registry.turn_limit_reached())
+        self.assertIn("Refused", self.call("read_file", '{"file_path": "a.py"}'))
+        self.registry.begin_turn()
+        self.assertIn("x = 1", self.call("read_file", '{"file_path": "a.py"}'))
+
+    def test_a_valid_call_resets_the_count(self):
+        for _ in range(MAX_PARSE_FAILURES - 1):
+            self.call("read_file", "not json")
+        self.call("read_file", '{"file_path": "a.py"}')
+        self.call("read_file", "not json")
+        self.assertIsNone(self.registry.turn_limit_reached())
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,374 @@
+import json
+import tempfile
+import threading
+import time
+import unittest
+from pathlib import Path
+from unittest import mock
+
+from neo_core.cancel import CANCELLED_RESULT, Cancelled
+from neo_core.config import NeoConfig
+from neo_core.conversation import Conversation
+from neo_core.fileops import Workspace
+from neo_core.tools import MAX_PARALLEL_READS, Tool, ToolContext, ToolRegistry, TurnBudget, call_key, create_default_registry
+from neo_core.ui import console
+
+class ToolHandlerTest(unittest.TestCase):
+    """Each handler run through the registry against a temporary workspace: success, missing fields and filesystem errors."""
+
+    def setUp(self):
+        patcher = mock.patch.object(console, "quiet", True)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        self.root = Path(self.tmp.name).resolve()
+        (self.root / "src").mkdir()
+        (self.root / "src" / "app.py").write_text("def main():\n    return 1\n")
+        (self.root / "notes.txt").write_text("one\ntwo\nthree\n")
+        self.approved = []
+        self.ctx = ToolContext(Workspace(str(self.root)), NeoConfig(), Conversation("system"), approve=self.approve)
+        self.registry = create_default_registry(self.ctx)
+        self.registry.begin_turn()
+
+    def approve(self, action, target):
+        self.approved.append((action, target))
+        return True
+
+    def run_tool(self, name, **arguments):
+        return self.registry.execute_with_status(
+            {"id": "c", "type": "function", "function": {"name": name, "arguments": json.dumps(arguments)}})
+
+    def assertMissing(self, name, field):
+        status, result = self.run_tool(name)
+        self.assertEqual(status, "invalid")
+        self.assertIn(f"missing required field(s): {field}", json.loads(result)["error"])
+
+    def test_read_file(self):
+        status, result = self.run_tool("read_file", file_path="notes.txt")
+        self.assertEqual(status, "ok")
+        self.assertIn("one\ntwo\nthree", result)
+        status, result = self.run_tool("read_file", file_path="notes.txt", start_line=2, end_line=2)
+        self.assertIn("Lines 2-2 of 3", result)
+        self.assertTrue(result.endswith("two\n"))
+
+    def test_read_file_errors(self):
+        self.assertMissing("read_file", "file_path")
+        for path, message in (("missing.txt", "does not exist"), ("src", "is a directory"), ("../x.txt", "outside the workspace")):
+            with self.subTest(path=path):
+                status, result = self.run_tool("read_file", file_path=path)
+                self.assertEqual(status, "error")
+                self.assertIn(message, result)
+
+    def test_read_multiple_files_reports_each_failure(self):
+        status, result = self.run_tool("read_multiple_files", file_paths=["notes.txt", "missing.txt"])
+        self.assertEqual(status, "ok")
+        self.assertIn("three", result)
+        self.assertIn("Error reading 'missing.txt'", result)
+        self.assertMissing("read_multiple_files", "file_paths")
+
+    def test_outline_file(self):
+        status, result = self.run_tool("outline_file", file_path="src/app.py")
+        self.assertEqual(status, "ok")
+        self.assertIn("def main()", result)
+        self.assertNotIn("return 1", result)
+
+    def test_create_file(self):
+        status, result = self.run_tool("create_file", file_path="docs/new.md", content="# New\n")
+        self.assertEqual(status, "ok")
+        self.assertEqual((self.root / "docs" / "new.md").read_text(), "# New\n")
+        self.assertEqual(self.approved, [("creation", "docs/new.md")])
+        self.assertMissing("create_file", "file_path, content")
+
+    def test_create_file_declined(self):
+        self.ctx.approve = lambda action, target: False
+        status, result = self.run_tool("create_file", file_path="new.md", content="# New\n")
+        self.assertEqual(status, "declined")
+        self.assertFalse((self.root / "new.md").exists())
+
+    def test_create_file_below_a_file(self):
+        status, result = self.run_tool("create_file", file_path="notes.txt/new.md", content="x\n")
+        self.assertEqual(status, "error")
+        self.assertIn("Error executing create_file", result)
+        self.assertEqual((self.root / "notes.txt").read_text(), "one\ntwo\nthree\n")
+
+    def test_create_multiple_files(self):
+        status, result = self.run_tool("create_multiple_files", files=[{"path": "a.md", "content": "a\n"},
+                                                                       {"path": "b.md", "content": "b\n"}])
+        self.assertEqual(status, "ok")
+        self.assertIn("Successfully created 2 files", result)
+        self.assertEqual([(self.root / name).read_text() for name in ("a.md", "b.md")], ["a\n", "b\n"])
+        self.assertMissing("create_multiple_files", "files")
+
+    def test_edit_file(self):
+        status, result = self.run_tool("edit_file", file_path="src/app.py", original_snippet="return 1", new_snippet="return 2")
+        self.assertEqual(status, "ok")
+        self.assertIn("Successfully edited file 'src/app.py'", result)
+        self.assertEqual((self.root / "src" / "app.py").read_text(), "def main():\n    return 2\n")
+        self.assertMissing("edit_file", "file_path, original_snippet, new_snippet")
+
+    def test_edit_file_errors(self):
+        status, result = self.run_tool("edit_file", file_path="src/app.py", original_snippet="return 3", new_snippet="x")
+        self.assertEqual(status, "error")
+        self.assertIn("was not found", result)
+        status, result = self.run_tool("edit_file", file_path="gone.py", original_snippet="a", new_snippet="b")
+        self.assertEqual(status, "ok")
+        self.assertIn("Could not read file 'gone.py'", result)
+        status, result = self.run_tool("edit_file", file_path="src/app.py", original_snippet="return 1", new_snippet="x",
+                                       occurrence_index=0)
+        self.assertIn("occurrence_index must be a whole number", result)
+        self.assertEqual((self.root / "src" / "app.py").read_text(), "def main():\n    return 1\n")
+
+    def test_protected_path_is_refused(self):
+        status, result = self.run_tool("create_file", file_path=".git/config", content="x\n")
+        self.assertEqual(status, "refused")
+        self.assertIn("protected path", result)
+        self.assertEqual(self.approved, [])
+
+    def test_unknown_tool(self):
+        status, result = self.run_tool("format_disk")
+        self.assertEqual(status, "unknown")
+        self.assertIn("read_file", json.loads(result)["available"])
+
+BLOCK = "if ready:\n    start()\n"
+THREE_BLOCKS = f"# one\n{BLOCK}# two\n{BLOCK}# three\n{BLOCK}"
+
+class EditOccurrenceTest(unittest.TestCase):
+    """edit_file on a snippet that appears three times: asked at the terminal, or told to the model."""
+
+    def setUp(self):
+        patcher = mock.patch.object(console, "quiet", True)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        self.path = Path(self.tmp.name).resolve() / "blocks.py"
+        self.path.write_text(THREE_BLOCKS)
+        self.ctx = ToolContext(Workspace(str(self.path.parent)), NeoConfig(), Conversation("system"))
+        self.registry = create_default_registry(self.ctx)
+        self.registry.begin_turn()
+
+    def edit(self, original=BLOCK, **arguments):
+        return self.registry.execute_with_status({"id": "c", "type": "function", "function": {
+            "name": "edit_file",
+            "arguments": json.dumps({"file_path": "blocks.py", "original_snippet": original, "new_snippet": "if ready:\n    stop()\n",
+                                     **arguments})}})
+
+    def answering(self, *answers):
+        return mock.patch("neo_core.ui.prompt_session.prompt", side_effect=list(answers))
+
+    def test_the_chosen_occurrence_is_edited(self):
+        with self.answering("9", "2") as prompt:
+            status, result = self.edit()
+        self.assertEqual(status, "ok")
+        self.assertEqual(prompt.call_count, 2)  # "9" is not an occurrence, so it asks again
+        self.assertEqual(self.path.read_text(), THREE_BLOCKS.replace("# two\n" + BLOCK, "# two\nif ready:\n    stop()\n"))
+
+    def test_all_occurrences(self):
+        with self.answering("all"):
+            self.edit()
+        self.assertEqual(self.path.read_text(), THREE_BLOCKS.replace("start()", "stop()"))
+
+    def test_cancel(self):
+        with self.answering("cancel"):
+            status, result = self.edit()
+        self.assertEqual(status, "declined")
+        self.assertIn("declined", result)
+        self.assertEqual(self.path.read_text(), THREE_BLOCKS)
+
+    def test_without_a_terminal_the_model_gets_the_lines(self):
+        self.ctx.approve = lambda action, target: True
+        with self.answering() as prompt:
+            status, result = self.edit()
+        self.assertEqual(status, "error")
+        self.assertIn("appears 3 times in 'blocks.py', starting at lines 2, 5, 8", result)
+        self.assertIn("occurrence_index", result)
+        prompt.assert_not_called()
+        self.assertEqual(self.path.read_text(), THREE_BLOCKS)
+
+    def test_occurrence_index_picks_one(self):
+        self.ctx.approve = lambda action, target: True
+        status, _ = self.edit(occurrence_index=3)
+        self.assertEqual(status, "ok")
+        self.assertTrue(self.path.read_text().endswith("# three\nif ready:\n    stop()\n"))
+        self.assertEqual(self.path.read_text().count("start()"), 2)
+        status, result = self.edit(occurrence_index=4)
+        self.assertIn("no occurrence_index 4", result)
+
+    def test_a_missing_snippet_is_refused_before_asking(self):
+        with self.answering() as prompt, mock.patch("neo_core.tools.show_diff_table") as diff:
+            status, result = self.edit(original="if never:\n")
+        self.assertEqual(status, "error")
+        self.assertIn("was not found", result)
+        prompt.assert_not_called()
+        diff.assert_not_called()
+
+class FakeTool(Tool):
+    """Records when each call starts and ends; the call's "n" picks how long it takes."""
+
+    parameters = {"type": "object", "properties": {"n": {"type": "integer"}}, "required": ["n"]}
+
+    def __init__(self, name, mutating, events, barrier=None, delays=()):
+        self.name = name
+        self.mutating = mutating
+        self.events = events
+        self.barrier = barrier  # Passed only by calls running at the same time
+        self.delays = delays
+        self.running = 0
+        self.most_running = 0
+        self.lock = threading.Lock()
+
+    def execute(self, ctx, arguments):
+        n = arguments["n"]
+        with self.lock:
+            self.running += 1
+            self.most_running = max(self.most_running, self.running)
+            self.events.append(("start", self.name, n))
+        try:
+            if self.barrier:
+                self.barrier.wait()
+            time.sleep(self.delays[n] if n < len(self.delays) else 0)
+            return f"{self.name} {n}"
+        finally:
+            with self.lock:
+                self.running -= 1
+                self.events.append(("end", self.name, n))
+
+class ExecuteAllTest(unittest.TestCase):
+    """Read-only calls from one response run concurrently; a mutating one runs alone, and results keep request order."""
+
+    def setUp(self):
+        patcher = mock.patch.object(console, "quiet", True)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        self.events = []
+
+    def registry(self, *tools):
+        ctx = ToolContext(Workspace(self.tmp.name), NeoConfig(), Conversation("system"))
+        registry = ToolRegistry(ctx, tools)
+        registry.begin_turn()
+        return registry
+
+    def calls(self, *requests):
+        return [{"id": f"c{i}", "type": "function", "function": {"name": name, "arguments": json.dumps({"n": n})}}
+                for i, (name, n) in enumerate(requests)]
+
+    def test_reads_run_together_and_answer_in_order(self):
+        # Each call waits for the other two at the barrier, so they can only pass if they run at once
+        slow = FakeTool("slow_read", False, self.events, threading.Barrier(3, timeout=5), delays=(0.2, 0.1, 0))
+        results = self.registry(slow).execute_all(self.calls(("slow_read", 0), ("slow_read", 1), ("slow_read", 2)))
+        self.assertEqual(results, ["slow_read 0", "slow_read 1", "slow_read 2"])
+        self.assertEqual([n for event, _, n in self.events if event == "end"], [2, 1, 0])  # The fastest finished first
+
+    def test_at_most_max_parallel_reads_at_once(self):
+        count = MAX_PARALLEL_READS * 2
+        slow = FakeTool("slow_read", False, self.events, threading.Barrier(MAX_PARALLEL_READS, timeout=5))
+        results = self.registry(slow).execute_all(self.calls(*(("slow_read", n) for n in range(count))))
+        self.assertEqual(results, [f"slow_read {n}" for n in range(count)])
+        self.assertEqual(slow.most_running, MAX_PARALLEL_READS)
+
+    def test_a_mutating_call_waits_and_runs_alone(self):
+        read = FakeTool("slow_read", False, self.events, delays=(0.1, 0.05, 0))
+        write = FakeTool("write", True, self.events)
+        results = self.registry(read, write).execute_all(
+            self.calls(("slow_read", 0), ("slow_read", 1), ("write", 0), ("slow_read", 2)))
+        self.assertEqual(results, ["slow_read 0", "slow_read 1", "write 0", "slow_read 2"])
+        write_start = self.events.index(("start", "write", 0))
+        self.assertEqual(sorted(self.events[:write_start]), sorted([
+            ("start", "slow_read", 0), ("end", "slow_read", 0), ("start", "slow_read", 1), ("end", "slow_read", 1)]))
+        self.assertEqual(self.events[write_start:], [
+            ("start", "write", 0), ("end", "write", 0), ("start", "slow_read", 2), ("end", "slow_read", 2)])
+
+    def test_cancelling_a_wait_cancels_unfinished_reads_and_the_rest(self):
+        release = threading.Event()
+        self.addCleanup(release.set)
+
+        class Stuck(FakeTool):
+            def execute(self, ctx, arguments):
+                if arguments["n"]:
+                    release.wait(5)
+                return super().execute(ctx, arguments)
+
+        def on_wait(batch, seconds):
+            if ("end", "stuck", 0) in self.events:
+                raise Cancelled()
+
+        stuck = Stuck("stuck", False, self.events)
+        write = FakeTool("write", True, self.events)
+        results = self.registry(stuck, write).execute_all(self.calls(("stuck", 0), ("stuck", 1), ("write", 0)),
+                                                          on_wait=on_wait)
+        self.assertEqual(results, ["stuck 0", CANCELLED_RESULT, CANCELLED_RESULT])
+        self.assertNotIn(("start", "write", 0), self.events)
+
+class TurnBudgetTest(unittest.TestCase):
+    """Repeated read-only calls are answered once per turn, still count against its limit, and a change forgets them."""
+
+    def setUp(self):
+        patcher = mock.patch.object(console, "quiet", True)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        self.events = []
+        self.read = FakeTool("read", False, self.events)
+        self.write = FakeTool("write", True, self.events)
+        ctx = ToolContext(Workspace(self.tmp.name), NeoConfig(max_tool_calls_per_turn=5, max_writes_per_turn=2), Conversation("system"))
+        self.registry = ToolRegistry(ctx, [self.read, self.write])
+        self.registry.begin_turn()
+
+    def run_call(self, name, arguments):
+        """The status of one call; 'arguments' is the raw JSON the model sent."""
+        return self.registry.execute_with_status({"id": "c", "type": "function", "function": {"name": name, "arguments": arguments}})[0]
+
+    def test_spacing_and_key_order_make_no_difference(self):
+        self.assertEqual(call_key("read", {"n": 1, "path": "a"}), call_key("read", {"path": "a", "n": 1}))
+        statuses = [self.run_call("read", arguments) for arguments in ('{"n": 1, "path": "a"}', '{"path":"a","n":1}', ' {\n "n" : 1,\n "path": "a"}')]
+        self.assertEqual(statuses, ["ok", "duplicate", "duplicate"])
+        self.assertEqual(len(self.events), 2)  # Run once
+
+    def test_near_duplicates_are_new_calls(self):
+        statuses = [self.run_call("read", arguments) for arguments in (
+            '{"n": 1, "path": "a"}',
+            '{"n": 2, "path": "a"}',
+            '{"n": 1, "path": "a "}',
+            '{"n": 1, "path": "A"}',
+            '{"n": 1, "path": "a", "extra": null}',
+        )]
+        self.assertEqual(statuses, ["ok"] * 5)
+
+    def test_repeats_count_towards_the_limit(self):
+        statuses = [self.run_call("read", '{"n": 0}') for _ in range(6)]
+        self.assertEqual(statuses, ["ok", "duplicate", "duplicate", "duplicate", "duplicate", "refused"])
+        self.assertEqual(self.registry.turn_limit_reached(), "at most 5 tool calls per message")
+        self.assertEqual(self.run_call("read", '{"n": 1}'), "refused")  # Every call after the limit, new or not
+
+    def test_a_change_forgets_the_answered_calls(self):
+        statuses = [self.run_call(*call) for call in (
+            ("read", '{"n": 0}'), ("read", '{"n": 0}'), ("write", '{"n": 0}'), ("read", '{"n": 0}'), ("read", '{"n": 0}'))]
+        self.assertEqual(statuses, ["ok", "duplicate", "ok", "ok", "duplicate"])
+
+    def test_writes_are_never_repeats(self):
+        self.assertEqual([self.run_call("write", '{"n": 0}') for _ in range(3)], ["ok", "ok", "refused"])
+        self.assertEqual(self.registry.turn_limit_reached(), "at most 2 file-changing tool calls per message")
+
+    def test_the_next_turn_starts_afresh(self):
+        for _ in range(6):
+            self.run_call("read", '{"n": 0}')
+        self.registry.begin_turn()
+        self.assertIsNone(self.registry.turn_limit_reached())
+        self.assertEqual([self.run_call("read", '{"n": 0}') for _ in range(2)], ["ok", "duplicate"])
+
+    def test_budget_on_its_own(self):
+        budget = TurnBudget(max_tool_calls=3, max_writes=1, max_bytes_written=100)
+        self.assertEqual([budget.repeated(self.read, {"n": 0}) for _ in range(2)], [False, True])
+        self.assertFalse(budget.repeated(self.write, {"n": 0}))
+        self.assertEqual(budget.answered, set())
+        self.assertFalse(budget.repeated(self.read, {"n": 0}))
+        self.assertEqual([budget.charge(self.read, {"n": 0}) for _ in range(4)], [None, None, None, "at most 3 tool calls per message"])
+        budget.reset()
+        self.assertEqual((budget.tool_calls, budget.exceeded, budget.answered), (0, None, set()))
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,356 @@
+import io
+import unittest
+from types import SimpleNamespace
+from unittest import mock
+
+from rich.console import Console
+
+from neo_core import ui
+from neo_core.ui import (MATRIX_THEME, RAIN_WIDTH, MatrixConsole, MatrixRain, MatrixTextFormatter, TerminalSize, TerminalWriter,
+                         format_inline)
+
+RENDERER = Console(theme=MATRIX_THEME, force_terminal=True, width=200, file=io.StringIO())
+
+def styled(text):
+    """A Text as the terminal gets it: (text, style) runs with the theme's names resolved, in order."""
+    runs = []
+    for segment in text.render(RENDERER):
+        style = str(segment.style or "")
+        if runs and runs[-1][1] == style:
+            runs[-1] = (runs[-1][0] + segment.text, style)
+        elif segment.text:
+            runs.append((segment.text, style))
+    return runs
+
+class RecordingConsole:
+    """Stands in for the console, keeping what the formatter prints instead of rendering it."""
+
+    width = 40
+
+    def __init__(self):
+        self.printed = []
+
+    def print(self, *renderables, **kwargs):
+        self.printed.extend(renderables)
+
+class FormatInlineTest(unittest.TestCase):
+    """format_inline styles code, bold and italics, and leaves unmatched markers alone."""
+
+    def assertStyled(self, line, plain, runs):
+        text = format_inline(line)
+        self.assertEqual(text.plain, plain)
+        self.assertEqual(styled(text), runs)
+
+    def test_code_bold_and_italics(self):
+        self.assertStyled("Use `a*b` and **bold** or *this*", "Use a*b and bold or this", [
+            ("Use ", "bright_green"),
+            ("a*b", "bright_cyan on grey19"),
+            (" and ", "bright_green"),
+            ("bold", "bold bright_green"),
+            (" or ", "bright_green"),
+            ("this", "italic bright_green"),
+        ])
+
+    def test_italics_nested_in_bold(self):
+        self.assertStyled("**bold with *italic* inside**", "bold with italic inside", [
+            ("bold with ", "bold bright_green"),
+            ("italic", "bold italic bright_green"),
+            (" inside", "bold bright_green"),
+        ])
+
+    def test_three_stars_are_bold_and_italic(self):
+        self.assertStyled("***both***", "both", [("both", "bold italic bright_green")])
+
+    def test_markers_inside_code_are_literal(self):
+        self.assertStyled("`**not bold**`", "**not bold**", [("**not bold**", "bright_cyan on grey19")])
+
+    def test_double_ticks_hold_a_single_tick(self):
+        self.assertStyled("``code with ` tick``", "code with ` tick", [("code with ` tick", "bright_cyan on grey19")])
+
+    def test_blank_code_keeps_its_space(self):
+        self.assertStyled("` `", " ", [(" ", "bright_cyan on grey19")])
+
+    def test_unmatched_markers_print_literally(self):
+        for line in ["2 * 3 * 4", "a ** b ** c", "**unclosed bold", "`unclosed code", "****"]:
+            with self.subTest(line=line):
+                self.assertStyled(line, line, [(line, "bright_green")])
+
+    def test_stars_inside_a_word_are_not_italics(self):
+        self.assertStyled("file*name*x and *emph*", "file*name*x and emph", [
+            ("file*name*x and ", "bright_green"),
+            ("emph", "italic bright_green"),
+        ])
+
+    def test_base_style_carries_into_spans(self):
+        self.assertEqual(styled(format_inline("**Name**", "bright_cyan")), [("Name", "bold bright_cyan")])
+
+class FormatterInlineTest(unittest.TestCase):
+    """The stream formatter styles spans in prose, lists and wrapped lines."""
+
+    def format(self, *chunks):
+        console = RecordingConsole()
+        formatter = MatrixTextFormatter(console)
+        for chunk in chunks:
+            formatter.process_chunk(chunk)
+        formatter.finalize()
+        return console.printed
+
+    def test_span_split_across_chunks(self):
+        (line,) = self.format("Read **the", " docs** first\n")
+        self.assertEqual(line.plain, "Read the docs first")
+        self.assertEqual(styled(line), [
+            ("Read ", "bright_green"),
+            ("the docs", "bold bright_green"),
+            (" first", "bright_green"),
+        ])
+
+    def test_bullets(self):
+        first, second = self.format("- **Note:** read `x` now\n  * nested *item*\n")
+        self.assertEqual(first.plain, "  • Note: read x now")
+        self.assertEqual(styled(first), [
+            ("  ", ""),
+            ("•", "bright_cyan"),
+            (" ", ""),
+            ("Note:", "bold bright_green"),
+            (" read ", "bright_green"),
+            ("x", "bright_cyan on grey19"),
+            (" now", "bright_green"),
+        ])
+        self.assertEqual(second.plain, "    ◦ nested item")
+        self.assertEqual(styled(second), [
+            ("    ", ""),
+            ("◦", "green"),
+            (" ", ""),
+            ("nested ", "bright_green"),
+            ("item", "italic bright_green"),
+        ])
+
+    def test_wrapped_line_keeps_its_styles(self):
+        first, second = self.format("1. first **step** of a long line that will need wrapping\n")
+        self.assertEqual(first.plain, "1. first step of a long line that will ")
+        self.assertEqual(styled(first), [
+            ("1.", "bright_cyan"),
+            (" ", ""),
+            ("first ", "bright_green"),
+            ("step", "bold bright_green"),
+            (" of a long line that will ", "bright_green"),
+        ])
+        self.assertEqual(second.plain, "   need wrapping")
+        self.assertEqual(styled(second), [("   ", ""), ("need wrapping", "bright_green")])
+
+    def test_code_block_lines_are_not_styled(self):
+        printed = self.format("```python\nx = a * b * c  # **not bold**\n```\n")
+        self.assertIn("[matrix.code]│ x = a * b * c  # **not bold**[/matrix.code]", printed)
+
+# A fixed synthetic stream: reasoning printed fragment by fragment, then a reply through the formatter
+REASONING = " ".join(f"step{n}" for n in range(60))
+REPLY = "".join(f"Line {n} of the reply, with **bold** text.\n" for n in range(30))
+CHUNK_SIZE = 6
+CHUNK_SECONDS = 0.005  # How far apart the chunks arrive
+
+def chunks(text):
+    return [text[i:i + CHUNK_SIZE] for i in range(0, len(text), CHUNK_SIZE)]
+
+class FakeTimer:
+    """Stands in for threading.Timer: runs only when the test says the delay is over."""
+
+    started = []
+
+    def __init__(self, delay, function):
+        self.function = function
+        self.daemon = False
+
+    def start(self):
+        FakeTimer.started.append(self)
+
+    def cancel(self):
+        FakeTimer.started.remove(self)
+
+class StreamWritesTest(unittest.TestCase):
+    """How many writes reach the terminal for the same stream, written through and batched."""
+
+    def setUp(self):
+        self.now = 1000.0
+        self.out = io.StringIO()
+        self.writer = TerminalWriter(interval=0.05)
+        self.console = MatrixConsole(file=self.writer, theme=MATRIX_THEME, width=80, force_terminal=True, soft_wrap=True)
+        FakeTimer.started = []
+        for patcher in (mock.patch("neo_core.ui.time", SimpleNamespace(monotonic=lambda: self.now)),
+                        mock.patch("neo_core.ui.threading", SimpleNamespace(Timer=FakeTimer)),
+                        mock.patch("neo_core.ui.sys", SimpleNamespace(stdout=self.out))):
+            patcher.start()
+            self.addCleanup(patcher.stop)
+
+    def stream(self, batched):
+        formatter = MatrixTextFormatter(self.console)
+        if batched:
+            self.writer.begin_stream()
+        for chunk in chunks(REASONING):
+            self.console.print(chunk, end="", markup=False, highlight=False)
+            self.now += CHUNK_SECONDS
+        self.console.print()
+        for chunk in chunks(REPLY):
+            formatter.process_chunk(chunk)
+            self.now += CHUNK_SECONDS
+        formatter.finalize()
+        self.writer.end_stream()
+        return self.writer.writes, self.out.getvalue()
+
+    def test_written_through_every_print_is_a_write(self):
+        writes, _ = self.stream(batched=False)
+        self.assertEqual(writes, len(chunks(REASONING)) + 1 + REPLY.count("\n"))  # 100
+
+    def test_batched_at_most_one_write_per_interval(self):
+        writes, output = self.stream(batched=True)
+        seconds = (len(chunks(REASONING)) + len(chunks(REPLY))) * CHUNK_SECONDS
+        self.assertEqual(writes, 22)
+        self.assertLessEqual(writes, seconds / self.writer.interval + 1)
+        self.assertTrue(output.endswith("\x1b[92m text.\x1b[0m\n"))  # All of it, through the last line
+        self.assertEqual(FakeTimer.started, [])  # end_stream cancelled the one waiting
+
+    def test_batching_changes_no_output(self):
+        _, through = self.stream(batched=False)
+        self.out.seek(0)
+        self.out.truncate()
+        _, batched = self.stream(batched=True)
+        self.assertEqual(batched, through)
+
+    def test_a_pause_writes_the_held_partial_line(self):
+        self.writer.begin_stream()
+        self.console.print("thinking", end="", markup=False, highlight=False)
+        self.assertEqual(self.out.getvalue(), "thinking")  # The first write after a quiet spell goes straight out
+        self.now += 0.01
+        self.console.print(" more", end="", markup=False, highlight=False)
+        self.assertEqual(self.out.getvalue(), "thinking")
+        (timer,) = FakeTimer.started
+        FakeTimer.started.remove(timer)
+        timer.function()
+        self.assertEqual(self.out.getvalue(), "thinking more")
+        self.writer.end_stream()
+
+class TtyBuffer(io.StringIO):
+    """Output that says it is a terminal, so the console follows its size."""
+
+    def isatty(self):
+        return True
+
+class TerminalSizeTest(unittest.TestCase):
+    """The console's width follows a resize, read between lines only."""
+
+    def setUp(self):
+        self.size = TerminalSize()
+        patcher = mock.patch.object(ui, "terminal_size", self.size)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+        self.console = MatrixConsole(file=TtyBuffer(), width=120, height=40)
+        self.terminal = (100, 30)
+        patcher = mock.patch("neo_core.ui.shutil.get_terminal_size", side_effect=lambda fallback: self.terminal)
+        self.get_size = patcher.start()
+        self.addCleanup(patcher.stop)
+
+    def test_nothing_is_read_until_marked_stale(self):
+        self.assertFalse(self.size.refresh(self.console))
+        self.get_size.assert_not_called()
+        self.assertEqual(self.console.width, 120)
+
+    def test_a_stale_size_is_read_once(self):
+        self.size.mark_stale()
+        self.assertTrue(self.size.refresh(self.console))
+        self.assertEqual((self.console.width, self.console.height, self.size.changes), (100, 30, 1))
+        self.assertFalse(self.size.refresh(self.console))
+        self.assertEqual(self.get_size.call_count, 1)
+
+    def test_the_same_size_is_no_change(self):
+        self.terminal = (120, 40)
+        self.size.mark_stale()
+        self.assertFalse(self.size.refresh(self.console))
+        self.assertEqual(self.size.changes, 0)
+
+    def test_output_that_is_not_a_terminal_keeps_its_width(self):
+        console = MatrixConsole(file=io.StringIO(), width=120)
+        self.size.mark_stale()
+        self.assertFalse(self.size.refresh(console))
+        self.assertFalse(self.size.stale)
+        self.assertEqual(console.width, 120)
+
+    def test_width_changes_between_lines_only(self):
+        self.console.print("half a ", end="")
+        self.size.mark_stale()
+        self.console.print("line", end="")
+        self.assertEqual(self.console.width, 120)  # Still mid-line
+        self.console.print()
+        self.assertEqual(self.console.width, 120)  # The print that ends the line is part of it
+        self.console.print("next line")
+        self.assertEqual(self.console.width, 100)
+
+    def test_after_a_prompt_the_handler_is_put_back_and_the_size_read(self):
+        self.size.reinstall = mock.Mock()
+        self.size.after_prompt()
+        self.size.reinstall.assert_called_once_with()
+        self.assertTrue(self.size.stale)
+
+    def test_rain_is_no_wider_than_the_console(self):
+        for width, expected in ((200, RAIN_WIDTH), (RAIN_WIDTH, RAIN_WIDTH), (30, 30)):
+            with self.subTest(width=width), mock.patch.object(ui, "console", MatrixConsole(file=io.StringIO(), width=width)):
+                self.assertEqual(ui.rain_width(), expected)
+
+class MatrixRainTest(unittest.TestCase):
+    """The rain's grid follows the width it is fitted to."""
+
+    def rain(self, width):
+        rain = MatrixRain(width=width, height=4)
+        with mock.patch("neo_core.ui.random.random", return_value=0.0):  # Every column spawns and grows
+            for _ in range(3):
+                rain.render()
+        return rain
+
+    def assertGrid(self, rendered, width, height=4):
+        lines = rendered.plain.split("\n")
+        self.assertEqual(len(lines), height)
+        self.assertEqual({len(line) for line in lines}, {width})
+
+    def test_rendered_rows_are_the_width(self):
+        rain = self.rain(10)
+        self.assertGrid(rain.render(), 10)
+        self.assertTrue(all(char != " " for char in rain.render().plain.split("\n")[-1]))  # The drops keep their columns
+
+    def test_narrower_drops_the_columns_past_the_edge(self):
+        rain = self.rain(10)
+        rain.fit(4)
+        self.assertEqual(sorted(rain.columns), [0, 1, 2, 3])
+        self.assertEqual(sorted(rain.speeds), [0, 1, 2, 3])
+        self.assertGrid(rain.render(), 4)
+
+    def test_wider_starts_the_new_columns_empty(self):
+        rain = self.rain(4)
+        rain.fit(8)
+        self.assertEqual(sorted(rain.columns), [0, 1, 2, 3])
+        with mock.patch("neo_core.ui.random.random", return_value=0.99):  # Nothing spawns or grows
+            self.assertGrid(rain.render(), 8)
+
+    def test_the_same_width_changes_nothing(self):
+        rain = self.rain(6)
+        columns = {col: list(chars) for col, chars in rain.columns.items()}
+        rain.fit(6)
+        self.assertEqual(rain.columns, columns)
+
+    def test_exit_animation_follows_a_resize(self):
+        console = MatrixConsole(file=io.StringIO(), width=RAIN_WIDTH)
+        frames = []
+        sleeps = []
+
+        def sleep(seconds):
+            sleeps.append(seconds)
+            if len(sleeps) == 5:
+                console.width = 40  # As TerminalSize.refresh sets it after a SIGWINCH
+
+        with mock.patch.object(ui, "console", console), mock.patch("neo_core.ui.time.sleep", side_effect=sleep), \
+                mock.patch.object(console, "clear"), \
+                mock.patch.object(console, "print", side_effect=lambda *objects, **kwargs: frames.extend(
+                    o for o in objects if isinstance(o, ui.Text))):
+            ui.display_matrix_exit()
+        self.assertEqual([{len(line) for line in frame.plain.split("\n")} for frame in frames],
+                         [{RAIN_WIDTH}] * 5 + [{40}] * 15)
+
+if __name__ == "__main__":
+    unittest.main()